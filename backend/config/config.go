@@ -5,12 +5,32 @@ import (
 	"strconv" // 导入strconv包，用于字符串和数字之间的转换
 )
 
+// DefaultPinPepper 未配置CODE_PIN_PEPPER时使用的PIN哈希密钥，仅限开发环境，release模式下拒绝启动。
+const DefaultPinPepper = "anti-fake-system-pin-pepper"
+
+// DefaultStorageURLSecret 未配置STORAGE_URL_SECRET时使用的下载链接签名密钥，仅限开发环境，release模式下拒绝启动。
+const DefaultStorageURLSecret = "anti-fake-system-storage-secret"
+
 // Config 结构体定义了整个应用程序的配置信息，聚合了服务器、数据库、Redis和JWT的配置。
 type Config struct {
-	Server   ServerConfig   // 服务器配置
-	Database DatabaseConfig // 数据库配置
-	Redis    RedisConfig    // Redis缓存配置
-	JWT      JWTConfig      // JWT认证配置
+	Server        ServerConfig        // 服务器配置
+	Database      DatabaseConfig      // 数据库配置
+	Redis         RedisConfig         // Redis缓存配置
+	JWT           JWTConfig           // JWT认证配置
+	I18n          I18nConfig          // 多语言配置
+	Code          CodeConfig          // 防伪码生成配置
+	Verify        VerifyConfig        // 防伪验证配置
+	VerifyLog     VerifyLogConfig     // 验证记录异步写入配置
+	VerifyArchive VerifyArchiveConfig // 验证记录归档配置
+	Webhook       WebhookConfig       // Webhook推送配置
+	Storage       StorageConfig       // 附件存储配置
+	TraceChain    TraceChainConfig    // 溯源哈希链配置
+	Import        ImportConfig        // 批量导入配置
+	Batch         BatchConfig         // 商品批次配置
+	Permission    PermissionConfig    // 权限配置
+	KMS           KMSConfig           // 数据加密密钥管理配置
+	LoginGuard    LoginGuardConfig    // 登录防暴力破解配置
+	TwoFactor     TwoFactorConfig     // 两步验证配置
 }
 
 // ServerConfig 结构体定义了服务器相关的配置，如端口和运行模式。
@@ -37,8 +57,129 @@ type RedisConfig struct {
 
 // JWTConfig 结构体定义了JWT认证相关的配置。
 type JWTConfig struct {
-	Secret string // JWT签名密钥
-	Expire int    // JWT过期时间 (小时)
+	Secret            string // 服务端密钥，仅用于迁移旧版本以其加密的数据，以及未配置KMS主密钥时派生开发用主密钥
+	AccessExpire      int    // 访问令牌过期时间 (分钟)
+	RefreshExpire     int    // 刷新令牌过期时间 (小时)
+	Algorithm         string // 新签名密钥使用的算法：HS256、RS256 或 EdDSA
+	KeyRotateInterval int    // 签名密钥自动轮换间隔 (小时)，0表示不自动轮换
+}
+
+// I18nConfig 结构体定义了多语言消息相关的配置。
+type I18nConfig struct {
+	Dir           string // 额外翻译文件目录，目录下的 <语言>.json 会覆盖内置翻译
+	DefaultLocale string // 默认语言
+}
+
+// CodeConfig 结构体定义了防伪码生成相关的配置。
+type CodeConfig struct {
+	PinPepper        string // 刮开PIN码哈希使用的服务端密钥
+	PrintDir         string // 印刷文件（含PIN明文）的临时存放目录
+	PrintTTL         int    // 印刷文件保留时长 (小时)，超时未确认删除的文件由清理协程删除
+	PinMaxFailures   int    // 同一防伪码在统计窗口内的PIN错误次数上限，达到后锁定该防伪码的PIN校验
+	PinIPMaxFailures int    // 同一IP在统计窗口内的PIN错误次数上限，达到后锁定该IP的PIN校验
+	PinFailureWindow int    // PIN错误次数统计窗口 (分钟)
+	PinLockout       int    // PIN校验锁定时长 (分钟)
+}
+
+// VerifyConfig 结构体定义了防伪验证相关的配置。
+type VerifyConfig struct {
+	AnomalyThreshold int // 同一防伪码验证次数超过该值时标记为异常
+}
+
+// VerifyLogConfig 结构体定义了验证记录异步批量写入相关的配置。
+type VerifyLogConfig struct {
+	BufferSize     int    // 内存队列长度
+	BatchSize      int    // 单次批量写入的最大记录数
+	FlushInterval  int    // 定时刷新间隔 (毫秒)
+	EnqueueTimeout int    // 队列已满时的最长等待时间 (毫秒)，超时后改为同步写入
+	MaxRetries     int    // 批量写入失败后的重试次数
+	SpillFile      string // 重试仍失败时的落盘文件 (JSON Lines)，用于事后补录
+	ReplayInterval int    // 落盘文件回放间隔 (秒)，启动时也会回放一次
+}
+
+// VerifyArchiveConfig 结构体定义了验证记录按月分表后的保留和归档配置。
+type VerifyArchiveConfig struct {
+	RetentionMonths int    // 数据库中保留的月份数（含当月），超过的月份表导出归档后删除，0表示不归档
+	Dir             string // 归档文件存放目录
+	CheckInterval   int    // 检查过期月份表的间隔 (分钟)
+}
+
+// WebhookConfig 结构体定义了Webhook异步推送相关的配置。
+type WebhookConfig struct {
+	Workers              int  // 投递协程数量
+	QueueSize            int  // 内存投递队列长度
+	Timeout              int  // 单次投递超时时间 (秒)
+	MaxAttempts          int  // 最大投递次数，超过后进入死信列表
+	RetryBaseDelay       int  // 首次重试间隔 (秒)，之后按指数递增
+	RetryMaxDelay        int  // 重试间隔上限 (秒)
+	ScanInterval         int  // 扫描待重试投递的间隔 (秒)
+	SubscriptionCacheTTL int  // 商户订阅缓存时长 (秒)，多实例部署时其他实例的订阅变更最迟在该时长后生效
+	AllowPrivateTargets  bool // 是否允许投递到回环和内网地址，仅限本地联调，release模式下不能开启
+}
+
+// StorageConfig 结构体定义了附件上传和存储相关的配置。
+type StorageConfig struct {
+	Driver        string // 存储驱动，local 或 s3
+	LocalDir      string // 本地存储根目录，仅local驱动
+	S3Endpoint    string // S3兼容服务地址，如 http://127.0.0.1:9000
+	S3Region      string // S3区域
+	S3Bucket      string // S3存储桶
+	S3AccessKey   string // S3访问密钥ID
+	S3SecretKey   string // S3访问密钥
+	MaxUploadSize int    // 单个附件大小上限 (KB)
+	AllowedTypes  string // 允许上传的MIME类型，逗号分隔
+	ThumbnailSize int    // 缩略图最长边 (像素)
+	URLSecret     string // 下载链接签名密钥
+	URLExpire     int    // 下载链接有效期 (秒)
+}
+
+// TraceChainConfig 结构体定义了溯源哈希链相关的配置。
+type TraceChainConfig struct {
+	RootInterval int // 发布商户默克尔根的间隔 (分钟)，0表示只手动发布
+}
+
+// ImportConfig 结构体定义了商品、批次和溯源信息批量导入相关的配置。
+type ImportConfig struct {
+	Workers      int // 处理导入任务的协程数量
+	QueueSize    int // 内存任务队列长度
+	MaxFileSize  int // 导入文件大小上限 (KB)
+	MaxRows      int // 单个文件的最大数据行数
+	ScanInterval int // 扫描排队中任务的间隔 (秒)
+}
+
+// BatchConfig 结构体定义了商品批次相关的配置。
+type BatchConfig struct {
+	CodePattern string // 自动生成批次标识的模式，支持{YYYY}{YY}{MM}{DD}{DDD}日期占位符和{SEQ:n}序号
+}
+
+// PermissionConfig 结构体定义了权限校验相关的配置。
+type PermissionConfig struct {
+	CacheTTL int // 用户权限在Redis中的缓存时间 (秒)
+}
+
+// KMSConfig 结构体定义了数据加密密钥管理相关的配置。
+type KMSConfig struct {
+	MasterKey         string // 主密钥（Base64编码的32字节），用于加密保存各商户的数据加密密钥，优先于MasterKeyFile
+	MasterKeyFile     string // 主密钥文件路径，文件内容为Base64编码或原始的32字节
+	ReencryptInterval int    // 定时检查并重新加密旧版本密文的间隔 (分钟)，0表示只在启动和轮换密钥时执行
+}
+
+// LoginGuardConfig 结构体定义了登录防暴力破解相关的配置。
+type LoginGuardConfig struct {
+	MaxFailures   int // 同一用户名在统计窗口内的失败次数上限，达到后锁定该用户名
+	IPMaxFailures int // 同一IP在统计窗口内的失败次数上限，达到后锁定该IP
+	Window        int // 失败次数统计窗口 (分钟)
+	Lockout       int // 锁定时长 (分钟)
+	DelayBase     int // 第一次失败后需要等待的时间 (秒)，之后每失败一次翻倍
+	DelayMax      int // 等待时间上限 (秒)
+}
+
+// TwoFactorConfig 结构体定义了TOTP两步验证和敏感操作二次验证相关的配置。
+type TwoFactorConfig struct {
+	Issuer          string // 验证器App中显示的签发方名称
+	RequiredRoles   string // 登录时必须通过两步验证的内置角色标识，逗号分隔，如platform_admin,merchant_admin
+	ChallengeExpire int    // 密码验证通过后提交验证码的有效期 (分钟)
+	StepUpExpire    int    // 二次验证令牌的有效期 (分钟)
 }
 
 // Load 函数用于从环境变量或使用默认值加载所有配置。
@@ -63,8 +204,101 @@ func Load() *Config {
 
 		},
 		JWT: JWTConfig{
-			Secret: getEnv("JWT_SECRET", "anti-fake-system-secret"), // 从环境变量JWT_SECRET获取JWT密钥，默认"anti-fake-system-secret"
-			Expire: getEnvInt("JWT_EXPIRE", 24),                     // 从环境变量JWT_EXPIRE获取JWT过期时间，默认24小时
+			Secret:            getEnv("JWT_SECRET", "anti-fake-system-secret"), // 从环境变量JWT_SECRET获取JWT密钥，默认"anti-fake-system-secret"
+			AccessExpire:      getEnvInt("JWT_ACCESS_EXPIRE", 15),              // 访问令牌过期时间，默认15分钟
+			RefreshExpire:     getEnvInt("JWT_REFRESH_EXPIRE", 168),            // 刷新令牌过期时间，默认168小时（7天）
+			Algorithm:         getEnv("JWT_ALGORITHM", "EdDSA"),                // 签名算法，默认EdDSA
+			KeyRotateInterval: getEnvInt("JWT_KEY_ROTATE_INTERVAL", 720),       // 签名密钥轮换间隔，默认720小时（30天）
+		},
+		I18n: I18nConfig{
+			Dir:           getEnv("I18N_DIR", "locales"),          // 翻译文件目录，默认locales
+			DefaultLocale: getEnv("I18N_DEFAULT_LOCALE", "zh-CN"), // 默认语言，默认zh-CN
+		},
+		Code: CodeConfig{
+			PinPepper:        getEnv("CODE_PIN_PEPPER", DefaultPinPepper), // PIN哈希密钥
+			PrintDir:         getEnv("CODE_PRINT_DIR", "data/print"),      // 印刷文件目录，默认data/print
+			PrintTTL:         getEnvInt("CODE_PRINT_TTL", 24),             // 印刷文件保留时长，默认24小时
+			PinMaxFailures:   getEnvInt("PIN_MAX_FAILURES", 5),            // 防伪码PIN错误次数上限，默认5次
+			PinIPMaxFailures: getEnvInt("PIN_IP_MAX_FAILURES", 30),        // IP的PIN错误次数上限，默认30次
+			PinFailureWindow: getEnvInt("PIN_FAILURE_WINDOW", 60),         // PIN错误次数统计窗口，默认60分钟
+			PinLockout:       getEnvInt("PIN_LOCKOUT", 60),                // PIN校验锁定时长，默认60分钟
+		},
+		Verify: VerifyConfig{
+			AnomalyThreshold: getEnvInt("VERIFY_ANOMALY_THRESHOLD", 5), // 验证次数异常阈值，默认5次
+		},
+		VerifyLog: VerifyLogConfig{
+			BufferSize:     getEnvInt("VERIFY_LOG_BUFFER_SIZE", 10000),                     // 内存队列长度，默认10000
+			BatchSize:      getEnvInt("VERIFY_LOG_BATCH_SIZE", 500),                        // 批量写入条数，默认500
+			FlushInterval:  getEnvInt("VERIFY_LOG_FLUSH_INTERVAL", 1000),                   // 刷新间隔，默认1000毫秒
+			EnqueueTimeout: getEnvInt("VERIFY_LOG_ENQUEUE_TIMEOUT", 50),                    // 入队等待时间，默认50毫秒
+			MaxRetries:     getEnvInt("VERIFY_LOG_MAX_RETRIES", 3),                         // 写入重试次数，默认3次
+			SpillFile:      getEnv("VERIFY_LOG_SPILL_FILE", "data/verify_log_spill.jsonl"), // 落盘文件路径
+			ReplayInterval: getEnvInt("VERIFY_LOG_REPLAY_INTERVAL", 60),                    // 落盘文件回放间隔，默认60秒
+		},
+		VerifyArchive: VerifyArchiveConfig{
+			RetentionMonths: getEnvInt("VERIFY_RETENTION_MONTHS", 12),             // 保留月份数，默认12个月
+			Dir:             getEnv("VERIFY_ARCHIVE_DIR", "data/verify_archives"), // 归档目录，默认data/verify_archives
+			CheckInterval:   getEnvInt("VERIFY_ARCHIVE_INTERVAL", 60),             // 检查间隔，默认60分钟
+		},
+		Webhook: WebhookConfig{
+			Workers:              getEnvInt("WEBHOOK_WORKERS", 4),                    // 投递协程数量，默认4
+			QueueSize:            getEnvInt("WEBHOOK_QUEUE_SIZE", 1000),              // 投递队列长度，默认1000
+			Timeout:              getEnvInt("WEBHOOK_TIMEOUT", 10),                   // 投递超时，默认10秒
+			MaxAttempts:          getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),               // 最大投递次数，默认8次
+			RetryBaseDelay:       getEnvInt("WEBHOOK_RETRY_BASE_DELAY", 30),          // 首次重试间隔，默认30秒
+			RetryMaxDelay:        getEnvInt("WEBHOOK_RETRY_MAX_DELAY", 3600),         // 重试间隔上限，默认1小时
+			ScanInterval:         getEnvInt("WEBHOOK_SCAN_INTERVAL", 10),             // 重试扫描间隔，默认10秒
+			SubscriptionCacheTTL: getEnvInt("WEBHOOK_SUBSCRIPTION_CACHE_TTL", 60),    // 订阅缓存时长，默认60秒
+			AllowPrivateTargets:  getEnvBool("WEBHOOK_ALLOW_PRIVATE_TARGETS", false), // 允许内网接收地址，默认关闭
+		},
+		Storage: StorageConfig{
+			Driver:        getEnv("STORAGE_DRIVER", "local"),                                                 // 存储驱动，默认local
+			LocalDir:      getEnv("STORAGE_LOCAL_DIR", "data/uploads"),                                       // 本地存储目录，默认data/uploads
+			S3Endpoint:    getEnv("STORAGE_S3_ENDPOINT", "http://127.0.0.1:9000"),                            // S3服务地址，默认本地MinIO
+			S3Region:      getEnv("STORAGE_S3_REGION", "us-east-1"),                                          // S3区域，默认us-east-1
+			S3Bucket:      getEnv("STORAGE_S3_BUCKET", "anti-fake"),                                          // S3存储桶，默认anti-fake
+			S3AccessKey:   getEnv("STORAGE_S3_ACCESS_KEY", ""),                                               // S3访问密钥ID
+			S3SecretKey:   getEnv("STORAGE_S3_SECRET_KEY", ""),                                               // S3访问密钥
+			MaxUploadSize: getEnvInt("STORAGE_MAX_UPLOAD_SIZE", 10240),                                       // 附件大小上限，默认10MB
+			AllowedTypes:  getEnv("STORAGE_ALLOWED_TYPES", "image/jpeg,image/png,image/gif,application/pdf"), // 允许的MIME类型
+			ThumbnailSize: getEnvInt("STORAGE_THUMBNAIL_SIZE", 320),                                          // 缩略图最长边，默认320像素
+			URLSecret:     getEnv("STORAGE_URL_SECRET", DefaultStorageURLSecret),                             // 下载链接签名密钥
+			URLExpire:     getEnvInt("STORAGE_URL_EXPIRE", 3600),                                             // 下载链接有效期，默认1小时
+		},
+		TraceChain: TraceChainConfig{
+			RootInterval: getEnvInt("TRACE_ROOT_INTERVAL", 60), // 默克尔根发布间隔，默认60分钟
+		},
+		Import: ImportConfig{
+			Workers:      getEnvInt("IMPORT_WORKERS", 2),           // 导入协程数量，默认2
+			QueueSize:    getEnvInt("IMPORT_QUEUE_SIZE", 100),      // 任务队列长度，默认100
+			MaxFileSize:  getEnvInt("IMPORT_MAX_FILE_SIZE", 20480), // 导入文件大小上限，默认20MB
+			MaxRows:      getEnvInt("IMPORT_MAX_ROWS", 10000),      // 单个文件最大行数，默认10000
+			ScanInterval: getEnvInt("IMPORT_SCAN_INTERVAL", 10),    // 任务扫描间隔，默认10秒
+		},
+		Batch: BatchConfig{
+			CodePattern: getEnv("BATCH_CODE_PATTERN", "{YY}{MM}{DD}{SEQ:2}"), // 批次标识模式，默认年月日加两位序号
+		},
+		Permission: PermissionConfig{
+			CacheTTL: getEnvInt("PERMISSION_CACHE_TTL", 300), // 用户权限缓存时间，默认300秒
+		},
+		KMS: KMSConfig{
+			MasterKey:         getEnv("KMS_MASTER_KEY", ""),            // 主密钥，默认为空
+			MasterKeyFile:     getEnv("KMS_MASTER_KEY_FILE", ""),       // 主密钥文件，默认为空
+			ReencryptInterval: getEnvInt("KMS_REENCRYPT_INTERVAL", 60), // 重新加密检查间隔，默认60分钟
+		},
+		LoginGuard: LoginGuardConfig{
+			MaxFailures:   getEnvInt("LOGIN_MAX_FAILURES", 5),     // 用户名失败次数上限，默认5次
+			IPMaxFailures: getEnvInt("LOGIN_IP_MAX_FAILURES", 20), // IP失败次数上限，默认20次
+			Window:        getEnvInt("LOGIN_FAILURE_WINDOW", 15),  // 失败次数统计窗口，默认15分钟
+			Lockout:       getEnvInt("LOGIN_LOCKOUT", 15),         // 锁定时长，默认15分钟
+			DelayBase:     getEnvInt("LOGIN_DELAY_BASE", 1),       // 第一次失败后的等待时间，默认1秒
+			DelayMax:      getEnvInt("LOGIN_DELAY_MAX", 30),       // 等待时间上限，默认30秒
+		},
+		TwoFactor: TwoFactorConfig{
+			Issuer:          getEnv("TOTP_ISSUER", "AntiFakeSystem"),     // 签发方名称，默认AntiFakeSystem
+			RequiredRoles:   getEnv("TWO_FACTOR_REQUIRED_ROLES", ""),     // 必须两步验证的内置角色，默认为空
+			ChallengeExpire: getEnvInt("TWO_FACTOR_CHALLENGE_EXPIRE", 5), // 验证码提交有效期，默认5分钟
+			StepUpExpire:    getEnvInt("STEP_UP_EXPIRE", 5),              // 二次验证令牌有效期，默认5分钟
 		},
 	}
 }
@@ -88,3 +322,14 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+// getEnvBool 是一个辅助函数，用于从环境变量中获取指定键的布尔值。
+// 支持 true/false、1/0 等写法，如果环境变量不存在、为空或无法识别，则返回提供的默认值。
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/handlers"
+	"anti-fake-system/middleware"
+	"anti-fake-system/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AccountController struct {
+	db          *gorm.DB
+	cfg         *config.Config
+	permissions *services.PermissionService
+	tokens      *services.TokenService
+	guard       *services.LoginGuard
+	twoFactor   *services.TwoFactorService
+}
+
+func NewAccountController(db *gorm.DB, cfg *config.Config, permissions *services.PermissionService, tokens *services.TokenService, guard *services.LoginGuard, twoFactor *services.TwoFactorService) *AccountController {
+	return &AccountController{db: db, cfg: cfg, permissions: permissions, tokens: tokens, guard: guard, twoFactor: twoFactor}
+}
+
+func (ac *AccountController) RegisterRoutes(r *gin.Engine) {
+	handler := handlers.NewAccountHandler(ac.db, ac.cfg, ac.permissions, ac.tokens, ac.guard, ac.twoFactor)
+
+	// 商户子账号管理
+	merchantGroup := r.Group("/api/merchant")
+	merchantGroup.Use(middleware.MerchantAuth())
+
+	merchantGroup.GET("/users", middleware.RequirePermission("user:view"), handler.GetUsers)
+	merchantGroup.POST("/users", middleware.RequirePermission("user:create"), handler.CreateUser)
+	merchantGroup.GET("/users/:id", middleware.RequirePermission("user:view"), handler.GetUser)
+	merchantGroup.PUT("/users/:id/status", middleware.RequirePermission("user:update"), handler.UpdateUserStatus)
+	merchantGroup.POST("/users/:id/reset-password", middleware.RequirePermission("user:update"), handler.ResetUserPassword)
+	merchantGroup.POST("/users/:id/unlock", middleware.RequirePermission("user:update"), handler.UnlockUser)
+	merchantGroup.POST("/users/:id/two-factor/reset", middleware.RequirePermission("user:update"), middleware.RequireStepUp(), handler.ResetUserTwoFactor)
+	merchantGroup.PUT("/users/:id/roles", middleware.RequirePermission("user:assign"), middleware.RequireStepUp(), handler.AssignUserRoles)
+
+	// 商户角色管理
+	merchantGroup.GET("/roles", middleware.RequirePermission("role:view"), handler.GetRoles)
+	merchantGroup.POST("/roles", middleware.RequirePermission("role:manage"), middleware.RequireStepUp(), handler.CreateRole)
+	merchantGroup.GET("/roles/:id", middleware.RequirePermission("role:view"), handler.GetRole)
+	merchantGroup.PUT("/roles/:id", middleware.RequirePermission("role:manage"), middleware.RequireStepUp(), handler.UpdateRole)
+	merchantGroup.DELETE("/roles/:id", middleware.RequirePermission("role:manage"), middleware.RequireStepUp(), handler.DeleteRole)
+
+	// 平台子管理员管理
+	platformGroup := r.Group("/api/platform")
+	platformGroup.Use(middleware.PlatformAuth())
+
+	platformGroup.GET("/users", middleware.RequirePermission("platform:user:view"), handler.GetUsers)
+	platformGroup.POST("/users", middleware.RequirePermission("platform:user:create"), handler.CreateUser)
+	platformGroup.GET("/users/:id", middleware.RequirePermission("platform:user:view"), handler.GetUser)
+	platformGroup.PUT("/users/:id/status", middleware.RequirePermission("platform:user:update"), handler.UpdateUserStatus)
+	platformGroup.POST("/users/:id/reset-password", middleware.RequirePermission("platform:user:update"), handler.ResetUserPassword)
+	platformGroup.POST("/users/:id/unlock", middleware.RequirePermission("platform:user:update"), handler.UnlockUser)
+	platformGroup.POST("/users/:id/two-factor/reset", middleware.RequirePermission("platform:user:update"), middleware.RequireStepUp(), handler.ResetUserTwoFactor)
+	platformGroup.PUT("/users/:id/roles", middleware.RequirePermission("platform:user:assign"), middleware.RequireStepUp(), handler.AssignUserRoles)
+	platformGroup.DELETE("/users/:id", middleware.RequirePermission("platform:user:delete"), middleware.RequireStepUp(), handler.DeleteUser)
+
+	// 平台角色管理
+	platformGroup.GET("/roles", middleware.RequirePermission("platform:role:view"), handler.GetRoles)
+	platformGroup.POST("/roles", middleware.RequirePermission("platform:role:manage"), middleware.RequireStepUp(), handler.CreateRole)
+	platformGroup.GET("/roles/:id", middleware.RequirePermission("platform:role:view"), handler.GetRole)
+	platformGroup.PUT("/roles/:id", middleware.RequirePermission("platform:role:manage"), middleware.RequireStepUp(), handler.UpdateRole)
+	platformGroup.DELETE("/roles/:id", middleware.RequirePermission("platform:role:manage"), middleware.RequireStepUp(), handler.DeleteRole)
+
+	// 跨商户管理商户账号
+	tenantHandler := handlers.NewTenantAccountHandler(ac.db, ac.cfg, ac.permissions, ac.tokens, ac.guard, ac.twoFactor)
+
+	platformGroup.GET("/merchant-users", middleware.RequirePermission("platform:merchant_user:view"), tenantHandler.GetUsers)
+	platformGroup.POST("/merchant-users", middleware.RequirePermission("platform:merchant_user:manage"), tenantHandler.CreateUser)
+	platformGroup.GET("/merchant-users/:id", middleware.RequirePermission("platform:merchant_user:view"), tenantHandler.GetUser)
+	platformGroup.PUT("/merchant-users/:id/status", middleware.RequirePermission("platform:merchant_user:manage"), tenantHandler.UpdateUserStatus)
+	platformGroup.POST("/merchant-users/:id/reset-password", middleware.RequirePermission("platform:merchant_user:manage"), tenantHandler.ResetUserPassword)
+	platformGroup.POST("/merchant-users/:id/unlock", middleware.RequirePermission("platform:merchant_user:manage"), tenantHandler.UnlockUser)
+	platformGroup.POST("/merchant-users/:id/two-factor/reset", middleware.RequirePermission("platform:merchant_user:manage"), middleware.RequireStepUp(), tenantHandler.ResetUserTwoFactor)
+	platformGroup.PUT("/merchant-users/:id/roles", middleware.RequirePermission("platform:merchant_user:manage"), middleware.RequireStepUp(), tenantHandler.AssignUserRoles)
+	platformGroup.DELETE("/merchant-users/:id", middleware.RequirePermission("platform:merchant_user:manage"), middleware.RequireStepUp(), tenantHandler.DeleteUser)
+	platformGroup.GET("/merchant-roles", middleware.RequirePermission("platform:merchant_user:view"), tenantHandler.GetRoles)
+
+	// 审计日志
+	auditHandler := handlers.NewAuditHandler(ac.db, ac.cfg)
+
+	platformGroup.GET("/audit-logs", middleware.RequirePermission("platform:audit:view"), auditHandler.GetAuditLogs)
+
+	// 解除IP的登录锁定
+	loginLockHandler := handlers.NewLoginLockHandler(ac.db, ac.cfg, ac.guard)
+
+	platformGroup.POST("/login-locks/unlock-ip", middleware.RequirePermission("platform:user:update"), loginLockHandler.UnlockIP)
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/handlers"
+	"anti-fake-system/middleware"
+	"anti-fake-system/storage"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AttachmentController struct {
+	db    *gorm.DB
+	cfg   *config.Config
+	store storage.Storage
+}
+
+func NewAttachmentController(db *gorm.DB, cfg *config.Config, store storage.Storage) *AttachmentController {
+	return &AttachmentController{db: db, cfg: cfg, store: store}
+}
+
+func (ac *AttachmentController) RegisterRoutes(r *gin.Engine) {
+	handler := handlers.NewAttachmentHandler(ac.db, ac.cfg, ac.store)
+
+	// 签名链接下载附件，无需登录
+	publicGroup := r.Group("/api/public")
+	publicGroup.GET("/attachments/:id", handler.DownloadAttachment)
+
+	// 商户附件管理
+	merchantGroup := r.Group("/api/merchant")
+	merchantGroup.Use(middleware.MerchantAuth())
+
+	merchantGroup.POST("/attachments", middleware.RequirePermission("attachment:upload"), handler.UploadAttachment)
+	merchantGroup.GET("/attachments", middleware.RequirePermission("attachment:view"), handler.GetAttachments)
+	merchantGroup.GET("/attachments/:id", middleware.RequirePermission("attachment:view"), handler.GetAttachment)
+	merchantGroup.DELETE("/attachments/:id", middleware.RequirePermission("attachment:delete"), handler.DeleteAttachment)
+}
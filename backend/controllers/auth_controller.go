@@ -3,24 +3,29 @@ package controllers
 import (
 	"anti-fake-system/config"
 	"anti-fake-system/handlers"
+	"anti-fake-system/middleware"
+	"anti-fake-system/services"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type AuthController struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db        *gorm.DB
+	cfg       *config.Config
+	tokens    *services.TokenService
+	guard     *services.LoginGuard
+	twoFactor *services.TwoFactorService
 }
 
-func NewAuthController(db *gorm.DB, cfg *config.Config) *AuthController {
-	return &AuthController{db: db, cfg: cfg}
+func NewAuthController(db *gorm.DB, cfg *config.Config, tokens *services.TokenService, guard *services.LoginGuard, twoFactor *services.TwoFactorService) *AuthController {
+	return &AuthController{db: db, cfg: cfg, tokens: tokens, guard: guard, twoFactor: twoFactor}
 }
 
 func (ac *AuthController) RegisterRoutes(r *gin.Engine) {
 	authGroup := r.Group("/api/auth")
 
-	handler := handlers.NewAuthHandler(ac.db, ac.cfg)
+	handler := handlers.NewAuthHandler(ac.db, ac.cfg, ac.tokens, ac.guard, ac.twoFactor)
 
 	// 平台登录
 	authGroup.POST("/platform/login", handler.PlatformLogin)
@@ -30,4 +35,22 @@ func (ac *AuthController) RegisterRoutes(r *gin.Engine) {
 
 	// 刷新token
 	authGroup.POST("/refresh", handler.RefreshToken)
+
+	// 登录时的两步验证，凭密码验证通过后返回的两步验证令牌调用
+	authGroup.POST("/2fa/challenge/setup", handler.ChallengeSetup)
+	authGroup.POST("/2fa/verify", handler.VerifyTwoFactor)
+
+	// 注销和修改密码需要登录
+	sessionGroup := authGroup.Group("")
+	sessionGroup.Use(middleware.AuthMiddleware())
+	sessionGroup.POST("/logout", handler.Logout)
+	sessionGroup.POST("/password", handler.ChangePassword)
+
+	// 两步验证管理和敏感操作的二次验证
+	sessionGroup.GET("/2fa", handler.GetTwoFactor)
+	sessionGroup.POST("/2fa/setup", handler.SetupTwoFactor)
+	sessionGroup.POST("/2fa/enable", handler.EnableTwoFactor)
+	sessionGroup.POST("/2fa/disable", handler.DisableTwoFactor)
+	sessionGroup.POST("/2fa/recovery-codes", handler.RegenerateRecoveryCodes)
+	sessionGroup.POST("/step-up", handler.StepUp)
 }
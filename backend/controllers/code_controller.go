@@ -4,37 +4,67 @@ import (
 	"anti-fake-system/config"
 	"anti-fake-system/handlers"
 	"anti-fake-system/middleware"
+	"anti-fake-system/services"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type CodeController struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db         *gorm.DB
+	cfg        *config.Config
+	dispatcher *services.WebhookDispatcher
+	logWriter  *services.VerifyLogWriter
+	records    *services.VerifyRecordRepository
+	archiver   *services.VerifyArchiver
+	keys       *services.KeyManager
+	pinGuard   *services.PinGuard
 }
 
-func NewCodeController(db *gorm.DB, cfg *config.Config) *CodeController {
-	return &CodeController{db: db, cfg: cfg}
+func NewCodeController(db *gorm.DB, cfg *config.Config, dispatcher *services.WebhookDispatcher, logWriter *services.VerifyLogWriter, records *services.VerifyRecordRepository, archiver *services.VerifyArchiver, keys *services.KeyManager, pinGuard *services.PinGuard) *CodeController {
+	return &CodeController{db: db, cfg: cfg, dispatcher: dispatcher, logWriter: logWriter, records: records, archiver: archiver, keys: keys, pinGuard: pinGuard}
 }
 
 func (cc *CodeController) RegisterRoutes(r *gin.Engine) {
 	// 商户端防伪码管理
 	merchantGroup := r.Group("/api/merchant")
-	merchantGroup.Use(middleware.MerchantAuth(cc.cfg.JWT.Secret))
+	merchantGroup.Use(middleware.MerchantAuth())
 
-	merchantHandler := handlers.NewCodeHandler(cc.db, cc.cfg)
+	merchantHandler := handlers.NewCodeHandler(cc.db, cc.cfg, cc.keys, cc.dispatcher)
 
-	merchantGroup.POST("/codes/generate", merchantHandler.GenerateCodes)
-	merchantGroup.GET("/codes", merchantHandler.GetCodes)
-	merchantGroup.GET("/codes/:id", merchantHandler.GetCodeDetail)
+	merchantGroup.POST("/codes/generate", middleware.RequirePermission("code:generate"), merchantHandler.GenerateCodes)
+	merchantGroup.GET("/codes", middleware.RequirePermission("code:view"), merchantHandler.GetCodes)
+	merchantGroup.GET("/codes/export", middleware.RequirePermission("code:export"), merchantHandler.ExportCodes)
+	merchantGroup.GET("/codes/print/:token", middleware.RequirePermission("code:print"), merchantHandler.DownloadPrintFile)
+	merchantGroup.DELETE("/codes/print/:token", middleware.RequirePermission("code:print"), merchantHandler.ConfirmPrintFile)
+	merchantGroup.PUT("/codes/status", middleware.RequirePermission("code:status"), middleware.RequireStepUp(), merchantHandler.BatchUpdateStatus)
+	merchantGroup.GET("/codes/:id", middleware.RequirePermission("code:view"), merchantHandler.GetCodeDetail)
+
+	verifyHandler := handlers.NewVerifyHandler(cc.db, cc.cfg, cc.dispatcher, cc.logWriter, cc.records, cc.pinGuard)
+
+	// 商户端验证记录（仅本商户数据）
+	merchantGroup.GET("/verify/records", middleware.RequirePermission("verify:view"), verifyHandler.GetVerifyRecords)
+	merchantGroup.GET("/verify/statistics", middleware.RequirePermission("verify:view"), verifyHandler.GetVerifyStatistics)
+
+	// 平台端验证记录（全局数据）
+	platformGroup := r.Group("/api/platform")
+	platformGroup.Use(middleware.PlatformAuth())
+
+	platformGroup.GET("/verify/records", middleware.RequirePermission("platform:verify:view"), verifyHandler.GetVerifyRecords)
+	platformGroup.GET("/verify/statistics", middleware.RequirePermission("platform:verify:view"), verifyHandler.GetVerifyStatistics)
+	platformGroup.GET("/metrics/verify-log", middleware.RequirePermission("platform:verify:view"), verifyHandler.GetVerifyLogMetrics)
+
+	// 平台端验证记录归档管理
+	archiveHandler := handlers.NewVerifyArchiveHandler(cc.db, cc.cfg, cc.records, cc.archiver)
+
+	platformGroup.GET("/verify/archives", middleware.RequirePermission("platform:archive:view"), archiveHandler.GetArchives)
+	platformGroup.POST("/verify/archives/:month", middleware.RequirePermission("platform:archive:manage"), archiveHandler.ArchiveMonth)
+	platformGroup.POST("/verify/archives/:month/restore", middleware.RequirePermission("platform:archive:manage"), archiveHandler.RestoreArchive)
+	platformGroup.DELETE("/verify/archives/:month/restore", middleware.RequirePermission("platform:archive:manage"), archiveHandler.ReleaseArchive)
 
 	// 公共验证接口
 	publicGroup := r.Group("/api/public")
 
-	verifyHandler := handlers.NewVerifyHandler(cc.db, cc.cfg)
-
 	publicGroup.POST("/verify", verifyHandler.VerifyCode)
-	publicGroup.GET("/verify/records", verifyHandler.GetVerifyRecords)
-	publicGroup.GET("/verify/statistics", verifyHandler.GetVerifyStatistics)
+	publicGroup.GET("/verify/:code/history", verifyHandler.GetVerifyHistory)
 }
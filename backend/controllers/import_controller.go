@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/handlers"
+	"anti-fake-system/middleware"
+	"anti-fake-system/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ImportController struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	runner *services.ImportRunner
+}
+
+func NewImportController(db *gorm.DB, cfg *config.Config, runner *services.ImportRunner) *ImportController {
+	return &ImportController{db: db, cfg: cfg, runner: runner}
+}
+
+func (ic *ImportController) RegisterRoutes(r *gin.Engine) {
+	handler := handlers.NewImportHandler(ic.db, ic.cfg, ic.runner)
+
+	// 商户端批量导入
+	merchantGroup := r.Group("/api/merchant")
+	merchantGroup.Use(middleware.MerchantAuth())
+
+	merchantGroup.GET("/import-templates/:type", middleware.RequirePermission("import:view"), handler.GetTemplate)
+	merchantGroup.POST("/imports", middleware.RequirePermission("import:create"), handler.CreateImport)
+	merchantGroup.GET("/imports", middleware.RequirePermission("import:view"), handler.GetImports)
+	merchantGroup.GET("/imports/:id", middleware.RequirePermission("import:view"), handler.GetImport)
+	merchantGroup.POST("/imports/:id/commit", middleware.RequirePermission("import:commit"), handler.CommitImport)
+	merchantGroup.GET("/imports/:id/errors", middleware.RequirePermission("import:view"), handler.GetImportErrors)
+}
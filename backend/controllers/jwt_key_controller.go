@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/handlers"
+	"anti-fake-system/middleware"
+	"anti-fake-system/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type JWTKeyController struct {
+	db   *gorm.DB
+	cfg  *config.Config
+	keys *services.JWTKeyService
+}
+
+func NewJWTKeyController(db *gorm.DB, cfg *config.Config, keys *services.JWTKeyService) *JWTKeyController {
+	return &JWTKeyController{db: db, cfg: cfg, keys: keys}
+}
+
+func (jc *JWTKeyController) RegisterRoutes(r *gin.Engine) {
+	handler := handlers.NewJWTKeyHandler(jc.db, jc.cfg, jc.keys)
+
+	// 公开访问令牌验证公钥
+	r.GET("/.well-known/jwks.json", handler.GetJWKS)
+
+	// 平台访问令牌签名密钥管理
+	platformGroup := r.Group("/api/platform")
+	platformGroup.Use(middleware.PlatformAuth())
+
+	platformGroup.GET("/jwt-keys", middleware.RequirePermission("platform:jwt_key:view"), handler.GetJWTKeys)
+	platformGroup.POST("/jwt-keys/rotate", middleware.RequirePermission("platform:jwt_key:manage"), handler.RotateJWTKey)
+	platformGroup.POST("/jwt-keys/:kid/revoke", middleware.RequirePermission("platform:jwt_key:manage"), handler.RevokeJWTKey)
+}
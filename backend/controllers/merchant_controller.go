@@ -4,38 +4,54 @@ import (
 	"anti-fake-system/config"
 	"anti-fake-system/handlers"
 	"anti-fake-system/middleware"
+	"anti-fake-system/services"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type MerchantController struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db      *gorm.DB
+	cfg     *config.Config
+	records *services.VerifyRecordRepository
 }
 
-func NewMerchantController(db *gorm.DB, cfg *config.Config) *MerchantController {
-	return &MerchantController{db: db, cfg: cfg}
+func NewMerchantController(db *gorm.DB, cfg *config.Config, records *services.VerifyRecordRepository) *MerchantController {
+	return &MerchantController{db: db, cfg: cfg, records: records}
 }
 
 func (mc *MerchantController) RegisterRoutes(r *gin.Engine) {
 	merchantGroup := r.Group("/api/merchant")
-	merchantGroup.Use(middleware.MerchantAuth(mc.cfg.JWT.Secret))
+	merchantGroup.Use(middleware.MerchantAuth())
 
-	handler := handlers.NewMerchantHandler(mc.db, mc.cfg)
+	handler := handlers.NewMerchantHandler(mc.db, mc.cfg, mc.records)
 
 	// 商品管理
-	merchantGroup.GET("/products", handler.GetProducts)
-	merchantGroup.POST("/products", handler.CreateProduct)
+	productHandler := handlers.NewProductHandler(mc.db, mc.cfg)
+	merchantGroup.GET("/products", middleware.RequirePermission("product:view"), productHandler.GetProducts)
+	merchantGroup.POST("/products", middleware.RequirePermission("product:create"), productHandler.CreateProduct)
+	merchantGroup.GET("/products/:id", middleware.RequirePermission("product:view"), productHandler.GetProduct)
+	merchantGroup.PUT("/products/:id", middleware.RequirePermission("product:update"), productHandler.UpdateProduct)
+	merchantGroup.POST("/products/:id/archive", middleware.RequirePermission("product:archive"), productHandler.ArchiveProduct)
+	merchantGroup.POST("/products/:id/restore", middleware.RequirePermission("product:archive"), productHandler.RestoreProduct)
+	merchantGroup.GET("/products/:id/history", middleware.RequirePermission("product:view"), productHandler.GetProductHistory)
+
+	// 商品分类
+	merchantGroup.GET("/product-categories", middleware.RequirePermission("product:view"), productHandler.GetCategories)
+	merchantGroup.POST("/product-categories", middleware.RequirePermission("category:manage"), productHandler.CreateCategory)
+	merchantGroup.PUT("/product-categories/:id", middleware.RequirePermission("category:manage"), productHandler.UpdateCategory)
+	merchantGroup.DELETE("/product-categories/:id", middleware.RequirePermission("category:manage"), productHandler.DeleteCategory)
 
 	// 批次管理
-	merchantGroup.GET("/batches", handler.GetBatches)
-	merchantGroup.POST("/batches", handler.CreateBatch)
+	batchHandler := handlers.NewBatchHandler(mc.db, mc.cfg, mc.records)
+	merchantGroup.GET("/batches", middleware.RequirePermission("batch:view"), batchHandler.GetBatches)
+	merchantGroup.POST("/batches", middleware.RequirePermission("batch:create"), batchHandler.CreateBatch)
+	merchantGroup.GET("/batches/reconciliation", middleware.RequirePermission("batch:view"), batchHandler.GetReconciliation)
+	merchantGroup.GET("/batches/:id", middleware.RequirePermission("batch:view"), batchHandler.GetBatch)
 
 	// 规则管理
-	merchantGroup.GET("/rules", handler.GetRules)
-	merchantGroup.POST("/rules", handler.CreateRule)
+	merchantGroup.GET("/rules", middleware.RequirePermission("rule:view"), handler.GetRules)
 
 	// 商户统计
-	merchantGroup.GET("/statistics", handler.GetMerchantStatistics)
+	merchantGroup.GET("/statistics", middleware.RequirePermission("statistics:view"), handler.GetMerchantStatistics)
 }
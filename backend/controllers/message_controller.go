@@ -0,0 +1,35 @@
+package controllers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/handlers"
+	"anti-fake-system/middleware"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type MessageController struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+func NewMessageController(db *gorm.DB, cfg *config.Config) *MessageController {
+	return &MessageController{db: db, cfg: cfg}
+}
+
+func (mc *MessageController) RegisterRoutes(r *gin.Engine) {
+	handler := handlers.NewMessageHandler(mc.db, mc.cfg)
+
+	// 公共语言列表
+	publicGroup := r.Group("/api/public")
+	publicGroup.GET("/locales", handler.GetLocales)
+
+	// 商户自定义消息
+	merchantGroup := r.Group("/api/merchant")
+	merchantGroup.Use(middleware.MerchantAuth())
+
+	merchantGroup.GET("/messages", middleware.RequirePermission("setting:view"), handler.GetMessages)
+	merchantGroup.PUT("/messages", middleware.RequirePermission("message:manage"), handler.SaveMessage)
+	merchantGroup.DELETE("/messages/:id", middleware.RequirePermission("message:manage"), handler.DeleteMessage)
+}
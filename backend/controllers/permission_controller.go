@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/handlers"
+	"anti-fake-system/middleware"
+	"anti-fake-system/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type PermissionController struct {
+	db          *gorm.DB
+	cfg         *config.Config
+	permissions *services.PermissionService
+}
+
+func NewPermissionController(db *gorm.DB, cfg *config.Config, permissions *services.PermissionService) *PermissionController {
+	return &PermissionController{db: db, cfg: cfg, permissions: permissions}
+}
+
+func (pc *PermissionController) RegisterRoutes(r *gin.Engine) {
+	handler := handlers.NewPermissionHandler(pc.db, pc.cfg, pc.permissions)
+
+	// 当前用户的权限树，平台和商户用户通用
+	authGroup := r.Group("/api/auth")
+	authGroup.GET("/permissions", middleware.AuthMiddleware(), handler.GetMyPermissions)
+
+	// 平台端权限目录管理
+	platformGroup := r.Group("/api/platform")
+	platformGroup.Use(middleware.PlatformAuth())
+
+	platformGroup.GET("/permissions", middleware.RequirePermission("platform:permission:view"), handler.GetPermissions)
+	platformGroup.POST("/permissions", middleware.RequirePermission("platform:permission:manage"), handler.CreatePermission)
+	platformGroup.PUT("/permissions/:id", middleware.RequirePermission("platform:permission:manage"), handler.UpdatePermission)
+	platformGroup.DELETE("/permissions/:id", middleware.RequirePermission("platform:permission:manage"), handler.DeletePermission)
+	platformGroup.POST("/permissions/seed", middleware.RequirePermission("platform:permission:manage"), handler.SeedPermissions)
+}
@@ -4,33 +4,35 @@ import (
 	"anti-fake-system/config"
 	"anti-fake-system/handlers"
 	"anti-fake-system/middleware"
+	"anti-fake-system/services"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type PlatformController struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db      *gorm.DB
+	cfg     *config.Config
+	records *services.VerifyRecordRepository
 }
 
-func NewPlatformController(db *gorm.DB, cfg *config.Config) *PlatformController {
-	return &PlatformController{db: db, cfg: cfg}
+func NewPlatformController(db *gorm.DB, cfg *config.Config, records *services.VerifyRecordRepository) *PlatformController {
+	return &PlatformController{db: db, cfg: cfg, records: records}
 }
 
 func (pc *PlatformController) RegisterRoutes(r *gin.Engine) {
 	platformGroup := r.Group("/api/platform")
 	platformGroup.Use(middleware.PlatformAuth())
 
-	handler := handlers.NewPlatformHandler(pc.db, pc.cfg)
+	handler := handlers.NewPlatformHandler(pc.db, pc.cfg, pc.records)
 
 	// 商户管理
-	platformGroup.GET("/merchants", handler.GetMerchants)
-	platformGroup.POST("/merchants", handler.CreateMerchant)
-	platformGroup.PUT("/merchants/:id", handler.UpdateMerchant)
-	platformGroup.GET("/merchants/:id", handler.GetMerchantDetail)
+	platformGroup.GET("/merchants", middleware.RequirePermission("platform:merchant:view"), handler.GetMerchants)
+	platformGroup.POST("/merchants", middleware.RequirePermission("platform:merchant:create"), handler.CreateMerchant)
+	platformGroup.PUT("/merchants/:id", middleware.RequirePermission("platform:merchant:update"), handler.UpdateMerchant)
+	platformGroup.GET("/merchants/:id", middleware.RequirePermission("platform:merchant:view"), handler.GetMerchantDetail)
 
 	// 统计报表
-	platformGroup.GET("/statistics", handler.GetStatistics)
-	platformGroup.GET("/verify-trend", handler.GetVerifyTrend)
+	platformGroup.GET("/statistics", middleware.RequirePermission("platform:statistics:view"), handler.GetStatistics)
+	platformGroup.GET("/verify-trend", middleware.RequirePermission("platform:statistics:view"), handler.GetVerifyTrend)
 }
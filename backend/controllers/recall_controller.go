@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/handlers"
+	"anti-fake-system/middleware"
+	"anti-fake-system/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type RecallController struct {
+	db         *gorm.DB
+	cfg        *config.Config
+	dispatcher *services.WebhookDispatcher
+	records    *services.VerifyRecordRepository
+}
+
+func NewRecallController(db *gorm.DB, cfg *config.Config, dispatcher *services.WebhookDispatcher, records *services.VerifyRecordRepository) *RecallController {
+	return &RecallController{db: db, cfg: cfg, dispatcher: dispatcher, records: records}
+}
+
+func (rc *RecallController) RegisterRoutes(r *gin.Engine) {
+	handler := handlers.NewRecallHandler(rc.db, rc.cfg, rc.dispatcher, rc.records)
+
+	// 商户端商品召回
+	merchantGroup := r.Group("/api/merchant")
+	merchantGroup.Use(middleware.MerchantAuth())
+
+	merchantGroup.GET("/recalls", middleware.RequirePermission("recall:view"), handler.GetRecalls)
+	merchantGroup.POST("/recalls", middleware.RequirePermission("recall:create"), handler.CreateRecall)
+	merchantGroup.GET("/recalls/:id", middleware.RequirePermission("recall:view"), handler.GetRecall)
+	merchantGroup.PUT("/recalls/:id", middleware.RequirePermission("recall:update"), handler.UpdateRecall)
+	merchantGroup.POST("/recalls/:id/open", middleware.RequirePermission("recall:open"), handler.OpenRecall)
+	merchantGroup.POST("/recalls/:id/close", middleware.RequirePermission("recall:close"), handler.CloseRecall)
+	merchantGroup.GET("/recalls/:id/report", middleware.RequirePermission("recall:view"), handler.GetRecallReport)
+}
@@ -4,30 +4,37 @@ import (
 	"anti-fake-system/config"
 	"anti-fake-system/handlers"
 	"anti-fake-system/middleware"
+	"anti-fake-system/services"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type RuleController struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db   *gorm.DB
+	cfg  *config.Config
+	keys *services.KeyManager
 }
 
-func NewRuleController(db *gorm.DB, cfg *config.Config) *RuleController {
-	return &RuleController{db: db, cfg: cfg}
+func NewRuleController(db *gorm.DB, cfg *config.Config, keys *services.KeyManager) *RuleController {
+	return &RuleController{db: db, cfg: cfg, keys: keys}
 }
 
 func (rc *RuleController) RegisterRoutes(r *gin.Engine) {
 	merchantGroup := r.Group("/api/merchant")
-	merchantGroup.Use(middleware.MerchantAuth(rc.cfg.JWT.Secret))
+	merchantGroup.Use(middleware.MerchantAuth())
 
-	handler := handlers.NewRuleHandler(rc.db, rc.cfg)
+	handler := handlers.NewRuleHandler(rc.db, rc.cfg, rc.keys)
 
 	// 规则管理
-	merchantGroup.POST("/rules", handler.CreateRule)
-	merchantGroup.PUT("/rules/:id", handler.UpdateRule)
-	merchantGroup.GET("/rules/:id", handler.GetRuleDetail)
-	merchantGroup.POST("/rules/:id/test", handler.TestRule)
-	merchantGroup.POST("/rules/validate", handler.ValidateRuleConfig)
+	merchantGroup.POST("/rules", middleware.RequirePermission("rule:create"), handler.CreateRule)
+	merchantGroup.PUT("/rules/:id", middleware.RequirePermission("rule:update"), handler.UpdateRule)
+	merchantGroup.GET("/rules/:id", middleware.RequirePermission("rule:view"), handler.GetRuleDetail)
+	merchantGroup.POST("/rules/:id/test", middleware.RequirePermission("rule:test"), handler.TestRule)
+	merchantGroup.POST("/rules/validate", middleware.RequirePermission("rule:test"), handler.ValidateRuleConfig)
+
+	// 规则配置的数据加密密钥
+	dataKeyHandler := handlers.NewDataKeyHandler(rc.db, rc.cfg, rc.keys)
+	merchantGroup.GET("/data-keys", middleware.RequirePermission("setting:view"), dataKeyHandler.GetDataKeys)
+	merchantGroup.POST("/data-keys/rotate", middleware.RequirePermission("data_key:manage"), dataKeyHandler.RotateDataKey)
 }
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/handlers"
+	"anti-fake-system/middleware"
+	"anti-fake-system/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type SigningKeyController struct {
+	db   *gorm.DB
+	cfg  *config.Config
+	keys *services.KeyManager
+}
+
+func NewSigningKeyController(db *gorm.DB, cfg *config.Config, keys *services.KeyManager) *SigningKeyController {
+	return &SigningKeyController{db: db, cfg: cfg, keys: keys}
+}
+
+func (sc *SigningKeyController) RegisterRoutes(r *gin.Engine) {
+	handler := handlers.NewSigningKeyHandler(sc.db, sc.cfg, sc.keys)
+
+	// 公开签名公钥，供扫码端离线验证
+	publicGroup := r.Group("/api/public")
+	publicGroup.GET("/merchants/:code/signing-keys", handler.GetPublicKeys)
+
+	// 商户签名密钥管理
+	merchantGroup := r.Group("/api/merchant")
+	merchantGroup.Use(middleware.MerchantAuth())
+
+	merchantGroup.GET("/signing-keys", middleware.RequirePermission("setting:view"), handler.GetSigningKeys)
+	merchantGroup.POST("/signing-keys/rotate", middleware.RequirePermission("signing_key:manage"), handler.RotateSigningKey)
+	merchantGroup.POST("/signing-keys/:kid/revoke", middleware.RequirePermission("signing_key:manage"), handler.RevokeSigningKey)
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/handlers"
+	"anti-fake-system/middleware"
+	"anti-fake-system/storage"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TraceController struct {
+	db    *gorm.DB
+	cfg   *config.Config
+	store storage.Storage
+}
+
+func NewTraceController(db *gorm.DB, cfg *config.Config, store storage.Storage) *TraceController {
+	return &TraceController{db: db, cfg: cfg, store: store}
+}
+
+func (tc *TraceController) RegisterRoutes(r *gin.Engine) {
+	handler := handlers.NewTraceHandler(tc.db, tc.cfg, tc.store)
+
+	// 商户端溯源信息管理
+	merchantGroup := r.Group("/api/merchant")
+	merchantGroup.Use(middleware.MerchantAuth())
+
+	merchantGroup.GET("/batches/:id/traces", middleware.RequirePermission("trace:view"), handler.GetTraces)
+	merchantGroup.POST("/batches/:id/traces", middleware.RequirePermission("trace:create"), handler.CreateTrace)
+	merchantGroup.PUT("/traces/:id", middleware.RequirePermission("trace:update"), handler.UpdateTrace)
+	merchantGroup.DELETE("/traces/:id", middleware.RequirePermission("trace:delete"), handler.DeleteTrace)
+
+	// 溯源阶段模板
+	schemaHandler := handlers.NewTraceSchemaHandler(tc.db, tc.cfg)
+
+	merchantGroup.GET("/trace-schemas", middleware.RequirePermission("trace:view"), schemaHandler.GetSchemas)
+	merchantGroup.GET("/trace-schemas/:stage_type/versions", middleware.RequirePermission("trace:view"), schemaHandler.GetSchemaVersions)
+	merchantGroup.PUT("/trace-schemas/:stage_type", middleware.RequirePermission("trace_schema:manage"), schemaHandler.SaveSchema)
+
+	// 溯源哈希链
+	chainHandler := handlers.NewTraceChainHandler(tc.db, tc.cfg)
+
+	merchantGroup.GET("/traces/:id/versions", middleware.RequirePermission("trace:view"), chainHandler.GetTraceVersions)
+	merchantGroup.GET("/batches/:id/trace-chain", middleware.RequirePermission("trace:view"), chainHandler.VerifyBatchChain)
+	merchantGroup.GET("/trace-roots", middleware.RequirePermission("trace:view"), chainHandler.GetRoots)
+	merchantGroup.POST("/trace-roots", middleware.RequirePermission("trace_root:publish"), chainHandler.PublishRoot)
+
+	// EPCIS 2.0 导入导出
+	epcisHandler := handlers.NewEPCISHandler(tc.db, tc.cfg)
+
+	merchantGroup.GET("/batches/:id/epcis", middleware.RequirePermission("epcis:export"), epcisHandler.ExportBatch)
+	merchantGroup.POST("/batches/:id/epcis", middleware.RequirePermission("epcis:import"), epcisHandler.ImportBatch)
+
+	// 消费者查看溯源信息和核验证明
+	publicGroup := r.Group("/api/public")
+	publicGroup.GET("/codes/:code/traces", handler.GetPublicTraces)
+	publicGroup.GET("/codes/:code/traces/proof", chainHandler.GetPublicProof)
+	publicGroup.GET("/merchants/:code/trace-roots", chainHandler.GetPublicRoots)
+}
@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/handlers"
+	"anti-fake-system/middleware"
+	"anti-fake-system/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type WebhookController struct {
+	db         *gorm.DB
+	cfg        *config.Config
+	dispatcher *services.WebhookDispatcher
+}
+
+func NewWebhookController(db *gorm.DB, cfg *config.Config, dispatcher *services.WebhookDispatcher) *WebhookController {
+	return &WebhookController{db: db, cfg: cfg, dispatcher: dispatcher}
+}
+
+func (wc *WebhookController) RegisterRoutes(r *gin.Engine) {
+	merchantGroup := r.Group("/api/merchant")
+	merchantGroup.Use(middleware.MerchantAuth())
+
+	handler := handlers.NewWebhookHandler(wc.db, wc.cfg, wc.dispatcher)
+
+	// Webhook订阅管理
+	merchantGroup.GET("/webhooks", middleware.RequirePermission("webhook:view"), handler.GetWebhooks)
+	merchantGroup.POST("/webhooks", middleware.RequirePermission("webhook:manage"), handler.CreateWebhook)
+	merchantGroup.PUT("/webhooks/:id", middleware.RequirePermission("webhook:manage"), handler.UpdateWebhook)
+	merchantGroup.DELETE("/webhooks/:id", middleware.RequirePermission("webhook:manage"), handler.DeleteWebhook)
+	merchantGroup.POST("/webhooks/:id/ping", middleware.RequirePermission("webhook:manage"), handler.PingWebhook)
+
+	// 投递日志与死信
+	merchantGroup.GET("/webhook-deliveries", middleware.RequirePermission("webhook:view"), handler.GetDeliveries)
+	merchantGroup.GET("/webhook-deliveries/dead-letters", middleware.RequirePermission("webhook:view"), handler.GetDeadLetters)
+	merchantGroup.GET("/webhook-deliveries/:id", middleware.RequirePermission("webhook:view"), handler.GetDeliveryDetail)
+	merchantGroup.POST("/webhook-deliveries/:id/redeliver", middleware.RequirePermission("webhook:manage"), handler.RedeliverDelivery)
+}
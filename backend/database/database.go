@@ -26,7 +26,8 @@ func InitDB(cfg *config.Config) (*gorm.DB, error) {
 	)
 
 	// 使用GORM打开数据库连接
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	// 开启TranslateError，唯一索引冲突等数据库错误转换为gorm.ErrDuplicatedKey等通用错误，便于业务层判断
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		return nil, fmt.Errorf("数据库连接失败: %v", err) // 如果连接失败，返回错误
 	}
@@ -0,0 +1,540 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AccountStatusRequest 启用或禁用账号请求
+type AccountStatusRequest struct {
+	Status *int `json:"status" binding:"required,oneof=0 1"` // 0-禁用, 1-启用
+}
+
+// ResetPasswordRequest 重置密码请求
+type ResetPasswordRequest struct {
+	Password string `json:"password" binding:"required,min=8,max=72"`
+}
+
+// AssignRolesRequest 分配角色请求，传空数组表示移除全部角色
+type AssignRolesRequest struct {
+	RoleIDs []uint `json:"role_ids" binding:"required"`
+}
+
+type AccountHandler struct {
+	db      *gorm.DB
+	cfg     *config.Config
+	service *services.AccountService
+	audits  *services.AuditService
+	tenants bool // 平台跨商户管理商户账号
+}
+
+func NewAccountHandler(db *gorm.DB, cfg *config.Config, permissions *services.PermissionService, tokens *services.TokenService, guard *services.LoginGuard, twoFactor *services.TwoFactorService) *AccountHandler {
+	return &AccountHandler{db: db, cfg: cfg, service: services.NewAccountService(db, permissions, tokens, guard, twoFactor), audits: services.NewAuditService(db)}
+}
+
+// NewTenantAccountHandler 创建平台跨商户管理商户账号的处理器，merchant_id参数限定商户
+func NewTenantAccountHandler(db *gorm.DB, cfg *config.Config, permissions *services.PermissionService, tokens *services.TokenService, guard *services.LoginGuard, twoFactor *services.TwoFactorService) *AccountHandler {
+	handler := NewAccountHandler(db, cfg, permissions, tokens, guard, twoFactor)
+	handler.tenants = true
+	return handler
+}
+
+// GetUsers 获取管理范围内的账号列表，支持username模糊查询和status筛选
+func (h *AccountHandler) GetUsers(c *gin.Context) {
+	username := c.Query("username")
+	status := c.Query("status")
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	query := h.service.Users(h.scope(c))
+	if username != "" {
+		query = query.Where("username LIKE ?", "%"+username+"%")
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var users []models.User
+	offset := (page - 1) * size
+	query.Offset(offset).Limit(size).Order("id desc").Find(&users)
+
+	list, err := h.service.UserViews(users)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"total": total,
+			"page":  page,
+			"size":  size,
+			"list":  list,
+		},
+	})
+}
+
+// CreateUser 新建子账号，可同时分配初始角色，跨商户管理时在merchant_id指定的商户下创建
+func (h *AccountHandler) CreateUser(c *gin.Context) {
+	var input services.AccountInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	scope := h.scope(c)
+	if h.tenants {
+		// 跨商户创建时账号属于请求中指定的商户
+		var merchant models.Merchant
+		if err := h.db.First(&merchant, input.MerchantID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"code":    404,
+				"msg_key": "merchant.not_found",
+				"msg":     i18n.T(c, "merchant.not_found"),
+			})
+			return
+		}
+		scope.MerchantID = &merchant.ID
+	}
+
+	user, err := h.service.CreateUser(c.Request.Context(), scope, accountOperator(c), &input)
+	if err != nil {
+		h.fail(c, err, "user.create_failed")
+		return
+	}
+	recordAudit(c, h.audits, services.AuditUserCreate, services.AuditTargetUser, user.ID, user.MerchantID, gin.H{
+		"username": user.Username,
+		"role_ids": input.RoleIDs,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "account.create_success",
+		"msg":     i18n.T(c, "account.create_success"),
+		"data": gin.H{
+			"user_id": user.ID,
+		},
+	})
+}
+
+// GetUser 获取账号详情
+func (h *AccountHandler) GetUser(c *gin.Context) {
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+
+	views, err := h.service.UserViews([]models.User{*user})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data":    views[0],
+	})
+}
+
+// UpdateUserStatus 启用或禁用账号
+func (h *AccountHandler) UpdateUserStatus(c *gin.Context) {
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+
+	var req AccountStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	if err := h.service.SetUserStatus(c.Request.Context(), accountOperator(c), user, *req.Status); err != nil {
+		h.fail(c, err, "account.update_failed")
+		return
+	}
+	recordAudit(c, h.audits, services.AuditUserStatus, services.AuditTargetUser, user.ID, user.MerchantID, gin.H{
+		"username": user.Username,
+		"status":   user.Status,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "account.update_success",
+		"msg":     i18n.T(c, "account.update_success"),
+	})
+}
+
+// ResetUserPassword 重置账号密码
+func (h *AccountHandler) ResetUserPassword(c *gin.Context) {
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	if err := h.service.ResetPassword(c.Request.Context(), accountOperator(c), user, req.Password); err != nil {
+		h.fail(c, err, "account.update_failed")
+		return
+	}
+	recordAudit(c, h.audits, services.AuditUserResetPassword, services.AuditTargetUser, user.ID, user.MerchantID, gin.H{
+		"username": user.Username,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "account.password_reset",
+		"msg":     i18n.T(c, "account.password_reset"),
+	})
+}
+
+// UnlockUser 解除账号的登录锁定
+func (h *AccountHandler) UnlockUser(c *gin.Context) {
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Unlock(c.Request.Context(), accountOperator(c), user); err != nil {
+		h.fail(c, err, "account.update_failed")
+		return
+	}
+	recordAudit(c, h.audits, services.AuditUserUnlock, services.AuditTargetUser, user.ID, user.MerchantID, gin.H{
+		"username": user.Username,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "account.unlocked",
+		"msg":     i18n.T(c, "account.unlocked"),
+	})
+}
+
+// ResetUserTwoFactor 重置账号的两步验证
+func (h *AccountHandler) ResetUserTwoFactor(c *gin.Context) {
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.ResetTwoFactor(c.Request.Context(), accountOperator(c), user); err != nil {
+		h.fail(c, err, "account.update_failed")
+		return
+	}
+	recordAudit(c, h.audits, services.AuditTwoFactorReset, services.AuditTargetUser, user.ID, user.MerchantID, gin.H{
+		"username": user.Username,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "account.two_factor_reset",
+		"msg":     i18n.T(c, "account.two_factor_reset"),
+	})
+}
+
+// AssignUserRoles 替换账号的全部角色
+func (h *AccountHandler) AssignUserRoles(c *gin.Context) {
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+
+	var req AssignRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	if err := h.service.AssignRoles(c.Request.Context(), services.UserScope(user), accountOperator(c), user, req.RoleIDs); err != nil {
+		h.fail(c, err, "account.update_failed")
+		return
+	}
+	recordAudit(c, h.audits, services.AuditUserAssignRoles, services.AuditTargetUser, user.ID, user.MerchantID, gin.H{
+		"username": user.Username,
+		"role_ids": req.RoleIDs,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "account.roles_assigned",
+		"msg":     i18n.T(c, "account.roles_assigned"),
+	})
+}
+
+// DeleteUser 删除账号
+func (h *AccountHandler) DeleteUser(c *gin.Context) {
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteUser(c.Request.Context(), accountOperator(c), user); err != nil {
+		h.fail(c, err, "account.delete_failed")
+		return
+	}
+	recordAudit(c, h.audits, services.AuditUserDelete, services.AuditTargetUser, user.ID, user.MerchantID, gin.H{
+		"username": user.Username,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "account.delete_success",
+		"msg":     i18n.T(c, "account.delete_success"),
+	})
+}
+
+// GetRoles 获取可分配的角色列表，包括内置角色和管理范围内的自定义角色，跨商户管理时需指定merchant_id
+func (h *AccountHandler) GetRoles(c *gin.Context) {
+	var roles []models.Role
+	h.service.Roles(h.scope(c)).Order("builtin desc, id").Find(&roles)
+
+	list, err := h.service.RoleViews(roles)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"list": list,
+		},
+	})
+}
+
+// CreateRole 新建自定义角色，只能包含当前用户拥有的权限
+func (h *AccountHandler) CreateRole(c *gin.Context) {
+	var input services.RoleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	role, err := h.service.CreateRole(c.Request.Context(), h.scope(c), accountOperator(c), &input)
+	if err != nil {
+		h.fail(c, err, "role.create_failed")
+		return
+	}
+	recordAudit(c, h.audits, services.AuditRoleCreate, services.AuditTargetRole, role.ID, role.MerchantID, gin.H{
+		"name":        role.Name,
+		"permissions": input.Permissions,
+		"two_factor":  input.TwoFactor,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "role.create_success",
+		"msg":     i18n.T(c, "role.create_success"),
+		"data": gin.H{
+			"role_id": role.ID,
+		},
+	})
+}
+
+// GetRole 获取角色详情
+func (h *AccountHandler) GetRole(c *gin.Context) {
+	role, ok := h.findRole(c)
+	if !ok {
+		return
+	}
+
+	views, err := h.service.RoleViews([]models.Role{*role})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data":    views[0],
+	})
+}
+
+// UpdateRole 修改自定义角色，拥有该角色的账号立即按新权限校验
+func (h *AccountHandler) UpdateRole(c *gin.Context) {
+	role, ok := h.findRole(c)
+	if !ok {
+		return
+	}
+
+	var input services.RoleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	if err := h.service.UpdateRole(c.Request.Context(), h.scope(c), accountOperator(c), role, &input); err != nil {
+		h.fail(c, err, "role.update_failed")
+		return
+	}
+	recordAudit(c, h.audits, services.AuditRoleUpdate, services.AuditTargetRole, role.ID, role.MerchantID, gin.H{
+		"name":        role.Name,
+		"permissions": input.Permissions,
+		"two_factor":  input.TwoFactor,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "role.update_success",
+		"msg":     i18n.T(c, "role.update_success"),
+	})
+}
+
+// DeleteRole 删除自定义角色
+func (h *AccountHandler) DeleteRole(c *gin.Context) {
+	role, ok := h.findRole(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteRole(c.Request.Context(), h.scope(c), accountOperator(c), role); err != nil {
+		h.fail(c, err, "role.delete_failed")
+		return
+	}
+	recordAudit(c, h.audits, services.AuditRoleDelete, services.AuditTargetRole, role.ID, role.MerchantID, gin.H{
+		"name": role.Name,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "role.delete_success",
+		"msg":     i18n.T(c, "role.delete_success"),
+	})
+}
+
+// findUser 查询管理范围内的账号，不存在时直接输出404
+func (h *AccountHandler) findUser(c *gin.Context) (*models.User, bool) {
+	var user models.User
+	if err := h.service.Users(h.scope(c)).Where("id = ?", c.Param("id")).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "user.not_found",
+			"msg":     i18n.T(c, "user.not_found"),
+		})
+		return nil, false
+	}
+	return &user, true
+}
+
+// findRole 查询管理范围内的角色，不存在时直接输出404
+func (h *AccountHandler) findRole(c *gin.Context) (*models.Role, bool) {
+	var role models.Role
+	if err := h.service.Roles(h.scope(c)).Where("id = ?", c.Param("id")).First(&role).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "role.not_found",
+			"msg":     i18n.T(c, "role.not_found"),
+		})
+		return nil, false
+	}
+	return &role, true
+}
+
+// fail 输出服务层错误，业务校验错误为400，其余为500
+func (h *AccountHandler) fail(c *gin.Context, err error, fallback string) {
+	key, args := i18n.ErrorKey(err, fallback)
+	status := http.StatusBadRequest
+	if key == fallback {
+		status = http.StatusInternalServerError
+	}
+	c.JSON(status, gin.H{
+		"code":    status,
+		"msg_key": key,
+		"msg":     i18n.T(c, key, args...),
+	})
+}
+
+// scope 当前请求的管理范围，跨商户管理时为merchant_id参数指定的商户，未指定时为全部商户
+func (h *AccountHandler) scope(c *gin.Context) services.AccountScope {
+	if !h.tenants {
+		return accountScope(c)
+	}
+	scope := services.AccountScope{UserType: 2}
+	if id, err := strconv.ParseUint(c.Query("merchant_id"), 10, 64); err == nil {
+		merchantID := uint(id)
+		scope.MerchantID = &merchantID
+	}
+	return scope
+}
+
+// accountOperator 当前执行管理操作的用户
+func accountOperator(c *gin.Context) services.AccountOperator {
+	userType, _ := c.Get("userType")
+	operatorType, _ := userType.(int)
+	return services.AccountOperator{UserID: currentUserID(c), UserType: operatorType}
+}
+
+// accountScope 当前用户可管理的账号范围，平台用户管理平台账号，商户用户管理本商户账号
+func accountScope(c *gin.Context) services.AccountScope {
+	if userType, _ := c.Get("userType"); userType == 1 {
+		return services.AccountScope{UserType: 1}
+	}
+	merchantID := currentMerchantID(c)
+	return services.AccountScope{UserType: 2, MerchantID: &merchantID}
+}
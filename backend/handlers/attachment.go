@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/services"
+	"anti-fake-system/storage"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AttachmentHandler struct {
+	db      *gorm.DB
+	cfg     *config.Config
+	service *services.AttachmentService
+}
+
+func NewAttachmentHandler(db *gorm.DB, cfg *config.Config, store storage.Storage) *AttachmentHandler {
+	return &AttachmentHandler{db: db, cfg: cfg, service: services.NewAttachmentService(db, store, cfg)}
+}
+
+// UploadAttachment 上传附件，表单字段为file，同一商户上传相同内容的文件时返回已有附件
+func (h *AttachmentHandler) UploadAttachment(c *gin.Context) {
+	// 请求体在附件上限之外预留1MB给表单边界和其他字段
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.service.MaxUploadSize()+1<<20)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		key := "attachment.file_required"
+		var args []interface{}
+		if errors.As(err, &maxBytesErr) {
+			key, args = "attachment.file_too_large", []interface{}{h.cfg.Storage.MaxUploadSize}
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
+		})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "attachment.upload_failed",
+			"msg":     i18n.T(c, "attachment.upload_failed"),
+		})
+		return
+	}
+	defer file.Close()
+
+	// 多读1字节用于判断是否超过大小限制
+	data, err := io.ReadAll(io.LimitReader(file, h.service.MaxUploadSize()+1))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "attachment.upload_failed",
+			"msg":     i18n.T(c, "attachment.upload_failed"),
+		})
+		return
+	}
+
+	attachment, reused, err := h.service.Upload(c.Request.Context(), currentMerchantID(c), currentUserID(c), fileHeader.Filename, data)
+	if err != nil {
+		key, args := i18n.ErrorKey(err, "attachment.upload_failed")
+		status := http.StatusBadRequest
+		if key == "attachment.upload_failed" {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{
+			"code":    status,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "attachment.upload_success",
+		"msg":     i18n.T(c, "attachment.upload_success"),
+		"data":    h.view(attachment, reused),
+	})
+}
+
+// GetAttachments 获取当前商户的附件列表
+func (h *AttachmentHandler) GetAttachments(c *gin.Context) {
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+	contentType := c.Query("content_type")
+
+	query := h.db.Model(&models.Attachment{}).Where("merchant_id = ?", currentMerchantID(c))
+	if contentType != "" {
+		query = query.Where("content_type = ?", contentType)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var attachments []models.Attachment
+	offset := (page - 1) * size
+	query.Offset(offset).Limit(size).Order("id desc").Find(&attachments)
+
+	list := make([]gin.H, len(attachments))
+	for i := range attachments {
+		list[i] = h.view(&attachments[i], false)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"total": total,
+			"page":  page,
+			"size":  size,
+			"list":  list,
+		},
+	})
+}
+
+// GetAttachment 获取附件详情和新的下载链接
+func (h *AttachmentHandler) GetAttachment(c *gin.Context) {
+	attachment, ok := h.findAttachment(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data":    h.view(attachment, false),
+	})
+}
+
+// DeleteAttachment 删除附件，已引用该附件的溯源信息将无法再下载
+func (h *AttachmentHandler) DeleteAttachment(c *gin.Context) {
+	attachment, ok := h.findAttachment(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Delete(c.Request.Context(), attachment); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "attachment.delete_failed",
+			"msg":     i18n.T(c, "attachment.delete_failed"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "attachment.delete_success",
+		"msg":     i18n.T(c, "attachment.delete_success"),
+	})
+}
+
+// DownloadAttachment 通过签名链接下载附件，无需登录，链接过期后需重新获取
+func (h *AttachmentHandler) DownloadAttachment(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	variant := c.DefaultQuery("variant", services.AttachmentVariantOriginal)
+	expires, _ := strconv.ParseInt(c.Query("expires"), 10, 64)
+
+	if !h.service.VerifyURL(uint(id), variant, expires, c.Query("signature")) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    403,
+			"msg_key": "attachment.link_invalid",
+			"msg":     i18n.T(c, "attachment.link_invalid"),
+		})
+		return
+	}
+
+	var attachment models.Attachment
+	if err := h.db.First(&attachment, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "attachment.not_found",
+			"msg":     i18n.T(c, "attachment.not_found"),
+		})
+		return
+	}
+
+	reader, contentType, err := h.service.Open(c.Request.Context(), &attachment, variant)
+	if err != nil {
+		status, key := http.StatusInternalServerError, "attachment.download_failed"
+		if errors.Is(err, storage.ErrNotFound) {
+			status, key = http.StatusNotFound, "attachment.not_found"
+		} else if k, _ := i18n.ErrorKey(err, ""); k != "" {
+			status, key = http.StatusNotFound, k
+		}
+		c.JSON(status, gin.H{
+			"code":    status,
+			"msg_key": key,
+			"msg":     i18n.T(c, key),
+		})
+		return
+	}
+	defer reader.Close()
+
+	contentLength := attachment.Size
+	if variant == services.AttachmentVariantThumbnail {
+		contentLength = -1
+	}
+	c.DataFromReader(http.StatusOK, contentLength, contentType, reader, map[string]string{
+		"Content-Disposition":    mime.FormatMediaType("inline", map[string]string{"filename": attachment.FileName}),
+		"Cache-Control":          "private, max-age=" + strconv.FormatInt(max(expires-time.Now().Unix(), 0), 10),
+		"X-Content-Type-Options": "nosniff",
+	})
+}
+
+// view 附件响应数据，附带签名下载链接
+func (h *AttachmentHandler) view(attachment *models.Attachment, reused bool) gin.H {
+	data := gin.H{
+		"id":           attachment.ID,
+		"file_name":    attachment.FileName,
+		"content_type": attachment.ContentType,
+		"size":         attachment.Size,
+		"hash":         attachment.Hash,
+		"width":        attachment.Width,
+		"height":       attachment.Height,
+		"created_at":   attachment.CreatedAt,
+		"reused":       reused,
+	}
+	for key, value := range h.service.URLs(attachment) {
+		data[key] = value
+	}
+	return data
+}
+
+// findAttachment 查询当前商户的附件，不存在时直接输出404
+func (h *AttachmentHandler) findAttachment(c *gin.Context) (*models.Attachment, bool) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	attachment, err := h.service.Find(currentMerchantID(c), uint(id))
+	if err != nil || attachment == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "attachment.not_found",
+			"msg":     i18n.T(c, "attachment.not_found"),
+		})
+		return nil, false
+	}
+	return attachment, true
+}
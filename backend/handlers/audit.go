@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AuditHandler struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+func NewAuditHandler(db *gorm.DB, cfg *config.Config) *AuditHandler {
+	return &AuditHandler{db: db, cfg: cfg}
+}
+
+// GetAuditLogs 获取审计日志，支持operator_id、merchant_id、action、target_type、target_id、start_date、end_date筛选
+func (h *AuditHandler) GetAuditLogs(c *gin.Context) {
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	query := h.db.Model(&models.AuditLog{})
+	for _, field := range []string{"operator_id", "merchant_id", "action", "target_type", "target_id"} {
+		if value := c.Query(field); value != "" {
+			query = query.Where(field+" = ?", value)
+		}
+	}
+	if startDate := c.Query("start_date"); startDate != "" {
+		query = query.Where("created_at >= ?", startDate)
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		query = query.Where("created_at < DATE_ADD(?, INTERVAL 1 DAY)", endDate)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var logs []models.AuditLog
+	offset := (page - 1) * size
+	query.Offset(offset).Limit(size).Order("id desc").Find(&logs)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"total": total,
+			"page":  page,
+			"size":  size,
+			"list":  logs,
+		},
+	})
+}
+
+// recordAudit 以当前登录用户为操作人写入审计日志
+func recordAudit(c *gin.Context, audits *services.AuditService, action, targetType string, targetID uint, merchantID *uint, detail interface{}) {
+	username, _ := c.Get("username")
+	userType, _ := c.Get("userType")
+	operatorName, _ := username.(string)
+	operatorType, _ := userType.(int)
+
+	audits.Record(&models.AuditLog{
+		OperatorID:   currentUserID(c),
+		OperatorName: operatorName,
+		OperatorType: operatorType,
+		MerchantID:   merchantID,
+		Action:       action,
+		TargetType:   targetType,
+		TargetID:     targetID,
+		IPAddress:    c.ClientIP(),
+	}, detail)
+}
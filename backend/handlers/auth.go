@@ -2,9 +2,13 @@ package handlers
 
 import (
 	"anti-fake-system/config"
+	"anti-fake-system/i18n"
 	"anti-fake-system/models"
-	"anti-fake-system/utils"
+	"anti-fake-system/services"
+	"math"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -13,12 +17,16 @@ import (
 )
 
 type AuthHandler struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db        *gorm.DB
+	cfg       *config.Config
+	tokens    *services.TokenService
+	guard     *services.LoginGuard
+	twoFactor *services.TwoFactorService
+	audits    *services.AuditService
 }
 
-func NewAuthHandler(db *gorm.DB, cfg *config.Config) *AuthHandler {
-	return &AuthHandler{db: db, cfg: cfg}
+func NewAuthHandler(db *gorm.DB, cfg *config.Config, tokens *services.TokenService, guard *services.LoginGuard, twoFactor *services.TwoFactorService) *AuthHandler {
+	return &AuthHandler{db: db, cfg: cfg, tokens: tokens, guard: guard, twoFactor: twoFactor, audits: services.NewAuditService(db)}
 }
 
 // LoginRequest 登录请求
@@ -27,73 +35,36 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
-// LoginResponse 登录响应
+// LoginResponse 登录响应，token为访问令牌，过期后用refresh_token换取新令牌
 type LoginResponse struct {
-	Token      string    `json:"token"`
-	UserID     uint      `json:"user_id"`
-	Username   string    `json:"username"`
-	UserType   int       `json:"user_type"`
-	MerchantID *uint     `json:"merchant_id,omitempty"`
-	ExpireAt   time.Time `json:"expire_at"`
+	services.TokenPair
+	UserID     uint   `json:"user_id"`
+	Username   string `json:"username"`
+	UserType   int    `json:"user_type"`
+	MerchantID *uint  `json:"merchant_id,omitempty"`
+	// 登录时按角色要求绑定两步验证后生成的恢复码，只返回这一次
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
 }
 
-// Login 用户登录
-func (h *AuthHandler) Login(c *gin.Context) {
-	var req LoginRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "请求参数错误",
-		})
-		return
-	}
-
-	// 查询用户
-	var user models.User
-	if err := h.db.Where("username = ? AND status = 1", req.Username).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"code": 401,
-			"msg":  "用户名或密码错误",
-		})
-		return
-	}
-
-	// 验证密码
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"code": 401,
-			"msg":  "用户名或密码错误",
-		})
-		return
-	}
+// RefreshRequest 刷新令牌请求
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
 
-	// 生成JWT令牌
-	token, err := utils.GenerateToken(user.ID, user.Username, user.UserType, user.MerchantID, h.cfg)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code": 500,
-			"msg":  "令牌生成失败",
-		})
-		return
-	}
+// LogoutRequest 注销请求，提供刷新令牌时同时吊销该次登录的刷新令牌
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
 
-	// 更新最后登录时间
-	now := time.Now()
-	h.db.Model(&user).Update("last_login_at", now)
+// ChangePasswordRequest 修改密码请求
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=8,max=72"`
+}
 
-	// 返回登录结果
-	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "登录成功",
-		"data": LoginResponse{
-			Token:      token,
-			UserID:     user.ID,
-			Username:   user.Username,
-			UserType:   user.UserType,
-			MerchantID: user.MerchantID,
-			ExpireAt:   now.Add(time.Hour * time.Duration(h.cfg.JWT.Expire)),
-		},
-	})
+// Login 用户登录
+func (h *AuthHandler) Login(c *gin.Context) {
+	h.login(c, 0)
 }
 
 // RegisterRequest 注册请求
@@ -111,8 +82,9 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "请求参数错误",
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
 		})
 		return
 	}
@@ -121,8 +93,9 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	var existingUser models.User
 	if err := h.db.Where("username = ?", req.Username).First(&existingUser).Error; err == nil {
 		c.JSON(http.StatusConflict, gin.H{
-			"code": 409,
-			"msg":  "用户名已存在",
+			"code":    409,
+			"msg_key": "user.username_exists",
+			"msg":     i18n.T(c, "user.username_exists"),
 		})
 		return
 	}
@@ -130,8 +103,9 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	// 商户用户必须指定商户ID
 	if req.UserType == 2 && req.MerchantID == nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "商户用户必须指定商户ID",
+			"code":    400,
+			"msg_key": "user.merchant_required",
+			"msg":     i18n.T(c, "user.merchant_required"),
 		})
 		return
 	}
@@ -141,8 +115,9 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		var merchant models.Merchant
 		if err := h.db.First(&merchant, *req.MerchantID).Error; err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
-				"code": 400,
-				"msg":  "商户不存在",
+				"code":    400,
+				"msg_key": "merchant.not_found",
+				"msg":     i18n.T(c, "merchant.not_found"),
 			})
 			return
 		}
@@ -152,8 +127,9 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"code": 500,
-			"msg":  "密码加密失败",
+			"code":    500,
+			"msg_key": "user.password_hash_failed",
+			"msg":     i18n.T(c, "user.password_hash_failed"),
 		})
 		return
 	}
@@ -171,15 +147,17 @@ func (h *AuthHandler) Register(c *gin.Context) {
 
 	if err := h.db.Create(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"code": 500,
-			"msg":  "用户创建失败",
+			"code":    500,
+			"msg_key": "user.create_failed",
+			"msg":     i18n.T(c, "user.create_failed"),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "注册成功",
+		"code":    200,
+		"msg_key": "user.register_success",
+		"msg":     i18n.T(c, "user.register_success"),
 		"data": gin.H{
 			"user_id": user.ID,
 		},
@@ -188,162 +166,252 @@ func (h *AuthHandler) Register(c *gin.Context) {
 
 // PlatformLogin 平台用户登录
 func (h *AuthHandler) PlatformLogin(c *gin.Context) {
+	h.login(c, 1)
+}
+
+// MerchantLogin 商户用户登录
+func (h *AuthHandler) MerchantLogin(c *gin.Context) {
+	h.login(c, 2)
+}
+
+// login 校验用户名和密码并签发令牌，userType为0时不限用户类型
+// 同一用户名或IP失败过多时在等待期或锁定期内直接拒绝，用户名不存在与密码错误的处理和响应相同。
+func (h *AuthHandler) login(c *gin.Context, userType int) {
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "请求参数错误",
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
 		})
 		return
 	}
 
-	// 查询平台用户
+	ctx := c.Request.Context()
+	if wait := h.guard.Check(ctx, req.Username, c.ClientIP()); wait > 0 {
+		tooManyAttempts(c, wait)
+		return
+	}
+
+	// 查询用户
+	query := h.db.Where("username = ? AND status = 1", req.Username)
+	if userType != 0 {
+		query = query.Where("user_type = ?", userType)
+	}
 	var user models.User
-	if err := h.db.Where("username = ? AND user_type = 1 AND status = 1", req.Username).First(&user).Error; err != nil {
+	if err := query.First(&user).Error; err != nil {
+		// 用户不存在时同样比较一次密码，响应时间与密码错误一致
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(req.Password))
+		h.guard.Fail(ctx, req.Username, c.ClientIP(), nil)
 		c.JSON(http.StatusUnauthorized, gin.H{
-			"code": 401,
-			"msg":  "用户名或密码错误",
+			"code":    401,
+			"msg_key": "auth.invalid_credentials",
+			"msg":     i18n.T(c, "auth.invalid_credentials"),
 		})
 		return
 	}
 
 	// 验证密码
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		h.guard.Fail(ctx, req.Username, c.ClientIP(), &user)
 		c.JSON(http.StatusUnauthorized, gin.H{
-			"code": 401,
-			"msg":  "用户名或密码错误",
+			"code":    401,
+			"msg_key": "auth.invalid_credentials",
+			"msg":     i18n.T(c, "auth.invalid_credentials"),
+		})
+		return
+	}
+
+	// 已启用或角色要求两步验证的账号先签发两步验证令牌，提交验证码后才签发访问令牌
+	status, err := h.twoFactor.Status(&user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
 		})
 		return
 	}
+	if status.Enabled || status.Required {
+		h.challenge(c, &user, !status.Enabled)
+		return
+	}
+
+	h.guard.Succeed(ctx, req.Username)
+	h.completeLogin(c, &user, nil)
+}
 
-	// 生成JWT令牌
-	token, err := utils.GenerateToken(user.ID, user.Username, user.UserType, user.MerchantID, h.cfg)
+// completeLogin 签发访问令牌和刷新令牌并返回登录结果
+func (h *AuthHandler) completeLogin(c *gin.Context, user *models.User, recoveryCodes []string) {
+	tokens, err := h.tokens.Issue(user, c.ClientIP(), c.Request.UserAgent())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"code": 500,
-			"msg":  "令牌生成失败",
+			"code":    500,
+			"msg_key": "auth.token_generate_failed",
+			"msg":     i18n.T(c, "auth.token_generate_failed"),
 		})
 		return
 	}
 
 	// 更新最后登录时间
 	now := time.Now()
-	h.db.Model(&user).Update("last_login_at", now)
+	h.db.Model(user).Update("last_login_at", now)
 
+	// 返回登录结果
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "登录成功",
+		"code":    200,
+		"msg_key": "auth.login_success",
+		"msg":     i18n.T(c, "auth.login_success"),
 		"data": LoginResponse{
-			Token:      token,
-			UserID:     user.ID,
-			Username:   user.Username,
-			UserType:   user.UserType,
-			MerchantID: user.MerchantID,
-			ExpireAt:   now.Add(time.Hour * time.Duration(h.cfg.JWT.Expire)),
+			TokenPair:     *tokens,
+			UserID:        user.ID,
+			Username:      user.Username,
+			UserType:      user.UserType,
+			MerchantID:    user.MerchantID,
+			RecoveryCodes: recoveryCodes,
 		},
 	})
 }
 
-// MerchantLogin 商户用户登录
-func (h *AuthHandler) MerchantLogin(c *gin.Context) {
-	var req LoginRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "请求参数错误",
-		})
-		return
-	}
+// tooManyAttempts 输出登录尝试过于频繁的响应，Retry-After为需要等待的秒数
+func tooManyAttempts(c *gin.Context, wait time.Duration) {
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"code":    429,
+		"msg_key": "auth.too_many_attempts",
+		"msg":     i18n.T(c, "auth.too_many_attempts", retryAfter),
+		"data": gin.H{
+			"retry_after": retryAfter,
+		},
+	})
+}
 
-	// 查询商户用户
-	var user models.User
-	if err := h.db.Where("username = ? AND user_type = 2 AND status = 1", req.Username).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"code": 401,
-			"msg":  "用户名或密码错误",
-		})
-		return
-	}
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
 
-	// 验证密码
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"code": 401,
-			"msg":  "用户名或密码错误",
+// dummyPasswordHash 返回用于用户不存在时比较的bcrypt哈希，成本与真实密码相同
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("anti-fake-system-dummy-password"), bcrypt.DefaultCost)
+	})
+	return dummyHash
+}
+
+// RefreshToken 使用刷新令牌换取新的访问令牌和刷新令牌，原刷新令牌立即失效
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
 		})
 		return
 	}
 
-	// 生成JWT令牌
-	token, err := utils.GenerateToken(user.ID, user.Username, user.UserType, user.MerchantID, h.cfg)
+	tokens, user, err := h.tokens.Refresh(c.Request.Context(), req.RefreshToken, c.ClientIP(), c.Request.UserAgent())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code": 500,
-			"msg":  "令牌生成失败",
+		key, args := i18n.ErrorKey(err, "auth.token_generate_failed")
+		status := http.StatusUnauthorized
+		if key == "auth.token_generate_failed" {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{
+			"code":    status,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
 		})
 		return
 	}
 
-	// 更新最后登录时间
-	now := time.Now()
-	h.db.Model(&user).Update("last_login_at", now)
-
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "登录成功",
+		"code":    200,
+		"msg_key": "auth.token_refreshed",
+		"msg":     i18n.T(c, "auth.token_refreshed"),
 		"data": LoginResponse{
-			Token:      token,
+			TokenPair:  *tokens,
 			UserID:     user.ID,
 			Username:   user.Username,
 			UserType:   user.UserType,
 			MerchantID: user.MerchantID,
-			ExpireAt:   now.Add(time.Hour * time.Duration(h.cfg.JWT.Expire)),
 		},
 	})
 }
 
-// RefreshToken 刷新令牌
-func (h *AuthHandler) RefreshToken(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"code": 401,
-			"msg":  "未认证",
+// Logout 注销当前登录，访问令牌立即失效
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req LogoutRequest
+	c.ShouldBindJSON(&req)
+
+	tokenID, _ := c.Get("tokenID")
+	expiresAt, _ := c.Get("tokenExpiresAt")
+	jti, _ := tokenID.(string)
+	expiry, _ := expiresAt.(time.Time)
+	h.tokens.Logout(c.Request.Context(), currentUserID(c), jti, expiry, req.RefreshToken)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "auth.logout_success",
+		"msg":     i18n.T(c, "auth.logout_success"),
+	})
+}
+
+// ChangePassword 修改当前用户的密码，成功后该用户的全部令牌失效，需要重新登录
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
 		})
 		return
 	}
 
-	// 查询用户信息
 	var user models.User
-	if err := h.db.First(&user, userID).Error; err != nil {
+	if err := h.db.First(&user, currentUserID(c)).Error; err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{
-			"code": 401,
-			"msg":  "用户不存在",
+			"code":    401,
+			"msg_key": "auth.user_not_found",
+			"msg":     i18n.T(c, "auth.user_not_found"),
 		})
 		return
 	}
 
-	// 生成新的JWT令牌
-	token, err := utils.GenerateToken(user.ID, user.Username, user.UserType, user.MerchantID, h.cfg)
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.OldPassword)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "auth.old_password_incorrect",
+			"msg":     i18n.T(c, "auth.old_password_incorrect"),
+		})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"code": 500,
-			"msg":  "令牌生成失败",
+			"code":    500,
+			"msg_key": "user.password_hash_failed",
+			"msg":     i18n.T(c, "user.password_hash_failed"),
+		})
+		return
+	}
+	if err := h.db.Model(&user).Update("password", string(hashedPassword)).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
 		})
 		return
 	}
+	h.tokens.RevokeUser(c.Request.Context(), user.ID)
 
-	now := time.Now()
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "令牌刷新成功",
-		"data": LoginResponse{
-			Token:      token,
-			UserID:     user.ID,
-			Username:   user.Username,
-			UserType:   user.UserType,
-			MerchantID: user.MerchantID,
-			ExpireAt:   now.Add(time.Hour * time.Duration(h.cfg.JWT.Expire)),
-		},
+		"code":    200,
+		"msg_key": "auth.password_changed",
+		"msg":     i18n.T(c, "auth.password_changed"),
 	})
 }
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type BatchHandler struct {
+	db      *gorm.DB
+	cfg     *config.Config
+	records *services.VerifyRecordRepository
+	service *services.BatchService
+}
+
+func NewBatchHandler(db *gorm.DB, cfg *config.Config, records *services.VerifyRecordRepository) *BatchHandler {
+	return &BatchHandler{db: db, cfg: cfg, records: records, service: services.NewBatchService(db, cfg)}
+}
+
+// GetBatches 获取商品批次列表
+func (h *BatchHandler) GetBatches(c *gin.Context) {
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	var total int64
+	h.batchQuery(c).Count(&total)
+
+	var batches []models.ProductBatch
+	offset := (page - 1) * size
+	h.batchQuery(c).Offset(offset).Limit(size).Order("id desc").Find(&batches)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"total": total,
+			"page":  page,
+			"size":  size,
+			"list":  batches,
+		},
+	})
+}
+
+// CreateBatch 创建批次，未填写批次标识时按配置的模式根据生产日期自动生成
+func (h *BatchHandler) CreateBatch(c *gin.Context) {
+	var input services.BatchInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	batch, err := h.service.Create(currentMerchantID(c), &input)
+	if err != nil {
+		key, args := i18n.ErrorKey(err, "batch.create_failed")
+		status := http.StatusBadRequest
+		if key == "batch.create_failed" {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{
+			"code":    status,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "batch.create_success",
+		"msg":     i18n.T(c, "batch.create_success"),
+		"data": gin.H{
+			"batch_id":   batch.ID,
+			"batch_code": batch.BatchCode,
+		},
+	})
+}
+
+// GetBatch 获取批次详情及对账结果
+func (h *BatchHandler) GetBatch(c *gin.Context) {
+	var batch models.ProductBatch
+	if err := h.db.Where("id = ? AND merchant_id = ?", c.Param("id"), currentMerchantID(c)).First(&batch).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "batch.not_found",
+			"msg":     i18n.T(c, "batch.not_found"),
+		})
+		return
+	}
+
+	list, err := services.Reconcile(h.db, h.records, []models.ProductBatch{batch})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"batch":          batch,
+			"reconciliation": list[0],
+		},
+	})
+}
+
+// GetReconciliation 分页对账批次的计划、已生成、已激活、已作废和已验证数量，筛选条件与批次列表相同
+func (h *BatchHandler) GetReconciliation(c *gin.Context) {
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	var total int64
+	h.batchQuery(c).Count(&total)
+
+	var batches []models.ProductBatch
+	offset := (page - 1) * size
+	h.batchQuery(c).Offset(offset).Limit(size).Order("id desc").Find(&batches)
+
+	list, err := services.Reconcile(h.db, h.records, batches)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"total": total,
+			"page":  page,
+			"size":  size,
+			"list":  list,
+		},
+	})
+}
+
+// batchQuery 按product_id、batch_code、production_line和生产日期范围筛选当前商户的批次
+func (h *BatchHandler) batchQuery(c *gin.Context) *gorm.DB {
+	query := h.db.Model(&models.ProductBatch{}).Where("merchant_id = ?", currentMerchantID(c))
+	if productID := c.Query("product_id"); productID != "" {
+		query = query.Where("product_id = ?", productID)
+	}
+	if batchCode := c.Query("batch_code"); batchCode != "" {
+		query = query.Where("batch_code LIKE ?", "%"+batchCode+"%")
+	}
+	if line := c.Query("production_line"); line != "" {
+		query = query.Where("production_line = ?", line)
+	}
+	if startDate := c.Query("start_date"); startDate != "" {
+		query = query.Where("production_date >= ?", startDate)
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		query = query.Where("production_date < DATE_ADD(?, INTERVAL 1 DAY)", endDate)
+	}
+	return query
+}
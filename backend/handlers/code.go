@@ -2,22 +2,35 @@ package handlers
 
 import (
 	"anti-fake-system/config"
+	"anti-fake-system/i18n"
 	"anti-fake-system/models"
 	"anti-fake-system/services"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type CodeHandler struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db         *gorm.DB
+	cfg        *config.Config
+	keys       *services.KeyManager
+	dispatcher *services.WebhookDispatcher
+	audits     *services.AuditService
 }
 
-func NewCodeHandler(db *gorm.DB, cfg *config.Config) *CodeHandler {
-	return &CodeHandler{db: db, cfg: cfg}
+func NewCodeHandler(db *gorm.DB, cfg *config.Config, keys *services.KeyManager, dispatcher *services.WebhookDispatcher) *CodeHandler {
+	return &CodeHandler{db: db, cfg: cfg, keys: keys, dispatcher: dispatcher, audits: services.NewAuditService(db)}
 }
 
 // GenerateRequest 生成防伪码请求
@@ -30,9 +43,10 @@ type GenerateRequest struct {
 
 // GenerateResponse 生成防伪码响应
 type GenerateResponse struct {
-	Total     int      `json:"total"`      // 生成总数
-	Codes     []string `json:"codes"`      // 生成的防伪码（前100条）
-	BatchCode string   `json:"batch_code"` // 批次标识
+	Total      int      `json:"total"`                 // 生成总数
+	Codes      []string `json:"codes"`                 // 生成的防伪码（前100条）
+	BatchCode  string   `json:"batch_code"`            // 批次标识
+	PrintToken string   `json:"print_token,omitempty"` // 印刷文件下载令牌，仅启用刮开PIN时返回
 }
 
 // GenerateCodes 生成防伪码
@@ -40,8 +54,9 @@ func (h *CodeHandler) GenerateCodes(c *gin.Context) {
 	var req GenerateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "请求参数错误",
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
 		})
 		return
 	}
@@ -54,8 +69,9 @@ func (h *CodeHandler) GenerateCodes(c *gin.Context) {
 		var rule models.SecurityCodeRule
 		if err := h.db.Where("id = ? AND merchant_id = ?", req.RuleID, merchantID).First(&rule).Error; err != nil {
 			c.JSON(http.StatusForbidden, gin.H{
-				"code": 403,
-				"msg":  "无权限使用此规则",
+				"code":    403,
+				"msg_key": "rule.forbidden",
+				"msg":     i18n.T(c, "rule.forbidden"),
 			})
 			return
 		}
@@ -65,31 +81,31 @@ func (h *CodeHandler) GenerateCodes(c *gin.Context) {
 	var rule models.SecurityCodeRule
 	if err := h.db.First(&rule, req.RuleID).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "规则不存在",
+			"code":    400,
+			"msg_key": "rule.not_found",
+			"msg":     i18n.T(c, "rule.not_found"),
 		})
 		return
 	}
 
-	// 查询批次信息
+	// 查询批次信息，批次必须与规则属于同一商户
 	var batch models.ProductBatch
-	if err := h.db.First(&batch, req.BatchID).Error; err != nil {
+	if err := h.db.Where("id = ? AND merchant_id = ?", req.BatchID, rule.MerchantID).First(&batch).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "批次不存在",
+			"code":    400,
+			"msg_key": "batch.not_found",
+			"msg":     i18n.T(c, "batch.not_found"),
 		})
 		return
 	}
 
-	// 解密规则配置
-	encryptionKey := []byte(h.cfg.JWT.Secret) // 使用JWT密钥作为加密密钥
-	generator := services.NewCodeGenerator(nil, encryptionKey)
-
-	var ruleConfig services.RuleConfig
-	if err := generator.DecryptRuleConfig(rule.RuleConfig); err != nil {
+	// 使用规则所属商户的数据加密密钥解密规则配置
+	ruleConfig, err := h.keys.DecryptRuleConfig(rule.MerchantID, rule.RuleConfig)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"code": 500,
-			"msg":  "规则配置解析失败",
+			"code":    500,
+			"msg_key": "rule.parse_failed",
+			"msg":     i18n.T(c, "rule.parse_failed"),
 		})
 		return
 	}
@@ -98,7 +114,21 @@ func (h *CodeHandler) GenerateCodes(c *gin.Context) {
 	ruleConfig.BatchCode = batch.BatchCode
 
 	// 创建生成器
-	generator = services.NewCodeGenerator(&ruleConfig, encryptionKey)
+	generator := services.NewCodeGenerator(ruleConfig)
+
+	// 签名规则使用商户当前签名密钥对每个防伪码签名
+	if ruleConfig.Signed {
+		signer, err := services.NewSigningKeyService(h.db, h.cfg, h.keys).Signer(batch.MerchantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    500,
+				"msg_key": "signing_key.load_failed",
+				"msg":     i18n.T(c, "signing_key.load_failed"),
+			})
+			return
+		}
+		generator.SetSigner(signer)
+	}
 
 	// 生成防伪码
 	startSeq := req.StartSeq
@@ -109,19 +139,79 @@ func (h *CodeHandler) GenerateCodes(c *gin.Context) {
 	codes, err := generator.GenerateBatch(startSeq, req.Quantity)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"code": 500,
-			"msg":  "防伪码生成失败",
+			"code":    500,
+			"msg_key": "code.generate_failed",
+			"msg":     i18n.T(c, "code.generate_failed"),
 		})
 		return
 	}
 
-	// 保存防伪码到数据库（这里简化处理，实际应该分表存储）
-	// 实际实现中需要根据分表策略存储到不同的表中
+	// 组装防伪码记录，规则启用刮开PIN时为每个码生成PIN，数据库只保存哈希
+	records := make([]models.SecurityCode, len(codes))
+	var pins []string
+	if ruleConfig.Pin != nil {
+		pins = make([]string, len(codes))
+	}
+	for i, code := range codes {
+		records[i] = models.SecurityCode{
+			Code:       code,
+			MerchantID: batch.MerchantID,
+			RuleID:     rule.ID,
+			BatchID:    batch.ID,
+			Sequence:   startSeq + i,
+			Status:     1,
+		}
+		if ruleConfig.Pin != nil {
+			pin, err := services.GeneratePin(ruleConfig.Pin)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"code":    500,
+					"msg_key": "code.pin_generate_failed",
+					"msg":     i18n.T(c, "code.pin_generate_failed"),
+				})
+				return
+			}
+			pins[i] = pin
+			records[i].PinHash = services.HashPin(code, pin, h.cfg.Code.PinPepper)
+		}
+	}
+
+	// 保存防伪码到数据库
+	// PIN明文只写入印刷文件，不出现在接口响应和导出文件中；印刷文件在事务提交前写入，
+	// 写入失败时回滚本批防伪码，避免出现已入库但PIN无法印刷的防伪码。
+	var printToken string
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(&records, 1000).Error; err != nil {
+			return err
+		}
+		if pins == nil {
+			return nil
+		}
+		token, err := writePrintFile(h.cfg.Code.PrintDir, batch.MerchantID, batch.BatchCode, codes, pins)
+		if err != nil {
+			return i18n.NewError("code.print_file_failed")
+		}
+		printToken = token
+		return nil
+	}); err != nil {
+		// 提交失败时删除已写入的印刷文件
+		if printToken != "" {
+			os.Remove(printFilePath(h.cfg.Code.PrintDir, batch.MerchantID, printToken))
+		}
+		key, args := i18n.ErrorKey(err, "code.save_failed")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
+		})
+		return
+	}
 
 	// 返回生成结果
 	response := GenerateResponse{
-		Total:     len(codes),
-		BatchCode: batch.BatchCode,
+		Total:      len(codes),
+		BatchCode:  batch.BatchCode,
+		PrintToken: printToken,
 	}
 
 	// 只返回前100条防伪码预览
@@ -132,9 +222,10 @@ func (h *CodeHandler) GenerateCodes(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "防伪码生成成功",
-		"data": response,
+		"code":    200,
+		"msg_key": "code.generate_success",
+		"msg":     i18n.T(c, "code.generate_success"),
+		"data":    response,
 	})
 }
 
@@ -148,7 +239,7 @@ func (h *CodeHandler) GetCodes(c *gin.Context) {
 	size, _ := strconv.Atoi(c.DefaultQuery("size", "20"))
 
 	// 构建查询条件
-	query := h.db.Model(&models.VerificationRecord{})
+	query := h.db.Model(&models.SecurityCode{})
 
 	// 添加商户过滤条件（非平台管理员）
 	merchantID, exists := c.Get("merchantID")
@@ -170,56 +261,281 @@ func (h *CodeHandler) GetCodes(c *gin.Context) {
 	var total int64
 	query.Count(&total)
 
-	var records []models.VerificationRecord
+	var codes []models.SecurityCode
 	offset := (page - 1) * size
-	query.Offset(offset).Limit(size).Find(&records)
+	query.Order("id asc").Offset(offset).Limit(size).Find(&codes)
 
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "查询成功",
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
 		"data": gin.H{
 			"total": total,
 			"page":  page,
 			"size":  size,
-			"list":  records,
+			"list":  codes,
 		},
 	})
 }
 
-// BatchUpdateStatus 批量更新防伪码状态
+// BatchUpdateStatusRequest 批量更新防伪码状态请求
+type BatchUpdateStatusRequest struct {
+	IDs    []uint `json:"ids" binding:"required,min=1,max=1000"` // 防伪码ID
+	Status *int   `json:"status" binding:"required,oneof=0 1"`   // 0-作废, 1-恢复正常
+}
+
+// BatchUpdateStatus 批量作废或恢复防伪码，已召回的防伪码由召回管理，不在此修改
+// 作废时为每个被作废的防伪码推送一条 code.voided 事件。
 func (h *CodeHandler) BatchUpdateStatus(c *gin.Context) {
-	// 实现批量状态更新逻辑
+	var req BatchUpdateStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	// 锁定需要变更的防伪码后再更新，确保推送的事件与实际变更的防伪码一致
+	merchantID := currentMerchantID(c)
+	var changed []models.SecurityCode
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "code", "batch_id").
+			Where("merchant_id = ? AND id IN ? AND status IN ?", merchantID, req.IDs, []int{services.CodeStatusVoid, services.CodeStatusNormal}).
+			Where("status <> ?", *req.Status).
+			Find(&changed).Error; err != nil {
+			return err
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, len(changed))
+		for i, code := range changed {
+			ids[i] = code.ID
+		}
+		return tx.Model(&models.SecurityCode{}).Where("id IN ?", ids).Update("status", *req.Status).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+	recordAudit(c, h.audits, services.AuditCodeBatchStatus, services.AuditTargetCode, 0, &merchantID, gin.H{
+		"ids":     req.IDs,
+		"status":  *req.Status,
+		"updated": len(changed),
+	})
+
+	if *req.Status == services.CodeStatusVoid {
+		now := time.Now()
+		events := make([]interface{}, len(changed))
+		for i, code := range changed {
+			events[i] = gin.H{
+				"code_id":       code.ID,
+				"security_code": code.Code,
+				"batch_id":      code.BatchID,
+				"voided_at":     now,
+			}
+		}
+		h.dispatcher.PublishEach(merchantID, services.EventCodeVoided, events)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "状态更新成功",
+		"code":    200,
+		"msg_key": "code.status_updated",
+		"msg":     i18n.T(c, "code.status_updated"),
+		"data": gin.H{
+			"updated": len(changed),
+		},
 	})
 }
 
 // GetCodeDetail 获取防伪码详情
 func (h *CodeHandler) GetCodeDetail(c *gin.Context) {
 	id := c.Param("id")
+	merchantID, _ := c.Get("merchantID")
 
-	var record models.VerificationRecord
-	if err := h.db.First(&record, id).Error; err != nil {
+	var code models.SecurityCode
+	if err := h.db.Where("id = ? AND merchant_id = ?", id, merchantID).First(&code).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
-			"code": 404,
-			"msg":  "防伪码不存在",
+			"code":    404,
+			"msg_key": "code.not_found",
+			"msg":     i18n.T(c, "code.not_found"),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "查询成功",
-		"data": record,
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"security_code": code,
+			"has_pin":       code.PinHash != "",
+		},
 	})
 }
 
 // ExportCodes 导出防伪码
+// 导出文件只包含明码序列号和状态，刮开PIN码仅出现在生成时的一次性印刷文件中。
 func (h *CodeHandler) ExportCodes(c *gin.Context) {
-	// 实现防伪码导出逻辑
+	merchantID, _ := c.Get("merchantID")
+	batchID := c.Query("batch_id")
+	if batchID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "batch.required",
+			"msg":     i18n.T(c, "batch.required"),
+		})
+		return
+	}
+
+	var batch models.ProductBatch
+	if err := h.db.Where("id = ? AND merchant_id = ?", batchID, merchantID).First(&batch).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "batch.not_found_or_forbidden",
+			"msg":     i18n.T(c, "batch.not_found_or_forbidden"),
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=codes_%s.csv", batch.BatchCode))
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"code", "batch_code", "sequence", "status", "has_pin", "created_at"})
+
+	var codes []models.SecurityCode
+	h.db.Where("batch_id = ?", batch.ID).Order("sequence asc").FindInBatches(&codes, 1000, func(tx *gorm.DB, _ int) error {
+		for _, code := range codes {
+			writer.Write([]string{
+				code.Code,
+				batch.BatchCode,
+				strconv.Itoa(code.Sequence),
+				strconv.Itoa(code.Status),
+				strconv.FormatBool(code.PinHash != ""),
+				code.CreatedAt.Format(time.RFC3339),
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	writer.Flush()
+}
+
+// DownloadPrintFile 下载印刷文件（含PIN明文）
+// 下载后文件仍保留，以便传输中断时重新下载；商户确认已保存后调用ConfirmPrintFile删除，
+// 未确认的文件超过保留时长后由印刷文件清理协程删除。
+func (h *CodeHandler) DownloadPrintFile(c *gin.Context) {
+	path, ok := h.printFile(c)
+	if !ok {
+		return
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "code.print_file_not_found",
+			"msg":     i18n.T(c, "code.print_file_not_found"),
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=print_"+c.Param("token")+".csv")
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", content)
+}
+
+// ConfirmPrintFile 确认印刷文件已保存并删除服务器上的文件
+func (h *CodeHandler) ConfirmPrintFile(c *gin.Context) {
+	path, ok := h.printFile(c)
+	if !ok {
+		return
+	}
+
+	if err := os.Remove(path); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "code.print_file_not_found",
+			"msg":     i18n.T(c, "code.print_file_not_found"),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "导出功能待实现",
+		"code":    200,
+		"msg_key": "code.print_file_deleted",
+		"msg":     i18n.T(c, "code.print_file_deleted"),
 	})
 }
+
+// printFile 校验下载令牌并返回当前商户的印刷文件路径
+func (h *CodeHandler) printFile(c *gin.Context) (string, bool) {
+	token := c.Param("token")
+	if !printTokenPattern.MatchString(token) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "code.print_token_invalid",
+			"msg":     i18n.T(c, "code.print_token_invalid"),
+		})
+		return "", false
+	}
+	return printFilePath(h.cfg.Code.PrintDir, currentMerchantID(c), token), true
+}
+
+// printTokenPattern 印刷文件令牌格式
+var printTokenPattern = regexp.MustCompile(`^[a-f0-9]{32}$`)
+
+// printFilePath 印刷文件路径，文件名包含商户ID，防止跨商户下载
+func printFilePath(dir string, merchantID uint, token string) string {
+	return filepath.Join(dir, fmt.Sprintf("%d_%s.csv", merchantID, token))
+}
+
+// writePrintFile 生成含PIN明文的印刷文件，返回下载令牌
+// 先写入临时文件再改名，写入失败时删除临时文件，不会留下不完整的印刷文件。
+func writePrintFile(dir string, merchantID uint, batchCode string, codes, pins []string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	path := printFilePath(dir, merchantID, token)
+	tmpPath := path + services.PrintFileTempSuffix
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"code", "pin", "batch_code"})
+	for i := range codes {
+		writer.Write([]string{codes[i], pins[i], batchCode})
+	}
+	writer.Flush()
+	err = writer.Error()
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return token, nil
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"anti-fake-system/i18n"
+	"anti-fake-system/middleware"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxPageSize 分页查询每页最大条数
+const maxPageSize = 100
+
+// currentMerchantID 从上下文中读取当前商户ID，未登录或平台用户返回0
+// 认证中间件写入的merchantID为*uint，这里统一转换为uint便于写入模型。
+func currentMerchantID(c *gin.Context) uint {
+	value, exists := c.Get("merchantID")
+	if !exists || value == nil {
+		return 0
+	}
+
+	switch id := value.(type) {
+	case uint:
+		return id
+	case *uint:
+		if id != nil {
+			return *id
+		}
+	}
+	return 0
+}
+
+// currentUserID 从上下文中读取当前用户ID，未登录返回0
+func currentUserID(c *gin.Context) uint {
+	value, _ := c.Get("userID")
+	id, _ := value.(uint)
+	return id
+}
+
+// canViewFullIP 判断当前用户是否拥有查看完整验证IP的数据权限，平台和商户使用各自的权限标识
+func canViewFullIP(c *gin.Context) bool {
+	if userType, _ := c.Get("userType"); userType == 2 {
+		return middleware.HasPermission(c, "verify:full_ip")
+	}
+	return middleware.HasPermission(c, "platform:verify:full_ip")
+}
+
+// pageParams 读取分页参数，page和size必须为正整数，size超过上限时按上限处理
+// 参数非法时直接返回400响应，调用方需检查ok。
+func pageParams(c *gin.Context) (page, size int, ok bool) {
+	page, errPage := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, errSize := strconv.Atoi(c.DefaultQuery("size", "20"))
+	if errPage != nil || errSize != nil || page < 1 || size < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.page_invalid",
+			"msg":     i18n.T(c, "common.page_invalid"),
+		})
+		return 0, 0, false
+	}
+	if size > maxPageSize {
+		size = maxPageSize
+	}
+	return page, size, true
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type DataKeyHandler struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	keys   *services.KeyManager
+	audits *services.AuditService
+}
+
+func NewDataKeyHandler(db *gorm.DB, cfg *config.Config, keys *services.KeyManager) *DataKeyHandler {
+	return &DataKeyHandler{db: db, cfg: cfg, keys: keys, audits: services.NewAuditService(db)}
+}
+
+// GetDataKeys 获取当前商户的数据加密密钥版本列表
+func (h *DataKeyHandler) GetDataKeys(c *gin.Context) {
+	keys, err := h.keys.Keys(currentMerchantID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data":    keys,
+	})
+}
+
+// RotateDataKey 轮换数据加密密钥，已有的规则配置由后台任务重新加密为新版本
+func (h *DataKeyHandler) RotateDataKey(c *gin.Context) {
+	merchantID := currentMerchantID(c)
+	key, err := h.keys.Rotate(merchantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "data_key.rotate_failed",
+			"msg":     i18n.T(c, "data_key.rotate_failed"),
+		})
+		return
+	}
+	recordAudit(c, h.audits, services.AuditDataKeyRotate, services.AuditTargetDataKey, key.ID, &merchantID, gin.H{
+		"version": key.Version,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "data_key.rotate_success",
+		"msg":     i18n.T(c, "data_key.rotate_success"),
+		"data":    key,
+	})
+}
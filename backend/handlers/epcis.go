@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/services"
+	"anti-fake-system/tracechain"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxEPCISDocumentSize EPCIS导入文档大小上限
+const maxEPCISDocumentSize = 10 << 20
+
+type EPCISHandler struct {
+	db    *gorm.DB
+	cfg   *config.Config
+	chain *services.TraceChainService
+}
+
+func NewEPCISHandler(db *gorm.DB, cfg *config.Config) *EPCISHandler {
+	return &EPCISHandler{db: db, cfg: cfg, chain: services.NewTraceChainService(db)}
+}
+
+// EPCISEventResult 单个导入事件的处理结果
+type EPCISEventResult struct {
+	services.EPCISImportEvent
+	SchemaVersion int      `json:"-"`
+	Status        string   `json:"status"` // valid、invalid、duplicate、imported
+	TraceID       uint     `json:"trace_id,omitempty"`
+	Errors        []string `json:"errors,omitempty"`
+}
+
+// ExportBatch 导出批次的EPCIS 2.0 JSON-LD文档，包含防伪码事件和溯源信息
+func (h *EPCISHandler) ExportBatch(c *gin.Context) {
+	batch, merchant, ok := h.findBatch(c)
+	if !ok {
+		return
+	}
+
+	var codes []models.SecurityCode
+	h.db.Where("batch_id = ?", batch.ID).Order("sequence asc").Find(&codes)
+
+	var traces []models.TraceabilityInfo
+	h.db.Where("batch_id = ?", batch.ID).Order("created_at asc, id asc").Find(&traces)
+
+	doc := services.NewEPCISDocument()
+	doc.AddCodeEvents(merchant, batch, codes)
+	doc.AddTraceEvents(merchant, batch, traces)
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=epcis_%s.jsonld", batch.BatchCode))
+	c.Data(http.StatusOK, "application/ld+json; charset=utf-8", data)
+}
+
+// ImportBatch 导入合作方的EPCIS文档，ObjectEvent和AggregationEvent按业务步骤写入对应阶段的溯源信息
+// 默认任一事件校验失败则全部不导入；partial=true时只导入校验通过的事件；dry_run=true时只校验不写入。
+func (h *EPCISHandler) ImportBatch(c *gin.Context) {
+	batch, _, ok := h.findBatch(c)
+	if !ok {
+		return
+	}
+	dryRun := c.Query("dry_run") == "true"
+	partial := c.Query("partial") == "true"
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxEPCISDocumentSize+1))
+	if err != nil || len(body) > maxEPCISDocumentSize {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "epcis.document_too_large",
+			"msg":     i18n.T(c, "epcis.document_too_large"),
+		})
+		return
+	}
+
+	events, err := services.ParseEPCISEvents(body)
+	if err != nil {
+		key, args := i18n.ErrorKey(err, "epcis.document_invalid")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
+		})
+		return
+	}
+
+	results, err := h.validateEvents(c, batch, events)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	summary := epcisSummary(results)
+	if summary["invalid"] > 0 && !partial {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "epcis.validation_failed",
+			"msg":     i18n.T(c, "epcis.validation_failed", summary["invalid"]),
+			"data":    gin.H{"summary": summary, "events": results},
+		})
+		return
+	}
+	if dryRun {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    200,
+			"msg_key": "epcis.validate_success",
+			"msg":     i18n.T(c, "epcis.validate_success"),
+			"data":    gin.H{"summary": summary, "events": results},
+		})
+		return
+	}
+
+	if err := h.importEvents(c, batch, results); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "epcis.import_failed",
+			"msg":     i18n.T(c, "epcis.import_failed"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "epcis.import_success",
+		"msg":     i18n.T(c, "epcis.import_success"),
+		"data":    gin.H{"summary": epcisSummary(results), "events": results},
+	})
+}
+
+// validateEvents 按商户的阶段模板校验事件内容，并标记已导入过的事件
+func (h *EPCISHandler) validateEvents(c *gin.Context, batch *models.ProductBatch, events []services.EPCISImportEvent) ([]EPCISEventResult, error) {
+	var eventIDs []string
+	for _, event := range events {
+		if event.EventID != "" {
+			eventIDs = append(eventIDs, event.EventID)
+		}
+	}
+
+	imported := make(map[string]bool)
+	if len(eventIDs) > 0 {
+		var existing []string
+		if err := h.db.Model(&models.TraceabilityInfo{}).
+			Where("batch_id = ? AND external_id IN ?", batch.ID, eventIDs).
+			Pluck("external_id", &existing).Error; err != nil {
+			return nil, err
+		}
+		for _, id := range existing {
+			imported[id] = true
+		}
+	}
+
+	type schemaEntry struct {
+		version int
+		fields  []services.SchemaField
+	}
+	schemas := make(map[int]*schemaEntry)
+
+	results := make([]EPCISEventResult, len(events))
+	for i, event := range events {
+		result := EPCISEventResult{EPCISImportEvent: event, Status: "valid"}
+
+		if len(event.Errors) == 0 {
+			entry, loaded := schemas[event.StageType]
+			if !loaded {
+				schema, fields, err := services.CurrentTraceSchema(h.db, batch.MerchantID, event.StageType)
+				if err != nil {
+					return nil, err
+				}
+				entry = &schemaEntry{fields: fields}
+				if schema != nil {
+					entry.version = schema.Version
+				}
+				schemas[event.StageType] = entry
+			}
+
+			content, err := services.FitEPCISContent(entry.fields, event.Content)
+			if err != nil {
+				result.EPCISImportEvent.Errors = append(result.EPCISImportEvent.Errors, err)
+			}
+			result.Content = content
+			result.SchemaVersion = entry.version
+		}
+
+		for _, err := range result.EPCISImportEvent.Errors {
+			result.Errors = append(result.Errors, i18n.ErrorText(c, err))
+		}
+		switch {
+		case len(result.Errors) > 0:
+			result.Status = "invalid"
+		case event.EventID != "" && imported[event.EventID]:
+			result.Status = "duplicate"
+		}
+		if event.EventID != "" && result.Status == "valid" {
+			// 同一文档内重复的事件只导入第一个
+			imported[event.EventID] = true
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+// importEvents 在一个事务中按事件时间顺序写入溯源信息和哈希链
+func (h *EPCISHandler) importEvents(c *gin.Context, batch *models.ProductBatch, results []EPCISEventResult) error {
+	order := make([]int, 0, len(results))
+	for i := range results {
+		if results[i].Status == "valid" {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return results[order[a]].EventTime.Before(results[order[b]].EventTime)
+	})
+
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		for _, i := range order {
+			result := &results[i]
+			content, err := json.Marshal(result.Content)
+			if err != nil {
+				return err
+			}
+
+			trace := models.TraceabilityInfo{
+				BatchID:       batch.ID,
+				MerchantID:    batch.MerchantID,
+				StageType:     result.StageType,
+				Content:       string(content),
+				SchemaVersion: result.SchemaVersion,
+				Attachments:   "[]",
+				Version:       1,
+				ExternalID:    result.EventID,
+				CreatedAt:     result.EventTime,
+			}
+			if err := tx.Create(&trace).Error; err != nil {
+				return err
+			}
+			if _, err := h.chain.Append(tx, &trace, tracechain.ActionCreate, currentUserID(c)); err != nil {
+				return err
+			}
+			result.Status, result.TraceID = "imported", trace.ID
+		}
+		return nil
+	})
+}
+
+// findBatch 查询当前商户的批次及所属商户，不存在时直接输出404
+func (h *EPCISHandler) findBatch(c *gin.Context) (*models.ProductBatch, *models.Merchant, bool) {
+	var batch models.ProductBatch
+	if err := h.db.Preload("Merchant").Where("id = ? AND merchant_id = ?", c.Param("id"), currentMerchantID(c)).
+		First(&batch).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "batch.not_found_or_forbidden",
+			"msg":     i18n.T(c, "batch.not_found_or_forbidden"),
+		})
+		return nil, nil, false
+	}
+	return &batch, &batch.Merchant, true
+}
+
+// epcisSummary 按状态统计事件数量
+func epcisSummary(results []EPCISEventResult) map[string]int {
+	summary := map[string]int{"total": len(results), "valid": 0, "invalid": 0, "duplicate": 0, "imported": 0}
+	for _, result := range results {
+		summary[result.Status]++
+	}
+	return summary
+}
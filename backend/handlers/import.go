@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/middleware"
+	"anti-fake-system/models"
+	"anti-fake-system/services"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// importErrorPreview 任务详情中返回的错误条数，完整错误请下载错误报告
+const importErrorPreview = 100
+
+type ImportHandler struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	runner *services.ImportRunner
+}
+
+func NewImportHandler(db *gorm.DB, cfg *config.Config, runner *services.ImportRunner) *ImportHandler {
+	return &ImportHandler{db: db, cfg: cfg, runner: runner}
+}
+
+// GetTemplate 下载导入模板，format为csv（默认）或xlsx
+func (h *ImportHandler) GetTemplate(c *gin.Context) {
+	importType := c.Param("type")
+	if !services.IsImportType(importType) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "import.type_invalid",
+			"msg":     i18n.T(c, "import.type_invalid", importType),
+		})
+		return
+	}
+
+	rows, err := services.ImportTemplate(h.db, currentMerchantID(c), importType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+	h.writeSpreadsheet(c, "import_template_"+importType, rows)
+}
+
+// importPermissions 各导入类型写入数据所需的权限
+var importPermissions = map[string]string{
+	services.ImportTypeProducts: "product:create",
+	services.ImportTypeBatches:  "batch:create",
+	services.ImportTypeTraces:   "trace:create",
+}
+
+// CreateImport 上传导入文件，表单字段为file和type，创建任务后在后台校验
+func (h *ImportHandler) CreateImport(c *gin.Context) {
+	// 请求体在文件上限之外预留1MB给表单边界和其他字段
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.runner.MaxFileSize()+1<<20)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		key := "import.file_required"
+		var args []interface{}
+		if errors.As(err, &maxBytesErr) {
+			key, args = "import.file_too_large", []interface{}{h.cfg.Import.MaxFileSize}
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
+		})
+		return
+	}
+
+	// 导入数据的写入权限按导入类型区分
+	if permission, ok := importPermissions[c.PostForm("type")]; ok && !middleware.HasPermission(c, permission) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    403,
+			"msg_key": "auth.permission_denied",
+			"msg":     i18n.T(c, "auth.permission_denied", permission),
+		})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "import.upload_failed",
+			"msg":     i18n.T(c, "import.upload_failed"),
+		})
+		return
+	}
+	defer file.Close()
+
+	// 多读1字节用于判断是否超过大小限制
+	data, err := io.ReadAll(io.LimitReader(file, h.runner.MaxFileSize()+1))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "import.upload_failed",
+			"msg":     i18n.T(c, "import.upload_failed"),
+		})
+		return
+	}
+
+	job, err := h.runner.Submit(c.Request.Context(), currentMerchantID(c), currentUserID(c), i18n.Locale(c),
+		c.PostForm("type"), fileHeader.Filename, data)
+	if err != nil {
+		key, args := i18n.ErrorKey(err, "import.upload_failed")
+		status := http.StatusBadRequest
+		if key == "import.upload_failed" {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{
+			"code":    status,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "import.upload_success",
+		"msg":     i18n.T(c, "import.upload_success"),
+		"data":    h.view(job, false),
+	})
+}
+
+// GetImports 获取当前商户的导入任务列表
+func (h *ImportHandler) GetImports(c *gin.Context) {
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+	importType := c.Query("type")
+	status := c.Query("status")
+
+	query := h.db.Model(&models.ImportJob{}).Where("merchant_id = ?", currentMerchantID(c))
+	if importType != "" {
+		query = query.Where("type = ?", importType)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var jobs []models.ImportJob
+	offset := (page - 1) * size
+	query.Offset(offset).Limit(size).Order("id desc").Find(&jobs)
+
+	list := make([]gin.H, len(jobs))
+	for i := range jobs {
+		list[i] = h.view(&jobs[i], false)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"total": total,
+			"page":  page,
+			"size":  size,
+			"list":  list,
+		},
+	})
+}
+
+// GetImport 获取导入任务的状态、进度和前若干条错误
+func (h *ImportHandler) GetImport(c *gin.Context) {
+	job, ok := h.findJob(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data":    h.view(job, true),
+	})
+}
+
+// CommitImport 确认导入校验通过的任务，在后台事务中写入全部行
+func (h *ImportHandler) CommitImport(c *gin.Context) {
+	job, ok := h.findJob(c)
+	if !ok {
+		return
+	}
+
+	if err := h.runner.Commit(job); err != nil {
+		key, args := i18n.ErrorKey(err, "common.internal_error")
+		status := http.StatusBadRequest
+		if key == "common.internal_error" {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{
+			"code":    status,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "import.commit_queued",
+		"msg":     i18n.T(c, "import.commit_queued"),
+		"data":    h.view(job, false),
+	})
+}
+
+// GetImportErrors 下载错误报告，默认与上传文件格式相同，可用format指定csv或xlsx
+func (h *ImportHandler) GetImportErrors(c *gin.Context) {
+	job, ok := h.findJob(c)
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", job.Format)
+	if format != services.SpreadsheetXLSX {
+		format = services.SpreadsheetCSV
+	}
+	data, err := h.runner.ErrorReport(c.Request.Context(), job, format)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=import_%d_errors.%s", job.ID, format))
+	c.Data(http.StatusOK, services.SpreadsheetContentType(format), data)
+}
+
+// writeSpreadsheet 按format参数输出CSV或XLSX文件
+func (h *ImportHandler) writeSpreadsheet(c *gin.Context, name string, rows [][]string) {
+	format := c.DefaultQuery("format", services.SpreadsheetCSV)
+	if format != services.SpreadsheetXLSX {
+		format = services.SpreadsheetCSV
+	}
+
+	data, err := services.WriteSpreadsheet(format, rows)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", name, format))
+	c.Data(http.StatusOK, services.SpreadsheetContentType(format), data)
+}
+
+// findJob 查询当前商户的导入任务，不存在时直接输出404
+func (h *ImportHandler) findJob(c *gin.Context) (*models.ImportJob, bool) {
+	var job models.ImportJob
+	if err := h.db.Where("id = ? AND merchant_id = ?", c.Param("id"), currentMerchantID(c)).First(&job).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "import.not_found",
+			"msg":     i18n.T(c, "import.not_found"),
+		})
+		return nil, false
+	}
+	return &job, true
+}
+
+// view 输出导入任务，progress为当前阶段的完成百分比，withErrors时附带前若干条错误
+func (h *ImportHandler) view(job *models.ImportJob, withErrors bool) gin.H {
+	progress := 0
+	if job.TotalRows > 0 {
+		progress = job.ProcessedRows * 100 / job.TotalRows
+	}
+	if job.Status == services.ImportStatusValidated || job.Status == services.ImportStatusInvalid ||
+		job.Status == services.ImportStatusCompleted || job.Status == services.ImportStatusFailed {
+		progress = 100
+	}
+
+	view := gin.H{
+		"job":      job,
+		"progress": progress,
+	}
+	if withErrors {
+		list := h.runner.Errors(job)
+		view["error_count"] = len(list)
+		if len(list) > importErrorPreview {
+			list = list[:importErrorPreview]
+		}
+		view["errors"] = list
+	}
+	return view
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type JWTKeyHandler struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	keys   *services.JWTKeyService
+	audits *services.AuditService
+}
+
+func NewJWTKeyHandler(db *gorm.DB, cfg *config.Config, keys *services.JWTKeyService) *JWTKeyHandler {
+	return &JWTKeyHandler{db: db, cfg: cfg, keys: keys, audits: services.NewAuditService(db)}
+}
+
+// GetJWKS 以标准JWKS格式公开访问令牌验证公钥，供其他内部服务验证令牌
+func (h *JWTKeyHandler) GetJWKS(c *gin.Context) {
+	// 密钥轮换后新公钥需要尽快被获取，缓存时间不宜过长
+	c.Header("Cache-Control", "public, max-age=60")
+	c.JSON(http.StatusOK, gin.H{"keys": h.keys.JWKS()})
+}
+
+// GetJWTKeys 获取访问令牌签名密钥列表
+func (h *JWTKeyHandler) GetJWTKeys(c *gin.Context) {
+	keys, err := h.keys.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data":    keys,
+	})
+}
+
+// RotateJWTKey 立即轮换访问令牌签名密钥，之后签发的令牌使用新密钥
+func (h *JWTKeyHandler) RotateJWTKey(c *gin.Context) {
+	key, err := h.keys.Rotate()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "jwt_key.rotate_failed",
+			"msg":     i18n.T(c, "jwt_key.rotate_failed"),
+		})
+		return
+	}
+	recordAudit(c, h.audits, services.AuditJWTKeyRotate, services.AuditTargetJWTKey, key.ID, nil, gin.H{
+		"kid":       key.KeyID,
+		"algorithm": key.Algorithm,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "jwt_key.rotate_success",
+		"msg":     i18n.T(c, "jwt_key.rotate_success"),
+		"data":    key,
+	})
+}
+
+// RevokeJWTKey 吊销已轮换的访问令牌签名密钥，使用该密钥签名的令牌立即失效
+func (h *JWTKeyHandler) RevokeJWTKey(c *gin.Context) {
+	key, err := h.keys.Revoke(c.Param("kid"))
+	if err != nil {
+		msgKey, args := i18n.ErrorKey(err, "jwt_key.revoke_failed")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": msgKey,
+			"msg":     i18n.T(c, msgKey, args...),
+		})
+		return
+	}
+	recordAudit(c, h.audits, services.AuditJWTKeyRevoke, services.AuditTargetJWTKey, key.ID, nil, gin.H{
+		"kid": key.KeyID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "jwt_key.revoke_success",
+		"msg":     i18n.T(c, "jwt_key.revoke_success"),
+	})
+}
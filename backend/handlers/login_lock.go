@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/services"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// UnlockIPRequest 解除IP登录锁定请求
+type UnlockIPRequest struct {
+	IP string `json:"ip" binding:"required"`
+}
+
+type LoginLockHandler struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	guard  *services.LoginGuard
+	audits *services.AuditService
+}
+
+func NewLoginLockHandler(db *gorm.DB, cfg *config.Config, guard *services.LoginGuard) *LoginLockHandler {
+	return &LoginLockHandler{db: db, cfg: cfg, guard: guard, audits: services.NewAuditService(db)}
+}
+
+// UnlockIP 解除IP因登录失败过多触发的锁定
+func (h *LoginLockHandler) UnlockIP(c *gin.Context) {
+	var req UnlockIPRequest
+	if err := c.ShouldBindJSON(&req); err != nil || net.ParseIP(req.IP) == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	if err := h.guard.UnlockIP(c.Request.Context(), req.IP); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+	recordAudit(c, h.audits, services.AuditLoginUnlockIP, services.AuditTargetIP, 0, nil, gin.H{
+		"ip": req.IP,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "auth.ip_unlocked",
+		"msg":     i18n.T(c, "auth.ip_unlocked"),
+	})
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type MessageHandler struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+func NewMessageHandler(db *gorm.DB, cfg *config.Config) *MessageHandler {
+	return &MessageHandler{db: db, cfg: cfg}
+}
+
+// MessageOverrideRequest 商户自定义消息请求
+type MessageOverrideRequest struct {
+	Locale     string `json:"locale" binding:"required"`
+	MessageKey string `json:"message_key" binding:"required"`
+	Text       string `json:"text" binding:"required,max=500"`
+}
+
+// GetLocales 获取支持的语言列表
+func (h *MessageHandler) GetLocales(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"locales":        i18n.Default().Locales(),
+			"default_locale": h.cfg.I18n.DefaultLocale,
+			"current_locale": i18n.Locale(c),
+		},
+	})
+}
+
+// GetMessages 获取指定语言的内置消息及本商户的覆盖文本
+func (h *MessageHandler) GetMessages(c *gin.Context) {
+	merchantID, _ := c.Get("merchantID")
+	locale := c.DefaultQuery("locale", i18n.Locale(c))
+
+	var overrides []models.MerchantMessage
+	h.db.Where("merchant_id = ? AND locale = ?", merchantID, locale).Order("message_key").Find(&overrides)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"locale":    locale,
+			"messages":  i18n.Default().Messages(locale),
+			"overrides": overrides,
+		},
+	})
+}
+
+// SaveMessage 新增或更新本商户的自定义消息
+func (h *MessageHandler) SaveMessage(c *gin.Context) {
+	var req MessageOverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	catalog := i18n.Default()
+	if !catalog.HasLocale(req.Locale) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "message.locale_invalid",
+			"msg":     i18n.T(c, "message.locale_invalid", req.Locale),
+		})
+		return
+	}
+	if !catalog.HasKey(req.MessageKey) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "message.key_invalid",
+			"msg":     i18n.T(c, "message.key_invalid", req.MessageKey),
+		})
+		return
+	}
+
+	merchantID := currentMerchantID(c)
+	override := models.MerchantMessage{
+		MerchantID: merchantID,
+		Locale:     req.Locale,
+		MessageKey: req.MessageKey,
+	}
+	err := h.db.Where(override).
+		Assign(models.MerchantMessage{Text: req.Text}).
+		FirstOrCreate(&override).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "message.save_failed",
+			"msg":     i18n.T(c, "message.save_failed"),
+		})
+		return
+	}
+
+	catalog.SetOverride(merchantID, req.Locale, req.MessageKey, req.Text)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "message.save_success",
+		"msg":     i18n.T(c, "message.save_success"),
+		"data": gin.H{
+			"message_id": override.ID,
+		},
+	})
+}
+
+// DeleteMessage 删除本商户的自定义消息，恢复使用内置文本
+func (h *MessageHandler) DeleteMessage(c *gin.Context) {
+	merchantID, _ := c.Get("merchantID")
+
+	var override models.MerchantMessage
+	if err := h.db.Where("id = ? AND merchant_id = ?", c.Param("id"), merchantID).First(&override).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "message.not_found",
+			"msg":     i18n.T(c, "message.not_found"),
+		})
+		return
+	}
+
+	if err := h.db.Delete(&override).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "message.delete_failed",
+			"msg":     i18n.T(c, "message.delete_failed"),
+		})
+		return
+	}
+
+	i18n.Default().SetOverride(override.MerchantID, override.Locale, override.MessageKey, "")
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "message.delete_success",
+		"msg":     i18n.T(c, "message.delete_success"),
+	})
+}
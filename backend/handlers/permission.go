@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type PermissionHandler struct {
+	db          *gorm.DB
+	cfg         *config.Config
+	permissions *services.PermissionService
+}
+
+func NewPermissionHandler(db *gorm.DB, cfg *config.Config, permissions *services.PermissionService) *PermissionHandler {
+	return &PermissionHandler{db: db, cfg: cfg, permissions: permissions}
+}
+
+// GetMyPermissions 获取当前用户的有效权限树和权限码列表，供前端渲染菜单和按钮
+func (h *PermissionHandler) GetMyPermissions(c *gin.Context) {
+	userType, _ := c.Get("userType")
+	scope, _ := userType.(int)
+
+	codes, err := h.permissions.UserPermissions(c.Request.Context(), currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	granted := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		granted[code] = true
+	}
+	tree, err := h.permissions.Tree(scope, granted)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"user_type": scope,
+			"codes":     codes,
+			"tree":      tree,
+		},
+	})
+}
+
+// GetPermissions 获取权限目录，scope为1查询平台权限，默认查询商户权限
+func (h *PermissionHandler) GetPermissions(c *gin.Context) {
+	scope, _ := strconv.Atoi(c.DefaultQuery("scope", "2"))
+
+	tree, err := h.permissions.Tree(scope, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"scope": scope,
+			"tree":  tree,
+		},
+	})
+}
+
+// CreatePermission 新建权限
+func (h *PermissionHandler) CreatePermission(c *gin.Context) {
+	var input services.PermissionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	permission, err := h.permissions.CreatePermission(c.Request.Context(), &input)
+	if err != nil {
+		h.fail(c, err, "permission.create_failed")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "permission.create_success",
+		"msg":     i18n.T(c, "permission.create_success"),
+		"data":    permission,
+	})
+}
+
+// UpdatePermission 修改权限，内置权限只能修改名称、类型和上级
+func (h *PermissionHandler) UpdatePermission(c *gin.Context) {
+	permission, ok := h.findPermission(c)
+	if !ok {
+		return
+	}
+
+	var input services.PermissionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	if err := h.permissions.UpdatePermission(c.Request.Context(), permission, &input); err != nil {
+		h.fail(c, err, "permission.update_failed")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "permission.update_success",
+		"msg":     i18n.T(c, "permission.update_success"),
+		"data":    permission,
+	})
+}
+
+// DeletePermission 删除权限
+func (h *PermissionHandler) DeletePermission(c *gin.Context) {
+	permission, ok := h.findPermission(c)
+	if !ok {
+		return
+	}
+
+	if err := h.permissions.DeletePermission(c.Request.Context(), permission); err != nil {
+		h.fail(c, err, "permission.delete_failed")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "permission.delete_success",
+		"msg":     i18n.T(c, "permission.delete_success"),
+	})
+}
+
+// SeedPermissions 补齐缺失的内置权限
+func (h *PermissionHandler) SeedPermissions(c *gin.Context) {
+	if err := h.permissions.SeedCatalogue(c.Request.Context()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "permission.seed_failed",
+			"msg":     i18n.T(c, "permission.seed_failed"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "permission.seed_success",
+		"msg":     i18n.T(c, "permission.seed_success"),
+	})
+}
+
+// findPermission 查询权限，不存在时直接输出404
+func (h *PermissionHandler) findPermission(c *gin.Context) (*models.Permission, bool) {
+	var permission models.Permission
+	if err := h.db.First(&permission, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "permission.not_found",
+			"msg":     i18n.T(c, "permission.not_found"),
+		})
+		return nil, false
+	}
+	return &permission, true
+}
+
+// fail 输出服务层错误，业务校验错误为400，其余为500
+func (h *PermissionHandler) fail(c *gin.Context, err error, fallback string) {
+	key, args := i18n.ErrorKey(err, fallback)
+	status := http.StatusBadRequest
+	if key == fallback {
+		status = http.StatusInternalServerError
+	}
+	c.JSON(status, gin.H{
+		"code":    status,
+		"msg_key": key,
+		"msg":     i18n.T(c, key, args...),
+	})
+}
@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"anti-fake-system/config"
+	"anti-fake-system/i18n"
 	"anti-fake-system/models"
+	"anti-fake-system/services"
 	"net/http"
 	"strconv"
 	"time"
@@ -12,12 +14,13 @@ import (
 )
 
 type PlatformHandler struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db      *gorm.DB
+	cfg     *config.Config
+	records *services.VerifyRecordRepository
 }
 
-func NewPlatformHandler(db *gorm.DB, cfg *config.Config) *PlatformHandler {
-	return &PlatformHandler{db: db, cfg: cfg}
+func NewPlatformHandler(db *gorm.DB, cfg *config.Config, records *services.VerifyRecordRepository) *PlatformHandler {
+	return &PlatformHandler{db: db, cfg: cfg, records: records}
 }
 
 // GetMerchants 获取商户列表
@@ -46,8 +49,9 @@ func (h *PlatformHandler) GetMerchants(c *gin.Context) {
 	query.Offset(offset).Limit(size).Find(&merchants)
 
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "查询成功",
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
 		"data": gin.H{
 			"total": total,
 			"page":  page,
@@ -62,8 +66,9 @@ func (h *PlatformHandler) CreateMerchant(c *gin.Context) {
 	var merchant models.Merchant
 	if err := c.ShouldBindJSON(&merchant); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "请求参数错误",
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
 		})
 		return
 	}
@@ -75,15 +80,17 @@ func (h *PlatformHandler) CreateMerchant(c *gin.Context) {
 
 	if err := h.db.Create(&merchant).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"code": 500,
-			"msg":  "商户创建失败",
+			"code":    500,
+			"msg_key": "merchant.create_failed",
+			"msg":     i18n.T(c, "merchant.create_failed"),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "商户创建成功",
+		"code":    200,
+		"msg_key": "merchant.create_success",
+		"msg":     i18n.T(c, "merchant.create_success"),
 		"data": gin.H{
 			"merchant_id": merchant.ID,
 		},
@@ -97,8 +104,9 @@ func (h *PlatformHandler) UpdateMerchant(c *gin.Context) {
 	var merchant models.Merchant
 	if err := h.db.First(&merchant, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
-			"code": 404,
-			"msg":  "商户不存在",
+			"code":    404,
+			"msg_key": "merchant.not_found",
+			"msg":     i18n.T(c, "merchant.not_found"),
 		})
 		return
 	}
@@ -106,8 +114,9 @@ func (h *PlatformHandler) UpdateMerchant(c *gin.Context) {
 	var updateData map[string]interface{}
 	if err := c.ShouldBindJSON(&updateData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "请求参数错误",
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
 		})
 		return
 	}
@@ -116,15 +125,17 @@ func (h *PlatformHandler) UpdateMerchant(c *gin.Context) {
 
 	if err := h.db.Model(&merchant).Updates(updateData).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"code": 500,
-			"msg":  "商户更新失败",
+			"code":    500,
+			"msg_key": "merchant.update_failed",
+			"msg":     i18n.T(c, "merchant.update_failed"),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "商户更新成功",
+		"code":    200,
+		"msg_key": "merchant.update_success",
+		"msg":     i18n.T(c, "merchant.update_success"),
 	})
 }
 
@@ -150,15 +161,18 @@ func (h *PlatformHandler) GetStatistics(c *gin.Context) {
 	// 统计批次数量
 	h.db.Model(&models.ProductBatch{}).Count(&stats.TotalBatches)
 
-	// 统计防伪码数量（简化处理）
-	h.db.Model(&models.VerificationRecord{}).Count(&stats.TotalCodes)
-	h.db.Model(&models.VerificationRecord{}).Where("result = 1").Count(&stats.VerifiedCodes)
-	h.db.Model(&models.VerificationRecord{}).Where("result = 0").Count(&stats.FakeCodes)
+	// 统计验证记录数量（一次扫描全部未归档的月份表）
+	h.records.All().
+		Select("COUNT(*) AS total_codes, " +
+			"COALESCE(SUM(CASE WHEN result = 1 THEN 1 ELSE 0 END), 0) AS verified_codes, " +
+			"COALESCE(SUM(CASE WHEN result = 0 THEN 1 ELSE 0 END), 0) AS fake_codes").
+		Scan(&stats)
 
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "统计查询成功",
-		"data": stats,
+		"code":    200,
+		"msg_key": "common.statistics_success",
+		"msg":     i18n.T(c, "common.statistics_success"),
+		"data":    stats,
 	})
 }
 
@@ -178,7 +192,7 @@ func (h *PlatformHandler) GetVerifyTrend(c *gin.Context) {
 	}
 
 	// 按日期统计验证记录
-	rows, err := h.db.Model(&models.VerificationRecord{}).
+	rows, err := h.records.Range(startDate, endDate).
 		Select("DATE(verify_time) as date, COUNT(*) as total, "+
 			"SUM(CASE WHEN result = 1 THEN 1 ELSE 0 END) as genuine, "+
 			"SUM(CASE WHEN result = 0 THEN 1 ELSE 0 END) as fake").
@@ -189,8 +203,9 @@ func (h *PlatformHandler) GetVerifyTrend(c *gin.Context) {
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"code": 500,
-			"msg":  "趋势数据查询失败",
+			"code":    500,
+			"msg_key": "statistics.trend_failed",
+			"msg":     i18n.T(c, "statistics.trend_failed"),
 		})
 		return
 	}
@@ -208,9 +223,10 @@ func (h *PlatformHandler) GetVerifyTrend(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "趋势数据查询成功",
-		"data": trends,
+		"code":    200,
+		"msg_key": "statistics.trend_success",
+		"msg":     i18n.T(c, "statistics.trend_success"),
+		"data":    trends,
 	})
 }
 
@@ -221,8 +237,9 @@ func (h *PlatformHandler) GetMerchantDetail(c *gin.Context) {
 	var merchant models.Merchant
 	if err := h.db.First(&merchant, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
-			"code": 404,
-			"msg":  "商户不存在",
+			"code":    404,
+			"msg_key": "merchant.not_found",
+			"msg":     i18n.T(c, "merchant.not_found"),
 		})
 		return
 	}
@@ -237,12 +254,15 @@ func (h *PlatformHandler) GetMerchantDetail(c *gin.Context) {
 
 	h.db.Model(&models.Product{}).Where("merchant_id = ?", id).Count(&stats.TotalProducts)
 	h.db.Model(&models.ProductBatch{}).Where("merchant_id = ?", id).Count(&stats.TotalBatches)
-	h.db.Model(&models.VerificationRecord{}).Where("merchant_id = ?", id).Count(&stats.TotalCodes)
-	h.db.Model(&models.VerificationRecord{}).Where("merchant_id = ? AND result = 1", id).Count(&stats.VerifiedCodes)
+	h.records.All().
+		Select("COUNT(*) AS total_codes, COALESCE(SUM(CASE WHEN result = 1 THEN 1 ELSE 0 END), 0) AS verified_codes").
+		Where("merchant_id = ?", id).
+		Scan(&stats)
 
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "商户详情查询成功",
+		"code":    200,
+		"msg_key": "merchant.detail_success",
+		"msg":     i18n.T(c, "merchant.detail_success"),
 		"data": gin.H{
 			"merchant": merchant,
 			"stats":    stats,
@@ -0,0 +1,439 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// productDetailBatches 商品详情中返回的最近批次数
+const productDetailBatches = 50
+
+type ProductHandler struct {
+	db      *gorm.DB
+	cfg     *config.Config
+	service *services.ProductService
+}
+
+func NewProductHandler(db *gorm.DB, cfg *config.Config) *ProductHandler {
+	return &ProductHandler{db: db, cfg: cfg, service: services.NewProductService(db)}
+}
+
+// ProductBatchSummary 商品详情中的批次及其防伪码数量
+type ProductBatchSummary struct {
+	models.ProductBatch
+	CodeCount       int64 `json:"code_count"`
+	ActiveCodeCount int64 `json:"active_code_count"`
+}
+
+// GetProducts 获取商户商品列表，默认不含已归档商品，category_id会包含其下级分类
+func (h *ProductHandler) GetProducts(c *gin.Context) {
+	merchantID := currentMerchantID(c)
+
+	// 获取查询参数
+	name := c.Query("name")
+	sku := c.Query("sku")
+	gtin := c.Query("gtin")
+	status := c.Query("status")
+	categoryID, _ := strconv.ParseUint(c.Query("category_id"), 10, 64)
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	// 构建查询条件
+	query := h.db.Model(&models.Product{}).Where("merchant_id = ?", merchantID)
+	if name != "" {
+		query = query.Where("name LIKE ?", "%"+name+"%")
+	}
+	if sku != "" {
+		query = query.Where("sku = ?", sku)
+	}
+	if gtin != "" {
+		query = query.Where("gtin = ?", gtin)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	} else if c.Query("include_archived") != "true" {
+		query = query.Where("status <> ?", services.ProductStatusArchived)
+	}
+	if categoryID > 0 {
+		ids, err := h.service.CategoryDescendants(merchantID, uint(categoryID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    500,
+				"msg_key": "common.internal_error",
+				"msg":     i18n.T(c, "common.internal_error"),
+			})
+			return
+		}
+		query = query.Where("category_id IN ?", ids)
+	}
+
+	// 分页查询
+	var total int64
+	query.Count(&total)
+
+	var products []models.Product
+	offset := (page - 1) * size
+	query.Offset(offset).Limit(size).Order("id desc").Find(&products)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"total": total,
+			"page":  page,
+			"size":  size,
+			"list":  products,
+		},
+	})
+}
+
+// CreateProduct 创建商品
+func (h *ProductHandler) CreateProduct(c *gin.Context) {
+	var input services.ProductInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	product, err := h.service.Create(currentMerchantID(c), currentUserID(c), &input)
+	if err != nil {
+		h.fail(c, err, "product.create_failed")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "product.create_success",
+		"msg":     i18n.T(c, "product.create_success"),
+		"data": gin.H{
+			"product_id": product.ID,
+		},
+	})
+}
+
+// GetProduct 获取商品详情，包含最近的批次和防伪码数量统计
+func (h *ProductHandler) GetProduct(c *gin.Context) {
+	product, ok := h.findProduct(c)
+	if !ok {
+		return
+	}
+
+	var batchCount int64
+	h.db.Model(&models.ProductBatch{}).Where("product_id = ?", product.ID).Count(&batchCount)
+
+	var batches []models.ProductBatch
+	h.db.Where("product_id = ?", product.ID).Order("id desc").Limit(productDetailBatches).Find(&batches)
+
+	// 按批次统计防伪码数量，总数按商品全部批次统计
+	var counts []struct {
+		BatchID uint
+		Total   int64
+		Active  int64
+	}
+	h.db.Model(&models.SecurityCode{}).
+		Select("security_codes.batch_id, COUNT(*) AS total, SUM(CASE WHEN security_codes.status = 1 THEN 1 ELSE 0 END) AS active").
+		Joins("JOIN product_batches ON product_batches.id = security_codes.batch_id").
+		Where("product_batches.product_id = ? AND security_codes.merchant_id = ?", product.ID, product.MerchantID).
+		Group("security_codes.batch_id").
+		Scan(&counts)
+
+	var codeCount, activeCount int64
+	byBatch := make(map[uint]int, len(counts))
+	for i, count := range counts {
+		codeCount += count.Total
+		activeCount += count.Active
+		byBatch[count.BatchID] = i
+	}
+
+	summaries := make([]ProductBatchSummary, len(batches))
+	for i, batch := range batches {
+		summaries[i] = ProductBatchSummary{ProductBatch: batch}
+		if j, ok := byBatch[batch.ID]; ok {
+			summaries[i].CodeCount = counts[j].Total
+			summaries[i].ActiveCodeCount = counts[j].Active
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"product":           product,
+			"batch_count":       batchCount,
+			"code_count":        codeCount,
+			"active_code_count": activeCount,
+			"batches":           summaries,
+		},
+	})
+}
+
+// UpdateProduct 修改商品，请求体与创建相同，返回本次变更的字段
+func (h *ProductHandler) UpdateProduct(c *gin.Context) {
+	product, ok := h.findProduct(c)
+	if !ok {
+		return
+	}
+
+	var input services.ProductInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	changes, err := h.service.Update(product, currentUserID(c), &input)
+	if err != nil {
+		h.fail(c, err, "product.update_failed")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "product.update_success",
+		"msg":     i18n.T(c, "product.update_success"),
+		"data": gin.H{
+			"product": product,
+			"changes": changes,
+		},
+	})
+}
+
+// ArchiveProduct 归档商品
+func (h *ProductHandler) ArchiveProduct(c *gin.Context) {
+	product, ok := h.findProduct(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Archive(product, currentUserID(c)); err != nil {
+		h.fail(c, err, "product.update_failed")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "product.archive_success",
+		"msg":     i18n.T(c, "product.archive_success"),
+	})
+}
+
+// RestoreProduct 恢复已归档的商品
+func (h *ProductHandler) RestoreProduct(c *gin.Context) {
+	product, ok := h.findProduct(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Restore(product, currentUserID(c)); err != nil {
+		h.fail(c, err, "product.update_failed")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "product.restore_success",
+		"msg":     i18n.T(c, "product.restore_success"),
+	})
+}
+
+// GetProductHistory 获取商品的变更历史
+func (h *ProductHandler) GetProductHistory(c *gin.Context) {
+	product, ok := h.findProduct(c)
+	if !ok {
+		return
+	}
+
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	query := h.db.Model(&models.ProductHistory{}).Where("product_id = ?", product.ID)
+
+	var total int64
+	query.Count(&total)
+
+	var history []models.ProductHistory
+	offset := (page - 1) * size
+	query.Offset(offset).Limit(size).Order("id desc").Find(&history)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"total": total,
+			"page":  page,
+			"size":  size,
+			"list":  history,
+		},
+	})
+}
+
+// GetCategories 获取商品分类树
+func (h *ProductHandler) GetCategories(c *gin.Context) {
+	tree, err := h.service.CategoryTree(currentMerchantID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data":    tree,
+	})
+}
+
+// CategoryRequest 创建/修改商品分类请求
+type CategoryRequest struct {
+	Name     string `json:"name" binding:"required,max=50"` // 分类名称
+	ParentID *uint  `json:"parent_id"`                      // 上级分类ID，为空表示一级分类
+	Sort     int    `json:"sort"`                           // 同级排序
+}
+
+// CreateCategory 创建商品分类
+func (h *ProductHandler) CreateCategory(c *gin.Context) {
+	var req CategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	category := models.ProductCategory{
+		MerchantID: currentMerchantID(c),
+		ParentID:   req.ParentID,
+		Name:       req.Name,
+		Sort:       req.Sort,
+	}
+	if err := h.service.SaveCategory(&category); err != nil {
+		h.fail(c, err, "product_category.save_failed")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "product_category.create_success",
+		"msg":     i18n.T(c, "product_category.create_success"),
+		"data":    category,
+	})
+}
+
+// UpdateCategory 修改商品分类的名称、上级和排序，相关商品的分类路径同步更新
+func (h *ProductHandler) UpdateCategory(c *gin.Context) {
+	category, ok := h.findCategory(c)
+	if !ok {
+		return
+	}
+
+	var req CategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	category.Name, category.ParentID, category.Sort = req.Name, req.ParentID, req.Sort
+	if err := h.service.SaveCategory(category); err != nil {
+		h.fail(c, err, "product_category.save_failed")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "product_category.update_success",
+		"msg":     i18n.T(c, "product_category.update_success"),
+		"data":    category,
+	})
+}
+
+// DeleteCategory 删除商品分类，有下级分类或商品时不能删除
+func (h *ProductHandler) DeleteCategory(c *gin.Context) {
+	category, ok := h.findCategory(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteCategory(category); err != nil {
+		h.fail(c, err, "product_category.delete_failed")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "product_category.delete_success",
+		"msg":     i18n.T(c, "product_category.delete_success"),
+	})
+}
+
+// findProduct 查询当前商户的商品，不存在时直接输出404
+func (h *ProductHandler) findProduct(c *gin.Context) (*models.Product, bool) {
+	var product models.Product
+	if err := h.db.Where("id = ? AND merchant_id = ?", c.Param("id"), currentMerchantID(c)).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "product.not_found",
+			"msg":     i18n.T(c, "product.not_found"),
+		})
+		return nil, false
+	}
+	return &product, true
+}
+
+// findCategory 查询当前商户的商品分类，不存在时直接输出404
+func (h *ProductHandler) findCategory(c *gin.Context) (*models.ProductCategory, bool) {
+	var category models.ProductCategory
+	if err := h.db.Where("id = ? AND merchant_id = ?", c.Param("id"), currentMerchantID(c)).First(&category).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "product_category.not_found",
+			"msg":     i18n.T(c, "product_category.not_found"),
+		})
+		return nil, false
+	}
+	return &category, true
+}
+
+// fail 输出服务层错误，业务校验错误为400，其余为500
+func (h *ProductHandler) fail(c *gin.Context, err error, fallback string) {
+	key, args := i18n.ErrorKey(err, fallback)
+	status := http.StatusBadRequest
+	if key == fallback {
+		status = http.StatusInternalServerError
+	}
+	c.JSON(status, gin.H{
+		"code":    status,
+		"msg_key": key,
+		"msg":     i18n.T(c, key, args...),
+	})
+}
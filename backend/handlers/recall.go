@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/services"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type RecallHandler struct {
+	db         *gorm.DB
+	cfg        *config.Config
+	dispatcher *services.WebhookDispatcher
+	records    *services.VerifyRecordRepository
+	service    *services.RecallService
+}
+
+func NewRecallHandler(db *gorm.DB, cfg *config.Config, dispatcher *services.WebhookDispatcher, records *services.VerifyRecordRepository) *RecallHandler {
+	return &RecallHandler{db: db, cfg: cfg, dispatcher: dispatcher, records: records, service: services.NewRecallService(db)}
+}
+
+// GetRecalls 获取当前商户的召回列表，支持status筛选
+func (h *RecallHandler) GetRecalls(c *gin.Context) {
+	status := c.Query("status")
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	query := h.db.Model(&models.Recall{}).Where("merchant_id = ?", currentMerchantID(c))
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var recalls []models.Recall
+	offset := (page - 1) * size
+	query.Offset(offset).Limit(size).Order("id desc").Find(&recalls)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"total": total,
+			"page":  page,
+			"size":  size,
+			"list":  recalls,
+		},
+	})
+}
+
+// CreateRecall 创建召回草稿，启动前防伪码状态不变
+func (h *RecallHandler) CreateRecall(c *gin.Context) {
+	var input services.RecallInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	recall, err := h.service.Create(currentMerchantID(c), currentUserID(c), &input)
+	if err != nil {
+		h.fail(c, err, "recall.create_failed")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "recall.create_success",
+		"msg":     i18n.T(c, "recall.create_success"),
+		"data":    recall,
+	})
+}
+
+// GetRecall 获取召回详情
+func (h *RecallHandler) GetRecall(c *gin.Context) {
+	recall, ok := h.findRecall(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data":    recall,
+	})
+}
+
+// UpdateRecall 修改召回，进行中的召回不能修改范围，已结束的召回不能修改
+func (h *RecallHandler) UpdateRecall(c *gin.Context) {
+	recall, ok := h.findRecall(c)
+	if !ok {
+		return
+	}
+
+	var input services.RecallInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	if err := h.service.Update(recall, &input); err != nil {
+		h.fail(c, err, "recall.update_failed")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "recall.update_success",
+		"msg":     i18n.T(c, "recall.update_success"),
+		"data":    recall,
+	})
+}
+
+// OpenRecall 启动召回，范围内的正常防伪码转为已召回状态并推送召回事件
+func (h *RecallHandler) OpenRecall(c *gin.Context) {
+	recall, ok := h.findRecall(c)
+	if !ok {
+		return
+	}
+
+	count, err := h.service.Open(recall)
+	if err != nil {
+		h.fail(c, err, "recall.open_failed")
+		return
+	}
+
+	var scopes []services.RecallScope
+	json.Unmarshal([]byte(recall.Scopes), &scopes)
+	h.dispatcher.Publish(recall.MerchantID, services.EventCodeRecalled, gin.H{
+		"recall_id":  recall.ID,
+		"title":      recall.Title,
+		"reason":     recall.Reason,
+		"scopes":     scopes,
+		"code_count": count,
+		"start_date": recall.StartDate,
+		"opened_at":  recall.OpenedAt,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "recall.open_success",
+		"msg":     i18n.T(c, "recall.open_success", count),
+		"data":    recall,
+	})
+}
+
+// CloseRecall 结束召回，已召回的防伪码保持已召回状态
+func (h *RecallHandler) CloseRecall(c *gin.Context) {
+	recall, ok := h.findRecall(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Close(recall); err != nil {
+		h.fail(c, err, "recall.close_failed")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "recall.close_success",
+		"msg":     i18n.T(c, "recall.close_success"),
+		"data":    recall,
+	})
+}
+
+// GetRecallReport 获取召回后已召回防伪码的扫描报告，明细按page/size分页
+func (h *RecallHandler) GetRecallReport(c *gin.Context) {
+	recall, ok := h.findRecall(c)
+	if !ok {
+		return
+	}
+
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	report, err := h.service.Report(h.records, recall, page, size)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"recall": recall,
+			"page":   page,
+			"size":   size,
+			"report": report,
+		},
+	})
+}
+
+// findRecall 查询当前商户的召回，不存在时直接输出404
+func (h *RecallHandler) findRecall(c *gin.Context) (*models.Recall, bool) {
+	var recall models.Recall
+	if err := h.db.Where("id = ? AND merchant_id = ?", c.Param("id"), currentMerchantID(c)).First(&recall).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "recall.not_found",
+			"msg":     i18n.T(c, "recall.not_found"),
+		})
+		return nil, false
+	}
+	return &recall, true
+}
+
+// fail 输出服务层错误，业务校验错误为400，其余为500
+func (h *RecallHandler) fail(c *gin.Context, err error, fallback string) {
+	key, args := i18n.ErrorKey(err, fallback)
+	status := http.StatusBadRequest
+	if key == fallback {
+		status = http.StatusInternalServerError
+	}
+	c.JSON(status, gin.H{
+		"code":    status,
+		"msg_key": key,
+		"msg":     i18n.T(c, key, args...),
+	})
+}
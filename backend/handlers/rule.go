@@ -2,9 +2,9 @@ package handlers
 
 import (
 	"anti-fake-system/config"
+	"anti-fake-system/i18n"
 	"anti-fake-system/models"
 	"anti-fake-system/services"
-	"anti-fake-system/utils"
 	"encoding/json"
 	"net/http"
 	"strconv"
@@ -15,12 +15,13 @@ import (
 )
 
 type RuleHandler struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db   *gorm.DB
+	cfg  *config.Config
+	keys *services.KeyManager
 }
 
-func NewRuleHandler(db *gorm.DB, cfg *config.Config) *RuleHandler {
-	return &RuleHandler{db: db, cfg: cfg}
+func NewRuleHandler(db *gorm.DB, cfg *config.Config, keys *services.KeyManager) *RuleHandler {
+	return &RuleHandler{db: db, cfg: cfg, keys: keys}
 }
 
 // CreateRuleRequest 创建规则请求
@@ -38,21 +39,42 @@ func (h *RuleHandler) CreateRule(c *gin.Context) {
 	var req CreateRuleRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "请求参数错误",
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
 		})
 		return
 	}
 
-	// 加密规则配置
-	encryptionKey := []byte(h.cfg.JWT.Secret)
-	generator := services.NewCodeGenerator(&req.RuleConfig, encryptionKey)
+	if req.RuleConfig.Pin != nil {
+		if err := services.ValidatePinConfig(req.RuleConfig.Pin); err != nil {
+			key, args := i18n.ErrorKey(err, "common.bad_request")
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    400,
+				"msg_key": key,
+				"msg":     i18n.T(c, key, args...),
+			})
+			return
+		}
+	}
+
+	if err := services.ValidateSignatureConfig(&req.RuleConfig); err != nil {
+		key, args := i18n.ErrorKey(err, "common.bad_request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
+		})
+		return
+	}
 
-	encryptedConfig, err := generator.EncryptRuleConfig()
+	// 使用商户的数据加密密钥加密规则配置
+	encryptedConfig, err := h.keys.EncryptRuleConfig(merchantID.(uint), &req.RuleConfig)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"code": 500,
-			"msg":  "规则配置加密失败",
+			"code":    500,
+			"msg_key": "rule.encrypt_failed",
+			"msg":     i18n.T(c, "rule.encrypt_failed"),
 		})
 		return
 	}
@@ -69,15 +91,17 @@ func (h *RuleHandler) CreateRule(c *gin.Context) {
 
 	if err := h.db.Create(&rule).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"code": 500,
-			"msg":  "规则创建失败",
+			"code":    500,
+			"msg_key": "rule.create_failed",
+			"msg":     i18n.T(c, "rule.create_failed"),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "规则创建成功",
+		"code":    200,
+		"msg_key": "rule.create_success",
+		"msg":     i18n.T(c, "rule.create_success"),
 		"data": gin.H{
 			"rule_id": rule.ID,
 		},
@@ -93,8 +117,9 @@ func (h *RuleHandler) UpdateRule(c *gin.Context) {
 	var rule models.SecurityCodeRule
 	if err := h.db.Where("id = ? AND merchant_id = ?", ruleID, merchantID).First(&rule).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
-			"code": 404,
-			"msg":  "规则不存在或无权限",
+			"code":    404,
+			"msg_key": "rule.not_found_or_forbidden",
+			"msg":     i18n.T(c, "rule.not_found_or_forbidden"),
 		})
 		return
 	}
@@ -102,32 +127,33 @@ func (h *RuleHandler) UpdateRule(c *gin.Context) {
 	var updateData map[string]interface{}
 	if err := c.ShouldBindJSON(&updateData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "请求参数错误",
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
 		})
 		return
 	}
 
 	// 如果更新了规则配置，需要重新加密
 	if ruleConfig, exists := updateData["rule_config"]; exists {
-		encryptionKey := []byte(h.cfg.JWT.Secret)
-
 		// 将规则配置转换为JSON
 		configJSON, err := json.Marshal(ruleConfig)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
-				"code": 500,
-				"msg":  "规则配置序列化失败",
+				"code":    500,
+				"msg_key": "rule.serialize_failed",
+				"msg":     i18n.T(c, "rule.serialize_failed"),
 			})
 			return
 		}
 
-		// 加密配置
-		encryptedConfig, err := utils.Encrypt(configJSON, encryptionKey)
+		// 使用商户当前的数据加密密钥加密配置
+		encryptedConfig, err := h.keys.Encrypt(rule.MerchantID, configJSON)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
-				"code": 500,
-				"msg":  "规则配置加密失败",
+				"code":    500,
+				"msg_key": "rule.encrypt_failed",
+				"msg":     i18n.T(c, "rule.encrypt_failed"),
 			})
 			return
 		}
@@ -139,15 +165,17 @@ func (h *RuleHandler) UpdateRule(c *gin.Context) {
 
 	if err := h.db.Model(&rule).Updates(updateData).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"code": 500,
-			"msg":  "规则更新失败",
+			"code":    500,
+			"msg_key": "rule.update_failed",
+			"msg":     i18n.T(c, "rule.update_failed"),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "规则更新成功",
+		"code":    200,
+		"msg_key": "rule.update_success",
+		"msg":     i18n.T(c, "rule.update_success"),
 	})
 }
 
@@ -159,28 +187,28 @@ func (h *RuleHandler) GetRuleDetail(c *gin.Context) {
 	var rule models.SecurityCodeRule
 	if err := h.db.Where("id = ? AND merchant_id = ?", ruleID, merchantID).First(&rule).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
-			"code": 404,
-			"msg":  "规则不存在或无权限",
+			"code":    404,
+			"msg_key": "rule.not_found_or_forbidden",
+			"msg":     i18n.T(c, "rule.not_found_or_forbidden"),
 		})
 		return
 	}
 
 	// 解密规则配置
-	encryptionKey := []byte(h.cfg.JWT.Secret)
-	generator := services.NewCodeGenerator(nil, encryptionKey)
-
-	var ruleConfig services.RuleConfig
-	if err := generator.DecryptRuleConfig(rule.RuleConfig); err != nil {
+	ruleConfig, err := h.keys.DecryptRuleConfig(rule.MerchantID, rule.RuleConfig)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"code": 500,
-			"msg":  "规则配置解密失败",
+			"code":    500,
+			"msg_key": "rule.decrypt_failed",
+			"msg":     i18n.T(c, "rule.decrypt_failed"),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "规则详情查询成功",
+		"code":    200,
+		"msg_key": "rule.detail_success",
+		"msg":     i18n.T(c, "rule.detail_success"),
 		"data": gin.H{
 			"rule":        rule,
 			"rule_config": ruleConfig,
@@ -201,39 +229,43 @@ func (h *RuleHandler) TestRule(c *gin.Context) {
 	var rule models.SecurityCodeRule
 	if err := h.db.Where("id = ? AND merchant_id = ?", ruleID, merchantID).First(&rule).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
-			"code": 404,
-			"msg":  "规则不存在或无权限",
+			"code":    404,
+			"msg_key": "rule.not_found_or_forbidden",
+			"msg":     i18n.T(c, "rule.not_found_or_forbidden"),
 		})
 		return
 	}
 
 	// 解密规则配置
-	encryptionKey := []byte(h.cfg.JWT.Secret)
-	generator := services.NewCodeGenerator(nil, encryptionKey)
-
-	var ruleConfig services.RuleConfig
-	if err := generator.DecryptRuleConfig(rule.RuleConfig); err != nil {
+	ruleConfig, err := h.keys.DecryptRuleConfig(rule.MerchantID, rule.RuleConfig)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"code": 500,
-			"msg":  "规则配置解密失败",
+			"code":    500,
+			"msg_key": "rule.decrypt_failed",
+			"msg":     i18n.T(c, "rule.decrypt_failed"),
 		})
 		return
 	}
 
 	// 创建生成器并测试生成
-	generator = services.NewCodeGenerator(&ruleConfig, encryptionKey)
+	generator := services.NewCodeGenerator(ruleConfig)
+	if !h.setPreviewSigner(c, generator, ruleConfig) {
+		return
+	}
 	testCodes, err := generator.GenerateBatch(startSeq, testCount)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"code": 500,
-			"msg":  "测试生成失败",
+			"code":    500,
+			"msg_key": "rule.test_failed",
+			"msg":     i18n.T(c, "rule.test_failed"),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "测试生成成功",
+		"code":    200,
+		"msg_key": "rule.test_success",
+		"msg":     i18n.T(c, "rule.test_success"),
 		"data": gin.H{
 			"test_codes":  testCodes,
 			"rule_config": ruleConfig,
@@ -246,8 +278,9 @@ func (h *RuleHandler) ValidateRuleConfig(c *gin.Context) {
 	var ruleConfig services.RuleConfig
 	if err := c.ShouldBindJSON(&ruleConfig); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "规则配置参数错误",
+			"code":    400,
+			"msg_key": "rule.config_bad_request",
+			"msg":     i18n.T(c, "rule.config_bad_request"),
 		})
 		return
 	}
@@ -255,45 +288,74 @@ func (h *RuleHandler) ValidateRuleConfig(c *gin.Context) {
 	// 验证规则配置的完整性
 	if ruleConfig.MerchantCode == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "商户标识码不能为空",
+			"code":    400,
+			"msg_key": "rule.merchant_code_required",
+			"msg":     i18n.T(c, "rule.merchant_code_required"),
 		})
 		return
 	}
 
 	if ruleConfig.BatchCode == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "批次标识不能为空",
+			"code":    400,
+			"msg_key": "rule.batch_code_required",
+			"msg":     i18n.T(c, "rule.batch_code_required"),
 		})
 		return
 	}
 
 	if ruleConfig.Sequence == nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "序号配置不能为空",
+			"code":    400,
+			"msg_key": "rule.sequence_required",
+			"msg":     i18n.T(c, "rule.sequence_required"),
 		})
 		return
 	}
 
 	if ruleConfig.TotalLength <= 0 {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "总长度必须大于0",
+			"code":    400,
+			"msg_key": "rule.total_length_invalid",
+			"msg":     i18n.T(c, "rule.total_length_invalid"),
+		})
+		return
+	}
+
+	if ruleConfig.Pin != nil {
+		if err := services.ValidatePinConfig(ruleConfig.Pin); err != nil {
+			key, args := i18n.ErrorKey(err, "common.bad_request")
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    400,
+				"msg_key": key,
+				"msg":     i18n.T(c, key, args...),
+			})
+			return
+		}
+	}
+
+	if err := services.ValidateSignatureConfig(&ruleConfig); err != nil {
+		key, args := i18n.ErrorKey(err, "common.bad_request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
 		})
 		return
 	}
 
 	// 测试生成一个防伪码验证配置是否有效
-	encryptionKey := []byte(h.cfg.JWT.Secret)
-	generator := services.NewCodeGenerator(&ruleConfig, encryptionKey)
+	generator := services.NewCodeGenerator(&ruleConfig)
+	if !h.setPreviewSigner(c, generator, &ruleConfig) {
+		return
+	}
 
 	testCode, err := generator.GenerateSingle(1)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "规则配置无效: " + err.Error(),
+			"code":    400,
+			"msg_key": "rule.config_invalid",
+			"msg":     i18n.T(c, "rule.config_invalid", i18n.ErrorText(c, err)),
 		})
 		return
 	}
@@ -301,18 +363,39 @@ func (h *RuleHandler) ValidateRuleConfig(c *gin.Context) {
 	// 验证生成的防伪码格式
 	if !generator.ValidateCode(testCode) {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "生成的防伪码格式不符合规则",
+			"code":    400,
+			"msg_key": "rule.code_format_mismatch",
+			"msg":     i18n.T(c, "rule.code_format_mismatch"),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "规则配置验证通过",
+		"code":    200,
+		"msg_key": "rule.validate_success",
+		"msg":     i18n.T(c, "rule.validate_success"),
 		"data": gin.H{
 			"test_code":   testCode,
 			"code_length": len(testCode),
 		},
 	})
 }
+
+// setPreviewSigner 签名规则测试时使用临时密钥签名，只用于预览格式，不会泄露商户签名能力
+func (h *RuleHandler) setPreviewSigner(c *gin.Context, generator *services.CodeGenerator, ruleConfig *services.RuleConfig) bool {
+	if !ruleConfig.Signed {
+		return true
+	}
+
+	signer, err := services.PreviewSigner()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "rule.test_failed",
+			"msg":     i18n.T(c, "rule.test_failed"),
+		})
+		return false
+	}
+	generator.SetSigner(signer)
+	return true
+}
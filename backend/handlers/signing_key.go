@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type SigningKeyHandler struct {
+	db      *gorm.DB
+	cfg     *config.Config
+	service *services.SigningKeyService
+}
+
+func NewSigningKeyHandler(db *gorm.DB, cfg *config.Config, keys *services.KeyManager) *SigningKeyHandler {
+	return &SigningKeyHandler{db: db, cfg: cfg, service: services.NewSigningKeyService(db, cfg, keys)}
+}
+
+// GetPublicKeys 获取商户公开的签名公钥，扫码端下载后可离线验证签名防伪码
+func (h *SigningKeyHandler) GetPublicKeys(c *gin.Context) {
+	var merchant models.Merchant
+	if err := h.db.Where("code = ? AND status = 1", c.Param("code")).First(&merchant).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "merchant.not_found",
+			"msg":     i18n.T(c, "merchant.not_found"),
+		})
+		return
+	}
+
+	keys, err := h.service.Published(merchant.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.TM(c, merchant.ID, "common.query_success"),
+		"data": gin.H{
+			"merchant_code": merchant.Code,
+			"algorithm":     services.SigningAlgorithm,
+			"keys":          keys,
+		},
+	})
+}
+
+// GetSigningKeys 获取当前商户的签名密钥列表
+func (h *SigningKeyHandler) GetSigningKeys(c *gin.Context) {
+	keys, err := h.service.List(currentMerchantID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data":    keys,
+	})
+}
+
+// RotateSigningKey 轮换签名密钥，之后生成的签名防伪码使用新密钥
+func (h *SigningKeyHandler) RotateSigningKey(c *gin.Context) {
+	key, err := h.service.Rotate(currentMerchantID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "signing_key.rotate_failed",
+			"msg":     i18n.T(c, "signing_key.rotate_failed"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "signing_key.rotate_success",
+		"msg":     i18n.T(c, "signing_key.rotate_success"),
+		"data":    key,
+	})
+}
+
+// RevokeSigningKey 吊销已轮换的签名密钥，吊销后不再公开
+func (h *SigningKeyHandler) RevokeSigningKey(c *gin.Context) {
+	if err := h.service.Revoke(currentMerchantID(c), c.Param("kid")); err != nil {
+		key, args := i18n.ErrorKey(err, "signing_key.revoke_failed")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "signing_key.revoke_success",
+		"msg":     i18n.T(c, "signing_key.revoke_success"),
+	})
+}
@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/services"
+	"anti-fake-system/storage"
+	"anti-fake-system/tracechain"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TraceHandler struct {
+	db          *gorm.DB
+	cfg         *config.Config
+	attachments *services.AttachmentService
+	chain       *services.TraceChainService
+}
+
+func NewTraceHandler(db *gorm.DB, cfg *config.Config, store storage.Storage) *TraceHandler {
+	return &TraceHandler{db: db, cfg: cfg, attachments: services.NewAttachmentService(db, store, cfg),
+		chain: services.NewTraceChainService(db)}
+}
+
+// TraceRequest 创建/更新溯源信息请求
+type TraceRequest struct {
+	StageType   int             `json:"stage_type" binding:"required"` // 溯源阶段类型
+	Content     json.RawMessage `json:"content" binding:"required"`    // 溯源信息内容，JSON对象
+	Attachments json.RawMessage `json:"attachments"`                   // 附件信息，JSON数组，元素为附件ID或附件地址
+}
+
+// validate 校验阶段类型和内容格式
+func (req *TraceRequest) validate() error {
+	if !services.IsTraceStage(req.StageType) {
+		return i18n.NewError("trace.stage_type_invalid", req.StageType)
+	}
+
+	var content map[string]interface{}
+	if err := json.Unmarshal(req.Content, &content); err != nil || content == nil {
+		return i18n.NewError("trace.content_invalid")
+	}
+
+	if len(req.Attachments) > 0 && string(req.Attachments) != "null" {
+		var attachments []interface{}
+		if err := json.Unmarshal(req.Attachments, &attachments); err != nil {
+			return i18n.NewError("trace.attachments_invalid")
+		}
+	}
+	return nil
+}
+
+// attachmentIDs 返回附件数组中以ID引用的附件
+func (req *TraceRequest) attachmentIDs() []uint {
+	var attachments []interface{}
+	json.Unmarshal(req.Attachments, &attachments)
+
+	var ids []uint
+	for _, item := range attachments {
+		if id, ok := item.(float64); ok {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
+
+// attachmentsJSON 返回附件JSON，未提供时为空数组
+func (req *TraceRequest) attachmentsJSON() string {
+	if len(req.Attachments) == 0 || string(req.Attachments) == "null" {
+		return "[]"
+	}
+	return string(req.Attachments)
+}
+
+// GetTraces 获取批次的溯源信息列表
+func (h *TraceHandler) GetTraces(c *gin.Context) {
+	batch, ok := h.findBatch(c)
+	if !ok {
+		return
+	}
+
+	stageType := c.Query("stage_type")
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	query := h.db.Model(&models.TraceabilityInfo{}).Where("batch_id = ? AND merchant_id = ?", batch.ID, batch.MerchantID)
+	if stageType != "" {
+		query = query.Where("stage_type = ?", stageType)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var traces []models.TraceabilityInfo
+	offset := (page - 1) * size
+	query.Order("stage_type asc, id asc").Offset(offset).Limit(size).Find(&traces)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"total":   total,
+			"page":    page,
+			"size":    size,
+			"list":    traces,
+			"schemas": h.loadSchemas(batch.MerchantID, traces),
+		},
+	})
+}
+
+// CreateTrace 为批次创建溯源信息
+func (h *TraceHandler) CreateTrace(c *gin.Context) {
+	batch, ok := h.findBatch(c)
+	if !ok {
+		return
+	}
+
+	req, ok := h.bindRequest(c)
+	if !ok {
+		return
+	}
+
+	version, ok := h.validateSchema(c, batch.MerchantID, req)
+	if !ok {
+		return
+	}
+	if !h.validateAttachments(c, batch.MerchantID, req) {
+		return
+	}
+
+	trace := models.TraceabilityInfo{
+		BatchID:       batch.ID,
+		MerchantID:    batch.MerchantID,
+		StageType:     req.StageType,
+		Content:       string(req.Content),
+		SchemaVersion: version,
+		Attachments:   req.attachmentsJSON(),
+		Version:       1,
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&trace).Error; err != nil {
+			return err
+		}
+		_, err := h.chain.Append(tx, &trace, tracechain.ActionCreate, currentUserID(c))
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "trace.create_failed",
+			"msg":     i18n.T(c, "trace.create_failed"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "trace.create_success",
+		"msg":     i18n.T(c, "trace.create_success"),
+		"data": gin.H{
+			"trace_id": trace.ID,
+			"version":  trace.Version,
+		},
+	})
+}
+
+// UpdateTrace 更新溯源信息
+func (h *TraceHandler) UpdateTrace(c *gin.Context) {
+	trace, ok := h.findTrace(c)
+	if !ok {
+		return
+	}
+
+	req, ok := h.bindRequest(c)
+	if !ok {
+		return
+	}
+
+	// 更新时按当前模板重新校验，并记录新的模板版本
+	version, ok := h.validateSchema(c, trace.MerchantID, req)
+	if !ok {
+		return
+	}
+	if !h.validateAttachments(c, trace.MerchantID, req) {
+		return
+	}
+
+	// 修改生成新版本，旧版本保留在哈希链中
+	trace.StageType = req.StageType
+	trace.Content = string(req.Content)
+	trace.SchemaVersion = version
+	trace.Attachments = req.attachmentsJSON()
+	trace.Version++
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(trace).Updates(map[string]interface{}{
+			"stage_type":     trace.StageType,
+			"content":        trace.Content,
+			"schema_version": trace.SchemaVersion,
+			"attachments":    trace.Attachments,
+			"version":        trace.Version,
+			"updated_at":     time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		_, err := h.chain.Append(tx, trace, tracechain.ActionUpdate, currentUserID(c))
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "trace.update_failed",
+			"msg":     i18n.T(c, "trace.update_failed"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "trace.update_success",
+		"msg":     i18n.T(c, "trace.update_success"),
+		"data": gin.H{
+			"trace_id": trace.ID,
+			"version":  trace.Version,
+		},
+	})
+}
+
+// DeleteTrace 删除溯源信息
+func (h *TraceHandler) DeleteTrace(c *gin.Context) {
+	trace, ok := h.findTrace(c)
+	if !ok {
+		return
+	}
+
+	// 软删除，并在哈希链中追加删除记录
+	trace.Version++
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(trace).Update("version", trace.Version).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(trace).Error; err != nil {
+			return err
+		}
+		_, err := h.chain.Append(tx, trace, tracechain.ActionDelete, currentUserID(c))
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "trace.delete_failed",
+			"msg":     i18n.T(c, "trace.delete_failed"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "trace.delete_success",
+		"msg":     i18n.T(c, "trace.delete_success"),
+	})
+}
+
+// GetPublicTraces 获取防伪码所属批次的溯源信息，只返回模板中标记为对消费者展示的字段
+// 调用方必须已知完整防伪码，未使用模板写入的溯源信息不展示内容。
+func (h *TraceHandler) GetPublicTraces(c *gin.Context) {
+	var code models.SecurityCode
+	if err := h.db.Where("code = ?", c.Param("code")).First(&code).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "code.not_found",
+			"msg":     i18n.T(c, "code.not_found"),
+		})
+		return
+	}
+
+	var traces []models.TraceabilityInfo
+	h.db.Where("batch_id = ? AND merchant_id = ?", code.BatchID, code.MerchantID).
+		Order("stage_type asc, id asc").Find(&traces)
+
+	schemas := h.loadSchemas(code.MerchantID, traces)
+	timeline := make([]gin.H, 0, len(traces))
+	for _, trace := range traces {
+		fields := []map[string]interface{}{}
+		if schema, ok := schemas[schemaKey(trace.StageType, trace.SchemaVersion)]; ok {
+			var content map[string]interface{}
+			if err := json.Unmarshal([]byte(trace.Content), &content); err == nil {
+				fields = services.ConsumerTraceContent(schema.FieldList, content)
+				h.resolveAttachments(code.MerchantID, fields)
+			}
+		}
+		timeline = append(timeline, gin.H{
+			"trace_id":   trace.ID,
+			"version":    trace.Version,
+			"stage_type": trace.StageType,
+			"fields":     fields,
+			"created_at": trace.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.TM(c, code.MerchantID, "common.query_success"),
+		"data":    timeline,
+	})
+}
+
+// validateSchema 按商户当前的阶段模板校验内容，返回使用的模板版本，未定义模板时为0
+func (h *TraceHandler) validateSchema(c *gin.Context, merchantID uint, req *TraceRequest) (int, bool) {
+	schema, fields, err := services.CurrentTraceSchema(h.db, merchantID, req.StageType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return 0, false
+	}
+	if schema == nil {
+		return 0, true
+	}
+
+	var content map[string]interface{}
+	json.Unmarshal(req.Content, &content)
+	if err := services.ValidateTraceContent(fields, content); err != nil {
+		key, args := i18n.ErrorKey(err, "trace.content_invalid")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
+		})
+		return 0, false
+	}
+	return schema.Version, true
+}
+
+// validateAttachments 校验以ID引用的附件属于当前商户
+func (h *TraceHandler) validateAttachments(c *gin.Context, merchantID uint, req *TraceRequest) bool {
+	ids := req.attachmentIDs()
+	found, err := h.attachments.FindMany(merchantID, ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return false
+	}
+	for _, id := range ids {
+		if _, ok := found[id]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    400,
+				"msg_key": "attachment.not_found_id",
+				"msg":     i18n.T(c, "attachment.not_found_id", id),
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// resolveAttachments 将图片和文件字段中的附件ID替换为签名下载链接，其他商户的附件ID不展示
+func (h *TraceHandler) resolveAttachments(merchantID uint, fields []map[string]interface{}) {
+	var ids []uint
+	for _, field := range fields {
+		if id, ok := field["value"].(float64); ok && (field["type"] == services.FieldTypeImage || field["type"] == services.FieldTypeFile) {
+			ids = append(ids, uint(id))
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	found, _ := h.attachments.FindMany(merchantID, ids)
+	for _, field := range fields {
+		id, ok := field["value"].(float64)
+		if !ok || (field["type"] != services.FieldTypeImage && field["type"] != services.FieldTypeFile) {
+			continue
+		}
+		if attachment, exists := found[uint(id)]; exists {
+			field["value"] = h.attachments.URLs(&attachment)
+		} else {
+			field["value"] = nil
+		}
+	}
+}
+
+// loadSchemas 加载溯源信息写入时使用的模板版本，键为 "阶段类型:版本"
+func (h *TraceHandler) loadSchemas(merchantID uint, traces []models.TraceabilityInfo) map[string]TraceSchemaView {
+	schemas := make(map[string]TraceSchemaView)
+	for _, trace := range traces {
+		if trace.SchemaVersion == 0 {
+			continue
+		}
+		key := schemaKey(trace.StageType, trace.SchemaVersion)
+		if _, loaded := schemas[key]; loaded {
+			continue
+		}
+
+		var schema models.TraceStageSchema
+		if err := h.db.Where("merchant_id = ? AND stage_type = ? AND version = ?",
+			merchantID, trace.StageType, trace.SchemaVersion).First(&schema).Error; err == nil {
+			schemas[key] = newTraceSchemaView(schema)
+		}
+	}
+	return schemas
+}
+
+// schemaKey 模板缓存键
+func schemaKey(stageType, version int) string {
+	return strconv.Itoa(stageType) + ":" + strconv.Itoa(version)
+}
+
+// bindRequest 绑定并校验溯源信息请求，失败时直接返回400
+func (h *TraceHandler) bindRequest(c *gin.Context) (*TraceRequest, bool) {
+	var req TraceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return nil, false
+	}
+
+	if err := req.validate(); err != nil {
+		key, args := i18n.ErrorKey(err, "common.bad_request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
+		})
+		return nil, false
+	}
+	return &req, true
+}
+
+// findBatch 查询属于当前商户的批次，不存在时直接返回404
+func (h *TraceHandler) findBatch(c *gin.Context) (*models.ProductBatch, bool) {
+	merchantID, _ := c.Get("merchantID")
+
+	var batch models.ProductBatch
+	if err := h.db.Where("id = ? AND merchant_id = ?", c.Param("id"), merchantID).First(&batch).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "batch.not_found_or_forbidden",
+			"msg":     i18n.T(c, "batch.not_found_or_forbidden"),
+		})
+		return nil, false
+	}
+	return &batch, true
+}
+
+// findTrace 查询属于当前商户批次的溯源信息，不存在时直接返回404
+func (h *TraceHandler) findTrace(c *gin.Context) (*models.TraceabilityInfo, bool) {
+	merchantID, _ := c.Get("merchantID")
+
+	var trace models.TraceabilityInfo
+	if err := h.db.Joins("JOIN product_batches ON product_batches.id = traceability_infos.batch_id").
+		Where("traceability_infos.id = ? AND traceability_infos.merchant_id = ? AND product_batches.merchant_id = ?",
+			c.Param("id"), merchantID, merchantID).
+		First(&trace).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "trace.not_found",
+			"msg":     i18n.T(c, "trace.not_found"),
+		})
+		return nil, false
+	}
+	return &trace, true
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/services"
+	"anti-fake-system/tracechain"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TraceChainHandler struct {
+	db    *gorm.DB
+	cfg   *config.Config
+	chain *services.TraceChainService
+}
+
+func NewTraceChainHandler(db *gorm.DB, cfg *config.Config) *TraceChainHandler {
+	return &TraceChainHandler{db: db, cfg: cfg, chain: services.NewTraceChainService(db)}
+}
+
+// GetTraceVersions 获取溯源信息的全部历史版本，已删除的溯源信息同样可查
+func (h *TraceChainHandler) GetTraceVersions(c *gin.Context) {
+	var entries []models.TraceChainEntry
+	h.db.Where("trace_id = ? AND merchant_id = ?", c.Param("id"), currentMerchantID(c)).
+		Order("seq asc").Find(&entries)
+	if len(entries) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "trace.not_found",
+			"msg":     i18n.T(c, "trace.not_found"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data":    entries,
+	})
+}
+
+// VerifyBatchChain 重算批次的整条哈希链，检查数据库中的记录是否被篡改
+func (h *TraceChainHandler) VerifyBatchChain(c *gin.Context) {
+	var batch models.ProductBatch
+	if err := h.db.Where("id = ? AND merchant_id = ?", c.Param("id"), currentMerchantID(c)).First(&batch).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "batch.not_found_or_forbidden",
+			"msg":     i18n.T(c, "batch.not_found_or_forbidden"),
+		})
+		return
+	}
+
+	head, count, err := h.chain.VerifyBatch(batch.ID)
+	data := gin.H{
+		"batch_id":    batch.ID,
+		"entry_count": count,
+		"head_hash":   head,
+		"valid":       err == nil,
+		"failure":     nil,
+		"scheme":      tracechain.Scheme,
+	}
+	if err != nil {
+		data["failure"] = err.Error()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data":    data,
+	})
+}
+
+// GetRoots 获取当前商户发布的默克尔根
+func (h *TraceChainHandler) GetRoots(c *gin.Context) {
+	h.listRoots(c, currentMerchantID(c))
+}
+
+// PublishRoot 立即发布当前商户的默克尔根，与最近一次相同时返回已有的根
+func (h *TraceChainHandler) PublishRoot(c *gin.Context) {
+	root, changed, err := h.chain.Publish(currentMerchantID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "trace_chain.publish_failed",
+			"msg":     i18n.T(c, "trace_chain.publish_failed"),
+		})
+		return
+	}
+	if root == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "trace_chain.nothing_to_publish",
+			"msg":     i18n.T(c, "trace_chain.nothing_to_publish"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "trace_chain.publish_success",
+		"msg":     i18n.T(c, "trace_chain.publish_success"),
+		"data": gin.H{
+			"root":      root,
+			"published": changed,
+		},
+	})
+}
+
+// GetPublicRoots 获取商户公开的默克尔根，第三方可自行留存用于日后核验
+func (h *TraceChainHandler) GetPublicRoots(c *gin.Context) {
+	var merchant models.Merchant
+	if err := h.db.Where("code = ? AND status = 1", c.Param("code")).First(&merchant).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "merchant.not_found",
+			"msg":     i18n.T(c, "merchant.not_found"),
+		})
+		return
+	}
+	h.listRoots(c, merchant.ID)
+}
+
+// GetPublicProof 获取防伪码所属批次的哈希链和默克尔证明
+// 只披露模板中对消费者展示的字段值，其余字段只返回承诺值，核验方法见 tracechain 包。
+func (h *TraceChainHandler) GetPublicProof(c *gin.Context) {
+	var code models.SecurityCode
+	if err := h.db.Where("code = ?", c.Param("code")).First(&code).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "code.not_found",
+			"msg":     i18n.T(c, "code.not_found"),
+		})
+		return
+	}
+
+	rootID, _ := strconv.ParseUint(c.Query("root_id"), 10, 64)
+	proof, err := h.chain.Proof(code.MerchantID, code.BatchID, uint(rootID))
+	if err != nil {
+		key, args := i18n.ErrorKey(err, "common.internal_error")
+		status := http.StatusNotFound
+		if key == "common.internal_error" {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{
+			"code":    status,
+			"msg_key": key,
+			"msg":     i18n.TM(c, code.MerchantID, key, args...),
+		})
+		return
+	}
+
+	visible := h.visibleFields(code.MerchantID)
+	records, err := h.chain.Records(code.BatchID, proof.Leaf.Seq, func(entry *models.TraceChainEntry, key string) bool {
+		return visible(entry.StageType, entry.SchemaVersion)[key]
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.TM(c, code.MerchantID, "common.internal_error"),
+		})
+		return
+	}
+
+	var pending int64
+	h.db.Model(&models.TraceChainEntry{}).Where("batch_id = ? AND seq > ?", code.BatchID, proof.Leaf.Seq).Count(&pending)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.TM(c, code.MerchantID, "common.query_success"),
+		"data": gin.H{
+			"scheme":          tracechain.Scheme,
+			"batch_id":        code.BatchID,
+			"records":         records,
+			"proof":           proof,
+			"pending_records": pending,
+		},
+	})
+}
+
+// visibleFields 返回按阶段模板版本查询对消费者展示字段的函数，结果按版本缓存
+func (h *TraceChainHandler) visibleFields(merchantID uint) func(stageType, version int) map[string]bool {
+	cache := make(map[string]map[string]bool)
+	return func(stageType, version int) map[string]bool {
+		key := schemaKey(stageType, version)
+		if fields, ok := cache[key]; ok {
+			return fields
+		}
+
+		fields := make(map[string]bool)
+		var schema models.TraceStageSchema
+		if version > 0 && h.db.Where("merchant_id = ? AND stage_type = ? AND version = ?",
+			merchantID, stageType, version).First(&schema).Error == nil {
+			if list, err := services.ParseSchemaFields(schema.Fields); err == nil {
+				for _, field := range list {
+					fields[field.Key] = field.ConsumerVisible
+				}
+			}
+		}
+		cache[key] = fields
+		return fields
+	}
+}
+
+// listRoots 分页输出商户发布的默克尔根
+func (h *TraceChainHandler) listRoots(c *gin.Context, merchantID uint) {
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	query := h.db.Model(&models.TraceMerkleRoot{}).Where("merchant_id = ?", merchantID)
+
+	var total int64
+	query.Count(&total)
+
+	var roots []models.TraceMerkleRoot
+	offset := (page - 1) * size
+	query.Offset(offset).Limit(size).Order("id desc").Find(&roots)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.TM(c, merchantID, "common.query_success"),
+		"data": gin.H{
+			"total": total,
+			"page":  page,
+			"size":  size,
+			"list":  roots,
+		},
+	})
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/services"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TraceSchemaHandler struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+func NewTraceSchemaHandler(db *gorm.DB, cfg *config.Config) *TraceSchemaHandler {
+	return &TraceSchemaHandler{db: db, cfg: cfg}
+}
+
+// TraceSchemaRequest 保存阶段模板请求
+type TraceSchemaRequest struct {
+	Fields []services.SchemaField `json:"fields" binding:"required"`
+}
+
+// TraceSchemaView 阶段模板及解析后的字段定义
+type TraceSchemaView struct {
+	models.TraceStageSchema
+	FieldList []services.SchemaField `json:"field_list"`
+}
+
+// GetSchemas 获取各阶段当前生效的模板
+func (h *TraceSchemaHandler) GetSchemas(c *gin.Context) {
+	merchantID, _ := c.Get("merchantID")
+
+	var schemas []models.TraceStageSchema
+	h.db.Where("merchant_id = ?", merchantID).Order("stage_type asc, version desc").Find(&schemas)
+
+	// 每个阶段只保留最新版本
+	current := make([]TraceSchemaView, 0, len(services.TraceStageTypes))
+	for _, schema := range schemas {
+		if len(current) > 0 && current[len(current)-1].StageType == schema.StageType {
+			continue
+		}
+		current = append(current, newTraceSchemaView(schema))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"list":        current,
+			"stage_types": services.TraceStageTypes,
+			"field_types": services.TraceFieldTypes,
+		},
+	})
+}
+
+// GetSchemaVersions 获取某阶段模板的全部历史版本
+func (h *TraceSchemaHandler) GetSchemaVersions(c *gin.Context) {
+	merchantID, _ := c.Get("merchantID")
+
+	stageType, ok := h.parseStageType(c)
+	if !ok {
+		return
+	}
+
+	var schemas []models.TraceStageSchema
+	h.db.Where("merchant_id = ? AND stage_type = ?", merchantID, stageType).Order("version desc").Find(&schemas)
+
+	versions := make([]TraceSchemaView, len(schemas))
+	for i, schema := range schemas {
+		versions[i] = newTraceSchemaView(schema)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data":    versions,
+	})
+}
+
+// SaveSchema 保存阶段模板，每次保存生成新版本，已有溯源信息继续按写入时的版本渲染
+func (h *TraceSchemaHandler) SaveSchema(c *gin.Context) {
+	stageType, ok := h.parseStageType(c)
+	if !ok {
+		return
+	}
+
+	var req TraceSchemaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	if err := services.ValidateSchemaFields(req.Fields); err != nil {
+		key, args := i18n.ErrorKey(err, "common.bad_request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
+		})
+		return
+	}
+
+	fields, _ := json.Marshal(req.Fields)
+	userID, _ := c.Get("userID")
+	schema := models.TraceStageSchema{
+		MerchantID: currentMerchantID(c),
+		StageType:  stageType,
+		Fields:     string(fields),
+	}
+	if id, ok := userID.(uint); ok {
+		schema.CreatedBy = id
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var latest int
+		if err := tx.Model(&models.TraceStageSchema{}).
+			Where("merchant_id = ? AND stage_type = ?", schema.MerchantID, stageType).
+			Select("COALESCE(MAX(version), 0)").Scan(&latest).Error; err != nil {
+			return err
+		}
+		schema.Version = latest + 1
+		return tx.Create(&schema).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "trace_schema.save_failed",
+			"msg":     i18n.T(c, "trace_schema.save_failed"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "trace_schema.save_success",
+		"msg":     i18n.T(c, "trace_schema.save_success"),
+		"data":    newTraceSchemaView(schema),
+	})
+}
+
+// parseStageType 解析路径中的阶段类型，不支持的类型直接返回400
+func (h *TraceSchemaHandler) parseStageType(c *gin.Context) (int, bool) {
+	stageType, err := strconv.Atoi(c.Param("stage_type"))
+	if err != nil || !services.IsTraceStage(stageType) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "trace.stage_type_invalid",
+			"msg":     i18n.T(c, "trace.stage_type_invalid", stageType),
+		})
+		return 0, false
+	}
+	return stageType, true
+}
+
+// newTraceSchemaView 解析模板字段定义
+func newTraceSchemaView(schema models.TraceStageSchema) TraceSchemaView {
+	fields, _ := services.ParseSchemaFields(schema.Fields)
+	return TraceSchemaView{TraceStageSchema: schema, FieldList: fields}
+}
@@ -0,0 +1,440 @@
+package handlers
+
+import (
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/services"
+	"anti-fake-system/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TwoFactorChallenge 密码验证通过但需要两步验证时的登录响应
+// setup_required为true表示角色要求两步验证但尚未绑定，需先用两步验证令牌获取绑定信息。
+type TwoFactorChallenge struct {
+	ChallengeToken string    `json:"challenge_token"`
+	ExpireAt       time.Time `json:"expire_at"`
+	SetupRequired  bool      `json:"setup_required"`
+}
+
+// TwoFactorChallengeRequest 使用两步验证令牌获取绑定信息的请求
+type TwoFactorChallengeRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+}
+
+// TwoFactorVerifyRequest 登录时提交两步验证码的请求，code为6位动态验证码或恢复码
+type TwoFactorVerifyRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required,max=20"`
+}
+
+// TwoFactorCodeRequest 提交两步验证码的请求，code为6位动态验证码，除启用外也可以使用恢复码
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required,max=20"`
+}
+
+// StepUpRequest 换取二次验证令牌的请求，已启用两步验证时提交code（验证码或恢复码），未启用时提交password
+type StepUpRequest struct {
+	Code     string `json:"code" binding:"max=20"`
+	Password string `json:"password" binding:"max=72"`
+}
+
+// challenge 签发两步验证令牌，客户端凭该令牌提交验证码完成登录
+func (h *AuthHandler) challenge(c *gin.Context, user *models.User, setupRequired bool) {
+	token, expireAt, err := h.tokens.IssuePurpose(user, utils.TokenPurposeChallenge,
+		time.Duration(h.cfg.TwoFactor.ChallengeExpire)*time.Minute)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "auth.token_generate_failed",
+			"msg":     i18n.T(c, "auth.token_generate_failed"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "auth.two_factor_required",
+		"msg":     i18n.T(c, "auth.two_factor_required"),
+		"data": TwoFactorChallenge{
+			ChallengeToken: token,
+			ExpireAt:       expireAt,
+			SetupRequired:  setupRequired,
+		},
+	})
+}
+
+// ChallengeSetup 角色要求两步验证但尚未绑定的账号在登录过程中获取绑定信息
+func (h *AuthHandler) ChallengeSetup(c *gin.Context) {
+	var req TwoFactorChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	user, ok := h.challengeUser(c, req.ChallengeToken)
+	if !ok {
+		return
+	}
+	h.setup(c, user)
+}
+
+// VerifyTwoFactor 登录时提交两步验证码，通过后签发访问令牌和刷新令牌
+// 尚未启用两步验证的账号提交绑定后的第一个验证码即启用，并在登录结果中返回恢复码。
+func (h *AuthHandler) VerifyTwoFactor(c *gin.Context) {
+	var req TwoFactorVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	user, ok := h.challengeUser(c, req.ChallengeToken)
+	if !ok {
+		return
+	}
+	status, err := h.twoFactor.Status(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	var recoveryCodes []string
+	if !h.verifyCode(c, user, func() error {
+		if status.Enabled {
+			return h.twoFactor.Verify(user, req.Code)
+		}
+		codes, err := h.twoFactor.Enable(user, req.Code)
+		recoveryCodes = codes
+		return err
+	}) {
+		return
+	}
+	if !status.Enabled {
+		recordAudit(c, h.audits, services.AuditTwoFactorEnable, services.AuditTargetUser, user.ID, user.MerchantID, nil)
+	}
+
+	h.guard.Succeed(c.Request.Context(), user.Username)
+	h.completeLogin(c, user, recoveryCodes)
+}
+
+// GetTwoFactor 获取当前用户的两步验证状态
+func (h *AuthHandler) GetTwoFactor(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	status, err := h.twoFactor.Status(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data":    status,
+	})
+}
+
+// SetupTwoFactor 生成TOTP密钥和otpauth地址，提交验证码启用前可以重复获取
+func (h *AuthHandler) SetupTwoFactor(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	h.setup(c, user)
+}
+
+// EnableTwoFactor 提交绑定后的第一个验证码启用两步验证，返回恢复码
+func (h *AuthHandler) EnableTwoFactor(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	var recoveryCodes []string
+	if !h.verifyCode(c, user, func() error {
+		codes, err := h.twoFactor.Enable(user, req.Code)
+		recoveryCodes = codes
+		return err
+	}) {
+		return
+	}
+	recordAudit(c, h.audits, services.AuditTwoFactorEnable, services.AuditTargetUser, user.ID, user.MerchantID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "two_factor.enabled",
+		"msg":     i18n.T(c, "two_factor.enabled"),
+		"data": gin.H{
+			"recovery_codes": recoveryCodes,
+		},
+	})
+}
+
+// DisableTwoFactor 提交验证码或恢复码关闭两步验证，角色要求两步验证时不能关闭
+func (h *AuthHandler) DisableTwoFactor(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if !h.verifyCode(c, user, func() error {
+		return h.twoFactor.Disable(user, req.Code)
+	}) {
+		return
+	}
+	recordAudit(c, h.audits, services.AuditTwoFactorDisable, services.AuditTargetUser, user.ID, user.MerchantID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "two_factor.disabled",
+		"msg":     i18n.T(c, "two_factor.disabled"),
+	})
+}
+
+// RegenerateRecoveryCodes 提交验证码重新生成恢复码，原有恢复码全部失效
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	var recoveryCodes []string
+	if !h.verifyCode(c, user, func() error {
+		codes, err := h.twoFactor.RegenerateRecoveryCodes(user, req.Code)
+		recoveryCodes = codes
+		return err
+	}) {
+		return
+	}
+	recordAudit(c, h.audits, services.AuditRecoveryCodes, services.AuditTargetUser, user.ID, user.MerchantID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "two_factor.recovery_codes_generated",
+		"msg":     i18n.T(c, "two_factor.recovery_codes_generated"),
+		"data": gin.H{
+			"recovery_codes": recoveryCodes,
+		},
+	})
+}
+
+// StepUp 换取短期的二次验证令牌，敏感操作在X-Step-Up-Token请求头中携带该令牌
+// 已启用两步验证的账号提交验证码或恢复码；未启用的账号提交登录密码，角色要求两步验证但尚未启用时需先启用。
+func (h *AuthHandler) StepUp(c *gin.Context) {
+	var req StepUpRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	status, err := h.twoFactor.Status(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "common.internal_error",
+			"msg":     i18n.T(c, "common.internal_error"),
+		})
+		return
+	}
+
+	var verify func() error
+	switch {
+	case status.Enabled:
+		verify = func() error {
+			if req.Code == "" {
+				return i18n.NewError("two_factor.code_required")
+			}
+			return h.twoFactor.Verify(user, req.Code)
+		}
+	case status.Required:
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    403,
+			"msg_key": "two_factor.enrollment_required",
+			"msg":     i18n.T(c, "two_factor.enrollment_required"),
+		})
+		return
+	default:
+		verify = func() error {
+			if req.Password == "" {
+				return i18n.NewError("auth.password_required")
+			}
+			if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+				return i18n.NewError("auth.password_incorrect")
+			}
+			return nil
+		}
+	}
+	if !h.verifyCode(c, user, verify) {
+		return
+	}
+	h.guard.Succeed(c.Request.Context(), user.Username)
+
+	token, expireAt, err := h.tokens.IssuePurpose(user, utils.TokenPurposeStepUp,
+		time.Duration(h.cfg.TwoFactor.StepUpExpire)*time.Minute)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "auth.token_generate_failed",
+			"msg":     i18n.T(c, "auth.token_generate_failed"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "auth.step_up_success",
+		"msg":     i18n.T(c, "auth.step_up_success"),
+		"data": gin.H{
+			"step_up_token": token,
+			"expire_at":     expireAt,
+		},
+	})
+}
+
+// setup 生成并返回TOTP绑定信息
+func (h *AuthHandler) setup(c *gin.Context, user *models.User) {
+	setup, err := h.twoFactor.Setup(user)
+	if err != nil {
+		key, args := i18n.ErrorKey(err, "common.internal_error")
+		status := http.StatusBadRequest
+		if key == "common.internal_error" {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{
+			"code":    status,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "two_factor.setup_success",
+		"msg":     i18n.T(c, "two_factor.setup_success"),
+		"data":    setup,
+	})
+}
+
+// verifyCode 执行验证码或密码校验，校验失败与登录密码错误共用该用户名的失败计数，等待期或锁定期内直接拒绝
+func (h *AuthHandler) verifyCode(c *gin.Context, user *models.User, verify func() error) bool {
+	ctx := c.Request.Context()
+	if wait := h.guard.Check(ctx, user.Username, c.ClientIP()); wait > 0 {
+		tooManyAttempts(c, wait)
+		return false
+	}
+
+	if err := verify(); err != nil {
+		key, args := i18n.ErrorKey(err, "common.internal_error")
+		status := http.StatusBadRequest
+		switch key {
+		case "common.internal_error":
+			status = http.StatusInternalServerError
+		case "two_factor.code_invalid", "auth.password_incorrect":
+			h.guard.Fail(ctx, user.Username, c.ClientIP(), user)
+		}
+		c.JSON(status, gin.H{
+			"code":    status,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
+		})
+		return false
+	}
+	return true
+}
+
+// challengeUser 解析两步验证令牌并查询用户，令牌无效、已吊销或账号已禁用时直接输出401
+// 通过后按该用户写入上下文，审计日志以其为操作人。
+func (h *AuthHandler) challengeUser(c *gin.Context, token string) (*models.User, bool) {
+	var user models.User
+	valid := false
+	if claims, err := h.tokens.ParsePurpose(token, utils.TokenPurposeChallenge); err == nil && !h.tokens.IsRevoked(c.Request.Context(), claims) {
+		valid = h.db.Where("id = ? AND status = ?", claims.UserID, services.UserStatusEnabled).First(&user).Error == nil
+	}
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"code":    401,
+			"msg_key": "two_factor.challenge_invalid",
+			"msg":     i18n.T(c, "two_factor.challenge_invalid"),
+		})
+		return nil, false
+	}
+
+	c.Set("userID", user.ID)
+	c.Set("userType", user.UserType)
+	c.Set("merchantID", user.MerchantID)
+	c.Set("username", user.Username)
+	return &user, true
+}
+
+// currentUser 查询当前登录用户
+func (h *AuthHandler) currentUser(c *gin.Context) (*models.User, bool) {
+	var user models.User
+	if err := h.db.First(&user, currentUserID(c)).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"code":    401,
+			"msg_key": "auth.user_not_found",
+			"msg":     i18n.T(c, "auth.user_not_found"),
+		})
+		return nil, false
+	}
+	return &user, true
+}
@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"anti-fake-system/config"
+	"anti-fake-system/i18n"
 	"anti-fake-system/models"
+	"anti-fake-system/services"
+	"anti-fake-system/utils"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,74 +14,118 @@ import (
 )
 
 type VerifyHandler struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db         *gorm.DB
+	cfg        *config.Config
+	dispatcher *services.WebhookDispatcher
+	logWriter  *services.VerifyLogWriter
+	records    *services.VerifyRecordRepository
+	pinGuard   *services.PinGuard
 }
 
-func NewVerifyHandler(db *gorm.DB, cfg *config.Config) *VerifyHandler {
-	return &VerifyHandler{db: db, cfg: cfg}
+func NewVerifyHandler(db *gorm.DB, cfg *config.Config, dispatcher *services.WebhookDispatcher, logWriter *services.VerifyLogWriter, records *services.VerifyRecordRepository, pinGuard *services.PinGuard) *VerifyHandler {
+	return &VerifyHandler{db: db, cfg: cfg, dispatcher: dispatcher, logWriter: logWriter, records: records, pinGuard: pinGuard}
 }
 
 // VerifyRequest 验证请求
 type VerifyRequest struct {
-	Code string `json:"code" binding:"required"` // 防伪码
+	Code string `json:"code" binding:"required"` // 防伪码（明码序列号）
+	Pin  string `json:"pin"`                     // 刮开PIN码，防伪码带PIN时用于确认真伪
 }
 
+// 验证状态
+const (
+	VerifyStateGenuine          = "genuine"            // 真品
+	VerifyStateFake             = "fake"               // 伪品（PIN码错误）
+	VerifyStateVoid             = "void"               // 已作废
+	VerifyStateNotFound         = "not_found"          // 防伪码不存在
+	VerifyStateScratchToConfirm = "scratch_to_confirm" // 仅输入序列号，需刮开涂层输入PIN确认
+	VerifyStateRecalled         = "recalled"           // 商品已召回
+	VerifyStatePinLocked        = "pin_locked"         // PIN错误次数过多，暂停校验
+)
+
+// 验证记录结果
+const (
+	VerifyResultFake     = 0 // 伪品
+	VerifyResultGenuine  = 1 // 真品
+	VerifyResultInvalid  = 2 // 无效码
+	VerifyResultPending  = 3 // 待刮开确认
+	VerifyResultRecalled = 4 // 已召回
+	VerifyResultLocked   = 5 // PIN校验已锁定
+)
+
 // VerifyResponse 验证响应
 type VerifyResponse struct {
-	IsGenuine       bool       `json:"is_genuine"`                  // 是否真品
-	ProductName     string     `json:"product_name"`                // 商品名称
-	BatchCode       string     `json:"batch_code"`                  // 批次标识
-	ProductionDate  time.Time  `json:"production_date"`             // 生产日期
-	FirstVerifyTime *time.Time `json:"first_verify_time,omitempty"` // 首次验证时间
-	VerifyCount     int        `json:"verify_count"`                // 验证次数
-	MerchantName    string     `json:"merchant_name"`               // 商户名称
-	Message         string     `json:"message"`                     // 验证结果消息
+	IsGenuine       bool                   `json:"is_genuine"`                  // 是否真品
+	State           string                 `json:"state"`                       // 验证状态
+	PinRequired     bool                   `json:"pin_required"`                // 是否需要输入刮开PIN码
+	ProductName     string                 `json:"product_name"`                // 商品名称
+	BatchCode       string                 `json:"batch_code"`                  // 批次标识
+	ProductionDate  time.Time              `json:"production_date"`             // 生产日期
+	FirstVerifyTime *time.Time             `json:"first_verify_time,omitempty"` // 首次验证时间
+	VerifyCount     int                    `json:"verify_count"`                // 验证次数
+	MerchantName    string                 `json:"merchant_name"`               // 商户名称
+	Recall          *services.RecallNotice `json:"recall,omitempty"`            // 召回公告，仅已召回的防伪码返回
+	RetryAfter      int                    `json:"retry_after,omitempty"`       // PIN校验锁定的剩余秒数，仅锁定时返回
+	MessageKey      string                 `json:"message_key"`                 // 验证结果消息键
+	Message         string                 `json:"message"`                     // 验证结果消息（按请求语言及商户覆盖文本输出）
+}
+
+// setMessage 设置验证结果消息，公开接口无登录商户，需显式传入防伪码所属商户以应用其覆盖文本
+func (r *VerifyResponse) setMessage(c *gin.Context, merchantID uint, key string, args ...interface{}) {
+	r.MessageKey = key
+	r.Message = i18n.TM(c, merchantID, key, args...)
 }
 
 // VerifyCode 验证防伪码
+// 带刮开PIN的防伪码只输入序列号时返回商品信息和待确认状态，只有PIN正确才确认为真品。
+// 同一防伪码或同一IP的PIN错误次数达到上限后，锁定期内不再校验PIN，返回pin_locked状态。
 func (h *VerifyHandler) VerifyCode(c *gin.Context) {
 	var req VerifyRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"code": 400,
-			"msg":  "请求参数错误",
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
 		})
 		return
 	}
 
-	// 查询防伪码记录（这里简化处理，实际应该根据分表策略查询）
-	var record models.VerificationRecord
-	if err := h.db.Where("security_code = ?", req.Code).First(&record).Error; err != nil {
+	// 查询防伪码
+	var code models.SecurityCode
+	if err := h.db.Where("code = ?", req.Code).First(&code).Error; err != nil {
 		// 防伪码不存在
 		response := VerifyResponse{
 			IsGenuine: false,
-			Message:   "防伪码不存在，请确认输入是否正确",
+			State:     VerifyStateNotFound,
 		}
+		response.setMessage(c, 0, "verify.code_not_found")
 
 		// 记录验证失败记录
-		h.recordVerification(req.Code, 0, 0, false, c)
+		h.recordVerification(req.Code, 0, VerifyResultInvalid, c)
 
 		c.JSON(http.StatusOK, gin.H{
-			"code": 200,
-			"msg":  "验证完成",
-			"data": response,
+			"code":    200,
+			"msg_key": "verify.completed",
+			"msg":     i18n.T(c, "verify.completed"),
+			"data":    response,
 		})
 		return
 	}
 
 	// 查询商品批次信息
 	var batch models.ProductBatch
-	if err := h.db.First(&batch, record.MerchantID).Error; err != nil {
+	if err := h.db.First(&batch, code.BatchID).Error; err != nil {
 		response := VerifyResponse{
 			IsGenuine: false,
-			Message:   "商品信息异常",
+			State:     VerifyStateNotFound,
 		}
-		h.recordVerification(req.Code, record.MerchantID, 0, false, c)
+		response.setMessage(c, code.MerchantID, "verify.product_error")
+		h.recordVerification(req.Code, code.MerchantID, VerifyResultInvalid, c)
 		c.JSON(http.StatusOK, gin.H{
-			"code": 200,
-			"msg":  "验证完成",
-			"data": response,
+			"code":    200,
+			"msg_key": "verify.completed",
+			"msg":     i18n.T(c, "verify.completed"),
+			"data":    response,
 		})
 		return
 	}
@@ -89,48 +135,84 @@ func (h *VerifyHandler) VerifyCode(c *gin.Context) {
 	if err := h.db.First(&product, batch.ProductID).Error; err != nil {
 		response := VerifyResponse{
 			IsGenuine: false,
-			Message:   "商品信息异常",
+			State:     VerifyStateNotFound,
 		}
-		h.recordVerification(req.Code, record.MerchantID, batch.ID, false, c)
+		response.setMessage(c, code.MerchantID, "verify.product_error")
+		h.recordVerification(req.Code, code.MerchantID, VerifyResultInvalid, c)
 		c.JSON(http.StatusOK, gin.H{
-			"code": 200,
-			"msg":  "验证完成",
-			"data": response,
+			"code":    200,
+			"msg_key": "verify.completed",
+			"msg":     i18n.T(c, "verify.completed"),
+			"data":    response,
 		})
 		return
 	}
 
 	// 查询商户信息
 	var merchant models.Merchant
-	if err := h.db.First(&merchant, record.MerchantID).Error; err != nil {
+	if err := h.db.First(&merchant, code.MerchantID).Error; err != nil {
 		response := VerifyResponse{
 			IsGenuine: false,
-			Message:   "商户信息异常",
+			State:     VerifyStateNotFound,
 		}
-		h.recordVerification(req.Code, record.MerchantID, batch.ID, false, c)
+		response.setMessage(c, code.MerchantID, "verify.merchant_error")
+		h.recordVerification(req.Code, code.MerchantID, VerifyResultInvalid, c)
 		c.JSON(http.StatusOK, gin.H{
-			"code": 200,
-			"msg":  "验证完成",
-			"data": response,
+			"code":    200,
+			"msg_key": "verify.completed",
+			"msg":     i18n.T(c, "verify.completed"),
+			"data":    response,
 		})
 		return
 	}
 
-	// 查询验证历史统计
+	// 判定验证结果
+	result := VerifyResultGenuine
+	state := VerifyStateGenuine
+	var lockWait time.Duration
+	if code.PinHash != "" && req.Pin != "" {
+		lockWait = h.pinGuard.Check(c.Request.Context(), code.Code, c.ClientIP())
+	}
+	switch {
+	case code.Status == services.CodeStatusVoid:
+		result, state = VerifyResultInvalid, VerifyStateVoid
+	case code.Status == services.CodeStatusRecalled:
+		// 已召回的防伪码无需刮开确认，直接展示召回公告
+		result, state = VerifyResultRecalled, VerifyStateRecalled
+	case code.PinHash != "" && req.Pin == "":
+		result, state = VerifyResultPending, VerifyStateScratchToConfirm
+	case lockWait > 0:
+		result, state = VerifyResultLocked, VerifyStatePinLocked
+	case code.PinHash != "" && !services.CheckPin(code.Code, req.Pin, h.cfg.Code.PinPepper, code.PinHash):
+		result, state = VerifyResultFake, VerifyStateFake
+		h.pinGuard.Fail(c.Request.Context(), code.Code, c.ClientIP())
+	case code.PinHash != "":
+		h.pinGuard.Succeed(c.Request.Context(), code.Code)
+	}
+
+	// 查询此前的真品验证次数，只需查询防伪码生成之后的月份表
 	var verifyCount int64
-	h.db.Model(&models.VerificationRecord{}).
-		Where("security_code = ?", req.Code).
+	h.records.Range(code.CreatedAt, time.Time{}).
+		Where("security_code = ? AND result = ?", req.Code, VerifyResultGenuine).
 		Count(&verifyCount)
 
 	// 查询首次验证时间
 	var firstRecord models.VerificationRecord
-	h.db.Where("security_code = ?", req.Code).
+	h.records.Range(code.CreatedAt, time.Time{}).
+		Where("security_code = ? AND result = ?", req.Code, VerifyResultGenuine).
 		Order("verify_time asc").
-		First(&firstRecord)
+		Limit(1).
+		Find(&firstRecord)
+
+	if result == VerifyResultGenuine {
+		verifyCount++
+	}
 
 	// 构建响应
 	response := VerifyResponse{
-		IsGenuine:      record.Result == 1,
+		IsGenuine:      result == VerifyResultGenuine,
+		State:          state,
+		PinRequired:    code.PinHash != "",
 		ProductName:    product.Name,
 		BatchCode:      batch.BatchCode,
 		ProductionDate: batch.ProductionDate,
@@ -141,54 +223,128 @@ func (h *VerifyHandler) VerifyCode(c *gin.Context) {
 	if firstRecord.ID != 0 {
 		response.FirstVerifyTime = &firstRecord.VerifyTime
 	}
+	if state == VerifyStateRecalled {
+		response.Recall, _ = services.NewRecallService(h.db).Notice(&code)
+	}
 
 	// 根据验证结果设置消息
-	if record.Result == 1 {
+	switch state {
+	case VerifyStateGenuine:
 		if verifyCount == 1 {
-			response.Message = "恭喜！这是正品，首次验证成功"
+			response.setMessage(c, code.MerchantID, "verify.genuine_first")
 		} else {
-			response.Message = "这是正品，但已被验证过" + strconv.FormatInt(verifyCount-1, 10) + "次"
+			response.setMessage(c, code.MerchantID, "verify.genuine_repeat", verifyCount-1)
 		}
-	} else if record.Result == 0 {
-		response.Message = "警告！此防伪码为伪品"
-	} else {
-		response.Message = "此防伪码已作废"
+	case VerifyStateScratchToConfirm:
+		response.setMessage(c, code.MerchantID, "verify.scratch_to_confirm")
+	case VerifyStateFake:
+		response.setMessage(c, code.MerchantID, "verify.pin_mismatch")
+	case VerifyStatePinLocked:
+		minutes := int((lockWait + time.Minute - 1) / time.Minute)
+		response.RetryAfter = int((lockWait + time.Second - 1) / time.Second)
+		response.setMessage(c, code.MerchantID, "verify.pin_locked", minutes)
+	case VerifyStateRecalled:
+		title := ""
+		if response.Recall != nil {
+			title = response.Recall.Title
+		}
+		response.setMessage(c, code.MerchantID, "verify.recalled", title)
+	default:
+		response.setMessage(c, code.MerchantID, "verify.void")
 	}
 
 	// 记录验证记录
-	h.recordVerification(req.Code, record.MerchantID, batch.ID, record.Result == 1, c)
+	h.recordVerification(req.Code, code.MerchantID, result, c)
+
+	// 推送验证事件，伪品、PIN校验锁定或验证次数超过阈值时额外推送异常事件
+	eventData := gin.H{
+		"security_code": req.Code,
+		"result":        result,
+		"state":         state,
+		"verify_count":  verifyCount,
+		"product_id":    product.ID,
+		"batch_id":      batch.ID,
+		"batch_code":    batch.BatchCode,
+		"ip_address":    c.ClientIP(),
+		"verify_time":   time.Now(),
+	}
+	h.dispatcher.Publish(code.MerchantID, services.EventCodeVerified, eventData)
+	if result == VerifyResultFake || result == VerifyResultLocked || verifyCount > int64(h.cfg.Verify.AnomalyThreshold) {
+		h.dispatcher.Publish(code.MerchantID, services.EventCodeAnomaly, eventData)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "验证完成",
-		"data": response,
+		"code":    200,
+		"msg_key": "verify.completed",
+		"msg":     i18n.T(c, "verify.completed"),
+		"data":    response,
 	})
 }
 
-// GetVerifyHistory 获取验证历史
+// PublicVerifyEntry 公开验证历史条目，IP已脱敏且不包含用户代理
+type PublicVerifyEntry struct {
+	VerifyTime time.Time `json:"verify_time"` // 验证时间
+	IPAddress  string    `json:"ip_address"`  // 脱敏后的IP地址
+	Result     int       `json:"result"`      // 验证结果
+}
+
+// GetVerifyHistory 获取单个防伪码的公开验证历史
+// 调用方必须已知完整防伪码，只返回该码的汇总信息和最近的脱敏记录。
 func (h *VerifyHandler) GetVerifyHistory(c *gin.Context) {
 	code := c.Param("code")
 
+	// 已知防伪码的生成时间时，只查询生成之后的月份表
+	var securityCode models.SecurityCode
+	h.db.Select("created_at").Where("code = ?", code).Limit(1).Find(&securityCode)
+
+	var summary struct {
+		VerifyCount     int64      `json:"verify_count"`
+		FirstVerifyTime *time.Time `json:"first_verify_time"`
+		LastVerifyTime  *time.Time `json:"last_verify_time"`
+	}
+	h.records.Range(securityCode.CreatedAt, time.Time{}).
+		Select("COUNT(*) AS verify_count, MIN(verify_time) AS first_verify_time, MAX(verify_time) AS last_verify_time").
+		Where("security_code = ?", code).
+		Scan(&summary)
+
+	if summary.VerifyCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "verify.no_records",
+			"msg":     i18n.T(c, "verify.no_records"),
+		})
+		return
+	}
+
 	var records []models.VerificationRecord
-	h.db.Where("security_code = ?", code).
+	h.records.Range(securityCode.CreatedAt, time.Time{}).
+		Where("security_code = ?", code).
 		Order("verify_time desc").
 		Limit(10).
 		Find(&records)
 
+	recent := make([]PublicVerifyEntry, 0, len(records))
+	for _, record := range records {
+		recent = append(recent, PublicVerifyEntry{
+			VerifyTime: record.VerifyTime,
+			IPAddress:  utils.MaskIP(record.IPAddress),
+			Result:     record.Result,
+		})
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "查询成功",
-		"data": records,
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"summary": summary,
+			"recent":  recent,
+		},
 	})
 }
 
-// recordVerification 记录验证记录
-func (h *VerifyHandler) recordVerification(code string, merchantID uint, batchID uint, isGenuine bool, c *gin.Context) {
-	result := 0
-	if isGenuine {
-		result = 1
-	}
-
+// recordVerification 记录验证记录，由异步写入器批量落库
+func (h *VerifyHandler) recordVerification(code string, merchantID uint, result int, c *gin.Context) {
 	record := models.VerificationRecord{
 		SecurityCode: code,
 		MerchantID:   merchantID,
@@ -198,36 +354,124 @@ func (h *VerifyHandler) recordVerification(code string, merchantID uint, batchID
 		UserAgent:    c.GetHeader("User-Agent"),
 	}
 
-	h.db.Create(&record)
+	h.logWriter.Write(record)
+}
+
+// GetVerifyLogMetrics 获取验证记录写入指标，用于确认记录无丢失
+func (h *VerifyHandler) GetVerifyLogMetrics(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data":    h.logWriter.Metrics(),
+	})
+}
+
+// scopedRecords 按调用方身份和start_date/end_date参数限定验证记录的查询范围
+// 商户用户只能查询本商户数据，平台用户可查询全局数据并按merchant_id筛选。
+// 时间范围决定需要联合查询的月份表，未指定时查询全部未归档的月份。
+func (h *VerifyHandler) scopedRecords(c *gin.Context) *gorm.DB {
+	var start, end time.Time
+	startDate := c.Query("start_date")
+	endDate := c.Query("end_date")
+	if startDate != "" {
+		start, _ = time.ParseInLocation("2006-01-02", startDate, time.Local)
+	}
+	if endDate != "" {
+		end, _ = time.ParseInLocation("2006-01-02", endDate, time.Local)
+	}
+
+	query := h.records.Range(start, end)
+	if startDate != "" {
+		query = query.Where("verify_time >= ?", startDate)
+	}
+	if endDate != "" {
+		query = query.Where("verify_time < DATE_ADD(?, INTERVAL 1 DAY)", endDate)
+	}
+
+	if userType, _ := c.Get("userType"); userType == 2 {
+		merchantID, _ := c.Get("merchantID")
+		return query.Where("merchant_id = ?", merchantID)
+	}
+
+	if merchantID := c.Query("merchant_id"); merchantID != "" {
+		query = query.Where("merchant_id = ?", merchantID)
+	}
+	return query
 }
 
-// GetVerifyRecords 获取验证记录
+// GetVerifyRecords 分页获取验证记录，每页最多100条
 func (h *VerifyHandler) GetVerifyRecords(c *gin.Context) {
+	code := c.Query("code")
+	result := c.Query("result")
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	query := h.scopedRecords(c)
+	if code != "" {
+		query = query.Where("security_code = ?", code)
+	}
+	if result != "" {
+		query = query.Where("result = ?", result)
+	}
+
+	var total int64
+	query.Count(&total)
+
 	var records []models.VerificationRecord
-	h.db.Order("verify_time desc").Limit(100).Find(&records)
+	offset := (page - 1) * size
+	query.Order("verify_time desc").Offset(offset).Limit(size).Find(&records)
+
+	// 没有查看完整IP数据权限时脱敏展示
+	if !canViewFullIP(c) {
+		for i := range records {
+			records[i].IPAddress = utils.MaskIP(records[i].IPAddress)
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "查询成功",
-		"data": records,
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"total": total,
+			"page":  page,
+			"size":  size,
+			"list":  records,
+		},
 	})
 }
 
-// GetVerifyStatistics 获取验证统计
+// GetVerifyStatistics 获取验证统计，支持start_date/end_date限定时间范围
 func (h *VerifyHandler) GetVerifyStatistics(c *gin.Context) {
-	var totalCount int64
-	var genuineCount int64
+	var stats struct {
+		TotalCount   int64
+		GenuineCount int64
+		FakeCount    int64
+		PendingCount int64
+	}
 
-	h.db.Model(&models.VerificationRecord{}).Count(&totalCount)
-	h.db.Model(&models.VerificationRecord{}).Where("result = 1").Count(&genuineCount)
+	// 一次扫描统计各类结果的数量
+	h.scopedRecords(c).
+		Select("COUNT(*) AS total_count, "+
+			"COALESCE(SUM(CASE WHEN result = ? THEN 1 ELSE 0 END), 0) AS genuine_count, "+
+			"COALESCE(SUM(CASE WHEN result = ? THEN 1 ELSE 0 END), 0) AS fake_count, "+
+			"COALESCE(SUM(CASE WHEN result = ? THEN 1 ELSE 0 END), 0) AS pending_count",
+			VerifyResultGenuine, VerifyResultFake, VerifyResultPending).
+		Scan(&stats)
 
 	c.JSON(http.StatusOK, gin.H{
-		"code": 200,
-		"msg":  "查询成功",
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
 		"data": gin.H{
-			"total_count":   totalCount,
-			"genuine_count": genuineCount,
-			"fake_count":    totalCount - genuineCount,
+			"total_count":   stats.TotalCount,
+			"genuine_count": stats.GenuineCount,
+			"fake_count":    stats.FakeCount,
+			"pending_count": stats.PendingCount,
+			"invalid_count": stats.TotalCount - stats.GenuineCount - stats.FakeCount - stats.PendingCount,
 		},
 	})
 }
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type VerifyArchiveHandler struct {
+	db       *gorm.DB
+	cfg      *config.Config
+	records  *services.VerifyRecordRepository
+	archiver *services.VerifyArchiver
+}
+
+func NewVerifyArchiveHandler(db *gorm.DB, cfg *config.Config, records *services.VerifyRecordRepository, archiver *services.VerifyArchiver) *VerifyArchiveHandler {
+	return &VerifyArchiveHandler{db: db, cfg: cfg, records: records, archiver: archiver}
+}
+
+// GetArchives 获取在线月份表和归档文件列表
+func (h *VerifyArchiveHandler) GetArchives(c *gin.Context) {
+	var archives []models.VerificationArchive
+	h.db.Order("month desc").Find(&archives)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"online_months":    h.records.Months(),
+			"retention_months": h.cfg.VerifyArchive.RetentionMonths,
+			"archives":         archives,
+		},
+	})
+}
+
+// ArchiveMonth 立即归档指定月份，不受保留期限制（当月除外）
+func (h *VerifyArchiveHandler) ArchiveMonth(c *gin.Context) {
+	month, ok := h.parseMonth(c)
+	if !ok {
+		return
+	}
+
+	archive, err := h.archiver.Archive(month)
+	if err != nil {
+		h.fail(c, err, "verify_archive.archive_failed")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "verify_archive.archive_success",
+		"msg":     i18n.T(c, "verify_archive.archive_success"),
+		"data":    archive,
+	})
+}
+
+// RestoreArchive 重新导入归档文件，用于调查历史验证记录
+func (h *VerifyArchiveHandler) RestoreArchive(c *gin.Context) {
+	month, ok := h.parseMonth(c)
+	if !ok {
+		return
+	}
+
+	archive, err := h.archiver.Restore(month)
+	if err != nil {
+		h.fail(c, err, "verify_archive.restore_failed")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "verify_archive.restore_success",
+		"msg":     i18n.T(c, "verify_archive.restore_success"),
+		"data":    archive,
+	})
+}
+
+// ReleaseArchive 调查结束后删除重新导入的月份表，归档文件保留
+func (h *VerifyArchiveHandler) ReleaseArchive(c *gin.Context) {
+	month, ok := h.parseMonth(c)
+	if !ok {
+		return
+	}
+
+	if err := h.archiver.Release(month); err != nil {
+		h.fail(c, err, "verify_archive.release_failed")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "verify_archive.release_success",
+		"msg":     i18n.T(c, "verify_archive.release_success"),
+	})
+}
+
+// parseMonth 校验路径中的月份参数，格式为YYYYMM
+func (h *VerifyArchiveHandler) parseMonth(c *gin.Context) (string, bool) {
+	month := c.Param("month")
+	if _, err := time.Parse("200601", month); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "verify_archive.month_invalid",
+			"msg":     i18n.T(c, "verify_archive.month_invalid"),
+		})
+		return "", false
+	}
+	return month, true
+}
+
+// fail 输出归档操作错误，业务错误返回400，其余返回500
+func (h *VerifyArchiveHandler) fail(c *gin.Context, err error, fallback string) {
+	key, args := i18n.ErrorKey(err, fallback)
+	status := http.StatusBadRequest
+	if key == fallback {
+		status = http.StatusInternalServerError
+	}
+	c.JSON(status, gin.H{
+		"code":    status,
+		"msg_key": key,
+		"msg":     i18n.T(c, key, args...),
+	})
+}
@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/services"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type WebhookHandler struct {
+	db         *gorm.DB
+	cfg        *config.Config
+	dispatcher *services.WebhookDispatcher
+}
+
+func NewWebhookHandler(db *gorm.DB, cfg *config.Config, dispatcher *services.WebhookDispatcher) *WebhookHandler {
+	return &WebhookHandler{db: db, cfg: cfg, dispatcher: dispatcher}
+}
+
+// WebhookRequest 创建/更新Webhook订阅请求
+type WebhookRequest struct {
+	Name       string   `json:"name" binding:"required"`
+	URL        string   `json:"url" binding:"required"`
+	Secret     string   `json:"secret"`      // 签名密钥，创建时为空则自动生成
+	EventTypes []string `json:"event_types"` // 订阅的事件类型，为空表示全部事件
+	Status     *int     `json:"status"`
+}
+
+// validate 校验接收地址和事件类型，未开启WEBHOOK_ALLOW_PRIVATE_TARGETS时接收地址不能指向内网或保留地址
+func (req *WebhookRequest) validate(allowPrivate bool) error {
+	if err := services.ValidateWebhookURL(req.URL, allowPrivate); err != nil {
+		return err
+	}
+	for _, t := range req.EventTypes {
+		if t != "*" && !services.IsWebhookEventType(t) {
+			return i18n.NewError("webhook.event_type_invalid", t)
+		}
+	}
+	return nil
+}
+
+// GetWebhooks 获取Webhook订阅列表
+func (h *WebhookHandler) GetWebhooks(c *gin.Context) {
+	merchantID, _ := c.Get("merchantID")
+
+	var subs []models.WebhookSubscription
+	h.db.Where("merchant_id = ?", merchantID).Order("id desc").Find(&subs)
+
+	// 列表中不返回签名密钥
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"list":        subs,
+			"event_types": services.WebhookEventTypes,
+		},
+	})
+}
+
+// CreateWebhook 创建Webhook订阅
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	if err := req.validate(h.cfg.Webhook.AllowPrivateTargets); err != nil {
+		key, args := i18n.ErrorKey(err, "common.bad_request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
+		})
+		return
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		generated, err := services.GenerateWebhookSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    500,
+				"msg_key": "webhook.secret_failed",
+				"msg":     i18n.T(c, "webhook.secret_failed"),
+			})
+			return
+		}
+		secret = generated
+	}
+
+	eventTypes, _ := json.Marshal(req.EventTypes)
+	sub := models.WebhookSubscription{
+		MerchantID: currentMerchantID(c),
+		Name:       req.Name,
+		URL:        req.URL,
+		Secret:     secret,
+		EventTypes: string(eventTypes),
+		Status:     1,
+	}
+	if req.Status != nil {
+		sub.Status = *req.Status
+	}
+
+	if err := h.db.Create(&sub).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "webhook.create_failed",
+			"msg":     i18n.T(c, "webhook.create_failed"),
+		})
+		return
+	}
+	h.dispatcher.InvalidateSubscriptions(sub.MerchantID)
+
+	// 签名密钥仅在创建时返回一次
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "webhook.create_success",
+		"msg":     i18n.T(c, "webhook.create_success"),
+		"data": gin.H{
+			"webhook_id": sub.ID,
+			"secret":     secret,
+		},
+	})
+}
+
+// UpdateWebhook 更新Webhook订阅
+func (h *WebhookHandler) UpdateWebhook(c *gin.Context) {
+	sub, ok := h.findSubscription(c)
+	if !ok {
+		return
+	}
+
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": "common.bad_request",
+			"msg":     i18n.T(c, "common.bad_request"),
+		})
+		return
+	}
+
+	if err := req.validate(h.cfg.Webhook.AllowPrivateTargets); err != nil {
+		key, args := i18n.ErrorKey(err, "common.bad_request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"msg_key": key,
+			"msg":     i18n.T(c, key, args...),
+		})
+		return
+	}
+
+	eventTypes, _ := json.Marshal(req.EventTypes)
+	updateData := map[string]interface{}{
+		"name":        req.Name,
+		"url":         req.URL,
+		"event_types": string(eventTypes),
+		"updated_at":  time.Now(),
+	}
+	if req.Secret != "" {
+		updateData["secret"] = req.Secret
+	}
+	if req.Status != nil {
+		updateData["status"] = *req.Status
+	}
+
+	if err := h.db.Model(sub).Updates(updateData).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "webhook.update_failed",
+			"msg":     i18n.T(c, "webhook.update_failed"),
+		})
+		return
+	}
+	h.dispatcher.InvalidateSubscriptions(sub.MerchantID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "webhook.update_success",
+		"msg":     i18n.T(c, "webhook.update_success"),
+	})
+}
+
+// DeleteWebhook 删除Webhook订阅
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	sub, ok := h.findSubscription(c)
+	if !ok {
+		return
+	}
+
+	if err := h.db.Delete(sub).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "webhook.delete_failed",
+			"msg":     i18n.T(c, "webhook.delete_failed"),
+		})
+		return
+	}
+	h.dispatcher.InvalidateSubscriptions(sub.MerchantID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "webhook.delete_success",
+		"msg":     i18n.T(c, "webhook.delete_success"),
+	})
+}
+
+// PingWebhook 发送测试事件，用于联调本地接收端
+func (h *WebhookHandler) PingWebhook(c *gin.Context) {
+	sub, ok := h.findSubscription(c)
+	if !ok {
+		return
+	}
+
+	delivery, err := h.dispatcher.Ping(sub)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "webhook.ping_failed",
+			"msg":     i18n.T(c, "webhook.ping_failed"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "webhook.ping_queued",
+		"msg":     i18n.T(c, "webhook.ping_queued"),
+		"data": gin.H{
+			"delivery_id": delivery.ID,
+			"event_id":    delivery.EventID,
+		},
+	})
+}
+
+// GetDeliveries 获取投递记录列表
+func (h *WebhookHandler) GetDeliveries(c *gin.Context) {
+	merchantID, _ := c.Get("merchantID")
+
+	subscriptionID := c.Query("subscription_id")
+	eventType := c.Query("event_type")
+	status := c.Query("status")
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	query := h.db.Model(&models.WebhookDelivery{}).Where("merchant_id = ?", merchantID)
+	if subscriptionID != "" {
+		query = query.Where("subscription_id = ?", subscriptionID)
+	}
+	if eventType != "" {
+		query = query.Where("event_type = ?", eventType)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	h.paginateDeliveries(c, query, page, size)
+}
+
+// GetDeadLetters 获取死信列表，即超过最大重试次数仍未投递成功的记录
+func (h *WebhookHandler) GetDeadLetters(c *gin.Context) {
+	merchantID, _ := c.Get("merchantID")
+
+	page, size, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	query := h.db.Model(&models.WebhookDelivery{}).
+		Where("merchant_id = ? AND status = ?", merchantID, services.DeliveryStatusDead)
+
+	h.paginateDeliveries(c, query, page, size)
+}
+
+// GetDeliveryDetail 获取投递详情及每次投递的日志
+func (h *WebhookHandler) GetDeliveryDetail(c *gin.Context) {
+	delivery, ok := h.findDelivery(c)
+	if !ok {
+		return
+	}
+
+	var attempts []models.WebhookDeliveryAttempt
+	h.db.Where("delivery_id = ?", delivery.ID).Order("attempt asc").Find(&attempts)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"delivery": delivery,
+			"attempts": attempts,
+		},
+	})
+}
+
+// RedeliverDelivery 手动重新投递
+func (h *WebhookHandler) RedeliverDelivery(c *gin.Context) {
+	delivery, ok := h.findDelivery(c)
+	if !ok {
+		return
+	}
+
+	if err := h.dispatcher.Redeliver(delivery); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"msg_key": "webhook.redeliver_failed",
+			"msg":     i18n.T(c, "webhook.redeliver_failed"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "webhook.redeliver_queued",
+		"msg":     i18n.T(c, "webhook.redeliver_queued"),
+	})
+}
+
+// findSubscription 查询属于当前商户的订阅，不存在时直接返回404
+func (h *WebhookHandler) findSubscription(c *gin.Context) (*models.WebhookSubscription, bool) {
+	merchantID, _ := c.Get("merchantID")
+
+	var sub models.WebhookSubscription
+	if err := h.db.Where("id = ? AND merchant_id = ?", c.Param("id"), merchantID).First(&sub).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "webhook.not_found",
+			"msg":     i18n.T(c, "webhook.not_found"),
+		})
+		return nil, false
+	}
+	return &sub, true
+}
+
+// findDelivery 查询属于当前商户的投递记录，不存在时直接返回404
+func (h *WebhookHandler) findDelivery(c *gin.Context) (*models.WebhookDelivery, bool) {
+	merchantID, _ := c.Get("merchantID")
+
+	var delivery models.WebhookDelivery
+	if err := h.db.Where("id = ? AND merchant_id = ?", c.Param("id"), merchantID).First(&delivery).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"msg_key": "webhook.delivery_not_found",
+			"msg":     i18n.T(c, "webhook.delivery_not_found"),
+		})
+		return nil, false
+	}
+	return &delivery, true
+}
+
+// paginateDeliveries 分页返回投递记录
+func (h *WebhookHandler) paginateDeliveries(c *gin.Context, query *gorm.DB, page, size int) {
+	var total int64
+	query.Count(&total)
+
+	var deliveries []models.WebhookDelivery
+	offset := (page - 1) * size
+	query.Order("id desc").Offset(offset).Limit(size).Find(&deliveries)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"msg_key": "common.query_success",
+		"msg":     i18n.T(c, "common.query_success"),
+		"data": gin.H{
+			"total": total,
+			"page":  page,
+			"size":  size,
+			"list":  deliveries,
+		},
+	})
+}
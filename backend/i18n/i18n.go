@@ -0,0 +1,333 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed locales/*.json
+var embedded embed.FS
+
+// Catalog 消息目录，按语言保存消息键到文本的映射，并支持商户按语言覆盖
+type Catalog struct {
+	mu            sync.RWMutex
+	defaultLocale string
+	messages      map[string]map[string]string          // 语言 -> 消息键 -> 文本
+	overrides     map[uint]map[string]map[string]string // 商户ID -> 语言 -> 消息键 -> 文本
+}
+
+// NewCatalog 创建空的消息目录
+func NewCatalog(defaultLocale string) *Catalog {
+	return &Catalog{
+		defaultLocale: defaultLocale,
+		messages:      make(map[string]map[string]string),
+		overrides:     make(map[uint]map[string]map[string]string),
+	}
+}
+
+// defaultCatalog 全局消息目录，服务启动时通过Load加载
+var defaultCatalog = NewCatalog("zh-CN")
+
+// Default 返回全局消息目录
+func Default() *Catalog {
+	return defaultCatalog
+}
+
+// Load 加载内置翻译文件，再加载dir目录下的 <语言>.json 文件（同名键覆盖内置文本）
+// dir不存在时只使用内置翻译。
+func Load(dir, defaultLocale string) error {
+	catalog := NewCatalog(defaultLocale)
+
+	entries, err := embedded.ReadDir("locales")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		data, err := embedded.ReadFile("locales/" + entry.Name())
+		if err != nil {
+			return err
+		}
+		if err := catalog.add(strings.TrimSuffix(entry.Name(), ".json"), data); err != nil {
+			return fmt.Errorf("内置翻译文件 %s 解析失败: %v", entry.Name(), err)
+		}
+	}
+
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			if err := catalog.add(strings.TrimSuffix(filepath.Base(file), ".json"), data); err != nil {
+				return fmt.Errorf("翻译文件 %s 解析失败: %v", file, err)
+			}
+		}
+	}
+
+	if _, ok := catalog.messages[defaultLocale]; !ok {
+		return fmt.Errorf("默认语言 %s 没有对应的翻译文件", defaultLocale)
+	}
+
+	defaultCatalog.mu.Lock()
+	defaultCatalog.defaultLocale = catalog.defaultLocale
+	defaultCatalog.messages = catalog.messages
+	defaultCatalog.mu.Unlock()
+	return nil
+}
+
+// add 合并一个语言的翻译
+func (c *Catalog) add(locale string, data []byte) error {
+	var messages map[string]string
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return err
+	}
+
+	if c.messages[locale] == nil {
+		c.messages[locale] = make(map[string]string, len(messages))
+	}
+	for key, text := range messages {
+		c.messages[locale][key] = text
+	}
+	return nil
+}
+
+// Locales 返回已加载的语言列表
+func (c *Catalog) Locales() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	locales := make([]string, 0, len(c.messages))
+	for locale := range c.messages {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Messages 返回指定语言的全部消息（不含商户覆盖）
+func (c *Catalog) Messages(locale string) map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := make(map[string]string, len(c.messages[locale]))
+	for key, text := range c.messages[locale] {
+		result[key] = text
+	}
+	return result
+}
+
+// HasKey 判断消息键是否存在于默认语言中
+func (c *Catalog) HasKey(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, ok := c.messages[c.defaultLocale][key]
+	return ok
+}
+
+// HasLocale 判断语言是否已加载
+func (c *Catalog) HasLocale(locale string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, ok := c.messages[locale]
+	return ok
+}
+
+// SetOverride 设置商户对某个语言消息的覆盖文本，text为空表示删除覆盖
+func (c *Catalog) SetOverride(merchantID uint, locale, key, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if text == "" {
+		if byLocale := c.overrides[merchantID]; byLocale != nil {
+			delete(byLocale[locale], key)
+		}
+		return
+	}
+
+	if c.overrides[merchantID] == nil {
+		c.overrides[merchantID] = make(map[string]map[string]string)
+	}
+	if c.overrides[merchantID][locale] == nil {
+		c.overrides[merchantID][locale] = make(map[string]string)
+	}
+	c.overrides[merchantID][locale][key] = text
+}
+
+// Translate 翻译消息键，查找顺序：商户覆盖 -> 目标语言 -> 默认语言 -> 消息键本身
+// 文本中的格式化占位符（如%d、%s）按args依次替换。
+func (c *Catalog) Translate(locale string, merchantID uint, key string, args ...interface{}) string {
+	c.mu.RLock()
+	text, ok := c.overrides[merchantID][locale][key]
+	if !ok {
+		text, ok = c.messages[locale][key]
+	}
+	if !ok {
+		text, ok = c.messages[c.defaultLocale][key]
+	}
+	c.mu.RUnlock()
+
+	if !ok {
+		text = key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+// Match 从候选语言中匹配已加载的语言，支持只按语种匹配（如en匹配en-US）
+func (c *Catalog) Match(candidates ...string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, candidate := range candidates {
+		candidate = normalize(candidate)
+		if candidate == "" {
+			continue
+		}
+		if _, ok := c.messages[candidate]; ok {
+			return candidate
+		}
+		base := strings.SplitN(candidate, "-", 2)[0]
+		for locale := range c.messages {
+			if strings.EqualFold(strings.SplitN(locale, "-", 2)[0], base) {
+				return locale
+			}
+		}
+	}
+	return c.defaultLocale
+}
+
+// normalize 规范化语言标签，如 en_us -> en-US
+func normalize(tag string) string {
+	tag = strings.TrimSpace(strings.ReplaceAll(tag, "_", "-"))
+	if tag == "" || tag == "*" {
+		return ""
+	}
+	parts := strings.SplitN(tag, "-", 2)
+	if len(parts) == 1 {
+		return strings.ToLower(parts[0])
+	}
+	return strings.ToLower(parts[0]) + "-" + strings.ToUpper(parts[1])
+}
+
+// parseAcceptLanguage 按q值从高到低解析Accept-Language请求头
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if fields[0] != "" {
+			tags = append(tags, weighted{tag: fields[0], q: q})
+		}
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
+
+// Locale 确定请求使用的语言：lang参数优先，其次为Accept-Language请求头
+func Locale(c *gin.Context) string {
+	if locale, ok := c.Get("locale"); ok {
+		return locale.(string)
+	}
+
+	candidates := []string{c.Query("lang")}
+	candidates = append(candidates, parseAcceptLanguage(c.GetHeader("Accept-Language"))...)
+	locale := defaultCatalog.Match(candidates...)
+
+	c.Set("locale", locale)
+	return locale
+}
+
+// T 按请求语言翻译消息键，已登录的商户用户会应用本商户的覆盖文本
+func T(c *gin.Context, key string, args ...interface{}) string {
+	return TM(c, merchantIDFromContext(c), key, args...)
+}
+
+// TM 按请求语言翻译消息键，并应用指定商户的覆盖文本（用于公开接口中已知所属商户的场景）
+func TM(c *gin.Context, merchantID uint, key string, args ...interface{}) string {
+	return defaultCatalog.Translate(Locale(c), merchantID, key, args...)
+}
+
+// merchantIDFromContext 读取认证中间件写入的商户ID
+func merchantIDFromContext(c *gin.Context) uint {
+	value, _ := c.Get("merchantID")
+	switch id := value.(type) {
+	case uint:
+		return id
+	case *uint:
+		if id != nil {
+			return *id
+		}
+	}
+	return 0
+}
+
+// Error 携带消息键的错误，服务层返回此类错误以便接口层按请求语言输出
+type Error struct {
+	Key  string
+	Args []interface{}
+}
+
+// NewError 创建携带消息键的错误
+func NewError(key string, args ...interface{}) *Error {
+	return &Error{Key: key, Args: args}
+}
+
+// Error 以默认语言输出错误文本，用于日志
+func (e *Error) Error() string {
+	return defaultCatalog.Translate("", 0, e.Key, e.Args...)
+}
+
+// ErrorKey 提取错误的消息键和参数，非Error类型的错误返回fallback
+func ErrorKey(err error, fallback string) (string, []interface{}) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Key, e.Args
+	}
+	return fallback, nil
+}
+
+// ErrorText 按请求语言输出错误文本，非Error类型的错误直接返回原始文本
+func ErrorText(c *gin.Context, err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return T(c, e.Key, e.Args...)
+	}
+	return err.Error()
+}
@@ -1,12 +1,21 @@
 package main
 
 import (
-	"log" // 导入log包，用于日志输出
+	"context"   // 导入context包，用于控制优雅退出的超时
+	"errors"    // 导入errors包，用于判断服务器关闭错误
+	"log"       // 导入log包，用于日志输出
+	"net/http"  // 导入net/http包，用于创建HTTP服务器
+	"os/signal" // 导入os/signal包，用于监听退出信号
+	"syscall"   // 导入syscall包，用于指定退出信号
+	"time"      // 导入time包，用于设置退出超时
 
 	"anti-fake-system/config"   // 导入项目配置包
 	"anti-fake-system/database" // 导入数据库初始化包
+	"anti-fake-system/i18n"     // 导入多语言消息包
 	"anti-fake-system/models"   // 导入数据模型包，用于数据库迁移
 	"anti-fake-system/routes"   // 导入路由设置包
+	"anti-fake-system/services" // 导入业务服务包
+	"anti-fake-system/storage"  // 导入附件存储包
 
 	"github.com/joho/godotenv" // 导入godotenv包，用于加载.env文件
 )
@@ -25,6 +34,22 @@ func main() {
 
 	cfg := config.Load()
 
+	// 检查PIN哈希密钥，release模式下不允许使用默认值。
+	if err := services.CheckPinPepper(cfg); err != nil {
+		log.Fatal("PIN哈希密钥检查失败:", err)
+	}
+
+	// 检查附件下载链接签名密钥，release模式下不允许使用默认值。
+	if err := services.CheckStorageURLSecret(cfg); err != nil {
+		log.Fatal("下载链接签名密钥检查失败:", err)
+	}
+
+	// 加载多语言消息目录。
+	// 先加载内置翻译，再加载I18N_DIR目录下的翻译文件，同名消息键以外部文件为准。
+	if err := i18n.Load(cfg.I18n.Dir, cfg.I18n.DefaultLocale); err != nil {
+		log.Fatal("翻译文件加载失败:", err) // 如果翻译文件格式错误，记录致命错误并退出程序
+	}
+
 	// 初始化数据库连接。
 	// 调用database.InitDB函数，传入加载的配置，获取GORM数据库实例。
 	db, err := database.InitDB(cfg)
@@ -38,6 +63,21 @@ func main() {
 		log.Fatal("数据库迁移失败:", err) // 如果数据库迁移失败，记录致命错误并退出程序
 	}
 
+	// 写入内置权限和角色，首次启用权限校验时为尚未分配角色的已有用户分配对应的管理员角色。
+	if err := services.SeedPermissions(db); err != nil {
+		log.Fatal("内置权限初始化失败:", err)
+	}
+
+	// 加载商户自定义消息文本。
+	if err := services.LoadMessageOverrides(db); err != nil {
+		log.Fatal("商户自定义消息加载失败:", err)
+	}
+
+	// 为启用哈希链之前写入的溯源信息补写链上记录。
+	if err := services.NewTraceChainService(db).Backfill(); err != nil {
+		log.Fatal("溯源哈希链补写失败:", err)
+	}
+
 	// 初始化Redis连接。
 	// 调用database.InitRedis函数，传入加载的配置，获取Redis客户端实例。
 	redisClient, err := database.InitRedis(cfg)
@@ -45,14 +85,118 @@ func main() {
 		log.Fatal("Redis初始化失败:", err) // 如果Redis初始化失败，记录致命错误并退出程序
 	}
 
+	// 启动Webhook异步投递器。
+	// 发布事件时只放入内存队列，由后台协程写入投递记录、完成签名投递和失败重试。
+	dispatcher, err := services.NewWebhookDispatcher(db, cfg)
+	if err != nil {
+		log.Fatal("Webhook投递器初始化失败:", err)
+	}
+	dispatcher.Start()
+
+	// 初始化验证记录仓库。
+	// 验证记录按月分表，启动时加载已有月份表并将旧的单表数据按月拆分。
+	records := services.NewVerifyRecordRepository(db)
+	if err := records.Init(); err != nil {
+		log.Fatal("验证记录月份表初始化失败:", err)
+	}
+
+	// 启动验证记录异步写入器。
+	// 验证接口只负责入队，后台协程按批量大小或时间间隔合并写入对应的月份表。
+	logWriter := services.NewVerifyLogWriter(records, cfg)
+	logWriter.Start()
+
+	// 启动验证记录归档器。
+	// 超过保留期的月份表定期导出为压缩文件并从数据库删除。
+	archiver := services.NewVerifyArchiver(db, records, cfg)
+	archiver.Start()
+
+	// 启动溯源默克尔根发布器。
+	// 定期以各商户溯源哈希链的链头计算默克尔根并公开。
+	rootPublisher := services.NewTraceRootPublisher(db, cfg)
+	rootPublisher.Start()
+
+	// 初始化附件存储。
+	// 根据STORAGE_DRIVER选择本地文件系统或S3兼容的对象存储。
+	store, err := storage.New(cfg.Storage)
+	if err != nil {
+		log.Fatal("附件存储初始化失败:", err)
+	}
+
+	// 启动印刷文件清理器。
+	// 含PIN明文的印刷文件在商户确认删除前保留，超过保留时长仍未删除的文件由后台协程清理。
+	printJanitor := services.NewPrintFileJanitor(cfg)
+	printJanitor.Start()
+
+	// 启动批量导入执行器。
+	// 上传的商品、批次和溯源信息文件在后台校验，商户确认后再在一个事务中写入。
+	importer := services.NewImportRunner(db, store, cfg)
+	importer.Start()
+
+	// 启动数据加密密钥管理。
+	// 规则配置和商户签名私钥使用各商户带版本的数据加密密钥加密，后台协程将旧版本规则配置重新加密为当前版本。
+	keys, err := services.NewKeyManager(db, cfg)
+	if err != nil {
+		log.Fatal("数据加密密钥管理初始化失败:", err)
+	}
+	if migrated, err := keys.MigrateLegacyRules(); err != nil {
+		log.Fatal("旧格式规则配置迁移失败:", err)
+	} else if migrated > 0 {
+		log.Printf("已将 %d 条旧格式规则配置迁移为数据加密密钥加密", migrated)
+	}
+	if _, err := services.NewSigningKeyService(db, cfg, keys).MigrateLegacyKeys(); err != nil {
+		log.Fatal("商户签名密钥迁移失败:", err)
+	}
+	keys.Start()
+
+	// 启动访问令牌签名密钥环。
+	// 私钥由主密钥加密保存；启动时确保存在当前签名密钥，后台协程定时重新加载密钥、到期轮换并删除已过验证截止时间的旧密钥。
+	jwtKeys := services.NewJWTKeyService(db, cfg, keys)
+	if err := jwtKeys.Init(); err != nil {
+		log.Fatal("JWT签名密钥初始化失败:", err)
+	}
+	jwtKeys.Start()
+
 	// 设置HTTP路由。
-	// routes.SetupRouter函数会配置所有API路由，并注入数据库和Redis客户端以及配置信息。
-	router := routes.SetupRouter(db, redisClient, cfg)
+	// routes.SetupRouter函数会配置所有API路由，并注入数据库和Redis客户端、配置信息以及后台服务。
+	router := routes.SetupRouter(db, redisClient, cfg, dispatcher, logWriter, records, archiver, store, importer, jwtKeys, keys)
 
 	// 启动HTTP服务器。
-	// 服务器将监听配置中指定的端口。
-	log.Printf("服务器启动在端口 %s", cfg.Server.Port) // 打印服务器启动信息
-	if err := router.Run(":" + cfg.Server.Port); err != nil {
-		log.Fatal("服务器启动失败:", err) // 如果服务器启动失败，记录致命错误并退出程序
+	// 服务器将监听配置中指定的端口，收到退出信号后停止接收新请求。
+	server := &http.Server{
+		Addr:    ":" + cfg.Server.Port,
+		Handler: router,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		log.Printf("服务器启动在端口 %s", cfg.Server.Port) // 打印服务器启动信息
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("服务器启动失败:", err) // 如果服务器启动失败，记录致命错误并退出程序
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("正在关闭服务器...")
+
+	// 优雅退出：先等待进行中的请求完成，再写完队列中的验证记录，最后停止Webhook投递、归档、默克尔根发布、印刷文件清理、批量导入、签名密钥轮换和重新加密。
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("服务器关闭失败:", err)
 	}
+	if err := logWriter.Close(shutdownCtx); err != nil {
+		log.Println("验证记录写入未完成:", err)
+	}
+	dispatcher.Stop()
+	archiver.Stop()
+	rootPublisher.Stop()
+	printJanitor.Stop()
+	importer.Stop()
+	jwtKeys.Stop()
+	keys.Stop()
+
+	log.Println("服务器已退出")
 }
@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"anti-fake-system/i18n"
+	"anti-fake-system/services"
 	"anti-fake-system/utils"
 	"net/http"
 	"strings"
@@ -8,6 +10,14 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// tokenService 验证访问令牌和检查吊销状态的令牌服务，由路由初始化时设置
+var tokenService *services.TokenService
+
+// UseTokens 设置验证访问令牌和检查吊销状态的令牌服务
+func UseTokens(service *services.TokenService) {
+	tokenService = service
+}
+
 // AuthMiddleware 认证中间件
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -15,8 +25,9 @@ func AuthMiddleware() gin.HandlerFunc {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{
-				"code": 401,
-				"msg":  "未提供认证令牌",
+				"code":    401,
+				"msg_key": "auth.token_missing",
+				"msg":     i18n.T(c, "auth.token_missing"),
 			})
 			c.Abort()
 			return
@@ -26,8 +37,9 @@ func AuthMiddleware() gin.HandlerFunc {
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
 			c.JSON(http.StatusUnauthorized, gin.H{
-				"code": 401,
-				"msg":  "认证令牌格式错误",
+				"code":    401,
+				"msg_key": "auth.token_malformed",
+				"msg":     i18n.T(c, "auth.token_malformed"),
 			})
 			c.Abort()
 			return
@@ -35,11 +47,23 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		// 验证JWT令牌
 		token := parts[1]
-		claims, err := utils.ParseToken(token)
+		claims, err := tokenService.Parse(token)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{
-				"code": 401,
-				"msg":  "认证令牌无效或已过期",
+				"code":    401,
+				"msg_key": "auth.token_invalid",
+				"msg":     i18n.T(c, "auth.token_invalid"),
+			})
+			c.Abort()
+			return
+		}
+
+		// 检查令牌是否已注销或随用户吊销
+		if tokenService.IsRevoked(c.Request.Context(), claims) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"code":    401,
+				"msg_key": "auth.token_revoked",
+				"msg":     i18n.T(c, "auth.token_revoked"),
 			})
 			c.Abort()
 			return
@@ -50,19 +74,45 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Set("userType", claims.UserType)
 		c.Set("merchantID", claims.MerchantID)
 		c.Set("username", claims.Username)
+		c.Set("tokenID", claims.ID)
+		if claims.ExpiresAt != nil {
+			c.Set("tokenExpiresAt", claims.ExpiresAt.Time)
+		}
 
 		c.Next()
 	}
 }
 
+// StepUpHeader 携带二次验证令牌的请求头
+const StepUpHeader = "X-Step-Up-Token"
+
+// RequireStepUp 敏感操作的二次验证中间件，请求需在X-Step-Up-Token头中携带当前用户的二次验证令牌，需在认证中间件之后使用
+func RequireStepUp() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := c.Get("userID")
+		claims, err := tokenService.ParsePurpose(c.GetHeader(StepUpHeader), utils.TokenPurposeStepUp)
+		if err != nil || claims.UserID != userID || tokenService.IsRevoked(c.Request.Context(), claims) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"code":    403,
+				"msg_key": "auth.step_up_required",
+				"msg":     i18n.T(c, "auth.step_up_required"),
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
 // PlatformAdminOnly 平台管理员专用中间件
 func PlatformAdminOnly() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userType, exists := c.Get("userType")
 		if !exists || userType != 1 { // 1表示平台用户
 			c.JSON(http.StatusForbidden, gin.H{
-				"code": 403,
-				"msg":  "无权限访问此资源",
+				"code":    403,
+				"msg_key": "auth.forbidden",
+				"msg":     i18n.T(c, "auth.forbidden"),
 			})
 			c.Abort()
 			return
@@ -79,8 +129,9 @@ func MerchantAdminOnly() gin.HandlerFunc {
 
 		if !exists || userType != 2 || merchantID == nil { // 2表示商户用户
 			c.JSON(http.StatusForbidden, gin.H{
-				"code": 403,
-				"msg":  "无权限访问此资源",
+				"code":    403,
+				"msg_key": "auth.forbidden",
+				"msg":     i18n.T(c, "auth.forbidden"),
 			})
 			c.Abort()
 			return
@@ -104,7 +155,7 @@ func PlatformAuth() gin.HandlerFunc {
 }
 
 // MerchantAuth 商户认证中间件（组合认证和商户权限检查）
-func MerchantAuth(jwtSecret string) gin.HandlerFunc {
+func MerchantAuth() gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
 		// 先进行认证
 		AuthMiddleware()(c)
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"anti-fake-system/i18n"
+	"anti-fake-system/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// permissionsKey 请求上下文中缓存当前用户权限集合的键
+const permissionsKey = "permissions"
+
+// permissionService 权限校验使用的用户权限服务，由路由初始化时设置
+var permissionService *services.PermissionService
+
+// UsePermissions 设置权限校验使用的用户权限服务
+func UsePermissions(service *services.PermissionService) {
+	permissionService = service
+}
+
+// RequirePermission 权限校验中间件，需在认证中间件之后使用
+func RequirePermission(code string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		permissions, err := loadPermissions(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    500,
+				"msg_key": "common.internal_error",
+				"msg":     i18n.T(c, "common.internal_error"),
+			})
+			c.Abort()
+			return
+		}
+
+		if !permissions[code] {
+			c.JSON(http.StatusForbidden, gin.H{
+				"code":    403,
+				"msg_key": "auth.permission_denied",
+				"msg":     i18n.T(c, "auth.permission_denied", code),
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// HasPermission 判断当前用户是否拥有权限，用于接口内的数据权限和按类型区分的操作权限
+func HasPermission(c *gin.Context, code string) bool {
+	permissions, err := loadPermissions(c)
+	return err == nil && permissions[code]
+}
+
+// loadPermissions 加载当前用户的权限集合，同一请求内只加载一次
+func loadPermissions(c *gin.Context) (map[string]bool, error) {
+	if value, exists := c.Get(permissionsKey); exists {
+		return value.(map[string]bool), nil
+	}
+
+	permissions := make(map[string]bool)
+	userID, _ := c.Get("userID")
+	if id, ok := userID.(uint); ok && permissionService != nil {
+		codes, err := permissionService.UserPermissions(c.Request.Context(), id)
+		if err != nil {
+			return nil, err
+		}
+		for _, code := range codes {
+			permissions[code] = true
+		}
+	}
+
+	c.Set(permissionsKey, permissions)
+	return permissions, nil
+}
@@ -30,6 +30,7 @@ type User struct {
 	MerchantID  *uint      // 商户用户关联的商户ID，可为空
 	Status      int        `gorm:"default:1"` // 用户状态：1-启用, 0-禁用，默认1
 	LastLoginAt *time.Time // 最后登录时间，可为空
+	CreatedBy   *uint      `gorm:"index"` // 创建该子账号的管理员用户ID，主账号为空
 	CreatedAt   time.Time  // 创建时间
 	UpdatedAt   time.Time  // 更新时间
 
@@ -41,9 +42,12 @@ type User struct {
 type Role struct {
 	ID          uint      `gorm:"primaryKey"`       // 主键ID
 	Name        string    `gorm:"size:50;not null"` // 角色名称，长度50，非空
+	Code        string    `gorm:"size:50;index"`    // 内置角色标识，如platform_admin、merchant_admin，自定义角色为空
 	RoleType    int       `gorm:"not null"`         // 角色类型：1-平台级角色, 2-商户级角色，非空
-	MerchantID  *uint     // 商户级角色关联的商户ID，可为空
-	Description string    `gorm:"size:200"` // 角色描述，长度200
+	MerchantID  *uint     // 商户级角色关联的商户ID，可为空，内置的商户管理员角色为空由所有商户共用
+	Builtin     bool      `gorm:"default:false"` // 是否内置角色，内置角色不能修改或删除
+	Description string    `gorm:"size:200"`      // 角色描述，长度200
+	TwoFactor   bool      `gorm:"default:false"` // 拥有该角色的账号登录时是否必须通过两步验证，内置角色由配置指定
 	CreatedAt   time.Time // 创建时间
 	UpdatedAt   time.Time // 更新时间
 
@@ -58,6 +62,7 @@ type Permission struct {
 	Name      string    `gorm:"size:50;not null"`              // 权限名称，长度50，非空
 	Code      string    `gorm:"size:100;uniqueIndex;not null"` // 权限标识码，长度100，唯一索引，非空
 	Type      int       `gorm:"not null"`                      // 权限类型：1-菜单权限, 2-按钮权限, 3-数据权限，非空
+	Scope     int       `gorm:"not null;default:2"`            // 适用范围：1-平台, 2-商户，与用户类型一致，默认2
 	ParentID  *uint     // 父权限ID，用于构建权限树，可为空
 	CreatedAt time.Time // 创建时间
 	UpdatedAt time.Time // 更新时间
@@ -66,9 +71,9 @@ type Permission struct {
 // UserRole 结构体定义了用户与角色关联表的数据模型。
 // 对应数据库中的 `user_roles` 表。
 type UserRole struct {
-	ID        uint      `gorm:"primaryKey"` // 主键ID
-	UserID    uint      `gorm:"not null"`   // 用户ID，非空
-	RoleID    uint      `gorm:"not null"`   // 角色ID，非空
+	ID        uint      `gorm:"primaryKey"`                         // 主键ID
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_user_role"` // 用户ID，非空
+	RoleID    uint      `gorm:"not null;uniqueIndex:idx_user_role"` // 角色ID，非空
 	CreatedAt time.Time // 创建时间
 
 	User User `gorm:"foreignKey:UserID"` // 关联的用户信息
@@ -81,7 +86,7 @@ type SecurityCodeRule struct {
 	ID         uint      `gorm:"primaryKey"`         // 主键ID
 	MerchantID uint      `gorm:"not null"`           // 商户ID，非空
 	Name       string    `gorm:"size:100;not null"`  // 规则名称，长度100，非空
-	RuleConfig string    `gorm:"type:json;not null"` // 规则配置，以JSON字符串形式存储，非空
+	RuleConfig string    `gorm:"type:text;not null"` // 加密后的规则配置，带数据加密密钥版本头，非空
 	Status     int       `gorm:"default:1"`          // 规则状态：1-启用, 0-禁用，默认1
 	CreatedAt  time.Time // 创建时间
 	UpdatedAt  time.Time // 更新时间
@@ -92,28 +97,61 @@ type SecurityCodeRule struct {
 // Product 结构体定义了商品表的数据模型。
 // 对应数据库中的 `products` 表。
 type Product struct {
-	ID          uint      `gorm:"primaryKey"`        // 主键ID
-	MerchantID  uint      `gorm:"not null"`          // 商户ID，非空
-	Name        string    `gorm:"size:200;not null"` // 商品名称，长度200，非空
-	Category    string    `gorm:"size:100"`          // 商品类别，长度100
-	Description string    `gorm:"type:text"`         // 商品描述，长文本
-	Images      string    `gorm:"type:json"`         // 商品图片URL数组，以JSON字符串形式存储
-	Status      int       `gorm:"default:1"`         // 商品状态：1-启用, 0-禁用，默认1
-	CreatedAt   time.Time // 创建时间
-	UpdatedAt   time.Time // 更新时间
+	ID          uint       `gorm:"primaryKey"`        // 主键ID
+	MerchantID  uint       `gorm:"not null"`          // 商户ID，非空
+	Name        string     `gorm:"size:200;not null"` // 商品名称，长度200，非空
+	SKU         string     `gorm:"size:64;index"`     // 商户内部货号，商户内唯一，可为空
+	GTIN        string     `gorm:"size:14;index"`     // GS1商品条码（GTIN-8/12/13/14），商户内唯一，可为空
+	Spec        string     `gorm:"size:200"`          // 规格，如250g/盒
+	Model       string     `gorm:"size:100"`          // 型号
+	CategoryID  *uint      `gorm:"index"`             // 商品分类ID，可为空
+	Category    string     `gorm:"size:255"`          // 商品类别，设置分类时为分类的完整路径，如 食品/茶叶/绿茶
+	Description string     `gorm:"type:text"`         // 商品描述，长文本
+	Images      string     `gorm:"type:json"`         // 商品图片URL数组，以JSON字符串形式存储
+	Status      int        `gorm:"default:1"`         // 商品状态：1-启用, 0-禁用, 2-已归档，默认1
+	ArchivedAt  *time.Time // 归档时间，归档的商品不能新建批次
+	CreatedAt   time.Time  // 创建时间
+	UpdatedAt   time.Time  // 更新时间
 
 	Merchant Merchant `gorm:"foreignKey:MerchantID"` // 关联的商户信息
 }
 
+// ProductCategory 结构体定义了商品分类表的数据模型。
+// 对应数据库中的 `product_categories` 表，商户内按ParentID组成多级分类树。
+type ProductCategory struct {
+	ID         uint      `gorm:"primaryKey"`       // 主键ID
+	MerchantID uint      `gorm:"not null;index"`   // 商户ID，非空
+	ParentID   *uint     `gorm:"index"`            // 上级分类ID，为空表示一级分类
+	Name       string    `gorm:"size:50;not null"` // 分类名称，长度50，非空
+	Sort       int       `gorm:"default:0"`        // 同级排序，数值小的在前
+	CreatedAt  time.Time // 创建时间
+	UpdatedAt  time.Time // 更新时间
+}
+
+// ProductHistory 结构体定义了商品变更历史表的数据模型。
+// 对应数据库中的 `product_histories` 表，每次创建、修改、归档和恢复记录一条。
+type ProductHistory struct {
+	ID         uint      `gorm:"primaryKey"`       // 主键ID
+	ProductID  uint      `gorm:"not null;index"`   // 商品ID，非空
+	MerchantID uint      `gorm:"not null"`         // 商户ID，非空
+	Action     string    `gorm:"size:20;not null"` // 动作：create、update、archive、restore、import
+	Changes    string    `gorm:"type:json"`        // 变更字段，格式为 {"字段": {"old": 旧值, "new": 新值}}
+	CreatedBy  uint      // 操作用户ID
+	CreatedAt  time.Time // 操作时间
+}
+
 // ProductBatch 结构体定义了商品批次表的数据模型。
 // 对应数据库中的 `product_batches` 表。
 type ProductBatch struct {
-	ID             uint      `gorm:"primaryKey"`      // 主键ID
-	ProductID      uint      `gorm:"not null"`        // 商品ID，非空
-	MerchantID     uint      `gorm:"not null"`        // 商户ID，非空
-	BatchCode      string    `gorm:"size:8;not null"` // 批次标识码，长度8，非空
+	ID             uint      `gorm:"primaryKey"`                                    // 主键ID
+	ProductID      uint      `gorm:"not null"`                                      // 商品ID，非空
+	MerchantID     uint      `gorm:"not null;index:idx_merchant_batch_code"`        // 商户ID，非空
+	BatchCode      string    `gorm:"size:8;not null;index:idx_merchant_batch_code"` // 批次标识码，长度8，非空，商户内唯一
 	ProductionDate time.Time // 生产日期
-	Quantity       int       `gorm:"not null"`  // 批次数量，非空
+	Quantity       int       `gorm:"not null"` // 批次计划数量，非空
+	ProductionLine string    `gorm:"size:100"` // 生产线
+	Supervisor     string    `gorm:"size:50"`  // 生产负责人
+	QCReportID     *uint     // 质检报告附件ID，可为空
 	Status         int       `gorm:"default:1"` // 批次状态：1-启用, 0-禁用，默认1
 	CreatedAt      time.Time // 创建时间
 	UpdatedAt      time.Time // 更新时间
@@ -122,50 +160,369 @@ type ProductBatch struct {
 	Merchant Merchant `gorm:"foreignKey:MerchantID"` // 关联的商户信息
 }
 
+// SecurityCode 结构体定义了防伪码表的数据模型。
+// 对应数据库中的 `security_codes` 表。
+type SecurityCode struct {
+	ID         uint      `gorm:"primaryKey"`                    // 主键ID
+	Code       string    `gorm:"size:191;uniqueIndex;not null"` // 防伪码（明码序列号，签名规则含签名），长度191，唯一索引，非空
+	MerchantID uint      `gorm:"not null;index"`                // 商户ID，非空
+	RuleID     uint      `gorm:"not null"`                      // 生成规则ID，非空
+	BatchID    uint      `gorm:"not null;index"`                // 商品批次ID，非空
+	Sequence   int       `gorm:"not null"`                      // 批次内序号，非空
+	PinHash    string    `gorm:"size:64" json:"-"`              // 刮开PIN码的哈希值，为空表示无PIN，不对外输出
+	Status     int       `gorm:"default:1"`                     // 防伪码状态：1-正常, 0-作废, 2-已召回，默认1
+	RecallID   *uint     `gorm:"index"`                         // 所属召回ID，未召回为空
+	CreatedAt  time.Time // 创建时间
+	UpdatedAt  time.Time // 更新时间
+}
+
+// Recall 结构体定义了商品召回表的数据模型。
+// 对应数据库中的 `recalls` 表，召回启动后范围内的正常防伪码转为已召回状态，验证时展示消费者公告。
+type Recall struct {
+	ID         uint       `gorm:"primaryKey"`        // 主键ID
+	MerchantID uint       `gorm:"not null;index"`    // 商户ID，非空
+	Title      string     `gorm:"size:200;not null"` // 召回标题，非空
+	Reason     string     `gorm:"type:text"`         // 召回原因
+	Notice     string     `gorm:"type:text"`         // 验证时展示给消费者的公告
+	Contact    string     `gorm:"size:255"`          // 联系方式（电话、邮箱或地址）
+	Scopes     string     `gorm:"type:json"`         // 召回范围，格式为 [{"batch_id": 1, "start_seq": 0, "end_seq": 0}]，序号为0表示整批
+	StartDate  *time.Time // 召回开始日期，为空时启动时取启动时间
+	Status     int        `gorm:"default:0"` // 召回状态：0-草稿, 1-进行中, 2-已结束，默认0
+	CodeCount  int        // 启动时转为已召回状态的防伪码数量
+	CreatedBy  uint       // 创建用户ID
+	OpenedAt   *time.Time // 启动时间
+	ClosedAt   *time.Time // 结束时间
+	CreatedAt  time.Time  // 创建时间
+	UpdatedAt  time.Time  // 更新时间
+}
+
 // TraceabilityInfo 结构体定义了溯源信息表的数据模型。
 // 对应数据库中的 `traceability_infos` 表。
 type TraceabilityInfo struct {
-	ID          uint      `gorm:"primaryKey"`         // 主键ID
-	BatchID     uint      `gorm:"not null"`           // 商品批次ID，非空
-	MerchantID  uint      `gorm:"not null"`           // 商户ID，非空
-	StageType   int       `gorm:"not null"`           // 溯源阶段类型：1-生产, 2-仓储, 3-物流, 4-销售，非空
-	Content     string    `gorm:"type:json;not null"` // 溯源信息内容，以JSON字符串形式存储，非空
-	Attachments string    `gorm:"type:json"`          // 附件信息，以JSON字符串形式存储
-	CreatedAt   time.Time // 创建时间
-	UpdatedAt   time.Time // 更新时间
+	ID            uint           `gorm:"primaryKey"`         // 主键ID
+	BatchID       uint           `gorm:"not null"`           // 商品批次ID，非空
+	MerchantID    uint           `gorm:"not null"`           // 商户ID，非空
+	StageType     int            `gorm:"not null"`           // 溯源阶段类型：1-生产, 2-仓储, 3-物流, 4-销售，非空
+	Content       string         `gorm:"type:json;not null"` // 溯源信息内容，以JSON字符串形式存储，非空
+	SchemaVersion int            `gorm:"default:0"`          // 写入时使用的阶段模板版本，0表示未使用模板
+	Attachments   string         `gorm:"type:json"`          // 附件信息，以JSON字符串形式存储
+	Version       int            `gorm:"default:1"`          // 当前版本，每次修改加1，历史版本保存在溯源哈希链中
+	ExternalID    string         `gorm:"size:191;index"`     // 外部事件ID，如导入的EPCIS eventID，用于重复导入时去重
+	CreatedAt     time.Time      // 创建时间
+	UpdatedAt     time.Time      // 更新时间
+	DeletedAt     gorm.DeletedAt `gorm:"index"` // 删除时间，溯源信息只做软删除
 
 	Batch    ProductBatch `gorm:"foreignKey:BatchID"`    // 关联的商品批次信息
 	Merchant Merchant     `gorm:"foreignKey:MerchantID"` // 关联的商户信息
 }
 
+// TraceStageSchema 结构体定义了溯源阶段模板表的数据模型。
+// 对应数据库中的 `trace_stage_schemas` 表，商户按阶段类型定义溯源信息的字段，每次修改生成新版本。
+type TraceStageSchema struct {
+	ID         uint      `gorm:"primaryKey"`                                      // 主键ID
+	MerchantID uint      `gorm:"not null;uniqueIndex:idx_merchant_stage_version"` // 商户ID，非空
+	StageType  int       `gorm:"not null;uniqueIndex:idx_merchant_stage_version"` // 溯源阶段类型，非空
+	Version    int       `gorm:"not null;uniqueIndex:idx_merchant_stage_version"` // 模板版本号，从1开始递增
+	Fields     string    `gorm:"type:json;not null"`                              // 字段定义，以JSON数组形式存储，非空
+	CreatedBy  uint      // 创建人用户ID
+	CreatedAt  time.Time // 创建时间
+}
+
 // VerificationRecord 结构体定义了防伪验证记录表的数据模型。
-// 对应数据库中的 `verification_records` 表。
+// 按验证时间所在月份分表存储，表名为 `verification_records_YYYYMM`，由验证记录仓库负责建表和路由查询。
 type VerificationRecord struct {
-	ID           uint      `gorm:"primaryKey"`       // 主键ID
-	SecurityCode string    `gorm:"size:32;not null"` // 防伪码，长度32，非空
-	MerchantID   uint      `gorm:"not null"`         // 商户ID，非空
-	VerifyTime   time.Time `gorm:"not null"`         // 验证时间，非空
-	IPAddress    string    `gorm:"size:45"`          // 验证IP地址，长度45
-	Result       int       `gorm:"not null"`         // 验证结果：1-真品, 0-伪品, 2-无效码，非空
-	UserAgent    string    `gorm:"size:500"`         // 用户代理（浏览器信息），长度500
+	ID           uint      `gorm:"primaryKey"`              // 主键ID（月份表内自增）
+	SecurityCode string    `gorm:"size:191;not null;index"` // 防伪码，长度191，非空，索引
+	MerchantID   uint      `gorm:"not null;index"`          // 商户ID，非空，索引
+	VerifyTime   time.Time `gorm:"not null;index"`          // 验证时间，非空，索引
+	IPAddress    string    `gorm:"size:45"`                 // 验证IP地址，长度45
+	Result       int       `gorm:"not null"`                // 验证结果：1-真品, 0-伪品, 2-无效码, 3-待刮开确认, 4-已召回, 5-PIN校验已锁定，非空
+	UserAgent    string    `gorm:"size:500"`                // 用户代理（浏览器信息），长度500
 	CreatedAt    time.Time // 创建时间
 
 	Merchant Merchant `gorm:"foreignKey:MerchantID"` // 关联的商户信息
 }
 
+// VerificationArchive 结构体定义了验证记录归档表的数据模型。
+// 对应数据库中的 `verification_archives` 表，记录超过保留期后导出到本地压缩文件的月份表。
+type VerificationArchive struct {
+	ID          uint       `gorm:"primaryKey"`                  // 主键ID
+	Month       string     `gorm:"size:6;uniqueIndex;not null"` // 归档月份，如202601，唯一索引
+	FilePath    string     `gorm:"size:255;not null"`           // 归档文件路径（gzip压缩的JSON Lines）
+	RecordCount int64      `gorm:"not null"`                    // 归档记录数
+	Checksum    string     `gorm:"size:64;not null"`            // 归档文件SHA-256校验值
+	ArchivedAt  time.Time  `gorm:"not null"`                    // 归档时间
+	RestoredAt  *time.Time // 重新导入时间，不为空表示月份表当前已恢复，可正常查询
+	CreatedAt   time.Time  // 创建时间
+	UpdatedAt   time.Time  // 更新时间
+}
+
+// MerchantSigningKey 结构体定义了商户签名密钥表的数据模型。
+// 对应数据库中的 `merchant_signing_keys` 表，用于生成离线可验证的Ed25519签名防伪码。
+type MerchantSigningKey struct {
+	ID         uint       `gorm:"primaryKey"`                                   // 主键ID
+	MerchantID uint       `gorm:"not null;uniqueIndex:idx_merchant_kid"`        // 商户ID，非空
+	KeyID      string     `gorm:"size:8;not null;uniqueIndex:idx_merchant_kid"` // 密钥ID，嵌入在签名防伪码中，商户内唯一
+	PublicKey  string     `gorm:"size:64;not null"`                             // 公钥，标准Base64编码
+	PrivateKey string     `gorm:"type:text;not null" json:"-"`                  // 商户数据加密密钥加密的私钥种子（带密钥版本头），已吊销的密钥可能为空，不对外输出
+	Status     int        `gorm:"default:1"`                                    // 密钥状态：1-当前签名密钥, 2-已轮换（仅用于验证）, 0-已吊销
+	RetiredAt  *time.Time // 轮换时间
+	RevokedAt  *time.Time // 吊销时间
+	CreatedAt  time.Time  // 创建时间
+	UpdatedAt  time.Time  // 更新时间
+}
+
+// TraceChainEntry 结构体定义了溯源哈希链表的数据模型。
+// 对应数据库中的 `trace_chain_entries` 表，只追加不修改，每个批次的记录按序号链接成哈希链。
+type TraceChainEntry struct {
+	ID            uint      `gorm:"primaryKey"`                         // 主键ID
+	MerchantID    uint      `gorm:"not null;index"`                     // 商户ID，非空
+	BatchID       uint      `gorm:"not null;uniqueIndex:idx_batch_seq"` // 商品批次ID，非空
+	Seq           uint      `gorm:"not null;uniqueIndex:idx_batch_seq"` // 批次内序号，从1开始连续递增
+	TraceID       uint      `gorm:"not null;index"`                     // 溯源信息ID，非空
+	Version       int       `gorm:"not null"`                           // 溯源信息版本
+	Action        string    `gorm:"size:10;not null"`                   // 动作：create、update、delete
+	StageType     int       // 溯源阶段类型
+	SchemaVersion int       // 阶段模板版本
+	Content       string    `gorm:"type:json"`          // 该版本的溯源信息内容
+	Attachments   string    `gorm:"type:json"`          // 该版本的附件信息
+	Salts         string    `gorm:"type:json" json:"-"` // 各字段承诺使用的随机盐，只对公开字段披露
+	ContentHash   string    `gorm:"size:64"`            // 内容哈希，删除记录为空
+	PrevHash      string    `gorm:"size:64;not null"`   // 上一条记录的哈希
+	EntryHash     string    `gorm:"size:64;not null"`   // 本条记录的哈希
+	CreatedBy     uint      // 操作用户ID
+	CreatedAt     time.Time // 写入时间，精确到秒，参与哈希计算
+}
+
+// TraceMerkleRoot 结构体定义了溯源默克尔根表的数据模型。
+// 对应数据库中的 `trace_merkle_roots` 表，定期以商户各批次的链头计算并公开。
+type TraceMerkleRoot struct {
+	ID         uint      `gorm:"primaryKey"`             // 主键ID
+	MerchantID uint      `gorm:"not null;index"`         // 商户ID，非空
+	Root       string    `gorm:"size:64;not null"`       // 默克尔根
+	LeafCount  int       `gorm:"not null"`               // 叶子数量，即已有溯源记录的批次数
+	Leaves     string    `gorm:"type:longtext" json:"-"` // 叶子对应的批次链头，用于生成证明
+	CreatedAt  time.Time // 发布时间
+}
+
+// Attachment 结构体定义了附件表的数据模型。
+// 对应数据库中的 `attachments` 表，同一商户上传内容相同的文件时复用已有附件。
+type Attachment struct {
+	ID           uint      `gorm:"primaryKey"`                                     // 主键ID
+	MerchantID   uint      `gorm:"not null;uniqueIndex:idx_merchant_hash"`         // 商户ID，非空
+	Hash         string    `gorm:"size:64;not null;uniqueIndex:idx_merchant_hash"` // 文件内容的SHA-256，用于去重
+	FileName     string    `gorm:"size:255"`                                       // 原始文件名
+	ContentType  string    `gorm:"size:100;not null"`                              // 根据文件内容识别的MIME类型
+	Size         int64     `gorm:"not null"`                                       // 文件大小（字节）
+	StorageKey   string    `gorm:"size:255;not null" json:"-"`                     // 存储中的对象键
+	ThumbnailKey string    `gorm:"size:255" json:"-"`                              // 缩略图对象键，非图片为空
+	Width        int       // 图片宽度，非图片为0
+	Height       int       // 图片高度，非图片为0
+	UploadedBy   uint      // 上传用户ID
+	CreatedAt    time.Time // 创建时间
+}
+
+// ImportJob 结构体定义了批量导入任务表的数据模型。
+// 对应数据库中的 `import_jobs` 表，上传的文件先在后台校验，校验通过后由商户确认再在一个事务中写入。
+type ImportJob struct {
+	ID            uint       `gorm:"primaryKey"`                 // 主键ID
+	MerchantID    uint       `gorm:"not null;index"`             // 商户ID，非空
+	Type          string     `gorm:"size:20;not null"`           // 导入类型：products、batches、traces
+	FileName      string     `gorm:"size:255"`                   // 上传的文件名
+	Format        string     `gorm:"size:10;not null"`           // 文件格式：csv、xlsx
+	FileKey       string     `gorm:"size:255;not null" json:"-"` // 上传文件在存储中的对象键
+	Locale        string     `gorm:"size:20" json:"-"`           // 上传时的语言，用于生成错误信息
+	Status        int        `gorm:"default:0;index"`            // 任务状态：0-待校验, 1-校验中, 2-校验通过, 3-校验未通过, 4-待导入, 5-导入中, 6-已导入, 7-导入失败
+	TotalRows     int        // 数据行数（不含表头）
+	ProcessedRows int        // 当前阶段已处理的行数，用于显示进度
+	ErrorRows     int        // 有错误的行数
+	ImportedRows  int        // 已写入的行数
+	Errors        string     `gorm:"type:longtext" json:"-"` // 行级错误，以JSON数组形式存储
+	CreatedBy     uint       // 上传用户ID
+	ValidatedAt   *time.Time // 校验完成时间
+	FinishedAt    *time.Time // 导入完成或失败时间
+	CreatedAt     time.Time  // 创建时间
+	UpdatedAt     time.Time  // 更新时间
+}
+
+// MerchantMessage 结构体定义了商户自定义消息文本表的数据模型。
+// 对应数据库中的 `merchant_messages` 表，用于按语言覆盖系统内置的提示文本。
+type MerchantMessage struct {
+	ID         uint      `gorm:"primaryKey"`                                            // 主键ID
+	MerchantID uint      `gorm:"not null;uniqueIndex:idx_merchant_locale_key"`          // 商户ID，非空
+	Locale     string    `gorm:"size:20;not null;uniqueIndex:idx_merchant_locale_key"`  // 语言，如zh-CN、en-US，非空
+	MessageKey string    `gorm:"size:100;not null;uniqueIndex:idx_merchant_locale_key"` // 消息键，非空
+	Text       string    `gorm:"size:500;not null"`                                     // 覆盖文本，长度500，非空
+	CreatedAt  time.Time // 创建时间
+	UpdatedAt  time.Time // 更新时间
+}
+
+// WebhookSubscription 结构体定义了商户Webhook订阅表的数据模型。
+// 对应数据库中的 `webhook_subscriptions` 表。
+type WebhookSubscription struct {
+	ID         uint      `gorm:"primaryKey"`        // 主键ID
+	MerchantID uint      `gorm:"not null;index"`    // 商户ID，非空
+	Name       string    `gorm:"size:100;not null"` // 订阅名称，长度100，非空
+	URL        string    `gorm:"size:500;not null"` // 接收地址，长度500，非空
+	Secret     string    `gorm:"size:128;not null"` // HMAC-SHA256签名密钥，长度128，非空
+	EventTypes string    `gorm:"type:json"`         // 订阅的事件类型，以JSON数组形式存储，为空表示订阅全部事件
+	Status     int       `gorm:"default:1"`         // 订阅状态：1-启用, 0-禁用，默认1
+	CreatedAt  time.Time // 创建时间
+	UpdatedAt  time.Time // 更新时间
+}
+
+// WebhookDelivery 结构体定义了Webhook投递记录表的数据模型。
+// 对应数据库中的 `webhook_deliveries` 表，状态为3的记录即死信列表。
+type WebhookDelivery struct {
+	ID             uint       `gorm:"primaryKey"`       // 主键ID
+	SubscriptionID uint       `gorm:"not null;index"`   // 订阅ID，非空
+	MerchantID     uint       `gorm:"not null;index"`   // 商户ID，非空
+	EventID        string     `gorm:"size:64;not null"` // 事件ID，同一事件投递到多个订阅时相同
+	EventType      string     `gorm:"size:50;not null"` // 事件类型，长度50，非空
+	Payload        string     `gorm:"type:text"`        // 推送的JSON报文
+	Status         int        `gorm:"default:0;index"`  // 投递状态：0-待投递, 1-成功, 2-等待重试, 3-死信, 4-投递中
+	Attempts       int        `gorm:"default:0"`        // 已投递次数
+	NextRetryAt    *time.Time `gorm:"index"`            // 下次重试时间，可为空
+	LastStatusCode int        // 最近一次投递的HTTP状态码
+	LastError      string     `gorm:"size:500"` // 最近一次投递的错误信息
+	DeliveredAt    *time.Time // 投递成功时间，可为空
+	CreatedAt      time.Time  // 创建时间
+	UpdatedAt      time.Time  // 更新时间
+}
+
+// WebhookDeliveryAttempt 结构体定义了Webhook单次投递日志表的数据模型。
+// 对应数据库中的 `webhook_delivery_attempts` 表。
+type WebhookDeliveryAttempt struct {
+	ID           uint      `gorm:"primaryKey"`     // 主键ID
+	DeliveryID   uint      `gorm:"not null;index"` // 投递记录ID，非空
+	Attempt      int       `gorm:"not null"`       // 第几次投递
+	StatusCode   int       // HTTP响应状态码，网络错误时为0
+	ResponseBody string    `gorm:"size:1000"` // 响应内容（截断），长度1000
+	Error        string    `gorm:"size:500"`  // 错误信息，长度500
+	DurationMs   int64     // 请求耗时（毫秒）
+	CreatedAt    time.Time // 创建时间
+}
+
+// RefreshToken 结构体定义了刷新令牌表的数据模型。
+// 对应数据库中的 `refresh_tokens` 表，只保存令牌的SHA-256摘要；同一次登录轮换出的令牌属于同一个令牌族。
+type RefreshToken struct {
+	ID              uint       `gorm:"primaryKey"`                   // 主键ID
+	UserID          uint       `gorm:"not null;index"`               // 用户ID，非空
+	FamilyID        string     `gorm:"size:32;not null;index"`       // 令牌族ID，同一次登录轮换出的令牌相同
+	TokenHash       string     `gorm:"size:64;not null;uniqueIndex"` // 令牌的SHA-256摘要（十六进制）
+	AccessTokenID   string     `gorm:"size:32"`                      // 同时签发的访问令牌ID（jti），令牌族被吊销时加入黑名单
+	AccessExpiresAt time.Time  // 同时签发的访问令牌过期时间
+	ExpiresAt       time.Time  `gorm:"index"` // 过期时间
+	UsedAt          *time.Time // 已轮换的时间，再次使用即视为泄露
+	RevokedAt       *time.Time // 吊销时间，可为空
+	IPAddress       string     `gorm:"size:45"`  // 签发时的IP地址
+	UserAgent       string     `gorm:"size:255"` // 签发时的用户代理
+	CreatedAt       time.Time  // 创建时间
+}
+
+// JWTSigningKey 结构体定义了访问令牌签名密钥表的数据模型。
+// 对应数据库中的 `jwt_signing_keys` 表，访问令牌头部的kid指向签名所用的密钥，轮换后旧密钥保留到其签发的令牌全部过期。
+type JWTSigningKey struct {
+	ID          uint       `gorm:"primaryKey"`                   // 主键ID
+	KeyID       string     `gorm:"size:32;not null;uniqueIndex"` // 密钥ID（kid），写入访问令牌头部
+	Algorithm   string     `gorm:"size:16;not null"`             // 签名算法：HS256、RS256 或 EdDSA
+	PublicKey   string     `gorm:"type:text"`                    // 公钥（PKIX DER，标准Base64编码），HS256密钥为空
+	PrivateKey  string     `gorm:"type:text;not null" json:"-"`  // 加密后的私钥（PKCS#8 DER）或HS256密钥，不对外输出
+	MasterKeyID string     `gorm:"size:16" json:"-"`             // 加密私钥所用主密钥的指纹，为空表示旧版本以JWT密钥派生的密钥加密
+	Status      int        `gorm:"default:1;index"`              // 密钥状态：1-当前签名密钥, 2-已轮换（仅用于验证）, 0-已吊销
+	RetiredAt   *time.Time // 轮换时间
+	ExpiresAt   *time.Time // 已轮换密钥的验证截止时间，之后自动删除
+	RevokedAt   *time.Time // 吊销时间
+	CreatedAt   time.Time  // 创建时间
+	UpdatedAt   time.Time  // 更新时间
+}
+
+// DataKey 结构体定义了数据加密密钥表的数据模型。
+// 对应数据库中的 `data_keys` 表，每个商户有多个版本的数据加密密钥，密钥由主密钥加密保存，密文头部记录所用的版本。
+type DataKey struct {
+	ID          uint       `gorm:"primaryKey"`                                // 主键ID
+	MerchantID  uint       `gorm:"not null;uniqueIndex:idx_merchant_version"` // 商户ID，非空
+	Version     int        `gorm:"not null;uniqueIndex:idx_merchant_version"` // 密钥版本，商户内从1开始递增
+	WrappedKey  string     `gorm:"type:text;not null" json:"-"`               // 主密钥加密后的数据加密密钥，不对外输出
+	MasterKeyID string     `gorm:"size:16;not null"`                          // 加密时所用主密钥的指纹，用于发现主密钥配置错误
+	Status      int        `gorm:"default:1"`                                 // 密钥状态：1-当前密钥, 2-已轮换（仅用于解密）
+	RetiredAt   *time.Time // 轮换时间
+	CreatedAt   time.Time  // 创建时间
+	UpdatedAt   time.Time  // 更新时间
+}
+
+// UserTwoFactor 结构体定义了用户两步验证表的数据模型。
+// 对应数据库中的 `user_two_factors` 表，每个用户一条，TOTP密钥由主密钥加密保存，提交第一个验证码通过后才启用。
+type UserTwoFactor struct {
+	ID        uint       `gorm:"primaryKey"`                  // 主键ID
+	UserID    uint       `gorm:"not null;uniqueIndex"`        // 用户ID，非空，唯一索引
+	Secret    string     `gorm:"type:text;not null" json:"-"` // 主密钥加密后的TOTP密钥（Base32），不对外输出
+	Enabled   bool       `gorm:"default:false"`               // 是否已启用
+	LastStep  int64      `json:"-"`                           // 最近一次通过验证的时间步，同一验证码不能重复使用
+	EnabledAt *time.Time // 启用时间
+	CreatedAt time.Time  // 创建时间
+	UpdatedAt time.Time  // 更新时间
+}
+
+// RecoveryCode 结构体定义了两步验证恢复码表的数据模型。
+// 对应数据库中的 `recovery_codes` 表，只保存恢复码的SHA-256摘要，每个恢复码只能使用一次。
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey"`             // 主键ID
+	UserID    uint       `gorm:"not null;index"`         // 用户ID，非空
+	CodeHash  string     `gorm:"size:64;not null;index"` // 恢复码的SHA-256摘要（十六进制）
+	UsedAt    *time.Time // 使用时间，为空表示未使用
+	CreatedAt time.Time  // 创建时间
+}
+
+// AuditLog 结构体定义了审计日志表的数据模型。
+// 对应数据库中的 `audit_logs` 表，记录账号、角色等管理操作，只追加不修改。
+type AuditLog struct {
+	ID           uint      `gorm:"primaryKey"` // 主键ID
+	OperatorID   uint      `gorm:"index"`      // 操作用户ID，系统操作为0
+	OperatorName string    `gorm:"size:50"`    // 操作用户名
+	OperatorType int       // 操作用户类型：1-平台用户, 2-商户用户
+	MerchantID   *uint     `gorm:"index"`                  // 操作对象所属商户ID，平台对象为空
+	Action       string    `gorm:"size:50;not null;index"` // 操作类型，如user.create、role.update
+	TargetType   string    `gorm:"size:20"`                // 操作对象类型：user、role等
+	TargetID     uint      // 操作对象ID
+	Detail       string    `gorm:"type:text"` // 操作内容（JSON）
+	IPAddress    string    `gorm:"size:45"`   // 操作IP地址
+	CreatedAt    time.Time `gorm:"index"`     // 操作时间
+}
+
 // AutoMigrate 函数用于自动迁移数据库表结构。
 // 它接收一个GORM数据库实例，并根据定义的模型创建或更新数据库表。
 func AutoMigrate(db *gorm.DB) error {
 	return db.AutoMigrate(
-		&Merchant{},           // 迁移商户表
-		&User{},               // 迁移用户表
-		&Role{},               // 迁移角色表
-		&Permission{},         // 迁移权限表
-		&UserRole{},           // 迁移用户角色关联表
-		&SecurityCodeRule{},   // 迁移防伪码规则表
-		&Product{},            // 迁移商品表
-		&ProductBatch{},       // 迁移商品批次表
-		&TraceabilityInfo{},   // 迁移溯源信息表
-		&VerificationRecord{}, // 迁移验证记录表
+		&Merchant{},               // 迁移商户表
+		&User{},                   // 迁移用户表
+		&Role{},                   // 迁移角色表
+		&Permission{},             // 迁移权限表
+		&UserRole{},               // 迁移用户角色关联表
+		&SecurityCodeRule{},       // 迁移防伪码规则表
+		&Product{},                // 迁移商品表
+		&ProductCategory{},        // 迁移商品分类表
+		&ProductHistory{},         // 迁移商品变更历史表
+		&SecurityCode{},           // 迁移防伪码表
+		&ProductBatch{},           // 迁移商品批次表
+		&Recall{},                 // 迁移商品召回表
+		&TraceabilityInfo{},       // 迁移溯源信息表
+		&TraceStageSchema{},       // 迁移溯源阶段模板表
+		&TraceChainEntry{},        // 迁移溯源哈希链表
+		&TraceMerkleRoot{},        // 迁移溯源默克尔根表
+		&VerificationArchive{},    // 迁移验证记录归档表（验证记录月份表由验证记录仓库创建）
+		&MerchantMessage{},        // 迁移商户自定义消息表
+		&MerchantSigningKey{},     // 迁移商户签名密钥表
+		&Attachment{},             // 迁移附件表
+		&ImportJob{},              // 迁移批量导入任务表
+		&WebhookSubscription{},    // 迁移Webhook订阅表
+		&WebhookDelivery{},        // 迁移Webhook投递记录表
+		&WebhookDeliveryAttempt{}, // 迁移Webhook投递日志表
+		&RefreshToken{},           // 迁移刷新令牌表
+		&JWTSigningKey{},          // 迁移访问令牌签名密钥表
+		&DataKey{},                // 迁移数据加密密钥表
+		&UserTwoFactor{},          // 迁移用户两步验证表
+		&RecoveryCode{},           // 迁移两步验证恢复码表
+		&AuditLog{},               // 迁移审计日志表
 	)
 }
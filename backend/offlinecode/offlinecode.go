@@ -0,0 +1,110 @@
+// Package offlinecode 实现离线可验证的签名防伪码。
+//
+// 签名防伪码格式为 "<明码>.<密钥ID>.<签名>"，签名是商户Ed25519私钥对
+// "AFS1:<密钥ID>:<明码>" 的签名，使用不带填充的Base32编码。
+// 扫码端预先下载商户公开的公钥列表（GET /api/public/merchants/:code/signing-keys），
+// 之后无需联网即可调用 Verify 校验防伪码真伪。
+package offlinecode
+
+import (
+	"crypto/ed25519"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Delimiter 明码、密钥ID与签名之间的分隔符，签名规则的明码中不能包含该字符
+const Delimiter = "."
+
+// signingDomain 签名域前缀，防止签名被用于其他用途
+const signingDomain = "AFS1"
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+var (
+	ErrMalformed  = errors.New("offlinecode: 防伪码格式错误")
+	ErrUnknownKey = errors.New("offlinecode: 未知的密钥ID")
+	ErrSignature  = errors.New("offlinecode: 签名校验失败")
+)
+
+// KeySet 密钥ID到公钥的映射
+type KeySet map[string]ed25519.PublicKey
+
+// PublishedKey 服务端公开的单个公钥
+type PublishedKey struct {
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	PublicKey string `json:"public_key"` // 标准Base64编码的32字节公钥
+	Status    string `json:"status"`     // active-当前签名密钥, retired-已轮换但仍可用于验证
+}
+
+// message 构造待签名的消息
+func message(kid, payload string) []byte {
+	return []byte(signingDomain + ":" + kid + ":" + payload)
+}
+
+// Sign 对明码签名，返回完整的签名防伪码
+func Sign(privateKey ed25519.PrivateKey, kid, payload string) (string, error) {
+	if strings.Contains(payload, Delimiter) || kid == "" || strings.Contains(kid, Delimiter) {
+		return "", ErrMalformed
+	}
+	signature := ed25519.Sign(privateKey, message(kid, payload))
+	return payload + Delimiter + kid + Delimiter + encoding.EncodeToString(signature), nil
+}
+
+// Parse 拆分签名防伪码，返回明码、密钥ID和签名
+func Parse(code string) (payload, kid string, signature []byte, err error) {
+	sigIndex := strings.LastIndex(code, Delimiter)
+	if sigIndex <= 0 {
+		return "", "", nil, ErrMalformed
+	}
+	kidIndex := strings.LastIndex(code[:sigIndex], Delimiter)
+	if kidIndex <= 0 {
+		return "", "", nil, ErrMalformed
+	}
+
+	// 末尾字符的填充位必须为0，拒绝非规范编码，保证同一签名只有一种写法
+	encoded := strings.ToUpper(code[sigIndex+1:])
+	signature, err = encoding.DecodeString(encoded)
+	if err != nil || len(signature) != ed25519.SignatureSize || encoding.EncodeToString(signature) != encoded {
+		return "", "", nil, ErrMalformed
+	}
+	return code[:kidIndex], code[kidIndex+1 : sigIndex], signature, nil
+}
+
+// Verify 使用公钥集合离线校验签名防伪码，成功时返回明码
+func Verify(code string, keys KeySet) (string, error) {
+	payload, kid, signature, err := Parse(strings.TrimSpace(code))
+	if err != nil {
+		return "", err
+	}
+
+	publicKey, ok := keys[kid]
+	if !ok {
+		return "", ErrUnknownKey
+	}
+	if !ed25519.Verify(publicKey, message(kid, payload), signature) {
+		return "", ErrSignature
+	}
+	return payload, nil
+}
+
+// ParseKeySet 从服务端公钥接口返回的keys数组构造公钥集合
+func ParseKeySet(data []byte) (KeySet, error) {
+	var published []PublishedKey
+	if err := json.Unmarshal(data, &published); err != nil {
+		return nil, err
+	}
+
+	keys := make(KeySet, len(published))
+	for _, p := range published {
+		raw, err := base64.StdEncoding.DecodeString(p.PublicKey)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return nil, ErrMalformed
+		}
+		keys[p.KeyID] = ed25519.PublicKey(raw)
+	}
+	return keys, nil
+}
@@ -4,13 +4,16 @@ import (
 	"anti-fake-system/config"
 	"anti-fake-system/controllers"
 	"anti-fake-system/middleware"
+	"anti-fake-system/services"
+	"anti-fake-system/storage"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
-func SetupRouter(db *gorm.DB, redisClient interface{}, cfg *config.Config) *gin.Engine {
+func SetupRouter(db *gorm.DB, redisClient *redis.Client, cfg *config.Config, dispatcher *services.WebhookDispatcher, logWriter *services.VerifyLogWriter, records *services.VerifyRecordRepository, archiver *services.VerifyArchiver, store storage.Storage, importer *services.ImportRunner, jwtKeys *services.JWTKeyService, keys *services.KeyManager) *gin.Engine {
 	r := gin.Default()
 
 	// 应用全局中间件
@@ -25,12 +28,31 @@ func SetupRouter(db *gorm.DB, redisClient interface{}, cfg *config.Config) *gin.
 		})
 	})
 
+	// 接口权限校验使用的用户权限服务
+	permissions := services.NewPermissionService(db, redisClient, cfg)
+	middleware.UsePermissions(permissions)
+	tokens := services.NewTokenService(db, redisClient, cfg, jwtKeys)
+	middleware.UseTokens(tokens)
+	loginGuard := services.NewLoginGuard(redisClient, cfg, services.NewAuditService(db))
+	twoFactor := services.NewTwoFactorService(db, cfg, keys, permissions)
+	pinGuard := services.NewPinGuard(redisClient, cfg)
+
 	// 初始化控制器
-	platformController := controllers.NewPlatformController(db, cfg)
-	merchantController := controllers.NewMerchantController(db, cfg)
-	authController := controllers.NewAuthController(db, cfg)
-	codeController := controllers.NewCodeController(db, cfg)
-	ruleController := controllers.NewRuleController(db, cfg)
+	platformController := controllers.NewPlatformController(db, cfg, records)
+	merchantController := controllers.NewMerchantController(db, cfg, records)
+	authController := controllers.NewAuthController(db, cfg, tokens, loginGuard, twoFactor)
+	codeController := controllers.NewCodeController(db, cfg, dispatcher, logWriter, records, archiver, keys, pinGuard)
+	ruleController := controllers.NewRuleController(db, cfg, keys)
+	webhookController := controllers.NewWebhookController(db, cfg, dispatcher)
+	messageController := controllers.NewMessageController(db, cfg)
+	signingKeyController := controllers.NewSigningKeyController(db, cfg, keys)
+	traceController := controllers.NewTraceController(db, cfg, store)
+	attachmentController := controllers.NewAttachmentController(db, cfg, store)
+	importController := controllers.NewImportController(db, cfg, importer)
+	recallController := controllers.NewRecallController(db, cfg, dispatcher, records)
+	accountController := controllers.NewAccountController(db, cfg, permissions, tokens, loginGuard, twoFactor)
+	permissionController := controllers.NewPermissionController(db, cfg, permissions)
+	jwtKeyController := controllers.NewJWTKeyController(db, cfg, jwtKeys)
 
 	// 注册路由
 	platformController.RegisterRoutes(r)
@@ -38,6 +60,16 @@ func SetupRouter(db *gorm.DB, redisClient interface{}, cfg *config.Config) *gin.
 	authController.RegisterRoutes(r)
 	codeController.RegisterRoutes(r)
 	ruleController.RegisterRoutes(r)
+	webhookController.RegisterRoutes(r)
+	messageController.RegisterRoutes(r)
+	signingKeyController.RegisterRoutes(r)
+	traceController.RegisterRoutes(r)
+	attachmentController.RegisterRoutes(r)
+	importController.RegisterRoutes(r)
+	recallController.RegisterRoutes(r)
+	accountController.RegisterRoutes(r)
+	permissionController.RegisterRoutes(r)
+	jwtKeyController.RegisterRoutes(r)
 
 	return r
 }
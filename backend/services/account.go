@@ -0,0 +1,521 @@
+package services
+
+import (
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"context"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 用户状态
+const (
+	UserStatusDisabled = 0 // 禁用
+	UserStatusEnabled  = 1 // 启用
+)
+
+// AccountScope 账号和角色的管理范围，平台账号的MerchantID为空，商户账号为所属商户
+type AccountScope struct {
+	UserType   int
+	MerchantID *uint
+}
+
+// AccountOperator 执行账号管理操作的用户
+type AccountOperator struct {
+	UserID   uint
+	UserType int
+}
+
+// AccountInput 新建子账号的请求参数
+type AccountInput struct {
+	Username string `json:"username" binding:"required,max=50"`
+	Password string `json:"password" binding:"required,min=8,max=72"`
+	Email    string `json:"email" binding:"omitempty,email,max=100"`
+	Phone    string `json:"phone" binding:"max=20"`
+	RoleIDs  []uint `json:"role_ids"` // 初始角色，可为空
+	// 平台跨商户创建账号时指定所属商户，其余情况忽略
+	MerchantID uint `json:"merchant_id"`
+}
+
+// RoleInput 新建或修改角色的请求参数
+type RoleInput struct {
+	Name        string   `json:"name" binding:"required,max=50"`
+	Description string   `json:"description" binding:"max=200"`
+	Permissions []string `json:"permissions" binding:"required,min=1"` // 权限码
+	TwoFactor   bool     `json:"two_factor"`                           // 拥有该角色的账号登录时是否必须通过两步验证
+}
+
+// AccountView 账号列表和详情的输出，不含密码
+type AccountView struct {
+	ID          uint       `json:"id"`
+	Username    string     `json:"username"`
+	Email       string     `json:"email"`
+	Phone       string     `json:"phone"`
+	UserType    int        `json:"user_type"`
+	MerchantID  *uint      `json:"merchant_id,omitempty"`
+	Status      int        `json:"status"`
+	CreatedBy   *uint      `json:"created_by"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Roles       []RoleView `json:"roles"`
+	TwoFactor   bool       `json:"two_factor"` // 是否已启用两步验证
+}
+
+// RoleView 角色的输出，内置管理员角色的权限为对应范围的全部权限
+type RoleView struct {
+	ID          uint      `json:"id"`
+	Name        string    `json:"name"`
+	Code        string    `json:"code,omitempty"`
+	Builtin     bool      `json:"builtin"`
+	Description string    `json:"description"`
+	TwoFactor   bool      `json:"two_factor"`
+	Permissions []string  `json:"permissions,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AccountService 账号和角色管理服务
+// 管理员只能授予自己拥有的权限，也不能管理权限超出自己的账号和角色。
+type AccountService struct {
+	db          *gorm.DB
+	permissions *PermissionService
+	tokens      *TokenService
+	guard       *LoginGuard
+	twoFactor   *TwoFactorService
+}
+
+// NewAccountService 创建账号和角色管理服务
+func NewAccountService(db *gorm.DB, permissions *PermissionService, tokens *TokenService, guard *LoginGuard, twoFactor *TwoFactorService) *AccountService {
+	return &AccountService{db: db, permissions: permissions, tokens: tokens, guard: guard, twoFactor: twoFactor}
+}
+
+// UserScope 返回账号所在的管理范围
+func UserScope(user *models.User) AccountScope {
+	return AccountScope{UserType: user.UserType, MerchantID: user.MerchantID}
+}
+
+// Users 返回管理范围内的账号查询，商户账号范围的MerchantID为空时为全部商户的账号
+func (s *AccountService) Users(scope AccountScope) *gorm.DB {
+	query := s.db.Model(&models.User{}).Where("user_type = ?", scope.UserType)
+	if scope.MerchantID != nil {
+		return query.Where("merchant_id = ?", *scope.MerchantID)
+	}
+	return query
+}
+
+// Roles 返回管理范围内可用的角色查询，包括该用户类型的内置角色和本范围的自定义角色
+func (s *AccountService) Roles(scope AccountScope) *gorm.DB {
+	query := s.db.Model(&models.Role{}).Where("role_type = ?", scope.UserType)
+	if scope.MerchantID != nil {
+		return query.Where("builtin = ? OR merchant_id = ?", true, *scope.MerchantID)
+	}
+	return query.Where("builtin = ? OR merchant_id IS NULL", true)
+}
+
+// UserViews 转换账号输出并附带各账号的角色
+func (s *AccountService) UserViews(users []models.User) ([]AccountView, error) {
+	userIDs := make([]uint, len(users))
+	for i, user := range users {
+		userIDs[i] = user.ID
+	}
+
+	var userRoles []models.UserRole
+	if len(userIDs) > 0 {
+		if err := s.db.Preload("Role").Where("user_id IN ?", userIDs).Order("role_id").Find(&userRoles).Error; err != nil {
+			return nil, err
+		}
+	}
+	roles := make(map[uint][]RoleView, len(users))
+	for _, userRole := range userRoles {
+		roles[userRole.UserID] = append(roles[userRole.UserID], roleView(&userRole.Role, nil))
+	}
+	twoFactor, err := s.twoFactor.EnabledUsers(userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]AccountView, len(users))
+	for i, user := range users {
+		views[i] = AccountView{
+			ID:          user.ID,
+			Username:    user.Username,
+			Email:       user.Email,
+			Phone:       user.Phone,
+			UserType:    user.UserType,
+			MerchantID:  user.MerchantID,
+			Status:      user.Status,
+			CreatedBy:   user.CreatedBy,
+			LastLoginAt: user.LastLoginAt,
+			CreatedAt:   user.CreatedAt,
+			Roles:       roles[user.ID],
+			TwoFactor:   twoFactor[user.ID],
+		}
+		if views[i].Roles == nil {
+			views[i].Roles = []RoleView{}
+		}
+	}
+	return views, nil
+}
+
+// RoleViews 转换角色输出并附带各角色的权限码
+func (s *AccountService) RoleViews(roles []models.Role) ([]RoleView, error) {
+	views := make([]RoleView, len(roles))
+	for i := range roles {
+		codes, err := s.permissions.RolePermissions(roles[i].RoleType, roles[i:i+1])
+		if err != nil {
+			return nil, err
+		}
+		views[i] = roleView(&roles[i], codes)
+	}
+	return views, nil
+}
+
+// CreateUser 在管理范围内新建子账号，初始角色的权限不能超出操作人的权限
+func (s *AccountService) CreateUser(ctx context.Context, scope AccountScope, operator AccountOperator, input *AccountInput) (*models.User, error) {
+	roles, err := s.findRoles(scope, input.RoleIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkRoles(ctx, scope, operator, roles); err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user := models.User{
+		Username:   input.Username,
+		Password:   string(hashedPassword),
+		Email:      input.Email,
+		Phone:      input.Phone,
+		UserType:   scope.UserType,
+		MerchantID: scope.MerchantID,
+		Status:     UserStatusEnabled,
+		CreatedBy:  &operator.UserID,
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// 用户名唯一性由唯一索引保证，并发创建同名账号时只有一个成功
+		if err := tx.Omit(clause.Associations).Create(&user).Error; errors.Is(err, gorm.ErrDuplicatedKey) {
+			return i18n.NewError("user.username_exists")
+		} else if err != nil {
+			return err
+		}
+		return replaceUserRoles(tx, user.ID, roles)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetUserStatus 启用或禁用账号，不能修改自己的状态，禁用后立即失去全部权限
+func (s *AccountService) SetUserStatus(ctx context.Context, operator AccountOperator, user *models.User, status int) error {
+	if user.ID == operator.UserID {
+		return i18n.NewError("account.self_forbidden")
+	}
+	if err := s.checkUser(ctx, operator, user); err != nil {
+		return err
+	}
+
+	if err := s.db.Model(user).Update("status", status).Error; err != nil {
+		return err
+	}
+	user.Status = status
+	s.permissions.Invalidate(ctx, user.ID)
+	if status != UserStatusEnabled {
+		// 禁用后已登录的会话立即失效
+		s.tokens.RevokeUser(ctx, user.ID)
+	}
+	return nil
+}
+
+// ResetPassword 重置账号密码，账号已签发的令牌全部失效
+func (s *AccountService) ResetPassword(ctx context.Context, operator AccountOperator, user *models.User, password string) error {
+	if err := s.checkUser(ctx, operator, user); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Model(user).Update("password", string(hashedPassword)).Error; err != nil {
+		return err
+	}
+	s.tokens.RevokeUser(ctx, user.ID)
+	return nil
+}
+
+// Unlock 解除账号因登录失败过多触发的锁定，同时清除失败次数
+func (s *AccountService) Unlock(ctx context.Context, operator AccountOperator, user *models.User) error {
+	if err := s.checkUser(ctx, operator, user); err != nil {
+		return err
+	}
+	return s.guard.Unlock(ctx, user.Username)
+}
+
+// ResetTwoFactor 为丢失验证器设备和恢复码的账号重置两步验证，账号下次登录时重新绑定
+// 账号已签发的令牌全部失效。
+func (s *AccountService) ResetTwoFactor(ctx context.Context, operator AccountOperator, user *models.User) error {
+	if user.ID == operator.UserID {
+		return i18n.NewError("account.self_forbidden")
+	}
+	if err := s.checkUser(ctx, operator, user); err != nil {
+		return err
+	}
+	if err := s.twoFactor.Reset(user.ID); err != nil {
+		return err
+	}
+	s.tokens.RevokeUser(ctx, user.ID)
+	return nil
+}
+
+// AssignRoles 替换账号的全部角色，不能修改自己的角色，新角色的权限不能超出操作人的权限
+func (s *AccountService) AssignRoles(ctx context.Context, scope AccountScope, operator AccountOperator, user *models.User, roleIDs []uint) error {
+	if user.ID == operator.UserID {
+		return i18n.NewError("account.self_forbidden")
+	}
+	if err := s.checkUser(ctx, operator, user); err != nil {
+		return err
+	}
+
+	roles, err := s.findRoles(scope, roleIDs)
+	if err != nil {
+		return err
+	}
+	if err := s.checkRoles(ctx, scope, operator, roles); err != nil {
+		return err
+	}
+
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		return replaceUserRoles(tx, user.ID, roles)
+	}); err != nil {
+		return err
+	}
+	s.permissions.Invalidate(ctx, user.ID)
+	return nil
+}
+
+// DeleteUser 删除账号及其角色关联，不能删除自己
+func (s *AccountService) DeleteUser(ctx context.Context, operator AccountOperator, user *models.User) error {
+	if user.ID == operator.UserID {
+		return i18n.NewError("account.self_forbidden")
+	}
+	if err := s.checkUser(ctx, operator, user); err != nil {
+		return err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.UserRole{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(user).Error
+	})
+	if err != nil {
+		return err
+	}
+	s.permissions.Invalidate(ctx, user.ID)
+	s.tokens.RevokeUser(ctx, user.ID)
+	return nil
+}
+
+// CreateRole 在管理范围内新建自定义角色，只能包含操作人拥有的权限
+func (s *AccountService) CreateRole(ctx context.Context, scope AccountScope, operator AccountOperator, input *RoleInput) (*models.Role, error) {
+	permissions, err := s.grantablePermissions(ctx, scope, operator, input.Permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	role := models.Role{
+		Name:        input.Name,
+		RoleType:    scope.UserType,
+		MerchantID:  scope.MerchantID,
+		Description: input.Description,
+		TwoFactor:   input.TwoFactor,
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(&role).Error; err != nil {
+			return err
+		}
+		return tx.Model(&role).Association("Permissions").Replace(permissions)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// UpdateRole 修改自定义角色，内置角色不能修改，原有权限和新权限都不能超出操作人的权限
+func (s *AccountService) UpdateRole(ctx context.Context, scope AccountScope, operator AccountOperator, role *models.Role, input *RoleInput) error {
+	if role.Builtin {
+		return i18n.NewError("role.builtin_readonly")
+	}
+	if err := s.checkRoles(ctx, scope, operator, []models.Role{*role}); err != nil {
+		return err
+	}
+	permissions, err := s.grantablePermissions(ctx, scope, operator, input.Permissions)
+	if err != nil {
+		return err
+	}
+
+	role.Name = input.Name
+	role.Description = input.Description
+	role.TwoFactor = input.TwoFactor
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Save(role).Error; err != nil {
+			return err
+		}
+		return tx.Model(role).Association("Permissions").Replace(permissions)
+	})
+	if err != nil {
+		return err
+	}
+	s.permissions.InvalidateRole(ctx, role.ID)
+	return nil
+}
+
+// DeleteRole 删除自定义角色并解除所有账号与该角色的关联
+func (s *AccountService) DeleteRole(ctx context.Context, scope AccountScope, operator AccountOperator, role *models.Role) error {
+	if role.Builtin {
+		return i18n.NewError("role.builtin_readonly")
+	}
+	if err := s.checkRoles(ctx, scope, operator, []models.Role{*role}); err != nil {
+		return err
+	}
+
+	var userIDs []uint
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.UserRole{}).Where("role_id = ?", role.ID).Pluck("user_id", &userIDs).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role_id = ?", role.ID).Delete(&models.UserRole{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(role).Association("Permissions").Clear(); err != nil {
+			return err
+		}
+		return tx.Delete(role).Error
+	})
+	if err != nil {
+		return err
+	}
+	s.permissions.Invalidate(ctx, userIDs...)
+	return nil
+}
+
+// findRoles 查询管理范围内的角色，任一角色不存在时返回错误
+func (s *AccountService) findRoles(scope AccountScope, roleIDs []uint) ([]models.Role, error) {
+	roleIDs = uniqueIDs(roleIDs)
+	if len(roleIDs) == 0 {
+		return []models.Role{}, nil
+	}
+	var roles []models.Role
+	if err := s.Roles(scope).Where("id IN ?", roleIDs).Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	if len(roles) != len(roleIDs) {
+		return nil, i18n.NewError("role.not_found")
+	}
+	return roles, nil
+}
+
+// grantablePermissions 查询管理范围内的权限，权限码不存在或操作人没有该权限时返回错误
+func (s *AccountService) grantablePermissions(ctx context.Context, scope AccountScope, operator AccountOperator, codes []string) ([]models.Permission, error) {
+	var permissions []models.Permission
+	if err := s.db.Where("code IN ? AND scope = ?", codes, scope.UserType).Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool, len(permissions))
+	for _, permission := range permissions {
+		found[permission.Code] = true
+	}
+	for _, code := range codes {
+		if !found[code] {
+			return nil, i18n.NewError("role.permission_invalid", code)
+		}
+	}
+
+	if err := s.checkCeiling(ctx, scope, operator, codes); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// checkRoles 校验角色的权限不超出操作人的权限
+func (s *AccountService) checkRoles(ctx context.Context, scope AccountScope, operator AccountOperator, roles []models.Role) error {
+	codes, err := s.permissions.RolePermissions(scope.UserType, roles)
+	if err != nil {
+		return err
+	}
+	return s.checkCeiling(ctx, scope, operator, codes)
+}
+
+// checkUser 校验目标账号角色的权限不超出操作人的权限，账号被禁用时同样按其角色判断
+func (s *AccountService) checkUser(ctx context.Context, operator AccountOperator, user *models.User) error {
+	roles, err := s.permissions.UserRoles(user.ID)
+	if err != nil {
+		return err
+	}
+	codes, err := s.permissions.RolePermissions(user.UserType, roles)
+	if err != nil {
+		return err
+	}
+	if err := s.checkCeiling(ctx, UserScope(user), operator, codes); err != nil {
+		return i18n.NewError("account.user_exceeded")
+	}
+	return nil
+}
+
+// checkCeiling 校验权限码都在操作人的有效权限内
+// 平台账号跨商户管理商户账号时不受商户权限上限约束，由接口权限控制。
+func (s *AccountService) checkCeiling(ctx context.Context, scope AccountScope, operator AccountOperator, codes []string) error {
+	if operator.UserType != scope.UserType {
+		return nil
+	}
+	granted, err := s.permissions.UserPermissions(ctx, operator.UserID)
+	if err != nil {
+		return err
+	}
+	held := make(map[string]bool, len(granted))
+	for _, code := range granted {
+		held[code] = true
+	}
+	for _, code := range codes {
+		if !held[code] {
+			return i18n.NewError("role.permission_exceeded", code)
+		}
+	}
+	return nil
+}
+
+// replaceUserRoles 替换账号的全部角色
+func replaceUserRoles(tx *gorm.DB, userID uint, roles []models.Role) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.UserRole{}).Error; err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if err := tx.Omit(clause.Associations).Create(&models.UserRole{UserID: userID, RoleID: role.ID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// roleView 转换角色输出
+func roleView(role *models.Role, codes []string) RoleView {
+	return RoleView{
+		ID:          role.ID,
+		Name:        role.Name,
+		Code:        role.Code,
+		Builtin:     role.Builtin,
+		Description: role.Description,
+		TwoFactor:   role.TwoFactor,
+		Permissions: codes,
+		CreatedAt:   role.CreatedAt,
+	}
+}
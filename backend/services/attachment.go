@@ -0,0 +1,365 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "image/gif" // 注册GIF解码器
+	_ "image/png" // 注册PNG解码器
+
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/storage"
+
+	"gorm.io/gorm"
+)
+
+// 附件下载的文件版本
+const (
+	AttachmentVariantOriginal  = "original"  // 原文件
+	AttachmentVariantThumbnail = "thumbnail" // 缩略图，仅图片
+)
+
+// maxImagePixels 生成缩略图时允许解码的最大像素数，防止解压炸弹
+const maxImagePixels = 50_000_000
+
+// attachmentExtensions 常见MIME类型对应的文件扩展名
+var attachmentExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+}
+
+// AttachmentService 附件服务，负责校验、去重、生成缩略图、写入存储和签发下载链接
+type AttachmentService struct {
+	db    *gorm.DB
+	store storage.Storage
+	cfg   config.StorageConfig
+}
+
+// NewAttachmentService 创建附件服务
+func NewAttachmentService(db *gorm.DB, store storage.Storage, cfg *config.Config) *AttachmentService {
+	return &AttachmentService{db: db, store: store, cfg: cfg.Storage}
+}
+
+// MaxUploadSize 单个附件大小上限（字节）
+func (s *AttachmentService) MaxUploadSize() int64 {
+	return int64(s.cfg.MaxUploadSize) * 1024
+}
+
+// Upload 保存商户上传的文件，内容相同的文件返回已有附件，第二个返回值表示是否复用
+// 文件类型根据内容识别，不信任客户端提供的文件名和Content-Type。
+func (s *AttachmentService) Upload(ctx context.Context, merchantID, userID uint, fileName string, data []byte) (*models.Attachment, bool, error) {
+	if len(data) == 0 {
+		return nil, false, i18n.NewError("attachment.file_empty")
+	}
+	if int64(len(data)) > s.MaxUploadSize() {
+		return nil, false, i18n.NewError("attachment.file_too_large", s.cfg.MaxUploadSize)
+	}
+
+	contentType := sniffContentType(data)
+	if !s.allowed(contentType) {
+		return nil, false, i18n.NewError("attachment.type_not_allowed", contentType)
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if existing, err := s.findByHash(merchantID, hash); err != nil {
+		return nil, false, err
+	} else if existing != nil {
+		return existing, true, nil
+	}
+
+	attachment := models.Attachment{
+		MerchantID:  merchantID,
+		Hash:        hash,
+		FileName:    truncateFileName(fileName),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		StorageKey:  attachmentKey(merchantID, hash, extensionFor(contentType)),
+		UploadedBy:  userID,
+	}
+
+	var thumbnail []byte
+	if strings.HasPrefix(contentType, "image/") && contentType != "image/webp" {
+		var err error
+		thumbnail, attachment.Width, attachment.Height, err = s.thumbnail(data)
+		if err != nil {
+			return nil, false, err
+		}
+		attachment.ThumbnailKey = attachmentKey(merchantID, hash+"_thumb", ".jpg")
+	}
+
+	if err := s.store.Put(ctx, attachment.StorageKey, data, contentType); err != nil {
+		return nil, false, err
+	}
+	if thumbnail != nil {
+		if err := s.store.Put(ctx, attachment.ThumbnailKey, thumbnail, "image/jpeg"); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err := s.db.Create(&attachment).Error; err != nil {
+		// 并发上传相同内容时唯一索引冲突，对象键相同，直接复用先写入的记录
+		if existing, findErr := s.findByHash(merchantID, hash); findErr == nil && existing != nil {
+			return existing, true, nil
+		}
+		return nil, false, err
+	}
+	return &attachment, false, nil
+}
+
+// Find 查询商户的附件，不存在时返回nil
+func (s *AttachmentService) Find(merchantID, id uint) (*models.Attachment, error) {
+	var attachment models.Attachment
+	err := s.db.Where("id = ? AND merchant_id = ?", id, merchantID).First(&attachment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &attachment, err
+}
+
+// FindMany 批量查询商户的附件，不属于该商户的ID被忽略
+func (s *AttachmentService) FindMany(merchantID uint, ids []uint) (map[uint]models.Attachment, error) {
+	result := make(map[uint]models.Attachment, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	var attachments []models.Attachment
+	if err := s.db.Where("merchant_id = ? AND id IN ?", merchantID, ids).Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+	for _, attachment := range attachments {
+		result[attachment.ID] = attachment
+	}
+	return result, nil
+}
+
+// Delete 删除附件记录和存储中的文件
+func (s *AttachmentService) Delete(ctx context.Context, attachment *models.Attachment) error {
+	if err := s.db.Delete(attachment).Error; err != nil {
+		return err
+	}
+	for _, key := range []string{attachment.StorageKey, attachment.ThumbnailKey} {
+		if key == "" {
+			continue
+		}
+		// 记录已删除，文件删除失败只留下孤立文件，不影响业务
+		if err := s.store.Delete(ctx, key); err != nil {
+			log.Printf("删除附件文件 %s 失败: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// Open 读取附件文件，返回文件内容和MIME类型
+func (s *AttachmentService) Open(ctx context.Context, attachment *models.Attachment, variant string) (io.ReadCloser, string, error) {
+	if variant == AttachmentVariantThumbnail {
+		if attachment.ThumbnailKey == "" {
+			return nil, "", i18n.NewError("attachment.thumbnail_missing")
+		}
+		reader, err := s.store.Get(ctx, attachment.ThumbnailKey)
+		return reader, "image/jpeg", err
+	}
+	reader, err := s.store.Get(ctx, attachment.StorageKey)
+	return reader, attachment.ContentType, err
+}
+
+// SignedURL 生成带过期时间的下载链接
+func (s *AttachmentService) SignedURL(attachment *models.Attachment, variant string) (string, time.Time) {
+	expiresAt := time.Now().Add(time.Duration(s.cfg.URLExpire) * time.Second)
+	query := url.Values{}
+	query.Set("variant", variant)
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("signature", s.signature(attachment.ID, variant, expiresAt.Unix()))
+	return fmt.Sprintf("/api/public/attachments/%d?%s", attachment.ID, query.Encode()), expiresAt
+}
+
+// VerifyURL 校验下载链接的签名和有效期
+func (s *AttachmentService) VerifyURL(id uint, variant string, expires int64, signature string) bool {
+	if time.Now().Unix() > expires {
+		return false
+	}
+	expected := s.signature(id, variant, expires)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// URLs 返回附件的下载链接，图片同时返回缩略图链接
+func (s *AttachmentService) URLs(attachment *models.Attachment) map[string]interface{} {
+	urls := map[string]interface{}{}
+	var expiresAt time.Time
+	urls["url"], expiresAt = s.SignedURL(attachment, AttachmentVariantOriginal)
+	if attachment.ThumbnailKey != "" {
+		urls["thumbnail_url"], _ = s.SignedURL(attachment, AttachmentVariantThumbnail)
+	}
+	urls["expires_at"] = expiresAt
+	return urls
+}
+
+// CheckStorageURLSecret 启动时检查下载链接签名密钥，release模式下未配置或仍为默认值时拒绝启动
+// 默认密钥随源码公开，任何人都可以为任意附件伪造长期有效的下载链接。
+func CheckStorageURLSecret(cfg *config.Config) error {
+	if cfg.Storage.URLSecret != "" && cfg.Storage.URLSecret != config.DefaultStorageURLSecret {
+		return nil
+	}
+	if cfg.Server.Mode == "release" {
+		return errors.New("release模式下必须配置STORAGE_URL_SECRET，且不能使用默认值")
+	}
+	log.Println("警告: 未配置STORAGE_URL_SECRET，使用默认的下载链接签名密钥，生产环境请配置独立的密钥")
+	return nil
+}
+
+// signature 计算下载链接签名
+func (s *AttachmentService) signature(id uint, variant string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(s.cfg.URLSecret))
+	fmt.Fprintf(mac, "%d:%s:%d", id, variant, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// allowed 判断MIME类型是否允许上传
+func (s *AttachmentService) allowed(contentType string) bool {
+	for _, allowed := range strings.Split(s.cfg.AllowedTypes, ",") {
+		if strings.TrimSpace(allowed) == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// findByHash 按内容哈希查询商户已有的附件，不存在时返回nil
+func (s *AttachmentService) findByHash(merchantID uint, hash string) (*models.Attachment, error) {
+	var attachment models.Attachment
+	err := s.db.Where("merchant_id = ? AND hash = ?", merchantID, hash).First(&attachment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &attachment, err
+}
+
+// thumbnail 解码图片并生成JPEG缩略图，返回缩略图和原图尺寸
+func (s *AttachmentService) thumbnail(data []byte) ([]byte, int, int, error) {
+	imgCfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, i18n.NewError("attachment.image_invalid")
+	}
+	if imgCfg.Width*imgCfg.Height > maxImagePixels {
+		return nil, 0, 0, i18n.NewError("attachment.image_too_large")
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, i18n.NewError("attachment.image_invalid")
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, resizeImage(img, s.cfg.ThumbnailSize), &jpeg.Options{Quality: 85}); err != nil {
+		return nil, 0, 0, err
+	}
+	return buf.Bytes(), imgCfg.Width, imgCfg.Height, nil
+}
+
+// resizeImage 按最长边等比缩小图片，使用区域平均采样，透明部分以白色填充
+func resizeImage(src image.Image, maxSide int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if maxSide <= 0 {
+		maxSide = 320
+	}
+
+	dstWidth, dstHeight := width, height
+	if width > maxSide || height > maxSide {
+		if width >= height {
+			dstWidth, dstHeight = maxSide, max(1, height*maxSide/width)
+		} else {
+			dstWidth, dstHeight = max(1, width*maxSide/height), maxSide
+		}
+	}
+
+	// 先铺白底再绘制原图，去掉透明通道
+	flat := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), src, bounds.Min, draw.Over)
+	if dstWidth == width && dstHeight == height {
+		return flat
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		y0, y1 := y*height/dstHeight, max((y+1)*height/dstHeight, y*height/dstHeight+1)
+		for x := 0; x < dstWidth; x++ {
+			x0, x1 := x*width/dstWidth, max((x+1)*width/dstWidth, x*width/dstWidth+1)
+
+			var r, g, b, n int
+			for sy := y0; sy < y1; sy++ {
+				offset := flat.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(flat.Pix[offset])
+					g += int(flat.Pix[offset+1])
+					b += int(flat.Pix[offset+2])
+					offset += 4
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(b / n), A: 255})
+		}
+	}
+	return dst
+}
+
+// sniffContentType 根据文件内容识别MIME类型，去掉charset等参数
+func sniffContentType(data []byte) string {
+	contentType := http.DetectContentType(data)
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return contentType
+}
+
+// extensionFor 返回MIME类型对应的文件扩展名
+func extensionFor(contentType string) string {
+	if ext, ok := attachmentExtensions[contentType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(contentType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// attachmentKey 生成按商户隔离的对象键，如 merchants/12/ab/abcdef....jpg
+func attachmentKey(merchantID uint, name, ext string) string {
+	return fmt.Sprintf("merchants/%d/%s/%s%s", merchantID, name[:2], name, ext)
+}
+
+// truncateFileName 去掉客户端文件名中的路径并限制长度
+func truncateFileName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	runes := []rune(name)
+	if len(runes) > 255 {
+		runes = runes[len(runes)-255:]
+	}
+	return string(runes)
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"anti-fake-system/models"
+	"encoding/json"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// 审计操作类型
+const (
+	AuditUserCreate        = "user.create"               // 新建账号
+	AuditUserStatus        = "user.status"               // 启用/禁用账号
+	AuditUserResetPassword = "user.reset_password"       // 重置密码
+	AuditUserAssignRoles   = "user.assign_roles"         // 分配角色
+	AuditUserDelete        = "user.delete"               // 删除账号
+	AuditRoleCreate        = "role.create"               // 新建角色
+	AuditRoleUpdate        = "role.update"               // 修改角色
+	AuditRoleDelete        = "role.delete"               // 删除角色
+	AuditJWTKeyRotate      = "jwt_key.rotate"            // 轮换访问令牌签名密钥
+	AuditJWTKeyRevoke      = "jwt_key.revoke"            // 吊销访问令牌签名密钥
+	AuditDataKeyRotate     = "data_key.rotate"           // 轮换数据加密密钥
+	AuditLoginLockout      = "login.lockout"             // 登录失败次数过多被锁定（系统操作）
+	AuditUserUnlock        = "user.unlock"               // 解除账号登录锁定
+	AuditLoginUnlockIP     = "login.unlock_ip"           // 解除IP登录锁定
+	AuditTwoFactorEnable   = "two_factor.enable"         // 启用两步验证
+	AuditTwoFactorDisable  = "two_factor.disable"        // 关闭两步验证
+	AuditRecoveryCodes     = "two_factor.recovery_codes" // 重新生成恢复码
+	AuditTwoFactorReset    = "two_factor.reset"          // 管理员重置账号的两步验证
+	AuditCodeBatchStatus   = "code.batch_status"         // 批量作废或恢复防伪码
+)
+
+// 审计对象类型
+const (
+	AuditTargetUser    = "user"
+	AuditTargetRole    = "role"
+	AuditTargetJWTKey  = "jwt_key"
+	AuditTargetDataKey = "data_key"
+	AuditTargetIP      = "ip"
+	AuditTargetCode    = "code"
+)
+
+// AuditService 审计日志服务
+type AuditService struct {
+	db *gorm.DB
+}
+
+// NewAuditService 创建审计日志服务
+func NewAuditService(db *gorm.DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// Record 写入一条审计日志，detail序列化为JSON
+// 审计日志在操作成功后写入，写入失败只记录日志，不影响已完成的操作。
+func (s *AuditService) Record(entry *models.AuditLog, detail interface{}) {
+	if detail != nil {
+		data, err := json.Marshal(detail)
+		if err == nil {
+			entry.Detail = string(data)
+		}
+	}
+	if err := s.db.Create(entry).Error; err != nil {
+		log.Printf("写入审计日志 %s 失败: %v", entry.Action, err)
+	}
+}
@@ -0,0 +1,334 @@
+package services
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BatchCodeMaxLength 批次标识的最大长度，批次标识会拼入防伪码
+const BatchCodeMaxLength = 8
+
+// 对账状态
+const (
+	ReconcileMatched = "matched" // 已生成数量与计划数量一致
+	ReconcileShort   = "short"   // 已生成数量少于计划数量
+	ReconcileOver    = "over"    // 已生成数量多于计划数量
+)
+
+// batchCodeToken 批次标识模式中的占位符
+var batchCodeToken = regexp.MustCompile(`\{(YYYY|YY|MM|DD|DDD|SEQ(?::([1-8]))?)\}`)
+
+// batchCodeLiteral 批次标识模式中占位符以外允许的字符
+var batchCodeLiteral = regexp.MustCompile(`^[A-Za-z0-9]*$`)
+
+// BatchInput 创建批次的请求参数，batch_code为空时按配置的模式根据生产日期自动生成
+type BatchInput struct {
+	ProductID      uint      `json:"product_id" binding:"required"`                 // 商品ID
+	BatchCode      string    `json:"batch_code" binding:"omitempty,max=8,alphanum"` // 批次标识，为空时自动生成
+	ProductionDate time.Time `json:"production_date"`                               // 生产日期，为空时取当天
+	Quantity       int       `json:"quantity" binding:"required,min=1"`             // 计划数量
+	ProductionLine string    `json:"production_line" binding:"max=100"`             // 生产线
+	Supervisor     string    `json:"supervisor" binding:"max=50"`                   // 生产负责人
+	QCReportID     *uint     `json:"qc_report_id"`                                  // 质检报告附件ID
+}
+
+// BatchReconciliation 批次对账结果，已激活为正常状态的防伪码，已验证为至少一次验证为真品的防伪码
+type BatchReconciliation struct {
+	BatchID    uint    `json:"batch_id"`
+	BatchCode  string  `json:"batch_code"`
+	ProductID  uint    `json:"product_id"`
+	Planned    int64   `json:"planned"`
+	Generated  int64   `json:"generated"`
+	Activated  int64   `json:"activated"`
+	Voided     int64   `json:"voided"`
+	Recalled   int64   `json:"recalled"`
+	Verified   int64   `json:"verified"`
+	Difference int64   `json:"difference"`  // 已生成数量减计划数量
+	VerifyRate float64 `json:"verify_rate"` // 已验证占已生成的百分比
+	Status     string  `json:"status"`
+}
+
+// BatchCodePattern 解析后的批次标识模式
+type BatchCodePattern struct {
+	pattern  string
+	seqWidth int // 序号位数，0表示模式中没有序号
+}
+
+// ParseBatchCodePattern 解析批次标识模式
+// 支持{YYYY}{YY}{MM}{DD}{DDD}（年内天数）日期占位符和{SEQ:n}序号（n为1-8位，默认2位），其余字符只能是字母和数字，
+// 生成的批次标识总长度不能超过8位。
+func ParseBatchCodePattern(pattern string) (*BatchCodePattern, error) {
+	p := &BatchCodePattern{pattern: pattern}
+	length, seqCount := 0, 0
+	last := 0
+	for _, match := range batchCodeToken.FindAllStringSubmatchIndex(pattern, -1) {
+		literal := pattern[last:match[0]]
+		if !batchCodeLiteral.MatchString(literal) {
+			return nil, i18n.NewError("batch.code_pattern_invalid", pattern)
+		}
+		length += len(literal)
+		last = match[1]
+
+		token := pattern[match[2]:match[3]]
+		switch {
+		case strings.HasPrefix(token, "SEQ"):
+			seqCount++
+			p.seqWidth = 2
+			if match[4] >= 0 {
+				p.seqWidth, _ = strconv.Atoi(pattern[match[4]:match[5]])
+			}
+			length += p.seqWidth
+		case token == "YYYY":
+			length += 4
+		case token == "DDD":
+			length += 3
+		default:
+			length += 2
+		}
+	}
+	if !batchCodeLiteral.MatchString(pattern[last:]) {
+		return nil, i18n.NewError("batch.code_pattern_invalid", pattern)
+	}
+	length += len(pattern) - last
+
+	if length == 0 || length > BatchCodeMaxLength || seqCount > 1 {
+		return nil, i18n.NewError("batch.code_pattern_invalid", pattern)
+	}
+	return p, nil
+}
+
+// render 按生产日期和序号生成批次标识
+func (p *BatchCodePattern) render(date time.Time, seq int) string {
+	return batchCodeToken.ReplaceAllStringFunc(p.pattern, func(token string) string {
+		switch name := token[1 : len(token)-1]; {
+		case name == "YYYY":
+			return fmt.Sprintf("%04d", date.Year())
+		case name == "YY":
+			return fmt.Sprintf("%02d", date.Year()%100)
+		case name == "MM":
+			return fmt.Sprintf("%02d", int(date.Month()))
+		case name == "DD":
+			return fmt.Sprintf("%02d", date.Day())
+		case name == "DDD":
+			return fmt.Sprintf("%03d", date.YearDay())
+		default:
+			return fmt.Sprintf("%0*d", p.seqWidth, seq)
+		}
+	})
+}
+
+// Generate 生成第一个未被占用的批次标识，序号从1开始；模式中没有序号时每个生产日期只能生成一个
+func (p *BatchCodePattern) Generate(date time.Time, taken func(code string) bool) (string, error) {
+	limit := 1
+	for i := 0; i < p.seqWidth; i++ {
+		limit *= 10
+	}
+	start := 1
+	if p.seqWidth == 0 {
+		start, limit = 0, 1
+	}
+	for seq := start; seq < limit; seq++ {
+		if code := p.render(date, seq); !taken(code) {
+			return code, nil
+		}
+	}
+	return "", i18n.NewError("batch.code_exhausted", p.pattern, date.Format("2006-01-02"))
+}
+
+// LockBatchCodes 在事务中锁定商户行，使同一商户的批次标识分配串行执行
+func LockBatchCodes(tx *gorm.DB, merchantID uint) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.Merchant{}, merchantID).Error
+}
+
+// BatchService 商品批次服务
+type BatchService struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+// NewBatchService 创建商品批次服务
+func NewBatchService(db *gorm.DB, cfg *config.Config) *BatchService {
+	return &BatchService{db: db, cfg: cfg}
+}
+
+// Create 创建批次，商品须属于当前商户且未归档，批次标识在商户内唯一
+func (s *BatchService) Create(merchantID uint, input *BatchInput) (*models.ProductBatch, error) {
+	var product models.Product
+	if err := s.db.Where("id = ? AND merchant_id = ?", input.ProductID, merchantID).First(&product).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, i18n.NewError("product.not_found")
+		}
+		return nil, err
+	}
+	if product.Status == ProductStatusArchived {
+		return nil, i18n.NewError("product.archived")
+	}
+
+	if input.QCReportID != nil {
+		var count int64
+		if err := s.db.Model(&models.Attachment{}).Where("id = ? AND merchant_id = ?", *input.QCReportID, merchantID).
+			Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, i18n.NewError("batch.qc_report_not_found", *input.QCReportID)
+		}
+	}
+
+	batch := models.ProductBatch{
+		ProductID:      product.ID,
+		MerchantID:     merchantID,
+		BatchCode:      input.BatchCode,
+		ProductionDate: input.ProductionDate,
+		Quantity:       input.Quantity,
+		ProductionLine: input.ProductionLine,
+		Supervisor:     input.Supervisor,
+		QCReportID:     input.QCReportID,
+		Status:         1,
+	}
+	if batch.ProductionDate.IsZero() {
+		batch.ProductionDate = time.Now()
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := LockBatchCodes(tx, merchantID); err != nil {
+			return err
+		}
+
+		if batch.BatchCode != "" {
+			var count int64
+			if err := tx.Model(&models.ProductBatch{}).Where("merchant_id = ? AND batch_code = ?", merchantID, batch.BatchCode).
+				Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return i18n.NewError("batch.code_exists", batch.BatchCode)
+			}
+		} else {
+			existing, err := existingBatchCodes(tx, merchantID)
+			if err != nil {
+				return err
+			}
+			if batch.BatchCode, err = s.generate(batch.ProductionDate, existing); err != nil {
+				return err
+			}
+		}
+
+		return tx.Omit("Product", "Merchant").Create(&batch).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// generate 按配置的模式生成一个不在existing中的批次标识
+func (s *BatchService) generate(date time.Time, existing map[string]bool) (string, error) {
+	pattern, err := ParseBatchCodePattern(s.cfg.Batch.CodePattern)
+	if err != nil {
+		return "", err
+	}
+	return pattern.Generate(date, func(code string) bool { return existing[code] })
+}
+
+// existingBatchCodes 查询商户已使用的批次标识
+func existingBatchCodes(db *gorm.DB, merchantID uint) (map[string]bool, error) {
+	var codes []string
+	if err := db.Model(&models.ProductBatch{}).Where("merchant_id = ?", merchantID).Pluck("batch_code", &codes).Error; err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		existing[code] = true
+	}
+	return existing, nil
+}
+
+// Reconcile 统计批次的计划、已生成、已激活、已作废和已验证数量
+// 已验证数量需要扫描全部未归档的验证记录月份表，已归档月份的验证不计入。
+func Reconcile(db *gorm.DB, records *VerifyRecordRepository, batches []models.ProductBatch) ([]BatchReconciliation, error) {
+	list := make([]BatchReconciliation, len(batches))
+	if len(batches) == 0 {
+		return list, nil
+	}
+
+	ids := make([]uint, len(batches))
+	for i, batch := range batches {
+		ids[i] = batch.ID
+	}
+
+	var codeCounts []struct {
+		BatchID   uint
+		Generated int64
+		Activated int64
+		Voided    int64
+	}
+	if err := db.Model(&models.SecurityCode{}).
+		Select("batch_id, COUNT(*) AS generated, "+
+			"COALESCE(SUM(CASE WHEN status = ? THEN 1 ELSE 0 END), 0) AS activated, "+
+			"COALESCE(SUM(CASE WHEN status = ? THEN 1 ELSE 0 END), 0) AS voided", CodeStatusNormal, CodeStatusVoid).
+		Where("batch_id IN ?", ids).Group("batch_id").Scan(&codeCounts).Error; err != nil {
+		return nil, err
+	}
+
+	var verifyCounts []struct {
+		BatchID  uint
+		Verified int64
+	}
+	if err := records.All().
+		Select("security_codes.batch_id, COUNT(DISTINCT verification_records.security_code) AS verified").
+		Joins("JOIN security_codes ON security_codes.code = verification_records.security_code").
+		Where("verification_records.merchant_id = ? AND verification_records.result = 1 AND security_codes.batch_id IN ?",
+			batches[0].MerchantID, ids).
+		Group("security_codes.batch_id").Scan(&verifyCounts).Error; err != nil {
+		return nil, err
+	}
+
+	index := make(map[uint]int, len(batches))
+	for i, batch := range batches {
+		index[batch.ID] = i
+		list[i] = BatchReconciliation{
+			BatchID:   batch.ID,
+			BatchCode: batch.BatchCode,
+			ProductID: batch.ProductID,
+			Planned:   int64(batch.Quantity),
+		}
+	}
+	for _, count := range codeCounts {
+		item := &list[index[count.BatchID]]
+		item.Generated = count.Generated
+		item.Activated = count.Activated
+		item.Voided = count.Voided
+		item.Recalled = count.Generated - count.Activated - count.Voided
+	}
+	for _, count := range verifyCounts {
+		list[index[count.BatchID]].Verified = count.Verified
+	}
+
+	for i := range list {
+		item := &list[i]
+		item.Difference = item.Generated - item.Planned
+		switch {
+		case item.Difference < 0:
+			item.Status = ReconcileShort
+		case item.Difference > 0:
+			item.Status = ReconcileOver
+		default:
+			item.Status = ReconcileMatched
+		}
+		if item.Generated > 0 {
+			item.VerifyRate = float64(item.Verified*10000/item.Generated) / 100
+		}
+	}
+	return list, nil
+}
@@ -2,13 +2,13 @@ package services
 
 import (
 	"crypto/rand"
-	"encoding/json"
 	"fmt"
 	"math/big"
 	"strconv"
 	"strings"
 
-	"anti-fake-system/utils"
+	"anti-fake-system/i18n"
+	"anti-fake-system/offlinecode"
 )
 
 // RuleConfig 防伪码规则配置
@@ -21,6 +21,8 @@ type RuleConfig struct {
 	Sequence     *SequenceConfig  `json:"sequence"`             // 序号配置
 	Separator    string           `json:"separator,omitempty"`  // 分隔符
 	TotalLength  int              `json:"total_length"`         // 总长度限制
+	Pin          *PinConfig       `json:"pin,omitempty"`        // 刮开PIN码配置，为空表示不生成PIN
+	Signed       bool             `json:"signed,omitempty"`     // 是否追加Ed25519签名，生成离线可验证的防伪码
 }
 
 type PrefixConfig struct {
@@ -43,22 +45,46 @@ type SequenceConfig struct {
 	Start  int `json:"start"`  // 起始序号
 }
 
+type PinConfig struct {
+	Length  int    `json:"length"`  // 长度(4-12位)
+	Charset string `json:"charset"` // 字符集: numeric(默认), alphanumeric
+}
+
 // CodeGenerator 防伪码生成器
 type CodeGenerator struct {
-	ruleConfig    *RuleConfig
-	encryptionKey []byte
+	ruleConfig *RuleConfig
+	signer     func(payload string) (string, error)
 }
 
-// NewCodeGenerator 创建防伪码生成器
-func NewCodeGenerator(ruleConfig *RuleConfig, encryptionKey []byte) *CodeGenerator {
+// NewCodeGenerator 创建防伪码生成器，规则配置的加密和解密由 KeyManager 负责
+func NewCodeGenerator(ruleConfig *RuleConfig) *CodeGenerator {
 	return &CodeGenerator{
-		ruleConfig:    ruleConfig,
-		encryptionKey: encryptionKey,
+		ruleConfig: ruleConfig,
 	}
 }
 
-// GenerateSingle 生成单个防伪码
+// SetSigner 设置签名函数，签名规则生成防伪码时对明码签名
+func (g *CodeGenerator) SetSigner(signer func(payload string) (string, error)) {
+	g.signer = signer
+}
+
+// GenerateSingle 生成单个防伪码，签名规则返回 "<明码>.<密钥ID>.<签名>"
 func (g *CodeGenerator) GenerateSingle(sequence int) (string, error) {
+	payload, err := g.GeneratePayload(sequence)
+	if err != nil {
+		return "", err
+	}
+	if !g.ruleConfig.Signed {
+		return payload, nil
+	}
+	if g.signer == nil {
+		return "", i18n.NewError("rule.signer_missing")
+	}
+	return g.signer(payload)
+}
+
+// GeneratePayload 生成防伪码明码部分，总长度限制只针对明码
+func (g *CodeGenerator) GeneratePayload(sequence int) (string, error) {
 	var parts []string
 
 	// 生成前置位
@@ -112,7 +138,7 @@ func (g *CodeGenerator) GenerateSingle(sequence int) (string, error) {
 
 	// 检查总长度
 	if len(code) > g.ruleConfig.TotalLength {
-		return "", fmt.Errorf("生成的防伪码长度超过限制: %d > %d", len(code), g.ruleConfig.TotalLength)
+		return "", i18n.NewError("rule.code_too_long", len(code), g.ruleConfig.TotalLength)
 	}
 
 	return code, nil
@@ -133,26 +159,6 @@ func (g *CodeGenerator) GenerateBatch(startSeq, count int) ([]string, error) {
 	return codes, nil
 }
 
-// EncryptRuleConfig 加密规则配置
-func (g *CodeGenerator) EncryptRuleConfig() (string, error) {
-	configJSON, err := json.Marshal(g.ruleConfig)
-	if err != nil {
-		return "", err
-	}
-
-	return utils.Encrypt(configJSON, g.encryptionKey)
-}
-
-// DecryptRuleConfig 解密规则配置
-func (g *CodeGenerator) DecryptRuleConfig(encryptedConfig string) error {
-	decrypted, err := utils.Decrypt(encryptedConfig, g.encryptionKey)
-	if err != nil {
-		return err
-	}
-
-	return json.Unmarshal(decrypted, &g.ruleConfig)
-}
-
 // generatePrefix 生成前置位
 func (g *CodeGenerator) generatePrefix() string {
 	if g.ruleConfig.Prefix == nil {
@@ -191,6 +197,15 @@ func (g *CodeGenerator) generateRandomNumber(length int) (string, error) {
 
 // ValidateCode 验证防伪码格式
 func (g *CodeGenerator) ValidateCode(code string) bool {
+	// 签名防伪码只校验明码部分
+	if g.ruleConfig.Signed {
+		payload, _, _, err := offlinecode.Parse(code)
+		if err != nil {
+			return false
+		}
+		code = payload
+	}
+
 	// 检查总长度
 	if len(code) != g.ruleConfig.TotalLength {
 		return false
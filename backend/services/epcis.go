@@ -0,0 +1,344 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+)
+
+// EPCIS 2.0 JSON-LD 常量
+const (
+	EPCISContext       = "https://ref.gs1.org/standards/epcis/epcis-context.jsonld"
+	EPCISSchemaVersion = "2.0"
+	EPCISNamespace     = "urn:afs:epcis:" // 本系统扩展字段的命名空间，前缀为 afs
+)
+
+// EPCIS 事件类型
+const (
+	EPCISObjectEvent      = "ObjectEvent"
+	EPCISAggregationEvent = "AggregationEvent"
+)
+
+// epcisStageBizSteps 溯源阶段导出时使用的业务步骤和处置状态
+var epcisStageBizSteps = map[int][2]string{
+	TraceStageProduction: {"commissioning", "active"},
+	TraceStageWarehouse:  {"storing", "in_progress"},
+	TraceStageLogistics:  {"shipping", "in_transit"},
+	TraceStageSales:      {"retail_selling", "retail_sold"},
+}
+
+// epcisBizStepStages 导入时业务步骤（CBV 2.0）对应的溯源阶段
+var epcisBizStepStages = map[string]int{
+	"commissioning":           TraceStageProduction,
+	"creating_class_instance": TraceStageProduction,
+	"encoding":                TraceStageProduction,
+	"assembling":              TraceStageProduction,
+	"packing":                 TraceStageProduction,
+	"inspecting":              TraceStageProduction,
+	"receiving":               TraceStageWarehouse,
+	"accepting":               TraceStageWarehouse,
+	"storing":                 TraceStageWarehouse,
+	"stocking":                TraceStageWarehouse,
+	"picking":                 TraceStageWarehouse,
+	"loading":                 TraceStageLogistics,
+	"shipping":                TraceStageLogistics,
+	"departing":               TraceStageLogistics,
+	"arriving":                TraceStageLogistics,
+	"transporting":            TraceStageLogistics,
+	"unloading":               TraceStageLogistics,
+	"retail_selling":          TraceStageSales,
+	"dispensing":              TraceStageSales,
+	"selling":                 TraceStageSales,
+}
+
+// EPCISDocument EPCIS 2.0 文档
+type EPCISDocument struct {
+	Context       []interface{} `json:"@context"`
+	Type          string        `json:"type"`
+	SchemaVersion string        `json:"schemaVersion"`
+	CreationDate  string        `json:"creationDate"`
+	EPCISBody     struct {
+		EventList []map[string]interface{} `json:"eventList"`
+	} `json:"epcisBody"`
+}
+
+// EPCISImportEvent 解析后的导入事件，Errors为空表示可以写入
+type EPCISImportEvent struct {
+	Index     int                    `json:"index"`
+	EventID   string                 `json:"event_id,omitempty"`
+	EventType string                 `json:"event_type,omitempty"`
+	StageType int                    `json:"stage_type,omitempty"`
+	EventTime time.Time              `json:"event_time"`
+	Content   map[string]interface{} `json:"-"`
+	Errors    []error                `json:"-"`
+}
+
+// EPCISLotURI 批次的类级别标识
+func EPCISLotURI(merchant *models.Merchant, batch *models.ProductBatch) string {
+	return fmt.Sprintf("urn:afs:lot:%s.%s", url.PathEscape(merchant.Code), url.PathEscape(batch.BatchCode))
+}
+
+// EPCISCodeURI 防伪码的实例级别标识
+func EPCISCodeURI(code string) string {
+	return "urn:afs:code:" + url.PathEscape(code)
+}
+
+// NewEPCISDocument 创建空的EPCIS文档
+func NewEPCISDocument() *EPCISDocument {
+	doc := &EPCISDocument{
+		Context:       []interface{}{EPCISContext, map[string]string{"afs": EPCISNamespace}},
+		Type:          "EPCISDocument",
+		SchemaVersion: EPCISSchemaVersion,
+		CreationDate:  time.Now().Format(time.RFC3339),
+	}
+	doc.EPCISBody.EventList = []map[string]interface{}{}
+	return doc
+}
+
+// AddCodeEvents 导出防伪码事件：有效防伪码聚合到批次（AggregationEvent ADD），作废防伪码为停用（ObjectEvent DELETE），
+// 已召回的防伪码仍聚合到批次，另有一个召回事件（ObjectEvent OBSERVE，disposition为recalled）
+func (doc *EPCISDocument) AddCodeEvents(merchant *models.Merchant, batch *models.ProductBatch, codes []models.SecurityCode) {
+	lot := EPCISLotURI(merchant, batch)
+
+	var active []string
+	var packedAt time.Time
+	for _, code := range codes {
+		if code.Status == CodeStatusVoid {
+			doc.EPCISBody.EventList = append(doc.EPCISBody.EventList, map[string]interface{}{
+				"type":                EPCISObjectEvent,
+				"eventID":             fmt.Sprintf("urn:afs:code-void:%d", code.ID),
+				"eventTime":           code.UpdatedAt.Format(time.RFC3339),
+				"eventTimeZoneOffset": code.UpdatedAt.Format("-07:00"),
+				"epcList":             []string{EPCISCodeURI(code.Code)},
+				"action":              "DELETE",
+				"bizStep":             "decommissioning",
+				"disposition":         "inactive",
+			})
+			continue
+		}
+		if code.Status == CodeStatusRecalled {
+			doc.EPCISBody.EventList = append(doc.EPCISBody.EventList, map[string]interface{}{
+				"type":                EPCISObjectEvent,
+				"eventID":             fmt.Sprintf("urn:afs:code-recall:%d", code.ID),
+				"eventTime":           code.UpdatedAt.Format(time.RFC3339),
+				"eventTimeZoneOffset": code.UpdatedAt.Format("-07:00"),
+				"epcList":             []string{EPCISCodeURI(code.Code)},
+				"action":              "OBSERVE",
+				"bizStep":             "holding",
+				"disposition":         "recalled",
+			})
+		}
+		active = append(active, EPCISCodeURI(code.Code))
+		if packedAt.IsZero() || code.CreatedAt.Before(packedAt) {
+			packedAt = code.CreatedAt
+		}
+	}
+	if len(active) == 0 {
+		return
+	}
+
+	doc.EPCISBody.EventList = append(doc.EPCISBody.EventList, map[string]interface{}{
+		"type":                EPCISAggregationEvent,
+		"eventID":             fmt.Sprintf("urn:afs:batch-codes:%d", batch.ID),
+		"eventTime":           packedAt.Format(time.RFC3339),
+		"eventTimeZoneOffset": packedAt.Format("-07:00"),
+		"parentID":            lot,
+		"childEPCs":           active,
+		"action":              "ADD",
+		"bizStep":             "packing",
+		"disposition":         "active",
+	})
+}
+
+// AddTraceEvents 导出溯源信息，每条溯源信息对应一个ObjectEvent，原始内容放在afs扩展字段中
+func (doc *EPCISDocument) AddTraceEvents(merchant *models.Merchant, batch *models.ProductBatch, traces []models.TraceabilityInfo) {
+	lot := EPCISLotURI(merchant, batch)
+	for _, trace := range traces {
+		step := epcisStageBizSteps[trace.StageType]
+		action := "OBSERVE"
+		if trace.StageType == TraceStageProduction {
+			action = "ADD"
+		}
+
+		event := map[string]interface{}{
+			"type":                EPCISObjectEvent,
+			"eventID":             fmt.Sprintf("urn:afs:trace:%d:%d", trace.ID, trace.Version),
+			"eventTime":           trace.CreatedAt.Format(time.RFC3339),
+			"eventTimeZoneOffset": trace.CreatedAt.Format("-07:00"),
+			"quantityList":        []map[string]interface{}{{"epcClass": lot, "quantity": batch.Quantity}},
+			"action":              action,
+			"bizStep":             step[0],
+			"disposition":         step[1],
+			"afs:traceStage":      trace.StageType,
+			"afs:traceVersion":    trace.Version,
+			"afs:content":         json.RawMessage(trace.Content),
+		}
+		if trace.Attachments != "" && trace.Attachments != "[]" {
+			event["afs:attachments"] = json.RawMessage(trace.Attachments)
+		}
+		doc.EPCISBody.EventList = append(doc.EPCISBody.EventList, event)
+	}
+}
+
+// ParseEPCISEvents 解析EPCIS文档中的事件并逐个校验，只支持ObjectEvent和AggregationEvent
+func ParseEPCISEvents(data []byte) ([]EPCISImportEvent, error) {
+	var doc EPCISDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, i18n.NewError("epcis.document_invalid")
+	}
+	if doc.Type != "EPCISDocument" {
+		return nil, i18n.NewError("epcis.document_invalid")
+	}
+	if len(doc.EPCISBody.EventList) == 0 {
+		return nil, i18n.NewError("epcis.events_required")
+	}
+
+	events := make([]EPCISImportEvent, len(doc.EPCISBody.EventList))
+	for i, raw := range doc.EPCISBody.EventList {
+		events[i] = parseEPCISEvent(i, raw)
+	}
+	return events, nil
+}
+
+// parseEPCISEvent 校验单个事件并转换为溯源内容
+func parseEPCISEvent(index int, raw map[string]interface{}) EPCISImportEvent {
+	event := EPCISImportEvent{Index: index}
+	event.EventID, _ = raw["eventID"].(string)
+	event.EventType, _ = raw["type"].(string)
+	fail := func(key string, args ...interface{}) {
+		event.Errors = append(event.Errors, i18n.NewError(key, args...))
+	}
+
+	if event.EventType != EPCISObjectEvent && event.EventType != EPCISAggregationEvent {
+		fail("epcis.event_type_unsupported", event.EventType)
+		return event
+	}
+
+	eventTime, _ := raw["eventTime"].(string)
+	parsed, err := time.Parse(time.RFC3339, eventTime)
+	if err != nil {
+		fail("epcis.event_time_invalid")
+	}
+	event.EventTime = parsed
+
+	action, _ := raw["action"].(string)
+	if action != "ADD" && action != "OBSERVE" && action != "DELETE" {
+		fail("epcis.action_invalid", action)
+	}
+
+	bizStep := epcisVocabulary(raw["bizStep"], "bizstep")
+	stage, ok := epcisBizStepStages[bizStep]
+	if !ok {
+		fail("epcis.biz_step_unsupported", bizStep)
+	}
+
+	epcCount := len(epcisStrings(raw["epcList"])) + len(epcisStrings(raw["childEPCs"]))
+	quantity := epcisQuantity(raw["quantityList"]) + epcisQuantity(raw["childQuantityList"])
+	parentID, _ := raw["parentID"].(string)
+	if event.EventType == EPCISAggregationEvent && parentID == "" && action != "OBSERVE" {
+		fail("epcis.parent_required")
+	}
+	if epcCount == 0 && quantity == 0 && parentID == "" {
+		fail("epcis.objects_required")
+	}
+
+	// 本系统导出的事件携带原始溯源内容，按原内容导入
+	if content, ok := raw["afs:content"].(map[string]interface{}); ok {
+		if traceStage, ok := raw["afs:traceStage"].(float64); ok && IsTraceStage(int(traceStage)) {
+			stage = int(traceStage)
+		}
+		event.StageType, event.Content = stage, content
+		return event
+	}
+
+	event.StageType = stage
+	event.Content = map[string]interface{}{
+		"event_type": event.EventType,
+		"action":     action,
+		"biz_step":   bizStep,
+	}
+	optional := map[string]string{
+		"disposition":  epcisVocabulary(raw["disposition"], "disp"),
+		"read_point":   epcisLocation(raw["readPoint"]),
+		"biz_location": epcisLocation(raw["bizLocation"]),
+	}
+	for key, value := range optional {
+		if value != "" {
+			event.Content[key] = value
+		}
+	}
+	if epcCount > 0 {
+		event.Content["epc_count"] = float64(epcCount)
+	}
+	if quantity > 0 {
+		event.Content["quantity"] = quantity
+	}
+	return event
+}
+
+// FitEPCISContent 按商户的阶段模板整理事件内容：丢弃模板外的字段，再按模板校验
+func FitEPCISContent(fields []SchemaField, content map[string]interface{}) (map[string]interface{}, error) {
+	if fields == nil {
+		return content, nil
+	}
+
+	fitted := make(map[string]interface{})
+	for _, field := range fields {
+		if value, ok := content[field.Key]; ok {
+			fitted[field.Key] = value
+		}
+	}
+	return fitted, ValidateTraceContent(fields, fitted)
+}
+
+// epcisVocabulary 将CBV词汇统一为短名称，兼容 urn:epcglobal:cbv:bizstep:xxx 和 https://ref.gs1.org/cbv/BizStep-xxx
+func epcisVocabulary(value interface{}, kind string) string {
+	text, _ := value.(string)
+	text = strings.TrimPrefix(text, "urn:epcglobal:cbv:"+kind+":")
+	if i := strings.LastIndex(text, "/"); i >= 0 && strings.HasPrefix(text, "http") {
+		text = text[i+1:]
+		if j := strings.Index(text, "-"); j >= 0 {
+			text = text[j+1:]
+		}
+	}
+	return text
+}
+
+// epcisLocation 读取 readPoint / bizLocation 的id
+func epcisLocation(value interface{}) string {
+	if location, ok := value.(map[string]interface{}); ok {
+		id, _ := location["id"].(string)
+		return id
+	}
+	return ""
+}
+
+// epcisStrings 读取字符串数组
+func epcisStrings(value interface{}) []string {
+	items, _ := value.([]interface{})
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		if text, ok := item.(string); ok {
+			result = append(result, text)
+		}
+	}
+	return result
+}
+
+// epcisQuantity 汇总quantityList中的数量
+func epcisQuantity(value interface{}) float64 {
+	items, _ := value.([]interface{})
+	var total float64
+	for _, item := range items {
+		if element, ok := item.(map[string]interface{}); ok {
+			quantity, _ := element["quantity"].(float64)
+			total += quantity
+		}
+	}
+	return total
+}
@@ -0,0 +1,956 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/storage"
+	"anti-fake-system/tracechain"
+
+	"gorm.io/gorm"
+)
+
+// 导入类型
+const (
+	ImportTypeProducts = "products" // 商品
+	ImportTypeBatches  = "batches"  // 批次
+	ImportTypeTraces   = "traces"   // 溯源信息
+)
+
+// 导入任务状态
+const (
+	ImportStatusPending     = 0 // 待校验
+	ImportStatusValidating  = 1 // 校验中
+	ImportStatusValidated   = 2 // 校验通过，等待确认导入
+	ImportStatusInvalid     = 3 // 校验未通过
+	ImportStatusCommitQueue = 4 // 已确认，等待导入
+	ImportStatusCommitting  = 5 // 导入中
+	ImportStatusCompleted   = 6 // 已导入
+	ImportStatusFailed      = 7 // 导入失败，事务已回滚
+)
+
+// importProgressStep 每处理多少行更新一次进度
+const importProgressStep = 100
+
+// importWriteBatch 批量写入商品和批次时每批的行数
+const importWriteBatch = 200
+
+// ImportTypes 支持的导入类型
+var ImportTypes = []string{ImportTypeProducts, ImportTypeBatches, ImportTypeTraces}
+
+// errImportInvalid 提交时重新校验发现错误（校验后数据已被修改）
+var errImportInvalid = errors.New("import: validation failed")
+
+// ImportColumn 导入模板中的一列
+type ImportColumn struct {
+	Key      string // 列名，即表头
+	Required bool   // 是否必填
+	Example  string // 模板中的示例值
+}
+
+// importColumns 各导入类型的固定列，溯源信息的其余列为阶段模板中的字段
+var importColumns = map[string][]ImportColumn{
+	ImportTypeProducts: {
+		{Key: "name", Required: true, Example: "有机绿茶250g"},
+		{Key: "sku", Example: "TEA-GREEN-250"},
+		{Key: "gtin", Example: "6901234567892"},
+		{Key: "spec", Example: "250g/盒"},
+		{Key: "model", Example: "GT-250"},
+		{Key: "category", Example: "食品/茶叶/绿茶"},
+		{Key: "description", Example: "明前采摘，一级"},
+		{Key: "images", Example: "https://example.com/1.jpg;https://example.com/2.jpg"},
+	},
+	ImportTypeBatches: {
+		{Key: "batch_code", Example: "B2026001"},
+		{Key: "product_id", Example: ""},
+		{Key: "product_name", Example: "有机绿茶250g"},
+		{Key: "production_date", Required: true, Example: "2026-03-01"},
+		{Key: "quantity", Required: true, Example: "1000"},
+		{Key: "production_line", Example: "A线"},
+		{Key: "supervisor", Example: "张三"},
+	},
+	ImportTypeTraces: {
+		{Key: "batch_code", Required: true, Example: "B2026001"},
+		{Key: "stage_type", Required: true, Example: "1"},
+	},
+}
+
+// ImportRowError 导入文件中的一条错误，Row为文件中的行号（表头为第1行），0表示文件级错误
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	Column  string `json:"column,omitempty"`
+	Key     string `json:"msg_key"`
+	Message string `json:"msg"`
+}
+
+// importRow 去掉表头后的一行数据
+type importRow struct {
+	line   int
+	values map[string]string
+}
+
+// importPlan 校验后待写入的数据
+type importPlan struct {
+	total    int
+	errors   []ImportRowError
+	products []models.Product
+	batches  []models.ProductBatch
+	traces   []models.TraceabilityInfo
+}
+
+// addError 记录一条错误，err为i18n.Error时使用其消息键
+func (p *importPlan) addError(job *models.ImportJob, line int, column string, err error) {
+	key, args := i18n.ErrorKey(err, "common.internal_error")
+	p.errors = append(p.errors, ImportRowError{
+		Row:     line,
+		Column:  column,
+		Key:     key,
+		Message: i18n.Default().Translate(job.Locale, job.MerchantID, key, args...),
+	})
+}
+
+// errorRows 有错误的行数，文件级错误不计入
+func (p *importPlan) errorRows() int {
+	rows := make(map[int]bool)
+	for _, e := range p.errors {
+		if e.Row > 0 {
+			rows[e.Row] = true
+		}
+	}
+	return len(rows)
+}
+
+// IsImportType 判断是否为支持的导入类型
+func IsImportType(importType string) bool {
+	return containsString(ImportTypes, importType)
+}
+
+// ImportTemplate 生成导入模板（表头和一行示例），溯源信息模板包含商户各阶段当前模板中的字段
+func ImportTemplate(db *gorm.DB, merchantID uint, importType string) ([][]string, error) {
+	columns := append([]ImportColumn(nil), importColumns[importType]...)
+
+	if importType == ImportTypeTraces {
+		seen := make(map[string]bool)
+		for _, stageType := range TraceStageTypes {
+			_, fields, err := CurrentTraceSchema(db, merchantID, stageType)
+			if err != nil {
+				return nil, err
+			}
+			for _, field := range fields {
+				if !seen[field.Key] {
+					seen[field.Key] = true
+					columns = append(columns, ImportColumn{Key: field.Key})
+				}
+			}
+		}
+	}
+
+	header := make([]string, len(columns))
+	example := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Key
+		example[i] = column.Example
+	}
+	return [][]string{header, example}, nil
+}
+
+// ImportRunner 批量导入任务执行器
+// 上传后的文件先在后台逐行校验（即试运行），商户确认后再在一个事务中全部写入，任一行失败则全部回滚。
+type ImportRunner struct {
+	db    *gorm.DB
+	store storage.Storage
+	cfg   config.ImportConfig
+	chain *TraceChainService
+
+	batchCodePattern string // 自动生成批次标识的模式
+
+	jobs chan uint
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewImportRunner 创建批量导入任务执行器
+func NewImportRunner(db *gorm.DB, store storage.Storage, cfg *config.Config) *ImportRunner {
+	return &ImportRunner{
+		db:    db,
+		store: store,
+		cfg:   cfg.Import,
+		chain: NewTraceChainService(db),
+		jobs:  make(chan uint, cfg.Import.QueueSize),
+		stop:  make(chan struct{}),
+
+		batchCodePattern: cfg.Batch.CodePattern,
+	}
+}
+
+// Start 启动执行协程和排队任务扫描协程，服务重启前中断的任务重新排队
+func (r *ImportRunner) Start() {
+	r.db.Model(&models.ImportJob{}).Where("status = ?", ImportStatusValidating).Update("status", ImportStatusPending)
+	r.db.Model(&models.ImportJob{}).Where("status = ?", ImportStatusCommitting).Update("status", ImportStatusCommitQueue)
+
+	for i := 0; i < r.cfg.Workers; i++ {
+		r.wg.Add(1)
+		go r.work()
+	}
+
+	r.wg.Add(1)
+	go r.scan()
+}
+
+// Stop 停止所有协程，等待进行中的任务完成
+func (r *ImportRunner) Stop() {
+	close(r.stop)
+	r.wg.Wait()
+}
+
+// MaxFileSize 导入文件大小上限（字节）
+func (r *ImportRunner) MaxFileSize() int64 {
+	return int64(r.cfg.MaxFileSize) * 1024
+}
+
+// Submit 保存上传的文件并创建导入任务，任务排队后在后台校验
+func (r *ImportRunner) Submit(ctx context.Context, merchantID, userID uint, locale, importType, fileName string, data []byte) (*models.ImportJob, error) {
+	if !IsImportType(importType) {
+		return nil, i18n.NewError("import.type_invalid", importType)
+	}
+	if len(data) == 0 {
+		return nil, i18n.NewError("import.file_empty")
+	}
+	if int64(len(data)) > r.MaxFileSize() {
+		return nil, i18n.NewError("import.file_too_large", r.cfg.MaxFileSize)
+	}
+	format := SpreadsheetFormat(fileName, data)
+	if format == "" {
+		return nil, i18n.NewError("import.format_invalid")
+	}
+
+	key := "imports/" + strconv.FormatUint(uint64(merchantID), 10) + "/" + newEventID() + "." + format
+	if err := r.store.Put(ctx, key, data, SpreadsheetContentType(format)); err != nil {
+		return nil, err
+	}
+
+	job := models.ImportJob{
+		MerchantID: merchantID,
+		Type:       importType,
+		FileName:   fileName,
+		Format:     format,
+		FileKey:    key,
+		Locale:     locale,
+		Status:     ImportStatusPending,
+		Errors:     "[]",
+		CreatedBy:  userID,
+	}
+	if err := r.db.Create(&job).Error; err != nil {
+		r.store.Delete(ctx, key)
+		return nil, err
+	}
+	r.enqueue(job.ID)
+	return &job, nil
+}
+
+// Commit 确认导入校验通过的任务
+func (r *ImportRunner) Commit(job *models.ImportJob) error {
+	result := r.db.Model(&models.ImportJob{}).
+		Where("id = ? AND status = ?", job.ID, ImportStatusValidated).
+		Updates(map[string]interface{}{"status": ImportStatusCommitQueue, "processed_rows": 0})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return i18n.NewError("import.not_committable")
+	}
+	job.Status, job.ProcessedRows = ImportStatusCommitQueue, 0
+	r.enqueue(job.ID)
+	return nil
+}
+
+// Errors 解析任务保存的行级错误
+func (r *ImportRunner) Errors(job *models.ImportJob) []ImportRowError {
+	var list []ImportRowError
+	json.Unmarshal([]byte(job.Errors), &list)
+	return list
+}
+
+// ErrorReport 生成错误报告：行号、列、错误信息，后面附上原始行数据，修改后可直接重新导入
+func (r *ImportRunner) ErrorReport(ctx context.Context, job *models.ImportJob, format string) ([]byte, error) {
+	header := []string{"row", "column", "error"}
+	byLine := make(map[int][]ImportRowError)
+	var lines []int
+	for _, e := range r.Errors(job) {
+		if _, ok := byLine[e.Row]; !ok {
+			lines = append(lines, e.Row)
+		}
+		byLine[e.Row] = append(byLine[e.Row], e)
+	}
+	sort.Ints(lines)
+
+	// 原始文件无法读取时只输出错误信息
+	var columns []string
+	values := make(map[int]map[string]string)
+	if data, err := r.readFile(ctx, job); err == nil {
+		if headers, rows, err := parseImportRows(job, data, 0); err == nil {
+			columns = headers
+			for _, row := range rows {
+				values[row.line] = row.values
+			}
+		}
+	}
+
+	report := [][]string{append(header, columns...)}
+	for _, line := range lines {
+		var cols, messages []string
+		for _, e := range byLine[line] {
+			if e.Column != "" && !containsString(cols, e.Column) {
+				cols = append(cols, e.Column)
+			}
+			messages = append(messages, e.Message)
+		}
+
+		row := []string{"", strings.Join(cols, ","), strings.Join(messages, "; ")}
+		if line > 0 {
+			row[0] = strconv.Itoa(line)
+		}
+		for _, column := range columns {
+			row = append(row, values[line][column])
+		}
+		report = append(report, row)
+	}
+	return WriteSpreadsheet(format, report)
+}
+
+// work 执行协程，认领排队中的任务后校验或导入
+func (r *ImportRunner) work() {
+	defer r.wg.Done()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case id := <-r.jobs:
+			switch {
+			case r.claim(id, ImportStatusPending, ImportStatusValidating):
+				r.validate(id)
+			case r.claim(id, ImportStatusCommitQueue, ImportStatusCommitting):
+				r.commit(id)
+			}
+		}
+	}
+}
+
+// scan 定期将排队中的任务放入队列，处理队列已满或重启前遗留的任务
+func (r *ImportRunner) scan() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(r.cfg.ScanInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		var ids []uint
+		r.db.Model(&models.ImportJob{}).
+			Where("status IN ?", []int{ImportStatusPending, ImportStatusCommitQueue}).
+			Order("id asc").
+			Limit(r.cfg.QueueSize/2+1).
+			Pluck("id", &ids)
+		for _, id := range ids {
+			r.enqueue(id)
+		}
+
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// enqueue 将任务放入队列，队列已满时保持排队状态，由扫描协程稍后处理
+func (r *ImportRunner) enqueue(id uint) {
+	select {
+	case r.jobs <- id:
+	default:
+	}
+}
+
+// claim 将任务从from状态切换为to状态，保证同一任务只被一个协程处理
+func (r *ImportRunner) claim(id uint, from, to int) bool {
+	result := r.db.Model(&models.ImportJob{}).Where("id = ? AND status = ?", id, from).Update("status", to)
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// validate 校验任务文件的全部行，结果保存在任务中
+func (r *ImportRunner) validate(id uint) {
+	var job models.ImportJob
+	if err := r.db.First(&job, id).Error; err != nil {
+		return
+	}
+
+	plan, err := r.prepare(r.db, &job, r.progress(&job))
+	if err != nil {
+		log.Printf("导入任务 %d 校验失败: %v", job.ID, err)
+		plan = &importPlan{}
+		plan.addError(&job, 0, "", i18n.NewError("import.validate_failed"))
+	}
+
+	status := ImportStatusValidated
+	if len(plan.errors) > 0 {
+		status = ImportStatusInvalid
+	}
+	now := time.Now()
+	r.db.Model(&job).Updates(map[string]interface{}{
+		"status":         status,
+		"total_rows":     plan.total,
+		"processed_rows": plan.total,
+		"error_rows":     plan.errorRows(),
+		"errors":         encodeImportErrors(plan.errors),
+		"validated_at":   &now,
+	})
+}
+
+// commit 在一个事务中重新校验并写入全部行，校验后数据有变化导致出错时整体回滚
+func (r *ImportRunner) commit(id uint) {
+	var job models.ImportJob
+	if err := r.db.First(&job, id).Error; err != nil {
+		return
+	}
+
+	var plan *importPlan
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if plan, err = r.prepare(tx, &job, nil); err != nil {
+			return err
+		}
+		if len(plan.errors) > 0 {
+			return errImportInvalid
+		}
+		return r.write(tx, &job, plan)
+	})
+
+	now := time.Now()
+	updates := map[string]interface{}{"finished_at": &now}
+	switch {
+	case err == nil:
+		updates["status"] = ImportStatusCompleted
+		updates["imported_rows"] = plan.total
+		updates["processed_rows"] = plan.total
+	case errors.Is(err, errImportInvalid):
+		updates["status"] = ImportStatusInvalid
+		updates["error_rows"] = plan.errorRows()
+		updates["errors"] = encodeImportErrors(plan.errors)
+	default:
+		log.Printf("导入任务 %d 写入失败: %v", job.ID, err)
+		failed := &importPlan{}
+		failed.addError(&job, 0, "", i18n.NewError("import.commit_failed"))
+		updates["status"] = ImportStatusFailed
+		updates["errors"] = encodeImportErrors(failed.errors)
+	}
+	r.db.Model(&job).Updates(updates)
+}
+
+// progress 返回更新任务进度的函数，每处理importProgressStep行写入一次
+func (r *ImportRunner) progress(job *models.ImportJob) func(processed, total int) {
+	return func(processed, total int) {
+		if processed%importProgressStep != 0 && processed != total {
+			return
+		}
+		r.db.Model(job).Updates(map[string]interface{}{"processed_rows": processed, "total_rows": total})
+	}
+}
+
+// readFile 读取任务上传的文件
+func (r *ImportRunner) readFile(ctx context.Context, job *models.ImportJob) ([]byte, error) {
+	reader, err := r.store.Get(ctx, job.FileKey)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(io.LimitReader(reader, r.MaxFileSize()+1))
+}
+
+// prepare 读取并逐行校验任务文件，文件本身的问题作为文件级错误返回在计划中
+func (r *ImportRunner) prepare(db *gorm.DB, job *models.ImportJob, progress func(processed, total int)) (*importPlan, error) {
+	data, err := r.readFile(context.Background(), job)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &importPlan{}
+	_, rows, err := parseImportRows(job, data, r.cfg.MaxRows)
+	if err != nil {
+		plan.addError(job, 0, "", err)
+		return plan, nil
+	}
+	plan.total = len(rows)
+	if progress == nil {
+		progress = func(int, int) {}
+	}
+
+	switch job.Type {
+	case ImportTypeProducts:
+		err = r.prepareProducts(db, job, rows, plan, progress)
+	case ImportTypeBatches:
+		err = r.prepareBatches(db, job, rows, plan, progress)
+	case ImportTypeTraces:
+		err = r.prepareTraces(db, job, rows, plan, progress)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// prepareProducts 校验商品行，商品名称、SKU和GTIN在商户内不能重复，category须为已有分类的完整路径
+func (r *ImportRunner) prepareProducts(db *gorm.DB, job *models.ImportJob, rows []importRow, plan *importPlan, progress func(int, int)) error {
+	var products []models.Product
+	if err := db.Select("name", "sku", "gtin").Where("merchant_id = ?", job.MerchantID).Find(&products).Error; err != nil {
+		return err
+	}
+	existing := map[string]map[string]bool{"name": {}, "sku": {}, "gtin": {}}
+	for _, product := range products {
+		existing["name"][product.Name] = true
+		existing["sku"][product.SKU] = true
+		existing["gtin"][product.GTIN] = true
+	}
+	existsKeys := map[string]string{"name": "import.product_exists", "sku": "product.sku_exists", "gtin": "product.gtin_exists"}
+	inFile := map[string]map[string]int{"name": {}, "sku": {}, "gtin": {}}
+
+	paths, err := CategoryPaths(db, job.MerchantID)
+	if err != nil {
+		return err
+	}
+	categoryIDs := make(map[string]uint, len(paths))
+	for id, path := range paths {
+		categoryIDs[path] = id
+	}
+
+	for i, row := range rows {
+		before := len(plan.errors)
+		if row.values["name"] == "" {
+			plan.addError(job, row.line, "name", i18n.NewError("import.value_required", "name"))
+		}
+		for _, column := range []string{"name", "sku", "gtin"} {
+			value := row.values[column]
+			switch {
+			case value == "":
+			case existing[column][value]:
+				plan.addError(job, row.line, column, i18n.NewError(existsKeys[column], value))
+			case inFile[column][value] > 0:
+				plan.addError(job, row.line, column, i18n.NewError("import.duplicate_in_file", value, inFile[column][value]))
+			default:
+				inFile[column][value] = row.line
+			}
+		}
+		if gtin := row.values["gtin"]; gtin != "" && !ValidGTIN(gtin) {
+			plan.addError(job, row.line, "gtin", i18n.NewError("product.gtin_invalid", gtin))
+		}
+		checkLength(plan, job, row, "name", 200)
+		checkLength(plan, job, row, "sku", 64)
+		checkLength(plan, job, row, "spec", 200)
+		checkLength(plan, job, row, "model", 100)
+
+		var categoryID *uint
+		if path := row.values["category"]; path != "" {
+			if id, ok := categoryIDs[path]; ok {
+				categoryID = &id
+			} else {
+				plan.addError(job, row.line, "category", i18n.NewError("import.category_not_found", path))
+			}
+		}
+
+		if len(plan.errors) == before {
+			images := []string{}
+			for _, image := range strings.Split(row.values["images"], ";") {
+				if image = strings.TrimSpace(image); image != "" {
+					images = append(images, image)
+				}
+			}
+			imagesJSON, _ := json.Marshal(images)
+			plan.products = append(plan.products, models.Product{
+				MerchantID:  job.MerchantID,
+				Name:        row.values["name"],
+				SKU:         row.values["sku"],
+				GTIN:        row.values["gtin"],
+				Spec:        row.values["spec"],
+				Model:       row.values["model"],
+				CategoryID:  categoryID,
+				Category:    row.values["category"],
+				Description: row.values["description"],
+				Images:      string(imagesJSON),
+				Status:      ProductStatusEnabled,
+			})
+		}
+		progress(i+1, len(rows))
+	}
+	return nil
+}
+
+// prepareBatches 校验批次行，商品可以用product_id或product_name指定，已归档的商品不能新建批次，
+// batch_code留空时按配置的模式根据生产日期自动生成
+func (r *ImportRunner) prepareBatches(db *gorm.DB, job *models.ImportJob, rows []importRow, plan *importPlan, progress func(int, int)) error {
+	var products []models.Product
+	if err := db.Select("id", "name").Where("merchant_id = ? AND status <> ?", job.MerchantID, ProductStatusArchived).
+		Find(&products).Error; err != nil {
+		return err
+	}
+	productIDs := make(map[string]uint, len(products))
+	productNames := make(map[string][]uint, len(products))
+	for _, product := range products {
+		productIDs[strconv.FormatUint(uint64(product.ID), 10)] = product.ID
+		productNames[product.Name] = append(productNames[product.Name], product.ID)
+	}
+
+	// 提交时在事务中锁定商户行，避免与手工创建的批次分配到相同的批次标识
+	if err := LockBatchCodes(db, job.MerchantID); err != nil {
+		return err
+	}
+	existing, err := existingBatchCodes(db, job.MerchantID)
+	if err != nil {
+		return err
+	}
+	inFile := make(map[string]int)
+	generated := make(map[string]bool)
+	pattern, patternErr := ParseBatchCodePattern(r.batchCodePattern)
+
+	for i, row := range rows {
+		before := len(plan.errors)
+		batch := models.ProductBatch{
+			MerchantID:     job.MerchantID,
+			ProductionLine: row.values["production_line"],
+			Supervisor:     row.values["supervisor"],
+			Status:         1,
+		}
+
+		batch.BatchCode = row.values["batch_code"]
+		switch {
+		case batch.BatchCode == "":
+			// 留空的批次标识在生产日期解析后自动生成
+		case !batchCodeLiteral.MatchString(batch.BatchCode):
+			plan.addError(job, row.line, "batch_code", i18n.NewError("batch.code_invalid", batch.BatchCode))
+		case existing[batch.BatchCode] || generated[batch.BatchCode]:
+			plan.addError(job, row.line, "batch_code", i18n.NewError("import.batch_exists", batch.BatchCode))
+		case inFile[batch.BatchCode] > 0:
+			plan.addError(job, row.line, "batch_code", i18n.NewError("import.duplicate_in_file", batch.BatchCode, inFile[batch.BatchCode]))
+		default:
+			inFile[batch.BatchCode] = row.line
+		}
+		checkLength(plan, job, row, "batch_code", BatchCodeMaxLength)
+		checkLength(plan, job, row, "production_line", 100)
+		checkLength(plan, job, row, "supervisor", 50)
+
+		if id := row.values["product_id"]; id != "" {
+			if batch.ProductID = productIDs[id]; batch.ProductID == 0 {
+				plan.addError(job, row.line, "product_id", i18n.NewError("product.not_found"))
+			}
+		} else if name := row.values["product_name"]; name != "" {
+			switch ids := productNames[name]; len(ids) {
+			case 0:
+				plan.addError(job, row.line, "product_name", i18n.NewError("product.not_found"))
+			case 1:
+				batch.ProductID = ids[0]
+			default:
+				plan.addError(job, row.line, "product_name", i18n.NewError("import.product_ambiguous", name))
+			}
+		} else {
+			plan.addError(job, row.line, "product_id", i18n.NewError("import.product_required"))
+		}
+
+		if value := row.values["production_date"]; value == "" {
+			plan.addError(job, row.line, "production_date", i18n.NewError("import.value_required", "production_date"))
+		} else if date, ok := ParseSpreadsheetDate(value); ok {
+			batch.ProductionDate = date
+		} else {
+			plan.addError(job, row.line, "production_date", i18n.NewError("import.date_invalid", value))
+		}
+
+		quantity, err := strconv.Atoi(row.values["quantity"])
+		if err != nil || quantity <= 0 {
+			plan.addError(job, row.line, "quantity", i18n.NewError("import.quantity_invalid", row.values["quantity"]))
+		}
+		batch.Quantity = quantity
+
+		if len(plan.errors) == before && batch.BatchCode == "" {
+			if patternErr != nil {
+				plan.addError(job, row.line, "batch_code", patternErr)
+			} else if batch.BatchCode, err = pattern.Generate(batch.ProductionDate, func(code string) bool {
+				return existing[code] || inFile[code] > 0 || generated[code]
+			}); err != nil {
+				plan.addError(job, row.line, "batch_code", err)
+			} else {
+				generated[batch.BatchCode] = true
+			}
+		}
+
+		if len(plan.errors) == before {
+			plan.batches = append(plan.batches, batch)
+		}
+		progress(i+1, len(rows))
+	}
+	return nil
+}
+
+// prepareTraces 校验溯源信息行，除batch_code和stage_type外的非空列作为内容字段，按该阶段当前模板转换类型并校验
+func (r *ImportRunner) prepareTraces(db *gorm.DB, job *models.ImportJob, rows []importRow, plan *importPlan, progress func(int, int)) error {
+	var batches []models.ProductBatch
+	if err := db.Select("id", "batch_code").Where("merchant_id = ?", job.MerchantID).Order("id asc").Find(&batches).Error; err != nil {
+		return err
+	}
+	batchIDs := make(map[string]uint, len(batches))
+	for _, batch := range batches {
+		if _, ok := batchIDs[batch.BatchCode]; !ok {
+			batchIDs[batch.BatchCode] = batch.ID
+		}
+	}
+
+	type stageSchema struct {
+		version int
+		fields  []SchemaField
+	}
+	schemas := make(map[int]*stageSchema)
+	attachments := make(map[uint]bool)
+
+	for i, row := range rows {
+		before := len(plan.errors)
+		trace := models.TraceabilityInfo{MerchantID: job.MerchantID, Attachments: "[]", Version: 1}
+
+		code := row.values["batch_code"]
+		if code == "" {
+			plan.addError(job, row.line, "batch_code", i18n.NewError("import.value_required", "batch_code"))
+		} else if trace.BatchID = batchIDs[code]; trace.BatchID == 0 {
+			plan.addError(job, row.line, "batch_code", i18n.NewError("batch.not_found"))
+		}
+
+		stageType, err := strconv.Atoi(row.values["stage_type"])
+		if err != nil || !IsTraceStage(stageType) {
+			plan.addError(job, row.line, "stage_type", i18n.NewError("trace.stage_type_invalid", stageType))
+			progress(i+1, len(rows))
+			continue
+		}
+		trace.StageType = stageType
+
+		schema, ok := schemas[stageType]
+		if !ok {
+			current, fields, err := CurrentTraceSchema(db, job.MerchantID, stageType)
+			if err != nil {
+				return err
+			}
+			schema = &stageSchema{fields: fields}
+			if current != nil {
+				schema.version = current.Version
+			}
+			schemas[stageType] = schema
+		}
+		trace.SchemaVersion = schema.version
+
+		content, column, err := r.traceContent(db, job, schema.fields, row, attachments)
+		if err != nil {
+			plan.addError(job, row.line, column, err)
+		}
+
+		if len(plan.errors) == before {
+			data, _ := json.Marshal(content)
+			trace.Content = string(data)
+			plan.traces = append(plan.traces, trace)
+		}
+		progress(i+1, len(rows))
+	}
+	return nil
+}
+
+// traceContent 将一行中的内容列转换为溯源信息内容，出错时返回出错的列
+func (r *ImportRunner) traceContent(db *gorm.DB, job *models.ImportJob, fields []SchemaField, row importRow, attachments map[uint]bool) (map[string]interface{}, string, error) {
+	content := make(map[string]interface{})
+	for key, value := range row.values {
+		if key != "batch_code" && key != "stage_type" && value != "" {
+			content[key] = value
+		}
+	}
+	if len(fields) == 0 {
+		if len(content) == 0 {
+			return nil, "", i18n.NewError("trace.content_invalid")
+		}
+		return content, "", nil
+	}
+
+	for _, field := range fields {
+		value, ok := content[field.Key].(string)
+		if !ok {
+			continue
+		}
+		invalid := i18n.NewError("trace.field_invalid", field.Label)
+
+		switch field.Type {
+		case FieldTypeNumber:
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, field.Key, invalid
+			}
+			content[field.Key] = number
+		case FieldTypePerson:
+			content[field.Key] = map[string]interface{}{"name": value}
+		case FieldTypeImage, FieldTypeFile:
+			// 纯数字按附件ID处理，附件必须属于当前商户
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			if _, checked := attachments[uint(id)]; !checked {
+				var count int64
+				if err := db.Model(&models.Attachment{}).Where("id = ? AND merchant_id = ?", id, job.MerchantID).Count(&count).Error; err != nil {
+					return nil, field.Key, err
+				}
+				attachments[uint(id)] = count > 0
+			}
+			if !attachments[uint(id)] {
+				return nil, field.Key, i18n.NewError("attachment.not_found_id", id)
+			}
+			content[field.Key] = float64(id)
+		}
+	}
+
+	if err := ValidateTraceContent(fields, content); err != nil {
+		return nil, "", err
+	}
+	return content, "", nil
+}
+
+// write 在事务中写入校验通过的数据，溯源信息同时追加哈希链记录
+func (r *ImportRunner) write(tx *gorm.DB, job *models.ImportJob, plan *importPlan) error {
+	progress := r.progress(job)
+
+	switch job.Type {
+	case ImportTypeProducts:
+		for start := 0; start < len(plan.products); start += importWriteBatch {
+			end := minInt(start+importWriteBatch, len(plan.products))
+			if err := tx.Omit("Merchant").Create(plan.products[start:end]).Error; err != nil {
+				return err
+			}
+			for j := start; j < end; j++ {
+				product := &plan.products[j]
+				if err := RecordProductHistory(tx, product, ProductActionImport, productChanges(&models.Product{}, product), job.CreatedBy); err != nil {
+					return err
+				}
+			}
+			progress(end, plan.total)
+		}
+	case ImportTypeBatches:
+		for start := 0; start < len(plan.batches); start += importWriteBatch {
+			end := minInt(start+importWriteBatch, len(plan.batches))
+			if err := tx.Omit("Product", "Merchant").Create(plan.batches[start:end]).Error; err != nil {
+				return err
+			}
+			progress(end, plan.total)
+		}
+	case ImportTypeTraces:
+		for i := range plan.traces {
+			trace := &plan.traces[i]
+			if err := tx.Omit("Batch", "Merchant").Create(trace).Error; err != nil {
+				return err
+			}
+			if _, err := r.chain.Append(tx, trace, tracechain.ActionCreate, job.CreatedBy); err != nil {
+				return err
+			}
+			progress(i+1, plan.total)
+		}
+	}
+	return nil
+}
+
+// parseImportRows 解析表格文件，校验表头并返回去掉空行后的数据行，maxRows为0时不限制行数
+func parseImportRows(job *models.ImportJob, data []byte, maxRows int) ([]string, []importRow, error) {
+	records, err := ReadSpreadsheet(job.Format, data)
+	if err != nil {
+		return nil, nil, i18n.NewError("import.format_invalid")
+	}
+	if len(records) == 0 {
+		return nil, nil, i18n.NewError("import.header_missing")
+	}
+
+	headers := make([]string, len(records[0]))
+	seen := make(map[string]bool)
+	for i, header := range records[0] {
+		header = strings.ToLower(strings.TrimSpace(header))
+		if header != "" && seen[header] {
+			return nil, nil, i18n.NewError("import.column_duplicate", header)
+		}
+		seen[header] = true
+		headers[i] = header
+	}
+
+	known := make(map[string]bool)
+	for _, column := range importColumns[job.Type] {
+		known[column.Key] = true
+		if column.Required && !seen[column.Key] {
+			return nil, nil, i18n.NewError("import.column_missing", column.Key)
+		}
+	}
+	// 溯源信息的其余列为内容字段，商品和批次不允许未知列
+	if job.Type != ImportTypeTraces {
+		for _, header := range headers {
+			if header != "" && !known[header] {
+				return nil, nil, i18n.NewError("import.column_unknown", header)
+			}
+		}
+	}
+
+	var rows []importRow
+	for i, record := range records[1:] {
+		values := make(map[string]string, len(headers))
+		empty := true
+		for j, header := range headers {
+			if header == "" || j >= len(record) {
+				continue
+			}
+			value := strings.TrimSpace(record[j])
+			values[header] = value
+			if value != "" {
+				empty = false
+			}
+		}
+		if empty {
+			continue
+		}
+		rows = append(rows, importRow{line: i + 2, values: values})
+		if maxRows > 0 && len(rows) > maxRows {
+			return nil, nil, i18n.NewError("import.too_many_rows", maxRows)
+		}
+	}
+	if len(rows) == 0 {
+		return nil, nil, i18n.NewError("import.rows_required")
+	}
+	return headers, rows, nil
+}
+
+// checkLength 校验单元格长度（按字符计）
+func checkLength(plan *importPlan, job *models.ImportJob, row importRow, column string, max int) {
+	if utf8.RuneCountInString(row.values[column]) > max {
+		plan.addError(job, row.line, column, i18n.NewError("import.value_too_long", column, max))
+	}
+}
+
+// encodeImportErrors 将错误列表编码为JSON保存
+func encodeImportErrors(list []ImportRowError) string {
+	if list == nil {
+		list = []ImportRowError{}
+	}
+	data, _ := json.Marshal(list)
+	return string(data)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,491 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/utils"
+
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 访问令牌签名密钥状态
+const (
+	JWTKeyStatusRevoked = 0 // 已吊销，使用该密钥签名的令牌立即失效
+	JWTKeyStatusActive  = 1 // 当前签名密钥
+	JWTKeyStatusRetired = 2 // 已轮换，仅用于验证轮换前签发的令牌
+)
+
+const (
+	jwtKeyRefreshInterval = time.Minute     // 定时从数据库重新加载密钥并检查轮换的间隔
+	jwtKeyReloadCooldown  = 5 * time.Second // 遇到未知kid时重新加载的最短间隔，避免伪造kid频繁查库
+	jwtKeyClockSkew       = time.Minute     // 已轮换密钥的验证截止时间额外保留的时钟偏差
+	jwtRSAKeyBits         = 2048            // RS256密钥长度
+	jwtHMACKeySize        = 32              // HS256密钥长度（字节）
+	jwtKeyIDSize          = 8               // 密钥ID随机字节数，十六进制表示后为16个字符
+)
+
+// JWK JSON Web Key，只包含公钥，用于其他服务验证访问令牌
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"` // EdDSA
+	X         string `json:"x,omitempty"`   // EdDSA公钥
+	N         string `json:"n,omitempty"`   // RSA模数
+	E         string `json:"e,omitempty"`   // RSA公钥指数
+}
+
+// jwtKey 已加载的签名密钥
+type jwtKey struct {
+	record    models.JWTSigningKey
+	method    jwt.SigningMethod
+	signKey   interface{} // 只有当前签名密钥会解密私钥
+	verifyKey interface{}
+}
+
+// JWTKeyService 访问令牌签名密钥环，负责生成、定时轮换、吊销密钥和公开JWKS
+// 密钥保存在数据库中并缓存在内存，多实例部署时各实例定时重新加载；已轮换的密钥保留到其签发的令牌全部过期。
+type JWTKeyService struct {
+	db  *gorm.DB
+	cfg *config.Config
+	kms *KeyManager
+
+	mu       sync.RWMutex
+	current  *jwtKey
+	keys     map[string]*jwtKey
+	loadedAt time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewJWTKeyService 创建签名密钥环，私钥使用KMS主密钥加密保存
+func NewJWTKeyService(db *gorm.DB, cfg *config.Config, kms *KeyManager) *JWTKeyService {
+	return &JWTKeyService{
+		db:   db,
+		cfg:  cfg,
+		kms:  kms,
+		keys: make(map[string]*jwtKey),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Init 迁移旧格式私钥并加载签名密钥，尚无当前密钥、配置的算法已变更或已到轮换时间时生成新密钥
+func (s *JWTKeyService) Init() error {
+	if _, ok := utils.SigningMethods[s.cfg.JWT.Algorithm]; !ok {
+		return fmt.Errorf("不支持的JWT签名算法: %s", s.cfg.JWT.Algorithm)
+	}
+	if err := s.migrateLegacyKeys(); err != nil {
+		return err
+	}
+	if _, err := s.rotate(false); err != nil {
+		return err
+	}
+	return s.reload()
+}
+
+// Start 启动定时协程：重新加载密钥、到期自动轮换并删除已过验证截止时间的旧密钥
+func (s *JWTKeyService) Start() {
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(jwtKeyRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.maintain()
+			}
+		}
+	}()
+}
+
+// Stop 停止定时协程
+func (s *JWTKeyService) Stop() {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	<-s.done
+}
+
+// SigningKey 返回当前签名密钥，实现 utils.TokenKeys
+func (s *JWTKeyService) SigningKey() (string, jwt.SigningMethod, interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.current == nil {
+		return "", nil, nil, errors.New("没有可用的JWT签名密钥")
+	}
+	return s.current.record.KeyID, s.current.method, s.current.signKey, nil
+}
+
+// VerificationKey 返回kid对应的验证密钥，实现 utils.TokenKeys
+// 内存中没有该kid时重新加载一次，以便尽快识别其他实例轮换出的新密钥。
+func (s *JWTKeyService) VerificationKey(kid, alg string) (interface{}, error) {
+	key := s.lookup(kid)
+	if key == nil {
+		s.mu.RLock()
+		stale := time.Since(s.loadedAt) > jwtKeyReloadCooldown
+		s.mu.RUnlock()
+		if stale {
+			if err := s.reload(); err != nil {
+				log.Printf("JWT签名密钥加载失败: %v", err)
+			}
+			key = s.lookup(kid)
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("未知的JWT签名密钥: %s", kid)
+	}
+	if key.record.Algorithm != alg {
+		return nil, fmt.Errorf("JWT签名密钥 %s 的算法不是 %s", kid, alg)
+	}
+	if key.record.ExpiresAt != nil && key.record.ExpiresAt.Before(time.Now()) {
+		return nil, fmt.Errorf("JWT签名密钥 %s 已过验证截止时间", kid)
+	}
+	return key.verifyKey, nil
+}
+
+// List 查询全部签名密钥
+func (s *JWTKeyService) List() ([]models.JWTSigningKey, error) {
+	var keys []models.JWTSigningKey
+	err := s.db.Order("id desc").Find(&keys).Error
+	return keys, err
+}
+
+// JWKS 返回当前和已轮换的非对称签名密钥的公钥，HS256密钥不公开
+func (s *JWTKeyService) JWKS() []JWK {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jwks := []JWK{}
+	for _, key := range s.sortedKeys() {
+		jwk := JWK{KeyID: key.record.KeyID, Use: "sig", Algorithm: key.record.Algorithm}
+		switch publicKey := key.verifyKey.(type) {
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		default:
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
+
+// Rotate 立即生成新的签名密钥，原当前密钥转为已轮换状态，继续用于验证轮换前签发的令牌
+func (s *JWTKeyService) Rotate() (*models.JWTSigningKey, error) {
+	key, err := s.rotate(true)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Revoke 吊销已轮换的签名密钥，使用该密钥签名的令牌立即失效，当前签名密钥需要先轮换才能吊销
+// 其他实例在下一次重新加载密钥（1分钟内）后生效。
+func (s *JWTKeyService) Revoke(kid string) (*models.JWTSigningKey, error) {
+	var key models.JWTSigningKey
+	if err := s.db.Where("key_id = ?", kid).First(&key).Error; err != nil {
+		return nil, i18n.NewError("jwt_key.not_found")
+	}
+	if key.Status == JWTKeyStatusActive {
+		return nil, i18n.NewError("jwt_key.revoke_active")
+	}
+
+	now := time.Now()
+	if err := s.db.Model(&key).Updates(map[string]interface{}{
+		"status":     JWTKeyStatusRevoked,
+		"revoked_at": now,
+	}).Error; err != nil {
+		return nil, err
+	}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// maintain 定时任务：到期轮换、删除过期的旧密钥并重新加载
+func (s *JWTKeyService) maintain() {
+	if _, err := s.rotate(false); err != nil {
+		log.Printf("JWT签名密钥轮换失败: %v", err)
+	}
+	if err := s.db.Where("status = ? AND expires_at < ?", JWTKeyStatusRetired, time.Now()).
+		Delete(&models.JWTSigningKey{}).Error; err != nil {
+		log.Printf("删除过期的JWT签名密钥失败: %v", err)
+	}
+	if err := s.reload(); err != nil {
+		log.Printf("JWT签名密钥加载失败: %v", err)
+	}
+}
+
+// rotate 生成新的当前签名密钥；force为false时只在没有当前密钥、算法已变更或已到轮换时间时生成
+// 当前密钥行在事务中加锁，多个实例同时检查时只有一个会轮换。
+func (s *JWTKeyService) rotate(force bool) (*models.JWTSigningKey, error) {
+	var created *models.JWTSigningKey
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var active []models.JWTSigningKey
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("status = ?", JWTKeyStatusActive).Order("id desc").Find(&active).Error; err != nil {
+			return err
+		}
+		if !force && len(active) > 0 && !s.rotationDue(&active[0]) {
+			return nil
+		}
+
+		key, err := s.generate()
+		if err != nil {
+			return err
+		}
+
+		if len(active) > 0 {
+			now := time.Now()
+			expiresAt := now.Add(time.Duration(s.cfg.JWT.AccessExpire)*time.Minute + jwtKeyClockSkew)
+			if err := tx.Model(&models.JWTSigningKey{}).Where("status = ?", JWTKeyStatusActive).
+				Updates(map[string]interface{}{
+					"status":     JWTKeyStatusRetired,
+					"retired_at": now,
+					"expires_at": expiresAt,
+				}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(key).Error; err != nil {
+			return err
+		}
+		created = key
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if created != nil {
+		log.Printf("已生成新的JWT签名密钥 %s (%s)", created.KeyID, created.Algorithm)
+	}
+	return created, nil
+}
+
+// rotationDue 判断当前签名密钥是否需要轮换
+func (s *JWTKeyService) rotationDue(active *models.JWTSigningKey) bool {
+	if active.Algorithm != s.cfg.JWT.Algorithm {
+		return true
+	}
+	interval := time.Duration(s.cfg.JWT.KeyRotateInterval) * time.Hour
+	return interval > 0 && time.Since(active.CreatedAt) >= interval
+}
+
+// generate 按配置的算法生成新的签名密钥，私钥加密后保存
+func (s *JWTKeyService) generate() (*models.JWTSigningKey, error) {
+	var privateDER, publicDER []byte
+	switch s.cfg.JWT.Algorithm {
+	case jwt.SigningMethodHS256.Alg():
+		secret, err := utils.GenerateRandomKey(jwtHMACKeySize)
+		if err != nil {
+			return nil, err
+		}
+		privateDER = secret
+	case jwt.SigningMethodRS256.Alg():
+		privateKey, err := rsa.GenerateKey(rand.Reader, jwtRSAKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		if privateDER, err = x509.MarshalPKCS8PrivateKey(privateKey); err != nil {
+			return nil, err
+		}
+		if publicDER, err = x509.MarshalPKIXPublicKey(&privateKey.PublicKey); err != nil {
+			return nil, err
+		}
+	case jwt.SigningMethodEdDSA.Alg():
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		if privateDER, err = x509.MarshalPKCS8PrivateKey(privateKey); err != nil {
+			return nil, err
+		}
+		if publicDER, err = x509.MarshalPKIXPublicKey(publicKey); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("不支持的JWT签名算法: %s", s.cfg.JWT.Algorithm)
+	}
+
+	encrypted, err := s.kms.EncryptSecret(privateDER)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := randomHex(jwtKeyIDSize)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &models.JWTSigningKey{
+		KeyID:       kid,
+		Algorithm:   s.cfg.JWT.Algorithm,
+		PrivateKey:  encrypted,
+		MasterKeyID: s.kms.MasterKeyID(),
+		Status:      JWTKeyStatusActive,
+	}
+	if publicDER != nil {
+		key.PublicKey = base64.StdEncoding.EncodeToString(publicDER)
+	}
+	return key, nil
+}
+
+// migrateLegacyKeys 将旧版本以JWT密钥派生的AES密钥加密的私钥一次性重新用主密钥加密
+// 已吊销的密钥不再使用，直接清除其私钥。这是唯一仍使用JWT密钥解密的地方，所有部署都完成迁移后可以删除。
+func (s *JWTKeyService) migrateLegacyKeys() error {
+	if err := s.db.Model(&models.JWTSigningKey{}).
+		Where("(master_key_id = '' OR master_key_id IS NULL) AND status = ?", JWTKeyStatusRevoked).
+		Updates(map[string]interface{}{"private_key": "", "master_key_id": s.kms.MasterKeyID()}).Error; err != nil {
+		return err
+	}
+
+	var records []models.JWTSigningKey
+	if err := s.db.Where("master_key_id = '' OR master_key_id IS NULL").Find(&records).Error; err != nil {
+		return err
+	}
+
+	legacyKey := sha256.Sum256([]byte(s.cfg.JWT.Secret))
+	for _, record := range records {
+		privateDER, err := utils.Decrypt(record.PrivateKey, legacyKey[:])
+		if err != nil {
+			return fmt.Errorf("JWT签名密钥 %s 的旧格式私钥解密失败: %w", record.KeyID, err)
+		}
+		encrypted, err := s.kms.EncryptSecret(privateDER)
+		if err != nil {
+			return err
+		}
+		if err := s.db.Model(&models.JWTSigningKey{}).
+			Where("id = ? AND private_key = ?", record.ID, record.PrivateKey).
+			Updates(map[string]interface{}{"private_key": encrypted, "master_key_id": s.kms.MasterKeyID()}).Error; err != nil {
+			return err
+		}
+	}
+	if len(records) > 0 {
+		log.Printf("已将 %d 个JWT签名密钥的私钥迁移为主密钥加密", len(records))
+	}
+	return nil
+}
+
+// reload 从数据库加载当前和已轮换的签名密钥
+func (s *JWTKeyService) reload() error {
+	var records []models.JWTSigningKey
+	if err := s.db.Where("status IN ?", []int{JWTKeyStatusActive, JWTKeyStatusRetired}).
+		Order("id desc").Find(&records).Error; err != nil {
+		return err
+	}
+
+	keys := make(map[string]*jwtKey, len(records))
+	var current *jwtKey
+	for _, record := range records {
+		key, err := s.load(record, record.Status == JWTKeyStatusActive && current == nil)
+		if err != nil {
+			log.Printf("JWT签名密钥 %s 加载失败: %v", record.KeyID, err)
+			continue
+		}
+		keys[record.KeyID] = key
+		if key.signKey != nil {
+			current = key
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+	s.current = current
+	s.loadedAt = time.Now()
+	return nil
+}
+
+// load 解析密钥记录，signing为true时解密私钥用于签名
+func (s *JWTKeyService) load(record models.JWTSigningKey, signing bool) (*jwtKey, error) {
+	method, ok := utils.SigningMethods[record.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("不支持的JWT签名算法: %s", record.Algorithm)
+	}
+	key := &jwtKey{record: record, method: method}
+
+	// HS256的验证密钥即签名密钥，始终需要解密
+	if signing || record.Algorithm == jwt.SigningMethodHS256.Alg() {
+		if record.MasterKeyID != s.kms.MasterKeyID() {
+			return nil, fmt.Errorf("私钥由其他主密钥（%s）加密，请检查主密钥配置", record.MasterKeyID)
+		}
+		privateDER, err := s.kms.DecryptSecret(record.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		if record.Algorithm == jwt.SigningMethodHS256.Alg() {
+			key.verifyKey = privateDER
+			if signing {
+				key.signKey = privateDER
+			}
+			return key, nil
+		}
+		privateKey, err := x509.ParsePKCS8PrivateKey(privateDER)
+		if err != nil {
+			return nil, err
+		}
+		key.signKey = privateKey
+	}
+
+	publicDER, err := base64.StdEncoding.DecodeString(record.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(publicDER)
+	if err != nil {
+		return nil, err
+	}
+	key.verifyKey = publicKey
+	return key, nil
+}
+
+// lookup 按kid查找已加载的密钥
+func (s *JWTKeyService) lookup(kid string) *jwtKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys[kid]
+}
+
+// sortedKeys 按创建顺序从新到旧返回已加载的密钥，调用方需持有读锁
+func (s *JWTKeyService) sortedKeys() []*jwtKey {
+	sorted := make([]*jwtKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		sorted = append(sorted, key)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].record.ID > sorted[j].record.ID })
+	return sorted
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/models"
+	"anti-fake-system/utils"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// newTestKeyRing 创建不连接数据库的签名密钥环，密钥直接放入内存
+func newTestKeyRing(t *testing.T) *JWTKeyService {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.KMS.MasterKey = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("m", dataKeySize)))
+	cfg.JWT.AccessExpire = 15
+	keys, err := NewKeyManager(nil, cfg)
+	if err != nil {
+		t.Fatalf("NewKeyManager error: %v", err)
+	}
+	s := NewJWTKeyService(nil, cfg, keys)
+	s.loadedAt = time.Now() // 冷却期内遇到未知kid不会查库
+	return s
+}
+
+// addTestKey 按算法生成密钥并以指定状态加入密钥环，当前密钥同时作为签名密钥
+func addTestKey(t *testing.T, s *JWTKeyService, alg string, id uint, status int, expiresAt *time.Time) *jwtKey {
+	t.Helper()
+	s.cfg.JWT.Algorithm = alg
+	record, err := s.generate()
+	if err != nil {
+		t.Fatalf("generate(%s) error: %v", alg, err)
+	}
+	record.ID = id
+	record.Status = status
+	record.ExpiresAt = expiresAt
+	record.CreatedAt = time.Now()
+
+	key, err := s.load(*record, status == JWTKeyStatusActive)
+	if err != nil {
+		t.Fatalf("load(%s) error: %v", alg, err)
+	}
+	s.mu.Lock()
+	s.keys[record.KeyID] = key
+	if status == JWTKeyStatusActive {
+		s.current = key
+	}
+	s.mu.Unlock()
+	return key
+}
+
+// retire 模拟轮换：原当前密钥转为已轮换状态，不再解密私钥
+func retire(t *testing.T, s *JWTKeyService, key *jwtKey, expiresAt time.Time) {
+	t.Helper()
+	record := key.record
+	record.Status = JWTKeyStatusRetired
+	record.ExpiresAt = &expiresAt
+	retired, err := s.load(record, false)
+	if err != nil {
+		t.Fatalf("load retired key error: %v", err)
+	}
+	s.mu.Lock()
+	s.keys[record.KeyID] = retired
+	s.mu.Unlock()
+}
+
+func issueTestToken(t *testing.T, s *JWTKeyService) string {
+	t.Helper()
+	token, _, err := utils.GenerateToken(s, 1, "admin", 1, nil, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateToken error: %v", err)
+	}
+	return token
+}
+
+func TestJWTKeyRotationDue(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		configAlg string
+		interval  int
+		keyAlg    string
+		createdAt time.Time
+		want      bool
+	}{
+		{"fresh key", "EdDSA", 24, "EdDSA", now, false},
+		{"interval reached", "EdDSA", 24, "EdDSA", now.Add(-25 * time.Hour), true},
+		{"just before interval", "EdDSA", 24, "EdDSA", now.Add(-23 * time.Hour), false},
+		{"auto rotation disabled", "EdDSA", 0, "EdDSA", now.Add(-1000 * time.Hour), false},
+		{"algorithm changed", "RS256", 0, "EdDSA", now, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &JWTKeyService{cfg: &config.Config{JWT: config.JWTConfig{Algorithm: tt.configAlg, KeyRotateInterval: tt.interval}}}
+			active := &models.JWTSigningKey{Algorithm: tt.keyAlg}
+			active.CreatedAt = tt.createdAt
+			if got := s.rotationDue(active); got != tt.want {
+				t.Errorf("rotationDue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWTKeyRingRotation(t *testing.T) {
+	algorithms := []string{
+		jwt.SigningMethodHS256.Alg(),
+		jwt.SigningMethodRS256.Alg(),
+		jwt.SigningMethodEdDSA.Alg(),
+	}
+	for _, oldAlg := range algorithms {
+		for _, newAlg := range algorithms {
+			t.Run(oldAlg+"->"+newAlg, func(t *testing.T) {
+				s := newTestKeyRing(t)
+				old := addTestKey(t, s, oldAlg, 1, JWTKeyStatusActive, nil)
+				oldToken := issueTestToken(t, s)
+
+				// 轮换后旧令牌继续有效，新令牌使用新密钥签名
+				retire(t, s, old, time.Now().Add(time.Hour))
+				current := addTestKey(t, s, newAlg, 2, JWTKeyStatusActive, nil)
+				newToken := issueTestToken(t, s)
+
+				if _, err := utils.ParseToken(s, oldToken); err != nil {
+					t.Errorf("token signed before rotation rejected: %v", err)
+				}
+				claims, err := utils.ParseToken(s, newToken)
+				if err != nil {
+					t.Fatalf("token signed after rotation rejected: %v", err)
+				}
+				parsed, _, _ := jwt.NewParser().ParseUnverified(newToken, &utils.CustomClaims{})
+				if kid := parsed.Header["kid"]; kid != current.record.KeyID {
+					t.Errorf("new token kid = %v, want %s", kid, current.record.KeyID)
+				}
+				if claims.UserID != 1 {
+					t.Errorf("new token user = %d, want 1", claims.UserID)
+				}
+
+				// 超过验证截止时间后旧令牌失效
+				retire(t, s, old, time.Now().Add(-time.Second))
+				if _, err := utils.ParseToken(s, oldToken); err == nil {
+					t.Error("token accepted after its key passed the verification deadline")
+				}
+
+				// 吊销后密钥不再加载，旧令牌失效，新令牌不受影响
+				s.mu.Lock()
+				delete(s.keys, old.record.KeyID)
+				s.mu.Unlock()
+				if _, err := utils.ParseToken(s, oldToken); err == nil {
+					t.Error("token accepted after its key was revoked")
+				}
+				if _, err := utils.ParseToken(s, newToken); err != nil {
+					t.Errorf("current token rejected after revoking the old key: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestJWTKeyVerificationKey(t *testing.T) {
+	s := newTestKeyRing(t)
+	key := addTestKey(t, s, jwt.SigningMethodEdDSA.Alg(), 1, JWTKeyStatusActive, nil)
+	expired := time.Now().Add(-time.Minute)
+	old := addTestKey(t, s, jwt.SigningMethodRS256.Alg(), 0, JWTKeyStatusRetired, &expired)
+
+	tests := []struct {
+		name    string
+		kid     string
+		alg     string
+		wantErr bool
+	}{
+		{"matching algorithm", key.record.KeyID, "EdDSA", false},
+		{"algorithm confusion", key.record.KeyID, "HS256", true},
+		{"unknown kid", "0000000000000000", "EdDSA", true},
+		{"past verification deadline", old.record.KeyID, "RS256", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.VerificationKey(tt.kid, tt.alg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerificationKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTKeyLoad(t *testing.T) {
+	s := newTestKeyRing(t)
+	s.cfg.JWT.Algorithm = jwt.SigningMethodEdDSA.Alg()
+	record, err := s.generate()
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	if record.MasterKeyID != s.kms.MasterKeyID() {
+		t.Errorf("generated key master key id = %q, want %q", record.MasterKeyID, s.kms.MasterKeyID())
+	}
+	if len(record.KeyID) != jwtKeyIDSize*2 {
+		t.Errorf("kid = %q, want %d hex characters", record.KeyID, jwtKeyIDSize*2)
+	}
+
+	tests := []struct {
+		name    string
+		mutate  func(r *models.JWTSigningKey)
+		signing bool
+		wantErr bool
+	}{
+		{"signing key", func(r *models.JWTSigningKey) {}, true, false},
+		{"verification only", func(r *models.JWTSigningKey) {}, false, false},
+		{"other master key", func(r *models.JWTSigningKey) { r.MasterKeyID = "0000000000000000" }, true, true},
+		{"other master key not needed for verification", func(r *models.JWTSigningKey) { r.MasterKeyID = "0000000000000000" }, false, false},
+		{"tampered private key", func(r *models.JWTSigningKey) { r.PrivateKey = base64.StdEncoding.EncodeToString([]byte("tampered")) }, true, true},
+		{"unsupported algorithm", func(r *models.JWTSigningKey) { r.Algorithm = "none" }, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copied := *record
+			tt.mutate(&copied)
+			key, err := s.load(copied, tt.signing)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (key.signKey != nil) != tt.signing {
+				t.Errorf("load() signKey loaded = %v, want %v", key.signKey != nil, tt.signing)
+			}
+		})
+	}
+}
+
+func TestJWTKeyJWKS(t *testing.T) {
+	s := newTestKeyRing(t)
+	addTestKey(t, s, jwt.SigningMethodRS256.Alg(), 1, JWTKeyStatusRetired, nil)
+	addTestKey(t, s, jwt.SigningMethodHS256.Alg(), 2, JWTKeyStatusRetired, nil)
+	addTestKey(t, s, jwt.SigningMethodEdDSA.Alg(), 3, JWTKeyStatusActive, nil)
+
+	jwks := s.JWKS()
+	if len(jwks) != 2 {
+		t.Fatalf("JWKS() returned %d keys, want 2 (HS256 excluded)", len(jwks))
+	}
+	// 从新到旧排列
+	if jwks[0].Algorithm != "EdDSA" || jwks[0].KeyType != "OKP" || jwks[0].Curve != "Ed25519" || jwks[0].X == "" {
+		t.Errorf("JWKS()[0] = %+v, want Ed25519 key", jwks[0])
+	}
+	if jwks[1].Algorithm != "RS256" || jwks[1].KeyType != "RSA" || jwks[1].N == "" || jwks[1].E != "AQAB" {
+		t.Errorf("JWKS()[1] = %+v, want RSA key", jwks[1])
+	}
+}
@@ -0,0 +1,528 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 数据加密密钥状态
+const (
+	DataKeyStatusActive  = 1 // 当前密钥，新数据使用该密钥加密
+	DataKeyStatusRetired = 2 // 已轮换，仅用于解密尚未重新加密的旧数据
+)
+
+const (
+	dataKeySize           = 32     // 数据加密密钥长度（AES-256）
+	dataKeyHeaderPrefix   = "k"    // 密文版本头前缀，完整格式为 k<版本>:<Base64密文>
+	reencryptBatchSize    = 100    // 重新加密时每批读取的规则数
+	masterKeyIDHexLength  = 16     // 主密钥指纹长度（十六进制字符）
+	masterKeyDerivePrefix = "kms:" // 未配置主密钥时由JWT密钥派生开发用主密钥的前缀
+)
+
+// dataKeyRef 已解密的数据加密密钥缓存键
+type dataKeyRef struct {
+	merchantID uint
+	version    int
+}
+
+// KeyManager 数据加密密钥管理，每个商户使用独立的、带版本的数据加密密钥，密钥由主密钥加密保存
+// 密文头部记录加密所用的密钥版本，轮换密钥后由后台协程将旧版本密文重新加密为当前版本。
+type KeyManager struct {
+	db          *gorm.DB
+	cfg         *config.Config
+	masterKey   []byte
+	masterKeyID string
+
+	mu    sync.RWMutex
+	cache map[dataKeyRef][]byte // 数据加密密钥创建后不再修改，解密后的明文可以一直缓存
+
+	interval time.Duration
+	trigger  chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewKeyManager 创建数据加密密钥管理服务，从KMS_MASTER_KEY或KMS_MASTER_KEY_FILE加载主密钥
+// 两者均未配置时，release模式下拒绝启动，其他模式由JWT密钥派生主密钥并输出警告，仅适用于开发环境。
+func NewKeyManager(db *gorm.DB, cfg *config.Config) (*KeyManager, error) {
+	masterKey, err := loadMasterKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(masterKey)
+
+	return &KeyManager{
+		db:          db,
+		cfg:         cfg,
+		masterKey:   masterKey,
+		masterKeyID: hex.EncodeToString(sum[:])[:masterKeyIDHexLength],
+		cache:       make(map[dataKeyRef][]byte),
+		interval:    time.Duration(cfg.KMS.ReencryptInterval) * time.Minute,
+		trigger:     make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}, nil
+}
+
+// loadMasterKey 读取并校验主密钥
+func loadMasterKey(cfg *config.Config) ([]byte, error) {
+	encoded := cfg.KMS.MasterKey
+	if encoded == "" && cfg.KMS.MasterKeyFile != "" {
+		data, err := os.ReadFile(cfg.KMS.MasterKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取主密钥文件失败: %w", err)
+		}
+		if len(data) == dataKeySize {
+			return data, nil
+		}
+		encoded = strings.TrimSpace(string(data))
+	}
+
+	if encoded == "" {
+		if cfg.Server.Mode == "release" {
+			return nil, errors.New("release模式下必须配置KMS_MASTER_KEY或KMS_MASTER_KEY_FILE")
+		}
+		log.Println("警告: 未配置KMS_MASTER_KEY或KMS_MASTER_KEY_FILE，使用由JWT_SECRET派生的主密钥，生产环境请配置独立的主密钥")
+		sum := sha256.Sum256([]byte(masterKeyDerivePrefix + cfg.JWT.Secret))
+		return sum[:], nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("主密钥不是有效的Base64编码: %w", err)
+	}
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("主密钥长度必须为%d字节，实际为%d字节", dataKeySize, len(key))
+	}
+	return key, nil
+}
+
+// Start 启动后台重新加密协程：启动时执行一次，之后按间隔定时执行，轮换密钥后立即执行
+func (m *KeyManager) Start() {
+	go func() {
+		defer close(m.done)
+
+		var tick <-chan time.Time
+		if m.interval > 0 {
+			ticker := time.NewTicker(m.interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		m.reencrypt()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-tick:
+				m.reencrypt()
+			case <-m.trigger:
+				m.reencrypt()
+			}
+		}
+	}()
+}
+
+// Stop 停止后台重新加密协程，进行中的一批规则处理完后退出
+func (m *KeyManager) Stop() {
+	select {
+	case <-m.stop:
+	default:
+		close(m.stop)
+	}
+	<-m.done
+}
+
+// Encrypt 使用商户当前的数据加密密钥加密数据，商户尚无密钥时自动生成第一个版本
+func (m *KeyManager) Encrypt(merchantID uint, plaintext []byte) (string, error) {
+	version, key, err := m.currentKey(merchantID)
+	if err != nil {
+		return "", err
+	}
+	return sealData(merchantID, version, key, plaintext)
+}
+
+// Decrypt 按密文头部的版本选择商户的数据加密密钥解密
+func (m *KeyManager) Decrypt(merchantID uint, ciphertext string) ([]byte, error) {
+	version, payload, ok := parseHeader(ciphertext)
+	if !ok {
+		return nil, errors.New("密文缺少数据加密密钥版本头")
+	}
+
+	key, err := m.key(merchantID, version)
+	if err != nil {
+		return nil, err
+	}
+	return openData(merchantID, version, key, payload)
+}
+
+// EncryptRuleConfig 加密防伪码规则配置
+func (m *KeyManager) EncryptRuleConfig(merchantID uint, ruleConfig interface{}) (string, error) {
+	configJSON, err := json.Marshal(ruleConfig)
+	if err != nil {
+		return "", err
+	}
+	return m.Encrypt(merchantID, configJSON)
+}
+
+// DecryptRuleConfig 解密防伪码规则配置
+func (m *KeyManager) DecryptRuleConfig(merchantID uint, ciphertext string) (*RuleConfig, error) {
+	plaintext, err := m.Decrypt(merchantID, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	var ruleConfig RuleConfig
+	if err := json.Unmarshal(plaintext, &ruleConfig); err != nil {
+		return nil, err
+	}
+	return &ruleConfig, nil
+}
+
+// EncryptSecret 直接使用主密钥加密不属于任何商户的少量敏感数据，如用户的TOTP密钥
+func (m *KeyManager) EncryptSecret(plaintext []byte) (string, error) {
+	return utils.Encrypt(plaintext, m.masterKey)
+}
+
+// DecryptSecret 解密 EncryptSecret 加密的数据
+func (m *KeyManager) DecryptSecret(ciphertext string) ([]byte, error) {
+	return utils.Decrypt(ciphertext, m.masterKey)
+}
+
+// MasterKeyID 返回当前主密钥的指纹
+func (m *KeyManager) MasterKeyID() string {
+	return m.masterKeyID
+}
+
+// Keys 查询商户的全部数据加密密钥版本
+func (m *KeyManager) Keys(merchantID uint) ([]models.DataKey, error) {
+	var keys []models.DataKey
+	err := m.db.Where("merchant_id = ?", merchantID).Order("version desc").Find(&keys).Error
+	return keys, err
+}
+
+// Rotate 为商户生成新版本的数据加密密钥，原密钥转为已轮换状态，并通知后台协程重新加密旧数据
+func (m *KeyManager) Rotate(merchantID uint) (*models.DataKey, error) {
+	var created *models.DataKey
+	err := m.db.Transaction(func(tx *gorm.DB) error {
+		var latest models.DataKey
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("merchant_id = ?", merchantID).Order("version desc").First(&latest).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&models.DataKey{}).
+			Where("merchant_id = ? AND status = ?", merchantID, DataKeyStatusActive).
+			Updates(map[string]interface{}{"status": DataKeyStatusRetired, "retired_at": now}).Error; err != nil {
+			return err
+		}
+
+		created, err = m.createKey(tx, merchantID, latest.Version+1)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+	return created, nil
+}
+
+// ReencryptRules 将全部防伪码规则配置重新加密为所属商户当前版本的密钥，返回重新加密的规则数
+// 更新时以原密文为条件，期间被修改过的规则会跳过，修改时已经使用了当前密钥。
+func (m *KeyManager) ReencryptRules() (int, error) {
+	current := make(map[uint]int)
+	count := 0
+	var lastID uint
+	for {
+		select {
+		case <-m.stop:
+			return count, nil
+		default:
+		}
+
+		var rules []models.SecurityCodeRule
+		if err := m.db.Select("id", "merchant_id", "rule_config").Where("id > ?", lastID).
+			Order("id").Limit(reencryptBatchSize).Find(&rules).Error; err != nil {
+			return count, err
+		}
+		if len(rules) == 0 {
+			return count, nil
+		}
+
+		for _, rule := range rules {
+			lastID = rule.ID
+
+			version, ok := current[rule.MerchantID]
+			if !ok {
+				v, _, err := m.currentKey(rule.MerchantID)
+				if err != nil {
+					return count, err
+				}
+				version, current[rule.MerchantID] = v, v
+			}
+			if v, _, ok := parseHeader(rule.RuleConfig); ok && v == version {
+				continue
+			}
+
+			plaintext, err := m.Decrypt(rule.MerchantID, rule.RuleConfig)
+			if err != nil {
+				log.Printf("规则 %d 的配置解密失败，跳过重新加密: %v", rule.ID, err)
+				continue
+			}
+			ciphertext, err := m.Encrypt(rule.MerchantID, plaintext)
+			if err != nil {
+				return count, err
+			}
+
+			result := m.db.Model(&models.SecurityCodeRule{}).
+				Where("id = ? AND rule_config = ?", rule.ID, rule.RuleConfig).
+				UpdateColumn("rule_config", ciphertext)
+			if result.Error != nil {
+				return count, result.Error
+			}
+			count += int(result.RowsAffected)
+		}
+	}
+}
+
+// MigrateLegacyRules 将引入密钥管理之前直接用JWT密钥加密的规则配置一次性重新加密为商户当前版本的密钥，返回迁移的规则数
+// 启动时在提供服务之前执行，是唯一仍使用JWT密钥解密的地方；所有部署都完成迁移后可以删除。
+func (m *KeyManager) MigrateLegacyRules() (int, error) {
+	count := 0
+	var lastID uint
+	for {
+		var rules []models.SecurityCodeRule
+		if err := m.db.Select("id", "merchant_id", "rule_config").Where("id > ?", lastID).
+			Order("id").Limit(reencryptBatchSize).Find(&rules).Error; err != nil {
+			return count, err
+		}
+		if len(rules) == 0 {
+			return count, nil
+		}
+
+		for _, rule := range rules {
+			lastID = rule.ID
+			if _, _, ok := parseHeader(rule.RuleConfig); ok {
+				continue
+			}
+
+			plaintext, err := utils.Decrypt(rule.RuleConfig, []byte(m.cfg.JWT.Secret))
+			if err != nil {
+				return count, fmt.Errorf("规则 %d 的旧格式配置解密失败: %w", rule.ID, err)
+			}
+			ciphertext, err := m.Encrypt(rule.MerchantID, plaintext)
+			if err != nil {
+				return count, err
+			}
+			result := m.db.Model(&models.SecurityCodeRule{}).
+				Where("id = ? AND rule_config = ?", rule.ID, rule.RuleConfig).
+				UpdateColumn("rule_config", ciphertext)
+			if result.Error != nil {
+				return count, result.Error
+			}
+			count += int(result.RowsAffected)
+		}
+	}
+}
+
+// reencrypt 后台执行一次重新加密并记录结果
+func (m *KeyManager) reencrypt() {
+	count, err := m.ReencryptRules()
+	if err != nil {
+		log.Printf("规则配置重新加密失败: %v", err)
+	}
+	if count > 0 {
+		log.Printf("已将 %d 条规则配置重新加密为当前密钥版本", count)
+	}
+}
+
+// currentKey 返回商户当前的数据加密密钥，商户尚无密钥时生成第一个版本
+func (m *KeyManager) currentKey(merchantID uint) (int, []byte, error) {
+	var record models.DataKey
+	err := m.db.Where("merchant_id = ? AND status = ?", merchantID, DataKeyStatusActive).
+		Order("version desc").First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		created, createErr := m.createKey(m.db, merchantID, 1)
+		if createErr != nil {
+			// 并发创建第一个版本时唯一索引冲突，重新读取另一方创建的密钥
+			if retryErr := m.db.Where("merchant_id = ? AND status = ?", merchantID, DataKeyStatusActive).
+				Order("version desc").First(&record).Error; retryErr != nil {
+				return 0, nil, createErr
+			}
+		} else {
+			record = *created
+		}
+		err = nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	key, err := m.unwrap(&record)
+	if err != nil {
+		return 0, nil, err
+	}
+	return record.Version, key, nil
+}
+
+// key 返回商户指定版本的数据加密密钥
+func (m *KeyManager) key(merchantID uint, version int) ([]byte, error) {
+	ref := dataKeyRef{merchantID: merchantID, version: version}
+	m.mu.RLock()
+	key, ok := m.cache[ref]
+	m.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	var record models.DataKey
+	if err := m.db.Where("merchant_id = ? AND version = ?", merchantID, version).First(&record).Error; err != nil {
+		return nil, i18n.NewError("data_key.not_found", version)
+	}
+	return m.unwrap(&record)
+}
+
+// createKey 生成指定版本的数据加密密钥，用主密钥加密后保存
+func (m *KeyManager) createKey(tx *gorm.DB, merchantID uint, version int) (*models.DataKey, error) {
+	key, err := utils.GenerateRandomKey(dataKeySize)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := utils.Encrypt(key, m.masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	record := models.DataKey{
+		MerchantID:  merchantID,
+		Version:     version,
+		WrappedKey:  wrapped,
+		MasterKeyID: m.masterKeyID,
+		Status:      DataKeyStatusActive,
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.cache[dataKeyRef{merchantID: merchantID, version: version}] = key
+	m.mu.Unlock()
+	return &record, nil
+}
+
+// unwrap 用主密钥解密数据加密密钥并缓存
+func (m *KeyManager) unwrap(record *models.DataKey) ([]byte, error) {
+	ref := dataKeyRef{merchantID: record.MerchantID, version: record.Version}
+	m.mu.RLock()
+	key, ok := m.cache[ref]
+	m.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	if record.MasterKeyID != m.masterKeyID {
+		return nil, fmt.Errorf("商户 %d 的数据加密密钥版本 %d 由其他主密钥（%s）加密，请检查主密钥配置",
+			record.MerchantID, record.Version, record.MasterKeyID)
+	}
+	key, err := utils.Decrypt(record.WrappedKey, m.masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != dataKeySize {
+		return nil, errors.New("数据加密密钥格式错误")
+	}
+
+	m.mu.Lock()
+	m.cache[ref] = key
+	m.mu.Unlock()
+	return key, nil
+}
+
+// sealData 使用AES-256-GCM加密数据并添加版本头，商户ID和版本作为附加认证数据，防止密文被挪用到其他商户
+func sealData(merchantID uint, version int, key, plaintext []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plaintext, associatedData(merchantID, version))
+	return dataKeyHeaderPrefix + strconv.Itoa(version) + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openData 解密sealData生成的密文（不含版本头）
+func openData(merchantID uint, version int, key []byte, payload string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("密文长度错误")
+	}
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, associatedData(merchantID, version))
+}
+
+// newGCM 创建AES-GCM加密器
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// associatedData 返回密文的附加认证数据
+func associatedData(merchantID uint, version int) []byte {
+	return []byte(fmt.Sprintf("merchant:%d:v%d", merchantID, version))
+}
+
+// parseHeader 解析密文的版本头，返回密钥版本和Base64密文
+func parseHeader(ciphertext string) (int, string, bool) {
+	if !strings.HasPrefix(ciphertext, dataKeyHeaderPrefix) {
+		return 0, "", false
+	}
+	versionText, payload, found := strings.Cut(ciphertext[len(dataKeyHeaderPrefix):], ":")
+	if !found {
+		return 0, "", false
+	}
+	version, err := strconv.Atoi(versionText)
+	if err != nil || version <= 0 {
+		return 0, "", false
+	}
+	return version, payload, true
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/models"
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 登录防暴力破解相关的Redis键前缀
+const (
+	loginFailUserPrefix = "afs:login:fail:user:" // 用户名失败次数，完整键为 afs:login:fail:user:<用户名>
+	loginFailIPPrefix   = "afs:login:fail:ip:"   // IP失败次数，完整键为 afs:login:fail:ip:<IP>
+	loginLockUserPrefix = "afs:login:lock:user:" // 用户名锁定标记，过期即解锁
+	loginLockIPPrefix   = "afs:login:lock:ip:"   // IP锁定标记，过期即解锁
+	loginWaitUserPrefix = "afs:login:wait:user:" // 失败后的等待期标记，等待期内不接受该用户名的登录
+)
+
+// loginUsernameMaxLength 计数键中用户名的最大长度，避免超长用户名生成超长的键
+const loginUsernameMaxLength = 100
+
+// LoginGuard 登录防暴力破解，按用户名和IP统计失败次数，失败后逐次延长等待时间，达到上限后临时锁定
+// 计数以提交的用户名为准，不区分用户名是否存在，锁定响应不会暴露用户名是否存在。Redis不可用时放行。
+type LoginGuard struct {
+	redis  *redis.Client
+	cfg    *config.Config
+	audits *AuditService
+}
+
+// NewLoginGuard 创建登录防暴力破解服务
+func NewLoginGuard(redisClient *redis.Client, cfg *config.Config, audits *AuditService) *LoginGuard {
+	return &LoginGuard{redis: redisClient, cfg: cfg, audits: audits}
+}
+
+// Check 登录前检查用户名和IP是否被锁定或仍在等待期内，返回还需等待的时间，0表示可以尝试登录
+func (g *LoginGuard) Check(ctx context.Context, username, ipAddress string) time.Duration {
+	if g.redis == nil {
+		return 0
+	}
+	name := loginUsername(username)
+
+	pipe := g.redis.Pipeline()
+	cmds := []*redis.DurationCmd{
+		pipe.PTTL(ctx, loginLockUserPrefix+name),
+		pipe.PTTL(ctx, loginLockIPPrefix+ipAddress),
+		pipe.PTTL(ctx, loginWaitUserPrefix+name),
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("查询登录锁定状态失败: %v", err)
+		return 0
+	}
+
+	var wait time.Duration
+	for _, cmd := range cmds {
+		if ttl := cmd.Val(); ttl > wait {
+			wait = ttl
+		}
+	}
+	return wait
+}
+
+// Fail 记录一次登录失败：设置逐次翻倍的等待期，用户名或IP失败次数达到上限时锁定并写入审计日志
+// user为nil表示用户名不存在或已禁用，处理方式与密码错误相同。
+func (g *LoginGuard) Fail(ctx context.Context, username, ipAddress string, user *models.User) {
+	if g.redis == nil {
+		return
+	}
+	name := loginUsername(username)
+	window := time.Duration(g.cfg.LoginGuard.Window) * time.Minute
+
+	pipe := g.redis.TxPipeline()
+	userFailures := pipe.Incr(ctx, loginFailUserPrefix+name)
+	pipe.Expire(ctx, loginFailUserPrefix+name, window)
+	ipFailures := pipe.Incr(ctx, loginFailIPPrefix+ipAddress)
+	pipe.Expire(ctx, loginFailIPPrefix+ipAddress, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("记录登录失败次数失败: %v", err)
+		return
+	}
+
+	if delay := g.delay(userFailures.Val()); delay > 0 {
+		g.redis.Set(ctx, loginWaitUserPrefix+name, 1, delay)
+	}
+
+	if max := g.cfg.LoginGuard.MaxFailures; max > 0 && userFailures.Val() >= int64(max) {
+		if g.lock(ctx, loginLockUserPrefix+name, loginFailUserPrefix+name, loginWaitUserPrefix+name) {
+			g.recordLockout(AuditTargetUser, name, ipAddress, user, userFailures.Val())
+		}
+	}
+	if max := g.cfg.LoginGuard.IPMaxFailures; max > 0 && ipFailures.Val() >= int64(max) {
+		if g.lock(ctx, loginLockIPPrefix+ipAddress, loginFailIPPrefix+ipAddress) {
+			g.recordLockout(AuditTargetIP, name, ipAddress, nil, ipFailures.Val())
+		}
+	}
+}
+
+// Succeed 登录成功后清除该用户名的失败次数和等待期，IP的失败次数保留，防止用一个账号掩护对其他账号的尝试
+func (g *LoginGuard) Succeed(ctx context.Context, username string) {
+	if g.redis == nil {
+		return
+	}
+	name := loginUsername(username)
+	if err := g.redis.Del(ctx, loginFailUserPrefix+name, loginWaitUserPrefix+name).Err(); err != nil {
+		log.Printf("清除登录失败次数失败: %v", err)
+	}
+}
+
+// Unlock 解除用户名的锁定，同时清除失败次数和等待期
+func (g *LoginGuard) Unlock(ctx context.Context, username string) error {
+	if g.redis == nil {
+		return nil
+	}
+	name := loginUsername(username)
+	return g.redis.Del(ctx, loginLockUserPrefix+name, loginFailUserPrefix+name, loginWaitUserPrefix+name).Err()
+}
+
+// UnlockIP 解除IP的锁定并清除失败次数
+func (g *LoginGuard) UnlockIP(ctx context.Context, ipAddress string) error {
+	if g.redis == nil {
+		return nil
+	}
+	return g.redis.Del(ctx, loginLockIPPrefix+ipAddress, loginFailIPPrefix+ipAddress).Err()
+}
+
+// Locked 判断用户名当前是否被锁定
+func (g *LoginGuard) Locked(ctx context.Context, username string) bool {
+	if g.redis == nil {
+		return false
+	}
+	count, err := g.redis.Exists(ctx, loginLockUserPrefix+loginUsername(username)).Result()
+	return err == nil && count > 0
+}
+
+// delay 返回第failures次失败后的等待时间，从DelayBase秒开始逐次翻倍，不超过DelayMax秒
+func (g *LoginGuard) delay(failures int64) time.Duration {
+	base := time.Duration(g.cfg.LoginGuard.DelayBase) * time.Second
+	max := time.Duration(g.cfg.LoginGuard.DelayMax) * time.Second
+	if base <= 0 || failures <= 0 {
+		return 0
+	}
+	delay := base
+	for i := int64(1); i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// lock 设置锁定标记并清除计数，返回是否为新的锁定（已锁定时不重复记录）
+func (g *LoginGuard) lock(ctx context.Context, lockKey string, clearKeys ...string) bool {
+	lockout := time.Duration(g.cfg.LoginGuard.Lockout) * time.Minute
+	locked, err := g.redis.SetNX(ctx, lockKey, time.Now().Unix(), lockout).Result()
+	if err != nil {
+		log.Printf("设置登录锁定失败: %v", err)
+		return false
+	}
+	g.redis.Del(ctx, clearKeys...)
+	return locked
+}
+
+// recordLockout 将锁定事件写入审计日志，操作人为系统
+func (g *LoginGuard) recordLockout(targetType, username, ipAddress string, user *models.User, failures int64) {
+	entry := &models.AuditLog{
+		Action:     AuditLoginLockout,
+		TargetType: targetType,
+		IPAddress:  ipAddress,
+	}
+	if user != nil {
+		entry.TargetID = user.ID
+		entry.MerchantID = user.MerchantID
+	}
+	g.audits.Record(entry, map[string]interface{}{
+		"username":        username,
+		"failures":        failures,
+		"lockout_minutes": g.cfg.LoginGuard.Lockout,
+	})
+}
+
+// loginUsername 规范化计数使用的用户名，大小写不同的用户名共用计数
+func loginUsername(username string) string {
+	return truncate(strings.ToLower(strings.TrimSpace(username)), loginUsernameMaxLength)
+}
@@ -0,0 +1,22 @@
+package services
+
+import (
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+
+	"gorm.io/gorm"
+)
+
+// LoadMessageOverrides 将数据库中的商户自定义消息加载到消息目录
+func LoadMessageOverrides(db *gorm.DB) error {
+	var overrides []models.MerchantMessage
+	if err := db.Find(&overrides).Error; err != nil {
+		return err
+	}
+
+	catalog := i18n.Default()
+	for _, o := range overrides {
+		catalog.SetOverride(o.MerchantID, o.Locale, o.MessageKey, o.Text)
+	}
+	return nil
+}
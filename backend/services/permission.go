@@ -0,0 +1,364 @@
+package services
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 权限类型
+const (
+	PermissionTypeMenu   = 1 // 菜单权限
+	PermissionTypeButton = 2 // 按钮权限
+	PermissionTypeData   = 3 // 数据权限
+)
+
+// 权限适用范围，与用户类型一致
+const (
+	PermissionScopePlatform = 1 // 平台
+	PermissionScopeMerchant = 2 // 商户
+)
+
+// 内置角色标识，内置管理员角色拥有对应范围内的全部权限
+const (
+	RoleCodePlatformAdmin = "platform_admin" // 平台超级管理员
+	RoleCodeMerchantAdmin = "merchant_admin" // 商户管理员
+)
+
+// permissionCachePrefix 用户权限缓存键前缀，完整键为 afs:perm:user:<用户ID>
+const permissionCachePrefix = "afs:perm:user:"
+
+// PermissionDef 内置权限定义，启动时写入权限表，已存在的权限不覆盖
+type PermissionDef struct {
+	Code     string
+	Name     string
+	Type     int
+	Children []PermissionDef
+}
+
+// MerchantPermissions 商户端内置权限，菜单权限对应页面和查询接口，按钮权限对应写操作
+var MerchantPermissions = []PermissionDef{
+	{Code: "statistics:view", Name: "数据统计", Type: PermissionTypeMenu},
+	{Code: "product:view", Name: "商品管理", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "product:create", Name: "新建商品", Type: PermissionTypeButton},
+		{Code: "product:update", Name: "修改商品", Type: PermissionTypeButton},
+		{Code: "product:archive", Name: "归档/恢复商品", Type: PermissionTypeButton},
+		{Code: "category:manage", Name: "管理商品分类", Type: PermissionTypeButton},
+	}},
+	{Code: "batch:view", Name: "批次管理", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "batch:create", Name: "新建批次", Type: PermissionTypeButton},
+	}},
+	{Code: "rule:view", Name: "防伪码规则", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "rule:create", Name: "新建规则", Type: PermissionTypeButton},
+		{Code: "rule:update", Name: "修改规则", Type: PermissionTypeButton},
+		{Code: "rule:test", Name: "校验/测试规则", Type: PermissionTypeButton},
+	}},
+	{Code: "code:view", Name: "防伪码管理", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "code:generate", Name: "生成防伪码", Type: PermissionTypeButton},
+		{Code: "code:export", Name: "导出防伪码", Type: PermissionTypeButton},
+		{Code: "code:print", Name: "下载印刷文件", Type: PermissionTypeButton},
+		{Code: "code:status", Name: "批量作废/恢复防伪码", Type: PermissionTypeButton},
+	}},
+	{Code: "trace:view", Name: "溯源管理", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "trace:create", Name: "新增溯源信息", Type: PermissionTypeButton},
+		{Code: "trace:update", Name: "修改溯源信息", Type: PermissionTypeButton},
+		{Code: "trace:delete", Name: "删除溯源信息", Type: PermissionTypeButton},
+		{Code: "trace_schema:manage", Name: "管理阶段模板", Type: PermissionTypeButton},
+		{Code: "trace_root:publish", Name: "发布默克尔根", Type: PermissionTypeButton},
+		{Code: "epcis:export", Name: "导出EPCIS", Type: PermissionTypeButton},
+		{Code: "epcis:import", Name: "导入EPCIS", Type: PermissionTypeButton},
+	}},
+	{Code: "recall:view", Name: "商品召回", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "recall:create", Name: "新建召回", Type: PermissionTypeButton},
+		{Code: "recall:update", Name: "修改召回", Type: PermissionTypeButton},
+		{Code: "recall:open", Name: "启动召回", Type: PermissionTypeButton},
+		{Code: "recall:close", Name: "结束召回", Type: PermissionTypeButton},
+	}},
+	{Code: "import:view", Name: "批量导入", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "import:create", Name: "上传导入文件", Type: PermissionTypeButton},
+		{Code: "import:commit", Name: "确认导入", Type: PermissionTypeButton},
+	}},
+	{Code: "attachment:view", Name: "附件管理", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "attachment:upload", Name: "上传附件", Type: PermissionTypeButton},
+		{Code: "attachment:delete", Name: "删除附件", Type: PermissionTypeButton},
+	}},
+	{Code: "verify:view", Name: "验证记录", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "verify:full_ip", Name: "查看完整IP地址", Type: PermissionTypeData},
+	}},
+	{Code: "webhook:view", Name: "Webhook推送", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "webhook:manage", Name: "管理订阅和重新投递", Type: PermissionTypeButton},
+	}},
+	{Code: "setting:view", Name: "系统设置", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "message:manage", Name: "管理自定义消息", Type: PermissionTypeButton},
+		{Code: "signing_key:manage", Name: "轮换/吊销签名密钥", Type: PermissionTypeButton},
+		{Code: "data_key:manage", Name: "轮换数据加密密钥", Type: PermissionTypeButton},
+	}},
+	{Code: "user:view", Name: "子账号管理", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "user:create", Name: "新建子账号", Type: PermissionTypeButton},
+		{Code: "user:update", Name: "禁用/启用子账号和重置密码", Type: PermissionTypeButton},
+		{Code: "user:assign", Name: "分配角色", Type: PermissionTypeButton},
+	}},
+	{Code: "role:view", Name: "角色管理", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "role:manage", Name: "新建/修改/删除角色", Type: PermissionTypeButton},
+	}},
+}
+
+// PlatformPermissions 平台端内置权限
+var PlatformPermissions = []PermissionDef{
+	{Code: "platform:statistics:view", Name: "平台统计", Type: PermissionTypeMenu},
+	{Code: "platform:merchant:view", Name: "商户管理", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "platform:merchant:create", Name: "新建商户", Type: PermissionTypeButton},
+		{Code: "platform:merchant:update", Name: "修改商户", Type: PermissionTypeButton},
+	}},
+	{Code: "platform:verify:view", Name: "验证记录", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "platform:verify:full_ip", Name: "查看完整IP地址", Type: PermissionTypeData},
+	}},
+	{Code: "platform:archive:view", Name: "验证记录归档", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "platform:archive:manage", Name: "归档/重新导入/释放", Type: PermissionTypeButton},
+	}},
+	{Code: "platform:user:view", Name: "平台账号管理", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "platform:user:create", Name: "新建平台账号", Type: PermissionTypeButton},
+		{Code: "platform:user:update", Name: "禁用/启用平台账号和重置密码", Type: PermissionTypeButton},
+		{Code: "platform:user:delete", Name: "删除平台账号", Type: PermissionTypeButton},
+		{Code: "platform:user:assign", Name: "分配平台角色", Type: PermissionTypeButton},
+	}},
+	{Code: "platform:role:view", Name: "平台角色管理", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "platform:role:manage", Name: "新建/修改/删除平台角色", Type: PermissionTypeButton},
+	}},
+	{Code: "platform:merchant_user:view", Name: "商户账号管理", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "platform:merchant_user:manage", Name: "新建/禁用/删除商户账号、重置密码和分配角色", Type: PermissionTypeButton},
+	}},
+	{Code: "platform:audit:view", Name: "审计日志", Type: PermissionTypeMenu},
+	{Code: "platform:permission:view", Name: "权限目录", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "platform:permission:manage", Name: "新建/修改/删除权限", Type: PermissionTypeButton},
+	}},
+	{Code: "platform:jwt_key:view", Name: "令牌签名密钥", Type: PermissionTypeMenu, Children: []PermissionDef{
+		{Code: "platform:jwt_key:manage", Name: "轮换/吊销令牌签名密钥", Type: PermissionTypeButton},
+	}},
+}
+
+// SeedPermissions 写入内置权限和内置管理员角色
+// 权限表为空（首次启用权限校验）时，为没有任何角色的主账号一次性分配其用户类型的管理员角色：启用权限校验前的用户都是管理员，
+// 补齐角色后行为保持不变。之后的启动不再补齐，新注册的账号和管理员创建的子账号需要显式分配角色。
+func SeedPermissions(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var existing int64
+		if err := tx.Model(&models.Permission{}).Count(&existing).Error; err != nil {
+			return err
+		}
+		backfill := existing == 0
+
+		if err := seedCatalogue(tx); err != nil {
+			return err
+		}
+
+		roles := map[int]*models.Role{
+			PermissionScopePlatform: {Name: "平台超级管理员", Code: RoleCodePlatformAdmin, RoleType: PermissionScopePlatform, Builtin: true},
+			PermissionScopeMerchant: {Name: "商户管理员", Code: RoleCodeMerchantAdmin, RoleType: PermissionScopeMerchant, Builtin: true},
+		}
+		for userType, role := range roles {
+			if err := tx.Omit("Merchant", "Permissions").Where("code = ? AND builtin = ?", role.Code, true).FirstOrCreate(role).Error; err != nil {
+				return err
+			}
+			if !backfill {
+				continue
+			}
+
+			var userIDs []uint
+			if err := tx.Model(&models.User{}).
+				Where("user_type = ? AND created_by IS NULL AND NOT EXISTS (SELECT 1 FROM user_roles WHERE user_roles.user_id = users.id)", userType).
+				Pluck("id", &userIDs).Error; err != nil {
+				return err
+			}
+			for _, userID := range userIDs {
+				if err := tx.Omit(clause.Associations).Create(&models.UserRole{UserID: userID, RoleID: role.ID}).Error; err != nil {
+					return err
+				}
+			}
+			if len(userIDs) > 0 {
+				log.Printf("为 %d 个无角色的用户分配内置角色 %s", len(userIDs), role.Code)
+			}
+		}
+		return nil
+	})
+}
+
+// seedCatalogue 写入平台和商户的内置权限定义，已存在的权限不覆盖
+func seedCatalogue(tx *gorm.DB) error {
+	if err := seedPermissionDefs(tx, PlatformPermissions, PermissionScopePlatform, nil); err != nil {
+		return err
+	}
+	return seedPermissionDefs(tx, MerchantPermissions, PermissionScopeMerchant, nil)
+}
+
+// seedPermissionDefs 递归写入权限定义，已存在的权限保留管理员修改过的名称和层级
+func seedPermissionDefs(tx *gorm.DB, defs []PermissionDef, scope int, parentID *uint) error {
+	for _, def := range defs {
+		permission := models.Permission{Code: def.Code}
+		if err := tx.Where("code = ?", def.Code).
+			Attrs(models.Permission{Name: def.Name, Type: def.Type, Scope: scope, ParentID: parentID}).
+			FirstOrCreate(&permission).Error; err != nil {
+			return err
+		}
+		id := permission.ID
+		if err := seedPermissionDefs(tx, def.Children, scope, &id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PermissionService 用户权限服务，用户的有效权限由其角色汇总并缓存在Redis中
+type PermissionService struct {
+	db    *gorm.DB
+	redis *redis.Client
+	ttl   time.Duration
+}
+
+// NewPermissionService 创建用户权限服务
+func NewPermissionService(db *gorm.DB, redisClient *redis.Client, cfg *config.Config) *PermissionService {
+	return &PermissionService{db: db, redis: redisClient, ttl: time.Duration(cfg.Permission.CacheTTL) * time.Second}
+}
+
+// UserPermissions 返回用户的有效权限码，先读Redis缓存，未命中时从数据库加载并写回
+// Redis不可用时直接查询数据库，不影响权限校验。
+func (s *PermissionService) UserPermissions(ctx context.Context, userID uint) ([]string, error) {
+	key := permissionCachePrefix + fmt.Sprint(userID)
+	if s.redis != nil {
+		if cached, err := s.redis.Get(ctx, key).Result(); err == nil {
+			var codes []string
+			if json.Unmarshal([]byte(cached), &codes) == nil {
+				return codes, nil
+			}
+		} else if !errors.Is(err, redis.Nil) {
+			log.Printf("读取用户 %d 的权限缓存失败: %v", userID, err)
+		}
+	}
+
+	codes, err := s.loadPermissions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.redis != nil && s.ttl > 0 {
+		data, _ := json.Marshal(codes)
+		if err := s.redis.Set(ctx, key, data, s.ttl).Err(); err != nil {
+			log.Printf("写入用户 %d 的权限缓存失败: %v", userID, err)
+		}
+	}
+	return codes, nil
+}
+
+// loadPermissions 从数据库汇总用户各角色的权限，只保留与用户类型范围一致的权限，已禁用的用户返回空
+func (s *PermissionService) loadPermissions(userID uint) ([]string, error) {
+	var user models.User
+	if err := s.db.Select("id", "user_type", "status").First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	// 已禁用的用户没有任何权限
+	if user.Status != 1 {
+		return []string{}, nil
+	}
+
+	roles, err := s.UserRoles(userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.RolePermissions(user.UserType, roles)
+}
+
+// UserRoles 查询用户的全部角色
+func (s *PermissionService) UserRoles(userID uint) ([]models.Role, error) {
+	var roles []models.Role
+	err := s.db.Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).Find(&roles).Error
+	return roles, err
+}
+
+// RolePermissions 汇总角色的权限码，只保留指定用户类型范围内的权限，内置管理员角色拥有该范围的全部权限
+func (s *PermissionService) RolePermissions(userType int, roles []models.Role) ([]string, error) {
+	query := s.db.Model(&models.Permission{}).Where("scope = ?", userType)
+	admin := false
+	roleIDs := make([]uint, 0, len(roles))
+	for _, role := range roles {
+		if isAdminRole(&role) && role.RoleType == userType {
+			admin = true
+		}
+		roleIDs = append(roleIDs, role.ID)
+	}
+	if !admin {
+		if len(roleIDs) == 0 {
+			return []string{}, nil
+		}
+		query = query.Where("id IN (SELECT permission_id FROM role_permissions WHERE role_id IN ?)", roleIDs)
+	}
+
+	var codes []string
+	if err := query.Distinct().Pluck("code", &codes).Error; err != nil {
+		return nil, err
+	}
+	sort.Strings(codes)
+	return codes, nil
+}
+
+// isAdminRole 判断是否为内置管理员角色
+func isAdminRole(role *models.Role) bool {
+	return role.Builtin && (role.Code == RoleCodePlatformAdmin || role.Code == RoleCodeMerchantAdmin)
+}
+
+// Invalidate 清除指定用户的权限缓存，用户角色或状态变化后调用
+func (s *PermissionService) Invalidate(ctx context.Context, userIDs ...uint) {
+	if s.redis == nil || len(userIDs) == 0 {
+		return
+	}
+	keys := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		keys[i] = permissionCachePrefix + fmt.Sprint(id)
+	}
+	if err := s.redis.Del(ctx, keys...).Err(); err != nil {
+		log.Printf("清除用户权限缓存失败: %v", err)
+	}
+}
+
+// InvalidateRole 清除拥有该角色的全部用户的权限缓存，角色权限变化后调用
+func (s *PermissionService) InvalidateRole(ctx context.Context, roleID uint) {
+	var userIDs []uint
+	if err := s.db.Model(&models.UserRole{}).Where("role_id = ?", roleID).Pluck("user_id", &userIDs).Error; err != nil {
+		log.Printf("查询角色 %d 的用户失败: %v", roleID, err)
+		return
+	}
+	s.Invalidate(ctx, userIDs...)
+}
+
+// InvalidateAll 清除全部用户的权限缓存，权限目录变化后调用
+func (s *PermissionService) InvalidateAll(ctx context.Context) {
+	if s.redis == nil {
+		return
+	}
+	iter := s.redis.Scan(ctx, 0, permissionCachePrefix+"*", 500).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		log.Printf("扫描用户权限缓存失败: %v", err)
+	}
+	if len(keys) > 0 {
+		if err := s.redis.Del(ctx, keys...).Err(); err != nil {
+			log.Printf("清除用户权限缓存失败: %v", err)
+		}
+	}
+}
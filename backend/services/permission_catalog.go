@@ -0,0 +1,199 @@
+package services
+
+import (
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// PermissionNode 权限树节点
+type PermissionNode struct {
+	ID       uint              `json:"id"`
+	Code     string            `json:"code"`
+	Name     string            `json:"name"`
+	Type     int               `json:"type"`
+	Granted  bool              `json:"granted,omitempty"` // 当前用户是否拥有该权限，只有上级节点用于展示层级时为false
+	Children []*PermissionNode `json:"children"`
+}
+
+// PermissionInput 新建或修改权限的请求参数
+type PermissionInput struct {
+	Code     string `json:"code" binding:"required,max=100"`
+	Name     string `json:"name" binding:"required,max=50"`
+	Type     int    `json:"type" binding:"required,oneof=1 2 3"` // 1-菜单, 2-按钮, 3-数据
+	Scope    int    `json:"scope" binding:"required,oneof=1 2"`  // 1-平台, 2-商户
+	ParentID *uint  `json:"parent_id"`                           // 上级权限，须与本权限适用范围相同
+}
+
+// Tree 按ParentID构建指定范围的权限树
+// granted为nil时返回完整目录；否则只保留拥有的权限及其上级节点，并标记拥有的权限。
+func (s *PermissionService) Tree(scope int, granted map[string]bool) ([]*PermissionNode, error) {
+	var permissions []models.Permission
+	if err := s.db.Where("scope = ?", scope).Order("id").Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[uint]*PermissionNode, len(permissions))
+	for _, permission := range permissions {
+		nodes[permission.ID] = &PermissionNode{
+			ID:       permission.ID,
+			Code:     permission.Code,
+			Name:     permission.Name,
+			Type:     permission.Type,
+			Granted:  granted[permission.Code],
+			Children: []*PermissionNode{},
+		}
+	}
+
+	roots := []*PermissionNode{}
+	for _, permission := range permissions {
+		node := nodes[permission.ID]
+		if permission.ParentID != nil {
+			if parent, ok := nodes[*permission.ParentID]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+
+	if granted == nil {
+		return roots, nil
+	}
+	return prunePermissionNodes(roots), nil
+}
+
+// prunePermissionNodes 去掉既未拥有、下级也没有拥有权限的节点
+func prunePermissionNodes(nodes []*PermissionNode) []*PermissionNode {
+	kept := []*PermissionNode{}
+	for _, node := range nodes {
+		node.Children = prunePermissionNodes(node.Children)
+		if node.Granted || len(node.Children) > 0 {
+			kept = append(kept, node)
+		}
+	}
+	return kept
+}
+
+// CreatePermission 新建权限并清除全部用户的权限缓存
+func (s *PermissionService) CreatePermission(ctx context.Context, input *PermissionInput) (*models.Permission, error) {
+	permission := models.Permission{}
+	if err := s.applyPermission(&permission, input); err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(&permission).Error; err != nil {
+		return nil, err
+	}
+	s.InvalidateAll(ctx)
+	return &permission, nil
+}
+
+// UpdatePermission 修改权限，内置权限不能修改权限码和适用范围
+func (s *PermissionService) UpdatePermission(ctx context.Context, permission *models.Permission, input *PermissionInput) error {
+	if builtinPermissionCodes()[permission.Code] && (input.Code != permission.Code || input.Scope != permission.Scope) {
+		return i18n.NewError("permission.builtin_readonly")
+	}
+	if input.Scope != permission.Scope {
+		// 修改适用范围时下级权限会与上级范围不一致
+		var count int64
+		if err := s.db.Model(&models.Permission{}).Where("parent_id = ?", permission.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return i18n.NewError("permission.has_children")
+		}
+	}
+	if err := s.applyPermission(permission, input); err != nil {
+		return err
+	}
+	if err := s.db.Save(permission).Error; err != nil {
+		return err
+	}
+	s.InvalidateAll(ctx)
+	return nil
+}
+
+// DeletePermission 删除权限及其角色授权，内置权限和有下级的权限不能删除
+func (s *PermissionService) DeletePermission(ctx context.Context, permission *models.Permission) error {
+	if builtinPermissionCodes()[permission.Code] {
+		return i18n.NewError("permission.builtin_readonly")
+	}
+	var count int64
+	if err := s.db.Model(&models.Permission{}).Where("parent_id = ?", permission.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return i18n.NewError("permission.has_children")
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM role_permissions WHERE permission_id = ?", permission.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(permission).Error
+	})
+	if err != nil {
+		return err
+	}
+	s.InvalidateAll(ctx)
+	return nil
+}
+
+// SeedCatalogue 补齐缺失的内置权限，已存在的权限保持不变
+func (s *PermissionService) SeedCatalogue(ctx context.Context) error {
+	if err := s.db.Transaction(seedCatalogue); err != nil {
+		return err
+	}
+	s.InvalidateAll(ctx)
+	return nil
+}
+
+// applyPermission 校验权限码唯一和上级权限有效并写入权限字段
+func (s *PermissionService) applyPermission(permission *models.Permission, input *PermissionInput) error {
+	var count int64
+	if err := s.db.Model(&models.Permission{}).Where("code = ? AND id <> ?", input.Code, permission.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return i18n.NewError("permission.code_exists", input.Code)
+	}
+
+	// 上级权限须为同一范围，且不能是自身或自身的下级
+	seen := map[uint]bool{permission.ID: permission.ID != 0}
+	for parentID := input.ParentID; parentID != nil; {
+		if seen[*parentID] {
+			return i18n.NewError("permission.parent_invalid")
+		}
+		seen[*parentID] = true
+		var parent models.Permission
+		if err := s.db.First(&parent, *parentID).Error; err != nil || parent.Scope != input.Scope {
+			return i18n.NewError("permission.parent_invalid")
+		}
+		parentID = parent.ParentID
+	}
+
+	permission.Code = input.Code
+	permission.Name = input.Name
+	permission.Type = input.Type
+	permission.Scope = input.Scope
+	permission.ParentID = input.ParentID
+	return nil
+}
+
+// builtinPermissionCodes 返回全部内置权限码，接口权限校验依赖这些权限码
+func builtinPermissionCodes() map[string]bool {
+	codes := make(map[string]bool)
+	var collect func(defs []PermissionDef)
+	collect = func(defs []PermissionDef) {
+		for _, def := range defs {
+			codes[def.Code] = true
+			collect(def.Children)
+		}
+	}
+	collect(PlatformPermissions)
+	collect(MerchantPermissions)
+	return codes
+}
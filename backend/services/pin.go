@@ -0,0 +1,82 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math/big"
+
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+)
+
+// PIN码字符集
+const (
+	PinCharsetNumeric      = "numeric"      // 纯数字
+	PinCharsetAlphanumeric = "alphanumeric" // 数字和大写字母（去除易混淆字符）
+)
+
+const (
+	pinDigits       = "0123456789"
+	pinAlphanumeric = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+)
+
+// GeneratePin 按配置生成一个随机刮开PIN码
+func GeneratePin(cfg *PinConfig) (string, error) {
+	charset := pinDigits
+	if cfg.Charset == PinCharsetAlphanumeric {
+		charset = pinAlphanumeric
+	}
+
+	max := big.NewInt(int64(len(charset)))
+	pin := make([]byte, cfg.Length)
+	for i := range pin {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		pin[i] = charset[n.Int64()]
+	}
+	return string(pin), nil
+}
+
+// HashPin 计算PIN码哈希：HMAC-SHA256(pepper, code + ":" + pin)
+// 以防伪码作为盐，数据库泄露时无法通过彩虹表批量还原PIN。
+func HashPin(code, pin, pepper string) string {
+	mac := hmac.New(sha256.New, []byte(pepper))
+	mac.Write([]byte(code + ":" + pin))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CheckPinPepper 启动时检查PIN哈希密钥，release模式下未配置或仍为默认值时拒绝启动
+// 默认密钥随源码公开，数据库泄露后可以直接离线穷举PIN码。
+func CheckPinPepper(cfg *config.Config) error {
+	if cfg.Code.PinPepper != "" && cfg.Code.PinPepper != config.DefaultPinPepper {
+		return nil
+	}
+	if cfg.Server.Mode == "release" {
+		return errors.New("release模式下必须配置CODE_PIN_PEPPER，且不能使用默认值")
+	}
+	log.Println("警告: 未配置CODE_PIN_PEPPER，使用默认的PIN哈希密钥，生产环境请配置独立的密钥")
+	return nil
+}
+
+// CheckPin 校验PIN码是否与哈希匹配
+func CheckPin(code, pin, pepper, pinHash string) bool {
+	expected := HashPin(code, pin, pepper)
+	return hmac.Equal([]byte(expected), []byte(pinHash))
+}
+
+// ValidatePinConfig 校验PIN配置
+func ValidatePinConfig(cfg *PinConfig) error {
+	if cfg.Length < 4 || cfg.Length > 12 {
+		return i18n.NewError("rule.pin_length_invalid")
+	}
+	if cfg.Charset != "" && cfg.Charset != PinCharsetNumeric && cfg.Charset != PinCharsetAlphanumeric {
+		return i18n.NewError("rule.pin_charset_invalid", cfg.Charset)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"anti-fake-system/config"
+	"context"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// PIN码防暴力破解相关的Redis键前缀
+const (
+	pinFailCodePrefix = "afs:pin:fail:code:" // 防伪码PIN错误次数，完整键为 afs:pin:fail:code:<防伪码>
+	pinFailIPPrefix   = "afs:pin:fail:ip:"   // IP的PIN错误次数，完整键为 afs:pin:fail:ip:<IP>
+	pinLockCodePrefix = "afs:pin:lock:code:" // 防伪码锁定标记，过期即解锁
+	pinLockIPPrefix   = "afs:pin:lock:ip:"   // IP锁定标记，过期即解锁
+)
+
+// PinGuard 刮开PIN码防暴力破解，按防伪码和IP统计PIN错误次数，达到上限后在锁定期内不再校验PIN
+// 最短4位的数字PIN只有一万种组合，没有次数限制时可在短时间内穷举。Redis不可用时放行。
+type PinGuard struct {
+	redis *redis.Client
+	cfg   *config.Config
+}
+
+// NewPinGuard 创建PIN码防暴力破解服务
+func NewPinGuard(redisClient *redis.Client, cfg *config.Config) *PinGuard {
+	return &PinGuard{redis: redisClient, cfg: cfg}
+}
+
+// Check 校验PIN前检查防伪码和IP是否被锁定，返回剩余锁定时间，0表示可以校验
+func (g *PinGuard) Check(ctx context.Context, code, ipAddress string) time.Duration {
+	if g == nil || g.redis == nil {
+		return 0
+	}
+
+	pipe := g.redis.Pipeline()
+	codeTTL := pipe.PTTL(ctx, pinLockCodePrefix+code)
+	ipTTL := pipe.PTTL(ctx, pinLockIPPrefix+ipAddress)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("查询PIN锁定状态失败: %v", err)
+		return 0
+	}
+
+	wait := codeTTL.Val()
+	if ttl := ipTTL.Val(); ttl > wait {
+		wait = ttl
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Fail 记录一次PIN错误，防伪码或IP的错误次数达到上限时锁定，返回是否因此被锁定
+func (g *PinGuard) Fail(ctx context.Context, code, ipAddress string) bool {
+	if g == nil || g.redis == nil {
+		return false
+	}
+	window := time.Duration(g.cfg.Code.PinFailureWindow) * time.Minute
+
+	pipe := g.redis.TxPipeline()
+	codeFailures := pipe.Incr(ctx, pinFailCodePrefix+code)
+	pipe.Expire(ctx, pinFailCodePrefix+code, window)
+	ipFailures := pipe.Incr(ctx, pinFailIPPrefix+ipAddress)
+	pipe.Expire(ctx, pinFailIPPrefix+ipAddress, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("记录PIN错误次数失败: %v", err)
+		return false
+	}
+
+	locked := false
+	if max := g.cfg.Code.PinMaxFailures; max > 0 && codeFailures.Val() >= int64(max) {
+		locked = g.lock(ctx, pinLockCodePrefix+code, pinFailCodePrefix+code) || locked
+	}
+	if max := g.cfg.Code.PinIPMaxFailures; max > 0 && ipFailures.Val() >= int64(max) {
+		locked = g.lock(ctx, pinLockIPPrefix+ipAddress, pinFailIPPrefix+ipAddress) || locked
+	}
+	return locked
+}
+
+// Succeed PIN校验通过后清除该防伪码的错误次数，IP的错误次数保留
+func (g *PinGuard) Succeed(ctx context.Context, code string) {
+	if g == nil || g.redis == nil {
+		return
+	}
+	if err := g.redis.Del(ctx, pinFailCodePrefix+code).Err(); err != nil {
+		log.Printf("清除PIN错误次数失败: %v", err)
+	}
+}
+
+// lock 设置锁定标记并清除计数，返回是否为新的锁定
+func (g *PinGuard) lock(ctx context.Context, lockKey, failKey string) bool {
+	lockout := time.Duration(g.cfg.Code.PinLockout) * time.Minute
+	locked, err := g.redis.SetNX(ctx, lockKey, time.Now().Unix(), lockout).Result()
+	if err != nil {
+		log.Printf("设置PIN锁定失败: %v", err)
+		return false
+	}
+	g.redis.Del(ctx, failKey)
+	return locked
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestHashPin(t *testing.T) {
+	// HMAC-SHA256("pepper", "AF123456:1234")
+	const want = "ff5e93ae3b06602fcd753fa9441a996f3e97f6bea0ee7f41a63f036e2c61be23"
+	if got := HashPin("AF123456", "1234", "pepper"); got != want {
+		t.Errorf("HashPin() = %s, want %s", got, want)
+	}
+
+	base := HashPin("AF123456", "1234", "pepper")
+	tests := []struct {
+		name              string
+		code, pin, pepper string
+	}{
+		{"different code", "AF123457", "1234", "pepper"},
+		{"different pin", "AF123456", "1235", "pepper"},
+		{"different pepper", "AF123456", "1234", "pepper2"},
+		{"separator shift", "AF123456:1", "234", "pepper"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if HashPin(tt.code, tt.pin, tt.pepper) == base {
+				t.Error("HashPin() should differ from the base hash")
+			}
+		})
+	}
+}
+
+func TestCheckPin(t *testing.T) {
+	hash := HashPin("AF123456", "ABCD2345", "pepper")
+	tests := []struct {
+		name   string
+		code   string
+		pin    string
+		pepper string
+		hash   string
+		want   bool
+	}{
+		{"match", "AF123456", "ABCD2345", "pepper", hash, true},
+		{"wrong pin", "AF123456", "ABCD2346", "pepper", hash, false},
+		{"pin is case sensitive", "AF123456", "abcd2345", "pepper", hash, false},
+		{"other code", "AF654321", "ABCD2345", "pepper", hash, false},
+		{"wrong pepper", "AF123456", "ABCD2345", "other", hash, false},
+		{"empty hash", "AF123456", "ABCD2345", "pepper", "", false},
+		{"truncated hash", "AF123456", "ABCD2345", "pepper", hash[:32], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CheckPin(tt.code, tt.pin, tt.pepper, tt.hash); got != tt.want {
+				t.Errorf("CheckPin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeneratePin(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     PinConfig
+		charset string
+	}{
+		{"default numeric", PinConfig{Length: 4}, pinDigits},
+		{"numeric", PinConfig{Length: 8, Charset: PinCharsetNumeric}, pinDigits},
+		{"alphanumeric", PinConfig{Length: 12, Charset: PinCharsetAlphanumeric}, pinAlphanumeric},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 50; i++ {
+				pin, err := GeneratePin(&tt.cfg)
+				if err != nil {
+					t.Fatalf("GeneratePin() error: %v", err)
+				}
+				if len(pin) != tt.cfg.Length {
+					t.Fatalf("GeneratePin() = %q, want length %d", pin, tt.cfg.Length)
+				}
+				for _, c := range pin {
+					if !strings.ContainsRune(tt.charset, c) {
+						t.Fatalf("GeneratePin() = %q, contains %q outside charset", pin, c)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestValidatePinConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     PinConfig
+		wantKey string
+	}{
+		{"min length", PinConfig{Length: 4}, ""},
+		{"max length", PinConfig{Length: 12, Charset: PinCharsetAlphanumeric}, ""},
+		{"numeric", PinConfig{Length: 6, Charset: PinCharsetNumeric}, ""},
+		{"too short", PinConfig{Length: 3}, "rule.pin_length_invalid"},
+		{"too long", PinConfig{Length: 13}, "rule.pin_length_invalid"},
+		{"zero length", PinConfig{}, "rule.pin_length_invalid"},
+		{"unknown charset", PinConfig{Length: 6, Charset: "hex"}, "rule.pin_charset_invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePinConfig(&tt.cfg)
+			if tt.wantKey == "" {
+				if err != nil {
+					t.Errorf("ValidatePinConfig() error = %v, want nil", err)
+				}
+				return
+			}
+			if key, _ := i18n.ErrorKey(err, ""); key != tt.wantKey {
+				t.Errorf("ValidatePinConfig() error key = %q, want %q", key, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestPinGuardWithoutRedis(t *testing.T) {
+	// Redis不可用时放行，不记录也不锁定
+	guard := NewPinGuard(nil, &config.Config{})
+	ctx := context.Background()
+	if wait := guard.Check(ctx, "AF123456", "127.0.0.1"); wait != 0 {
+		t.Errorf("Check() = %v, want 0", wait)
+	}
+	if guard.Fail(ctx, "AF123456", "127.0.0.1") {
+		t.Error("Fail() = true, want false")
+	}
+	guard.Succeed(ctx, "AF123456")
+
+	var nilGuard *PinGuard
+	if wait := nilGuard.Check(ctx, "AF123456", "127.0.0.1"); wait != 0 {
+		t.Errorf("nil guard Check() = %v, want 0", wait)
+	}
+}
+
+func TestCheckPinPepper(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		pepper  string
+		wantErr bool
+	}{
+		{"release with custom pepper", "release", "custom-pepper", false},
+		{"release with default pepper", "release", config.DefaultPinPepper, true},
+		{"release with empty pepper", "release", "", true},
+		{"debug with default pepper", "debug", config.DefaultPinPepper, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Server.Mode = tt.mode
+			cfg.Code.PinPepper = tt.pepper
+			if err := CheckPinPepper(cfg); (err != nil) != tt.wantErr {
+				t.Errorf("CheckPinPepper() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
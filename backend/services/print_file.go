@@ -0,0 +1,103 @@
+package services
+
+import (
+	"anti-fake-system/config"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PrintFileTempSuffix 印刷文件写入过程中使用的临时文件后缀
+const PrintFileTempSuffix = ".tmp"
+
+// printJanitorInterval 印刷文件清理间隔
+const printJanitorInterval = 10 * time.Minute
+
+// PrintFileJanitor 定期删除超过保留时长的印刷文件（含PIN明文）和写入中断遗留的临时文件
+type PrintFileJanitor struct {
+	dir  string
+	ttl  time.Duration
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewPrintFileJanitor 创建印刷文件清理器
+func NewPrintFileJanitor(cfg *config.Config) *PrintFileJanitor {
+	return &PrintFileJanitor{
+		dir:  cfg.Code.PrintDir,
+		ttl:  time.Duration(cfg.Code.PrintTTL) * time.Hour,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Start 启动清理协程，启动时先清理一次，保留时长为0时不自动清理
+func (j *PrintFileJanitor) Start() {
+	if j.ttl <= 0 {
+		close(j.done)
+		return
+	}
+
+	go func() {
+		defer close(j.done)
+
+		j.Sweep()
+
+		ticker := time.NewTicker(printJanitorInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-j.stop:
+				return
+			case <-ticker.C:
+				j.Sweep()
+			}
+		}
+	}()
+}
+
+// Stop 停止清理协程
+func (j *PrintFileJanitor) Stop() {
+	select {
+	case <-j.stop:
+	default:
+		close(j.stop)
+	}
+	<-j.done
+}
+
+// Sweep 删除修改时间早于保留时长的印刷文件和临时文件，返回删除的文件数
+func (j *PrintFileJanitor) Sweep() int {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("印刷文件目录读取失败: %v", err)
+		}
+		return 0
+	}
+
+	cutoff := time.Now().Add(-j.ttl)
+	removed := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || (!strings.HasSuffix(name, ".csv") && !strings.HasSuffix(name, ".csv"+PrintFileTempSuffix)) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(j.dir, name)); err != nil {
+			log.Printf("印刷文件删除失败: %v", err)
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("已清理%d个过期印刷文件", removed)
+	}
+	return removed
+}
@@ -0,0 +1,519 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+
+	"gorm.io/gorm"
+)
+
+// 商品状态
+const (
+	ProductStatusDisabled = 0 // 禁用
+	ProductStatusEnabled  = 1 // 启用
+	ProductStatusArchived = 2 // 已归档
+)
+
+// 商品变更历史动作
+const (
+	ProductActionCreate  = "create"  // 创建
+	ProductActionUpdate  = "update"  // 修改
+	ProductActionArchive = "archive" // 归档
+	ProductActionRestore = "restore" // 恢复
+	ProductActionImport  = "import"  // 批量导入
+)
+
+// maxCategoryDepth 商品分类的最大层级
+const maxCategoryDepth = 5
+
+// categoryPathSeparator 分类路径的分隔符
+const categoryPathSeparator = "/"
+
+// ProductInput 创建/修改商品的请求，只接受白名单字段
+type ProductInput struct {
+	Name        string   `json:"name" binding:"required,max=200"`      // 商品名称
+	SKU         string   `json:"sku" binding:"max=64"`                 // 商户内部货号
+	GTIN        string   `json:"gtin" binding:"max=14"`                // GS1商品条码
+	Spec        string   `json:"spec" binding:"max=200"`               // 规格
+	Model       string   `json:"model" binding:"max=100"`              // 型号
+	CategoryID  *uint    `json:"category_id"`                          // 商品分类ID
+	Description string   `json:"description"`                          // 商品描述
+	Images      []string `json:"images"`                               // 商品图片URL
+	Status      *int     `json:"status" binding:"omitempty,oneof=0 1"` // 启用或禁用，归档请使用归档接口
+}
+
+// FieldChange 字段变更的新旧值
+type FieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// CategoryNode 商品分类树的节点
+type CategoryNode struct {
+	models.ProductCategory
+	Path         string          `json:"path"`          // 完整路径
+	ProductCount int64           `json:"product_count"` // 直接属于该分类的商品数
+	Children     []*CategoryNode `json:"children"`
+}
+
+// ProductService 商品服务，负责字段校验、分类路径和变更历史
+type ProductService struct {
+	db *gorm.DB
+}
+
+// NewProductService 创建商品服务
+func NewProductService(db *gorm.DB) *ProductService {
+	return &ProductService{db: db}
+}
+
+// Create 创建商品并记录历史
+func (s *ProductService) Create(merchantID, userID uint, input *ProductInput) (*models.Product, error) {
+	product := models.Product{MerchantID: merchantID, Status: ProductStatusEnabled}
+	if err := s.apply(&product, input); err != nil {
+		return nil, err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&product).Error; err != nil {
+			return err
+		}
+		return RecordProductHistory(tx, &product, ProductActionCreate, productChanges(&models.Product{}, &product), userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// Update 修改商品，只有字段发生变化时才记录历史，已归档的商品需先恢复
+func (s *ProductService) Update(product *models.Product, userID uint, input *ProductInput) (map[string]FieldChange, error) {
+	if product.Status == ProductStatusArchived {
+		return nil, i18n.NewError("product.archived")
+	}
+
+	before := *product
+	if err := s.apply(product, input); err != nil {
+		return nil, err
+	}
+	changes := productChanges(&before, product)
+	if len(changes) == 0 {
+		return changes, nil
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Merchant").Save(product).Error; err != nil {
+			return err
+		}
+		return RecordProductHistory(tx, product, ProductActionUpdate, changes, userID)
+	})
+	return changes, err
+}
+
+// Archive 归档商品，归档后不能新建批次，已有批次和防伪码不受影响
+func (s *ProductService) Archive(product *models.Product, userID uint) error {
+	if product.Status == ProductStatusArchived {
+		return i18n.NewError("product.already_archived")
+	}
+
+	now := time.Now()
+	changes := map[string]FieldChange{"status": {Old: product.Status, New: ProductStatusArchived}}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(product).Updates(map[string]interface{}{
+			"status":      ProductStatusArchived,
+			"archived_at": &now,
+		}).Error; err != nil {
+			return err
+		}
+		return RecordProductHistory(tx, product, ProductActionArchive, changes, userID)
+	})
+}
+
+// Restore 恢复已归档的商品，恢复后为启用状态
+func (s *ProductService) Restore(product *models.Product, userID uint) error {
+	if product.Status != ProductStatusArchived {
+		return i18n.NewError("product.not_archived")
+	}
+
+	changes := map[string]FieldChange{"status": {Old: ProductStatusArchived, New: ProductStatusEnabled}}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(product).Updates(map[string]interface{}{
+			"status":      ProductStatusEnabled,
+			"archived_at": nil,
+		}).Error; err != nil {
+			return err
+		}
+		return RecordProductHistory(tx, product, ProductActionRestore, changes, userID)
+	})
+}
+
+// apply 校验请求并写入商品字段
+func (s *ProductService) apply(product *models.Product, input *ProductInput) error {
+	input.Name = strings.TrimSpace(input.Name)
+	input.SKU = strings.TrimSpace(input.SKU)
+	input.GTIN = strings.TrimSpace(input.GTIN)
+	if input.Name == "" {
+		return i18n.NewError("product.name_required")
+	}
+	if input.GTIN != "" && !ValidGTIN(input.GTIN) {
+		return i18n.NewError("product.gtin_invalid", input.GTIN)
+	}
+	if err := s.checkUnique(product.MerchantID, product.ID, "sku", input.SKU, "product.sku_exists"); err != nil {
+		return err
+	}
+	if err := s.checkUnique(product.MerchantID, product.ID, "gtin", input.GTIN, "product.gtin_exists"); err != nil {
+		return err
+	}
+
+	category := ""
+	if input.CategoryID != nil {
+		paths, err := CategoryPaths(s.db, product.MerchantID)
+		if err != nil {
+			return err
+		}
+		path, ok := paths[*input.CategoryID]
+		if !ok {
+			return i18n.NewError("product_category.not_found")
+		}
+		category = path
+	}
+
+	images := input.Images
+	if images == nil {
+		images = []string{}
+	}
+	imagesJSON, _ := json.Marshal(images)
+
+	product.Name = input.Name
+	product.SKU = input.SKU
+	product.GTIN = input.GTIN
+	product.Spec = input.Spec
+	product.Model = input.Model
+	product.CategoryID = input.CategoryID
+	product.Category = category
+	product.Description = input.Description
+	product.Images = string(imagesJSON)
+	if input.Status != nil {
+		product.Status = *input.Status
+	}
+	return nil
+}
+
+// checkUnique 校验字段在商户内唯一，空值不校验
+func (s *ProductService) checkUnique(merchantID, productID uint, column, value, key string) error {
+	if value == "" {
+		return nil
+	}
+	var count int64
+	if err := s.db.Model(&models.Product{}).
+		Where("merchant_id = ? AND id <> ? AND "+column+" = ?", merchantID, productID, value).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return i18n.NewError(key, value)
+	}
+	return nil
+}
+
+// RecordProductHistory 写入一条商品变更历史
+func RecordProductHistory(tx *gorm.DB, product *models.Product, action string, changes map[string]FieldChange, userID uint) error {
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&models.ProductHistory{
+		ProductID:  product.ID,
+		MerchantID: product.MerchantID,
+		Action:     action,
+		Changes:    string(data),
+		CreatedBy:  userID,
+	}).Error
+}
+
+// productChanges 比较商品的可编辑字段，返回有变化的字段
+func productChanges(before, after *models.Product) map[string]FieldChange {
+	fields := []struct {
+		key      string
+		old, new interface{}
+	}{
+		{"name", before.Name, after.Name},
+		{"sku", before.SKU, after.SKU},
+		{"gtin", before.GTIN, after.GTIN},
+		{"spec", before.Spec, after.Spec},
+		{"model", before.Model, after.Model},
+		{"category_id", before.CategoryID, after.CategoryID},
+		{"category", before.Category, after.Category},
+		{"description", before.Description, after.Description},
+		{"images", before.Images, after.Images},
+		{"status", before.Status, after.Status},
+	}
+
+	changes := make(map[string]FieldChange)
+	for _, field := range fields {
+		old, new := derefValue(field.old), derefValue(field.new)
+		if !reflect.DeepEqual(old, new) {
+			changes[field.key] = FieldChange{Old: old, New: new}
+		}
+	}
+	return changes
+}
+
+// derefValue 将可为空的ID转换为值，便于比较和输出
+func derefValue(v interface{}) interface{} {
+	if id, ok := v.(*uint); ok {
+		if id == nil {
+			return nil
+		}
+		return *id
+	}
+	return v
+}
+
+// ValidGTIN 校验GTIN-8/12/13/14的长度和GS1校验位
+func ValidGTIN(gtin string) bool {
+	switch len(gtin) {
+	case 8, 12, 13, 14:
+	default:
+		return false
+	}
+
+	sum := 0
+	for i := len(gtin) - 2; i >= 0; i-- {
+		d := gtin[i]
+		if d < '0' || d > '9' {
+			return false
+		}
+		// 从校验位左侧第一位开始，奇数位权重为3
+		weight := 1
+		if (len(gtin)-2-i)%2 == 0 {
+			weight = 3
+		}
+		sum += int(d-'0') * weight
+	}
+	check := gtin[len(gtin)-1]
+	return check >= '0' && check <= '9' && int(check-'0') == (10-sum%10)%10
+}
+
+// CategoryTree 构建商户的商品分类树，附带每个分类的商品数
+func (s *ProductService) CategoryTree(merchantID uint) ([]*CategoryNode, error) {
+	var categories []models.ProductCategory
+	if err := s.db.Where("merchant_id = ?", merchantID).Order("sort asc, id asc").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		CategoryID uint
+		Count      int64
+	}
+	if err := s.db.Model(&models.Product{}).Select("category_id, COUNT(*) AS count").
+		Where("merchant_id = ? AND category_id IS NOT NULL", merchantID).
+		Group("category_id").Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	countMap := make(map[uint]int64, len(counts))
+	for _, c := range counts {
+		countMap[c.CategoryID] = c.Count
+	}
+
+	paths := categoryPaths(categories)
+	nodes := make(map[uint]*CategoryNode, len(categories))
+	for _, category := range categories {
+		nodes[category.ID] = &CategoryNode{
+			ProductCategory: category,
+			Path:            paths[category.ID],
+			ProductCount:    countMap[category.ID],
+			Children:        []*CategoryNode{},
+		}
+	}
+
+	roots := []*CategoryNode{}
+	for _, category := range categories {
+		node := nodes[category.ID]
+		if category.ParentID != nil {
+			if parent, ok := nodes[*category.ParentID]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+	return roots, nil
+}
+
+// SaveCategory 创建或修改商品分类，修改名称或上级后同步更新相关商品的分类路径
+func (s *ProductService) SaveCategory(category *models.ProductCategory) error {
+	category.Name = strings.TrimSpace(category.Name)
+	if category.Name == "" || strings.Contains(category.Name, categoryPathSeparator) {
+		return i18n.NewError("product_category.name_invalid")
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var categories []models.ProductCategory
+		if err := tx.Where("merchant_id = ?", category.MerchantID).Find(&categories).Error; err != nil {
+			return err
+		}
+		byID := make(map[uint]*models.ProductCategory, len(categories))
+		for i := range categories {
+			byID[categories[i].ID] = &categories[i]
+		}
+
+		if category.ParentID != nil {
+			if _, ok := byID[*category.ParentID]; !ok {
+				return i18n.NewError("product_category.parent_not_found")
+			}
+			// 上级不能是自身或自身的下级
+			depth := 1
+			for id := category.ParentID; id != nil; {
+				parent, ok := byID[*id]
+				if !ok {
+					break
+				}
+				if category.ID != 0 && parent.ID == category.ID {
+					return i18n.NewError("product_category.parent_invalid")
+				}
+				depth++
+				id = parent.ParentID
+			}
+			if depth+categorySubtreeHeight(categories, category.ID) > maxCategoryDepth {
+				return i18n.NewError("product_category.too_deep", maxCategoryDepth)
+			}
+		} else if 1+categorySubtreeHeight(categories, category.ID) > maxCategoryDepth {
+			return i18n.NewError("product_category.too_deep", maxCategoryDepth)
+		}
+
+		for _, sibling := range categories {
+			if sibling.ID != category.ID && sibling.Name == category.Name && sameParent(sibling.ParentID, category.ParentID) {
+				return i18n.NewError("product_category.name_exists", category.Name)
+			}
+		}
+
+		if err := tx.Save(category).Error; err != nil {
+			return err
+		}
+		if existing, ok := byID[category.ID]; ok {
+			*existing = *category
+		} else {
+			categories = append(categories, *category)
+		}
+		return syncProductCategories(tx, category.MerchantID, categoryPaths(categories))
+	})
+}
+
+// DeleteCategory 删除商品分类，有下级分类或商品时不能删除
+func (s *ProductService) DeleteCategory(category *models.ProductCategory) error {
+	var count int64
+	if err := s.db.Model(&models.ProductCategory{}).Where("parent_id = ?", category.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return i18n.NewError("product_category.has_children")
+	}
+	if err := s.db.Model(&models.Product{}).Where("category_id = ?", category.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return i18n.NewError("product_category.in_use", count)
+	}
+	return s.db.Delete(category).Error
+}
+
+// CategoryDescendants 返回分类及其全部下级分类的ID
+func (s *ProductService) CategoryDescendants(merchantID, categoryID uint) ([]uint, error) {
+	var categories []models.ProductCategory
+	if err := s.db.Select("id", "parent_id").Where("merchant_id = ?", merchantID).Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	ids := []uint{categoryID}
+	for i := 0; i < len(ids); i++ {
+		for _, category := range categories {
+			if category.ParentID != nil && *category.ParentID == ids[i] {
+				ids = append(ids, category.ID)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// CategoryPaths 返回商户全部分类ID到完整路径的映射
+func CategoryPaths(db *gorm.DB, merchantID uint) (map[uint]string, error) {
+	var categories []models.ProductCategory
+	if err := db.Where("merchant_id = ?", merchantID).Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	return categoryPaths(categories), nil
+}
+
+// categoryPaths 由分类列表计算每个分类的完整路径
+func categoryPaths(categories []models.ProductCategory) map[uint]string {
+	byID := make(map[uint]models.ProductCategory, len(categories))
+	for _, category := range categories {
+		byID[category.ID] = category
+	}
+
+	paths := make(map[uint]string, len(categories))
+	for _, category := range categories {
+		var names []string
+		current, ok := category, true
+		for ok && len(names) <= maxCategoryDepth {
+			names = append(names, current.Name)
+			if current.ParentID == nil {
+				break
+			}
+			current, ok = byID[*current.ParentID]
+		}
+		for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+			names[i], names[j] = names[j], names[i]
+		}
+		paths[category.ID] = strings.Join(names, categoryPathSeparator)
+	}
+	return paths
+}
+
+// categorySubtreeHeight 计算以分类为根的子树高度（不含自身），新建分类为0
+func categorySubtreeHeight(categories []models.ProductCategory, id uint) int {
+	if id == 0 {
+		return 0
+	}
+	height := 0
+	for _, category := range categories {
+		if category.ParentID != nil && *category.ParentID == id {
+			if h := 1 + categorySubtreeHeight(categories, category.ID); h > height {
+				height = h
+			}
+		}
+	}
+	return height
+}
+
+// syncProductCategories 按分类路径更新商品的类别文本
+func syncProductCategories(tx *gorm.DB, merchantID uint, paths map[uint]string) error {
+	ids := make([]uint, 0, len(paths))
+	for id := range paths {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		if err := tx.Model(&models.Product{}).
+			Where("merchant_id = ? AND category_id = ? AND category <> ?", merchantID, id, paths[id]).
+			Update("category", paths[id]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameParent 判断两个分类的上级是否相同
+func sameParent(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
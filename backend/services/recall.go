@@ -0,0 +1,305 @@
+package services
+
+import (
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 防伪码状态
+const (
+	CodeStatusVoid     = 0 // 作废
+	CodeStatusNormal   = 1 // 正常
+	CodeStatusRecalled = 2 // 已召回
+)
+
+// 召回状态
+const (
+	RecallStatusDraft  = 0 // 草稿，可修改范围
+	RecallStatusActive = 1 // 进行中
+	RecallStatusClosed = 2 // 已结束
+)
+
+// recallReportTopIPs 召回扫描报告中按IP汇总的条数
+const recallReportTopIPs = 20
+
+// RecallScope 召回范围，StartSeq和EndSeq都为0表示整个批次，否则为批次内的序号区间（含两端）
+type RecallScope struct {
+	BatchID  uint `json:"batch_id" binding:"required"`
+	StartSeq int  `json:"start_seq" binding:"min=0"`
+	EndSeq   int  `json:"end_seq" binding:"min=0"`
+}
+
+// RecallInput 创建或修改召回的请求参数
+type RecallInput struct {
+	Title     string        `json:"title" binding:"required,max=200"`     // 召回标题
+	Reason    string        `json:"reason" binding:"required"`            // 召回原因
+	Notice    string        `json:"notice" binding:"required"`            // 消费者公告
+	Contact   string        `json:"contact" binding:"max=255"`            // 联系方式
+	StartDate *time.Time    `json:"start_date"`                           // 召回开始日期，为空时取启动时间
+	Scopes    []RecallScope `json:"scopes" binding:"required,min=1,dive"` // 召回范围
+}
+
+// RecallNotice 验证已召回防伪码时展示的召回公告
+type RecallNotice struct {
+	RecallID  uint       `json:"recall_id"`
+	Title     string     `json:"title"`
+	Reason    string     `json:"reason"`
+	Notice    string     `json:"notice"`
+	Contact   string     `json:"contact"`
+	StartDate *time.Time `json:"start_date"`
+}
+
+// RecallScan 召回后对已召回防伪码的一次扫描
+type RecallScan struct {
+	SecurityCode string    `json:"security_code"`
+	BatchID      uint      `json:"batch_id"`
+	Sequence     int       `json:"sequence"`
+	VerifyTime   time.Time `json:"verify_time"`
+	IPAddress    string    `json:"ip_address"`
+	UserAgent    string    `json:"user_agent"`
+	Result       int       `json:"result"`
+}
+
+// RecallIPSummary 召回扫描按IP地址的汇总
+type RecallIPSummary struct {
+	IPAddress    string    `json:"ip_address"`
+	ScanCount    int64     `json:"scan_count"`
+	CodeCount    int64     `json:"code_count"`
+	LastScanTime time.Time `json:"last_scan_time"`
+}
+
+// RecallReport 召回扫描报告
+type RecallReport struct {
+	Since        time.Time         `json:"since"`         // 统计起点，取召回开始日期和启动时间中较早者
+	ScannedCodes int64             `json:"scanned_codes"` // 被扫描过的已召回防伪码数量
+	ScanCount    int64             `json:"scan_count"`    // 扫描次数
+	IPCount      int64             `json:"ip_count"`      // 不同IP地址数量
+	TopIPs       []RecallIPSummary `json:"top_ips"`       // 扫描次数最多的IP地址
+	Total        int64             `json:"total"`         // 扫描明细总数
+	Scans        []RecallScan      `json:"scans"`         // 当前页的扫描明细，按时间倒序
+}
+
+// RecallService 商品召回服务
+type RecallService struct {
+	db *gorm.DB
+}
+
+// NewRecallService 创建商品召回服务
+func NewRecallService(db *gorm.DB) *RecallService {
+	return &RecallService{db: db}
+}
+
+// Create 创建草稿状态的召回
+func (s *RecallService) Create(merchantID, userID uint, input *RecallInput) (*models.Recall, error) {
+	recall := models.Recall{MerchantID: merchantID, Status: RecallStatusDraft, CreatedBy: userID}
+	if err := s.apply(&recall, input); err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(&recall).Error; err != nil {
+		return nil, err
+	}
+	return &recall, nil
+}
+
+// Update 修改召回，已启动的召回只能修改标题、原因、公告、联系方式和开始日期，已结束的召回不能修改
+func (s *RecallService) Update(recall *models.Recall, input *RecallInput) error {
+	switch recall.Status {
+	case RecallStatusClosed:
+		return i18n.NewError("recall.status_invalid")
+	case RecallStatusActive:
+		scopes, err := json.Marshal(input.Scopes)
+		if err != nil {
+			return err
+		}
+		if string(scopes) != recall.Scopes {
+			return i18n.NewError("recall.scope_locked")
+		}
+	}
+
+	if err := s.apply(recall, input); err != nil {
+		return err
+	}
+	return s.db.Save(recall).Error
+}
+
+// apply 校验范围内的批次属于召回所属商户并写入召回字段
+func (s *RecallService) apply(recall *models.Recall, input *RecallInput) error {
+	batchIDs := make([]uint, 0, len(input.Scopes))
+	for _, scope := range input.Scopes {
+		if (scope.StartSeq == 0) != (scope.EndSeq == 0) || scope.StartSeq > scope.EndSeq {
+			return i18n.NewError("recall.scope_invalid", scope.BatchID)
+		}
+		batchIDs = append(batchIDs, scope.BatchID)
+	}
+
+	var count int64
+	if err := s.db.Model(&models.ProductBatch{}).Where("id IN ? AND merchant_id = ?", batchIDs, recall.MerchantID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if int(count) != len(uniqueIDs(batchIDs)) {
+		return i18n.NewError("recall.batch_not_found")
+	}
+
+	scopes, err := json.Marshal(input.Scopes)
+	if err != nil {
+		return err
+	}
+	recall.Title = input.Title
+	recall.Reason = input.Reason
+	recall.Notice = input.Notice
+	recall.Contact = input.Contact
+	recall.StartDate = input.StartDate
+	recall.Scopes = string(scopes)
+	return nil
+}
+
+// Open 启动召回，在一个事务中将范围内的正常防伪码转为已召回状态
+// 已作废或已被其他召回覆盖的防伪码保持不变，返回转为已召回状态的数量。
+func (s *RecallService) Open(recall *models.Recall) (int, error) {
+	var scopes []RecallScope
+	if err := json.Unmarshal([]byte(recall.Scopes), &scopes); err != nil {
+		return 0, err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// 锁定召回行，避免重复启动
+		var current models.Recall
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, recall.ID).Error; err != nil {
+			return err
+		}
+		if current.Status != RecallStatusDraft {
+			return i18n.NewError("recall.status_invalid")
+		}
+
+		count := 0
+		for _, scope := range scopes {
+			query := tx.Model(&models.SecurityCode{}).
+				Where("merchant_id = ? AND batch_id = ? AND status = ?", recall.MerchantID, scope.BatchID, CodeStatusNormal)
+			if scope.StartSeq > 0 {
+				query = query.Where("sequence BETWEEN ? AND ?", scope.StartSeq, scope.EndSeq)
+			}
+			result := query.Updates(map[string]interface{}{"status": CodeStatusRecalled, "recall_id": recall.ID})
+			if result.Error != nil {
+				return result.Error
+			}
+			count += int(result.RowsAffected)
+		}
+
+		now := time.Now()
+		recall.Status = RecallStatusActive
+		recall.CodeCount = count
+		recall.OpenedAt = &now
+		if recall.StartDate == nil {
+			recall.StartDate = &now
+		}
+		return tx.Save(recall).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return recall.CodeCount, nil
+}
+
+// Close 结束召回，已召回的防伪码保持已召回状态，验证时仍展示公告
+func (s *RecallService) Close(recall *models.Recall) error {
+	if recall.Status != RecallStatusActive {
+		return i18n.NewError("recall.status_invalid")
+	}
+	now := time.Now()
+	recall.Status = RecallStatusClosed
+	recall.ClosedAt = &now
+	return s.db.Save(recall).Error
+}
+
+// Notice 查询防伪码所属召回的公告，防伪码未召回时返回nil
+func (s *RecallService) Notice(code *models.SecurityCode) (*RecallNotice, error) {
+	if code.RecallID == nil {
+		return nil, nil
+	}
+	var recall models.Recall
+	if err := s.db.First(&recall, *code.RecallID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &RecallNotice{
+		RecallID:  recall.ID,
+		Title:     recall.Title,
+		Reason:    recall.Reason,
+		Notice:    recall.Notice,
+		Contact:   recall.Contact,
+		StartDate: recall.StartDate,
+	}, nil
+}
+
+// Report 统计召回开始后对已召回防伪码的扫描，返回汇总、按IP的排行和分页明细
+// 只扫描开始日期之后的未归档月份表，已归档月份的扫描不计入。
+func (s *RecallService) Report(records *VerifyRecordRepository, recall *models.Recall, page, size int) (*RecallReport, error) {
+	report := &RecallReport{TopIPs: []RecallIPSummary{}, Scans: []RecallScan{}}
+	if recall.OpenedAt == nil {
+		return report, nil
+	}
+	report.Since = *recall.OpenedAt
+	if recall.StartDate != nil && recall.StartDate.Before(report.Since) {
+		report.Since = *recall.StartDate
+	}
+
+	scans := func() *gorm.DB {
+		return records.Range(report.Since, time.Time{}).
+			Joins("JOIN security_codes ON security_codes.code = verification_records.security_code").
+			Where("verification_records.merchant_id = ? AND verification_records.verify_time >= ? AND security_codes.recall_id = ?",
+				recall.MerchantID, report.Since, recall.ID)
+	}
+
+	var summary struct {
+		ScannedCodes int64
+		ScanCount    int64
+		IPCount      int64
+	}
+	if err := scans().
+		Select("COUNT(DISTINCT verification_records.security_code) AS scanned_codes, COUNT(*) AS scan_count, " +
+			"COUNT(DISTINCT verification_records.ip_address) AS ip_count").
+		Scan(&summary).Error; err != nil {
+		return nil, err
+	}
+	report.ScannedCodes, report.ScanCount, report.IPCount = summary.ScannedCodes, summary.ScanCount, summary.IPCount
+	report.Total = summary.ScanCount
+
+	if err := scans().
+		Select("verification_records.ip_address, COUNT(*) AS scan_count, " +
+			"COUNT(DISTINCT verification_records.security_code) AS code_count, MAX(verification_records.verify_time) AS last_scan_time").
+		Group("verification_records.ip_address").Order("scan_count desc").Limit(recallReportTopIPs).
+		Scan(&report.TopIPs).Error; err != nil {
+		return nil, err
+	}
+
+	if err := scans().
+		Select("verification_records.security_code, security_codes.batch_id, security_codes.sequence, " +
+			"verification_records.verify_time, verification_records.ip_address, verification_records.user_agent, verification_records.result").
+		Order("verification_records.verify_time desc").Offset((page - 1) * size).Limit(size).
+		Scan(&report.Scans).Error; err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// uniqueIDs 去除重复的ID
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	unique := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/offlinecode"
+	"anti-fake-system/utils"
+
+	"gorm.io/gorm"
+)
+
+// 签名密钥状态
+const (
+	SigningKeyStatusRevoked = 0 // 已吊销，不再公开，使用该密钥签名的防伪码离线验证失败
+	SigningKeyStatusActive  = 1 // 当前签名密钥
+	SigningKeyStatusRetired = 2 // 已轮换，仅公开用于验证历史防伪码
+)
+
+// SigningAlgorithm 签名算法名称
+const SigningAlgorithm = "Ed25519"
+
+// keyIDLength 密钥ID长度（Base32字符）
+const keyIDLength = 4
+
+// SigningKeyService 商户签名密钥管理，负责生成、轮换、吊销密钥和签名防伪码
+type SigningKeyService struct {
+	db   *gorm.DB
+	cfg  *config.Config
+	keys *KeyManager
+}
+
+// NewSigningKeyService 创建签名密钥服务，私钥种子使用商户的数据加密密钥加密保存
+func NewSigningKeyService(db *gorm.DB, cfg *config.Config, keys *KeyManager) *SigningKeyService {
+	return &SigningKeyService{db: db, cfg: cfg, keys: keys}
+}
+
+// ValidateSignatureConfig 校验签名规则配置，签名规则的明码中不能出现签名分隔符
+func ValidateSignatureConfig(cfg *RuleConfig) error {
+	if !cfg.Signed {
+		return nil
+	}
+	if strings.Contains(cfg.Separator, offlinecode.Delimiter) || strings.Contains(cfg.MerchantCode, offlinecode.Delimiter) ||
+		(cfg.Prefix != nil && strings.Contains(cfg.Prefix.Content, offlinecode.Delimiter)) ||
+		(cfg.FixedNum != nil && strings.Contains(cfg.FixedNum.Content, offlinecode.Delimiter)) {
+		return i18n.NewError("rule.signature_delimiter_conflict", offlinecode.Delimiter)
+	}
+	return nil
+}
+
+// List 查询商户的全部签名密钥
+func (s *SigningKeyService) List(merchantID uint) ([]models.MerchantSigningKey, error) {
+	var keys []models.MerchantSigningKey
+	err := s.db.Where("merchant_id = ?", merchantID).Order("id desc").Find(&keys).Error
+	return keys, err
+}
+
+// Published 返回商户公开的公钥列表（当前密钥和已轮换密钥）
+func (s *SigningKeyService) Published(merchantID uint) ([]offlinecode.PublishedKey, error) {
+	var keys []models.MerchantSigningKey
+	if err := s.db.Where("merchant_id = ? AND status IN ?", merchantID,
+		[]int{SigningKeyStatusActive, SigningKeyStatusRetired}).Order("id desc").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	published := make([]offlinecode.PublishedKey, len(keys))
+	for i, key := range keys {
+		status := "active"
+		if key.Status == SigningKeyStatusRetired {
+			status = "retired"
+		}
+		published[i] = offlinecode.PublishedKey{
+			KeyID:     key.KeyID,
+			Algorithm: SigningAlgorithm,
+			PublicKey: key.PublicKey,
+			Status:    status,
+		}
+	}
+	return published, nil
+}
+
+// Rotate 生成新的签名密钥，原当前密钥转为已轮换状态，继续公开用于验证已印刷的防伪码
+func (s *SigningKeyService) Rotate(merchantID uint) (*models.MerchantSigningKey, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := s.keys.Encrypt(merchantID, privateKey.Seed())
+	if err != nil {
+		return nil, err
+	}
+
+	key := models.MerchantSigningKey{
+		MerchantID: merchantID,
+		PublicKey:  base64.StdEncoding.EncodeToString(publicKey),
+		PrivateKey: encrypted,
+		Status:     SigningKeyStatusActive,
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		kid, err := s.newKeyID(tx, merchantID)
+		if err != nil {
+			return err
+		}
+		key.KeyID = kid
+
+		now := time.Now()
+		if err := tx.Model(&models.MerchantSigningKey{}).
+			Where("merchant_id = ? AND status = ?", merchantID, SigningKeyStatusActive).
+			Updates(map[string]interface{}{"status": SigningKeyStatusRetired, "retired_at": now}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&key).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Revoke 吊销已轮换的签名密钥，当前签名密钥需要先轮换才能吊销
+func (s *SigningKeyService) Revoke(merchantID uint, kid string) error {
+	var key models.MerchantSigningKey
+	if err := s.db.Where("merchant_id = ? AND key_id = ?", merchantID, kid).First(&key).Error; err != nil {
+		return i18n.NewError("signing_key.not_found")
+	}
+	if key.Status == SigningKeyStatusActive {
+		return i18n.NewError("signing_key.revoke_active")
+	}
+
+	now := time.Now()
+	return s.db.Model(&key).Updates(map[string]interface{}{
+		"status":     SigningKeyStatusRevoked,
+		"revoked_at": now,
+	}).Error
+}
+
+// Signer 返回使用商户当前签名密钥的签名函数，商户尚无密钥时自动生成
+func (s *SigningKeyService) Signer(merchantID uint) (func(payload string) (string, error), error) {
+	var key models.MerchantSigningKey
+	err := s.db.Where("merchant_id = ? AND status = ?", merchantID, SigningKeyStatusActive).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		created, rotateErr := s.Rotate(merchantID)
+		if rotateErr != nil {
+			return nil, rotateErr
+		}
+		key, err = *created, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := s.keys.Decrypt(merchantID, key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.New("签名密钥格式错误")
+	}
+	privateKey := ed25519.NewKeyFromSeed(seed)
+
+	return func(payload string) (string, error) {
+		return offlinecode.Sign(privateKey, key.KeyID, payload)
+	}, nil
+}
+
+// MigrateLegacyKeys 将旧版本以JWT密钥派生的AES密钥加密的私钥种子一次性重新用商户的数据加密密钥加密，返回迁移的密钥数
+// 已吊销的密钥不再签名，直接清除其私钥。这是唯一仍使用JWT密钥解密的地方，所有部署都完成迁移后可以删除。
+func (s *SigningKeyService) MigrateLegacyKeys() (int, error) {
+	var records []models.MerchantSigningKey
+	if err := s.db.Select("id", "merchant_id", "key_id", "private_key", "status").Find(&records).Error; err != nil {
+		return 0, err
+	}
+
+	legacyKey := sha256.Sum256([]byte(s.cfg.JWT.Secret))
+	count := 0
+	for _, record := range records {
+		if record.PrivateKey == "" {
+			continue
+		}
+		if _, _, ok := parseHeader(record.PrivateKey); ok {
+			continue
+		}
+
+		var encrypted string
+		if record.Status != SigningKeyStatusRevoked {
+			seed, err := utils.Decrypt(record.PrivateKey, legacyKey[:])
+			if err != nil {
+				return count, fmt.Errorf("商户 %d 的签名密钥 %s 解密失败: %w", record.MerchantID, record.KeyID, err)
+			}
+			if encrypted, err = s.keys.Encrypt(record.MerchantID, seed); err != nil {
+				return count, err
+			}
+		}
+
+		result := s.db.Model(&models.MerchantSigningKey{}).
+			Where("id = ? AND private_key = ?", record.ID, record.PrivateKey).
+			UpdateColumn("private_key", encrypted)
+		if result.Error != nil {
+			return count, result.Error
+		}
+		count += int(result.RowsAffected)
+	}
+	if count > 0 {
+		log.Printf("已将 %d 个商户签名密钥迁移为数据加密密钥加密", count)
+	}
+	return count, nil
+}
+
+// PreviewSigner 返回使用临时密钥的签名函数，用于规则测试时预览签名防伪码的格式和长度
+// 临时密钥不会公开，预览出的防伪码无法通过离线验证。
+func PreviewSigner() (func(payload string) (string, error), error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return func(payload string) (string, error) {
+		return offlinecode.Sign(privateKey, "TEST", payload)
+	}, nil
+}
+
+// newKeyID 生成商户内唯一的随机密钥ID
+func (s *SigningKeyService) newKeyID(tx *gorm.DB, merchantID uint) (string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for {
+		buf := make([]byte, 3)
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		kid := encoding.EncodeToString(buf)[:keyIDLength]
+
+		var count int64
+		if err := tx.Model(&models.MerchantSigningKey{}).
+			Where("merchant_id = ? AND key_id = ?", merchantID, kid).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return kid, nil
+		}
+	}
+}
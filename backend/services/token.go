@@ -0,0 +1,288 @@
+package services
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/utils"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 令牌吊销相关的Redis键前缀
+const (
+	tokenDenyPrefix        = "afs:auth:deny:"         // 已注销的访问令牌，完整键为 afs:auth:deny:<jti>
+	tokenUserRevokedPrefix = "afs:auth:user_revoked:" // 用户令牌吊销时间，完整键为 afs:auth:user_revoked:<用户ID>
+)
+
+// 刷新令牌状态
+const (
+	refreshTokenValid   = iota // 可以使用
+	refreshTokenInvalid        // 已吊销或已过期
+	refreshTokenReused         // 已轮换过，再次出现视为泄露
+)
+
+// TokenPair 登录或刷新后签发的访问令牌和刷新令牌
+type TokenPair struct {
+	AccessToken     string    `json:"token"`
+	ExpireAt        time.Time `json:"expire_at"`
+	RefreshToken    string    `json:"refresh_token"`
+	RefreshExpireAt time.Time `json:"refresh_expire_at"`
+}
+
+// TokenService 令牌服务，负责签发、轮换和吊销令牌
+// 访问令牌为短期JWT，刷新令牌为保存在数据库中的随机串，每次刷新都轮换；已轮换的刷新令牌再次出现时吊销整个令牌族。
+type TokenService struct {
+	db    *gorm.DB
+	redis *redis.Client
+	cfg   *config.Config
+	keys  *JWTKeyService
+}
+
+// NewTokenService 创建令牌服务，访问令牌使用签名密钥环中的当前密钥签名
+func NewTokenService(db *gorm.DB, redisClient *redis.Client, cfg *config.Config, keys *JWTKeyService) *TokenService {
+	return &TokenService{db: db, redis: redisClient, cfg: cfg, keys: keys}
+}
+
+// Issue 为登录成功的用户签发令牌，开始一个新的令牌族
+func (s *TokenService) Issue(user *models.User, ipAddress, userAgent string) (*TokenPair, error) {
+	// 顺带清理该用户已过期的刷新令牌
+	s.db.Where("user_id = ? AND expires_at < ?", user.ID, time.Now()).Delete(&models.RefreshToken{})
+
+	familyID, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	return s.issue(s.db, user, familyID, ipAddress, userAgent)
+}
+
+// Refresh 使用刷新令牌换取新的令牌，原刷新令牌立即失效
+// 已轮换过的刷新令牌再次使用时视为泄露，吊销整个令牌族及其签发的访问令牌。
+func (s *TokenService) Refresh(ctx context.Context, refreshToken, ipAddress, userAgent string) (*TokenPair, *models.User, error) {
+	var (
+		pair   *TokenPair
+		user   models.User
+		reused string
+	)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var current models.RefreshToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashToken(refreshToken)).First(&current).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return i18n.NewError("auth.refresh_invalid")
+			}
+			return err
+		}
+
+		now := time.Now()
+		switch refreshTokenStateAt(&current, now) {
+		case refreshTokenInvalid:
+			return i18n.NewError("auth.refresh_invalid")
+		case refreshTokenReused:
+			reused = current.FamilyID
+			return i18n.NewError("auth.refresh_reused")
+		}
+
+		if err := tx.First(&user, current.UserID).Error; err != nil || user.Status != UserStatusEnabled {
+			return i18n.NewError("auth.refresh_invalid")
+		}
+		if err := tx.Model(&current).Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		var err error
+		pair, err = s.issue(tx, &user, current.FamilyID, ipAddress, userAgent)
+		return err
+	})
+	if reused != "" {
+		log.Printf("检测到刷新令牌重复使用，吊销令牌族 %s", reused)
+		s.revokeFamilies(ctx, s.db.Where("family_id = ?", reused))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return pair, &user, nil
+}
+
+// Logout 注销当前会话：访问令牌加入黑名单，提供刷新令牌时吊销其令牌族
+func (s *TokenService) Logout(ctx context.Context, userID uint, tokenID string, expiresAt time.Time, refreshToken string) {
+	s.deny(ctx, tokenID, expiresAt)
+	if refreshToken != "" {
+		var current models.RefreshToken
+		if err := s.db.Where("token_hash = ? AND user_id = ?", hashToken(refreshToken), userID).First(&current).Error; err == nil {
+			s.revokeFamilies(ctx, s.db.Where("family_id = ?", current.FamilyID))
+		}
+	}
+}
+
+// RevokeUser 吊销用户的全部令牌，用于禁用账号、修改或重置密码、删除账号
+// 此前签发的访问令牌在剩余有效期内都会被拒绝。
+func (s *TokenService) RevokeUser(ctx context.Context, userID uint) {
+	s.revokeFamilies(ctx, s.db.Where("user_id = ?", userID))
+	if s.redis == nil {
+		return
+	}
+	ttl := time.Duration(s.cfg.JWT.AccessExpire) * time.Minute
+	key := tokenUserRevokedPrefix + fmt.Sprint(userID)
+	if err := s.redis.Set(ctx, key, time.Now().Unix(), ttl).Err(); err != nil {
+		log.Printf("写入用户 %d 的令牌吊销时间失败: %v", userID, err)
+	}
+}
+
+// Parse 按令牌头部的kid验证并解析访问令牌，其他用途的令牌不能当作访问令牌使用
+func (s *TokenService) Parse(tokenString string) (*utils.CustomClaims, error) {
+	return s.ParsePurpose(tokenString, utils.TokenPurposeAccess)
+}
+
+// IssuePurpose 为用户签发指定用途的短期令牌，如两步验证令牌和二次验证令牌，不关联刷新令牌
+func (s *TokenService) IssuePurpose(user *models.User, purpose string, expire time.Duration) (string, time.Time, error) {
+	token, claims, err := utils.GeneratePurposeToken(s.keys, purpose, user.ID, user.Username, user.UserType, user.MerchantID, expire)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, claims.ExpiresAt.Time, nil
+}
+
+// ParsePurpose 验证并解析指定用途的令牌，用途不符时返回错误
+func (s *TokenService) ParsePurpose(tokenString, purpose string) (*utils.CustomClaims, error) {
+	claims, err := utils.ParseToken(s.keys, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose {
+		return nil, errors.New("令牌用途不符")
+	}
+	return claims, nil
+}
+
+// IsRevoked 判断访问令牌是否已注销或已随用户吊销
+// Redis不可用时放行，访问令牌有效期较短，由过期时间兜底。
+func (s *TokenService) IsRevoked(ctx context.Context, claims *utils.CustomClaims) bool {
+	if s.redis == nil {
+		return false
+	}
+
+	values, err := s.redis.MGet(ctx, tokenDenyPrefix+claims.ID, tokenUserRevokedPrefix+fmt.Sprint(claims.UserID)).Result()
+	if err != nil {
+		log.Printf("查询令牌黑名单失败: %v", err)
+		return false
+	}
+	if values[0] != nil {
+		return true
+	}
+	if revokedAt, ok := values[1].(string); ok && claims.IssuedAt != nil {
+		// 与吊销在同一秒内签发的令牌同样拒绝，客户端重新登录即可
+		if unix, err := strconv.ParseInt(revokedAt, 10, 64); err == nil && claims.IssuedAt.Unix() <= unix {
+			return true
+		}
+	}
+	return false
+}
+
+// issue 签发访问令牌和刷新令牌，刷新令牌属于指定的令牌族
+func (s *TokenService) issue(tx *gorm.DB, user *models.User, familyID, ipAddress, userAgent string) (*TokenPair, error) {
+	accessToken, claims, err := utils.GenerateToken(s.keys, user.ID, user.Username, user.UserType, user.MerchantID,
+		time.Duration(s.cfg.JWT.AccessExpire)*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(raw)
+
+	record := models.RefreshToken{
+		UserID:          user.ID,
+		FamilyID:        familyID,
+		TokenHash:       hashToken(refreshToken),
+		AccessTokenID:   claims.ID,
+		AccessExpiresAt: claims.ExpiresAt.Time,
+		ExpiresAt:       time.Now().Add(time.Duration(s.cfg.JWT.RefreshExpire) * time.Hour),
+		IPAddress:       ipAddress,
+		UserAgent:       truncate(userAgent, 255),
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:     accessToken,
+		ExpireAt:        claims.ExpiresAt.Time,
+		RefreshToken:    refreshToken,
+		RefreshExpireAt: record.ExpiresAt,
+	}, nil
+}
+
+// revokeFamilies 吊销查询到的刷新令牌所在的令牌族，并将其中未过期的访问令牌加入黑名单
+func (s *TokenService) revokeFamilies(ctx context.Context, query *gorm.DB) {
+	var familyIDs []string
+	if err := query.Model(&models.RefreshToken{}).Distinct().Pluck("family_id", &familyIDs).Error; err != nil {
+		log.Printf("查询刷新令牌失败: %v", err)
+		return
+	}
+	if len(familyIDs) == 0 {
+		return
+	}
+
+	var tokens []models.RefreshToken
+	s.db.Where("family_id IN ? AND access_expires_at > ?", familyIDs, time.Now()).Find(&tokens)
+	for _, token := range tokens {
+		s.deny(ctx, token.AccessTokenID, token.AccessExpiresAt)
+	}
+
+	if err := s.db.Model(&models.RefreshToken{}).Where("family_id IN ? AND revoked_at IS NULL", familyIDs).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		log.Printf("吊销刷新令牌失败: %v", err)
+	}
+}
+
+// deny 将访问令牌加入黑名单直到其过期
+func (s *TokenService) deny(ctx context.Context, tokenID string, expiresAt time.Time) {
+	ttl := time.Until(expiresAt)
+	if s.redis == nil || tokenID == "" || ttl <= 0 {
+		return
+	}
+	if err := s.redis.Set(ctx, tokenDenyPrefix+tokenID, 1, ttl).Err(); err != nil {
+		log.Printf("写入令牌黑名单失败: %v", err)
+	}
+}
+
+// refreshTokenStateAt 判断刷新令牌在指定时间的状态，已吊销的令牌族不再按重复使用处理
+func refreshTokenStateAt(token *models.RefreshToken, now time.Time) int {
+	switch {
+	case token.RevokedAt != nil || token.ExpiresAt.Before(now):
+		return refreshTokenInvalid
+	case token.UsedAt != nil:
+		return refreshTokenReused
+	}
+	return refreshTokenValid
+}
+
+// hashToken 计算刷新令牌的SHA-256摘要
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex 生成n字节的随机数并以十六进制表示
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
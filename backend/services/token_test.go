@@ -0,0 +1,73 @@
+package services
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/models"
+	"anti-fake-system/utils"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokenStateAt(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	earlier := now.Add(-time.Minute)
+
+	tests := []struct {
+		name  string
+		token models.RefreshToken
+		want  int
+	}{
+		{"unused", models.RefreshToken{ExpiresAt: now.Add(time.Hour)}, refreshTokenValid},
+		{"expires exactly now", models.RefreshToken{ExpiresAt: now}, refreshTokenValid},
+		{"expired", models.RefreshToken{ExpiresAt: earlier}, refreshTokenInvalid},
+		{"revoked", models.RefreshToken{ExpiresAt: now.Add(time.Hour), RevokedAt: &earlier}, refreshTokenInvalid},
+		{"already rotated", models.RefreshToken{ExpiresAt: now.Add(time.Hour), UsedAt: &earlier}, refreshTokenReused},
+		// 重复使用后整个令牌族已吊销，之后再出现只按无效处理，不再重复吊销
+		{"rotated and revoked", models.RefreshToken{ExpiresAt: now.Add(time.Hour), UsedAt: &earlier, RevokedAt: &earlier}, refreshTokenInvalid},
+		{"rotated and expired", models.RefreshToken{ExpiresAt: earlier, UsedAt: &earlier}, refreshTokenInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := refreshTokenStateAt(&tt.token, now); got != tt.want {
+				t.Errorf("refreshTokenStateAt() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	// SHA-256("abc")
+	const want = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if got := hashToken("abc"); got != want {
+		t.Errorf("hashToken(abc) = %s, want %s", got, want)
+	}
+	if hashToken("abc") == hashToken("abd") {
+		t.Error("hashToken should differ for different tokens")
+	}
+}
+
+func TestRandomHex(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		value, err := randomHex(16)
+		if err != nil {
+			t.Fatalf("randomHex error: %v", err)
+		}
+		if len(value) != 32 {
+			t.Fatalf("randomHex(16) = %q, want 32 hex characters", value)
+		}
+		if seen[value] {
+			t.Fatalf("randomHex returned duplicate value %q", value)
+		}
+		seen[value] = true
+	}
+}
+
+func TestIsRevokedWithoutRedis(t *testing.T) {
+	// Redis不可用时放行，由访问令牌的过期时间兜底
+	s := NewTokenService(nil, nil, &config.Config{}, nil)
+	if s.IsRevoked(context.Background(), &utils.CustomClaims{UserID: 1}) {
+		t.Error("IsRevoked() = true without redis, want false")
+	}
+}
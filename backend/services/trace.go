@@ -0,0 +1,22 @@
+package services
+
+// 溯源阶段类型
+const (
+	TraceStageProduction = 1 // 生产
+	TraceStageWarehouse  = 2 // 仓储
+	TraceStageLogistics  = 3 // 物流
+	TraceStageSales      = 4 // 销售
+)
+
+// TraceStageTypes 支持的溯源阶段类型
+var TraceStageTypes = []int{TraceStageProduction, TraceStageWarehouse, TraceStageLogistics, TraceStageSales}
+
+// IsTraceStage 判断是否为支持的溯源阶段类型
+func IsTraceStage(stageType int) bool {
+	for _, t := range TraceStageTypes {
+		if t == stageType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,396 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/tracechain"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TraceRootLeaf 默克尔根中的一个叶子，即某批次在发布时的链头
+type TraceRootLeaf struct {
+	BatchID  uint   `json:"batch_id"`
+	Seq      uint   `json:"seq"`
+	HeadHash string `json:"head_hash"`
+}
+
+// TraceProof 批次链头包含在默克尔根中的证明
+type TraceProof struct {
+	Root     models.TraceMerkleRoot `json:"root"`
+	Leaf     TraceRootLeaf          `json:"leaf"`
+	LeafHash string                 `json:"leaf_hash"`
+	Path     []tracechain.ProofStep `json:"path"`
+}
+
+// TraceChainService 溯源哈希链服务
+// 溯源信息的每次新增、修改、删除都在同一事务中追加一条链上记录，链上记录只追加不修改。
+type TraceChainService struct {
+	db *gorm.DB
+}
+
+// NewTraceChainService 创建溯源哈希链服务
+func NewTraceChainService(db *gorm.DB) *TraceChainService {
+	return &TraceChainService{db: db}
+}
+
+// Append 在事务中为溯源信息的当前状态追加一条链上记录
+// 先锁定批次行，保证同一批次的记录串行写入、序号连续。
+func (s *TraceChainService) Append(tx *gorm.DB, trace *models.TraceabilityInfo, action string, userID uint) (*models.TraceChainEntry, error) {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").
+		First(&models.ProductBatch{}, trace.BatchID).Error; err != nil {
+		return nil, err
+	}
+
+	var last models.TraceChainEntry
+	prevHash, seq := tracechain.GenesisHash, uint(1)
+	if err := tx.Where("batch_id = ?", trace.BatchID).Order("seq desc").Limit(1).Find(&last).Error; err != nil {
+		return nil, err
+	}
+	if last.ID != 0 {
+		prevHash, seq = last.EntryHash, last.Seq+1
+	}
+
+	entry := models.TraceChainEntry{
+		MerchantID:    trace.MerchantID,
+		BatchID:       trace.BatchID,
+		Seq:           seq,
+		TraceID:       trace.ID,
+		Version:       trace.Version,
+		Action:        action,
+		StageType:     trace.StageType,
+		SchemaVersion: trace.SchemaVersion,
+		Content:       trace.Content,
+		Attachments:   trace.Attachments,
+		Salts:         "{}",
+		PrevHash:      prevHash,
+		CreatedBy:     userID,
+		CreatedAt:     time.Now().Truncate(time.Second),
+	}
+
+	if action != tracechain.ActionDelete {
+		var content map[string]interface{}
+		if err := json.Unmarshal([]byte(trace.Content), &content); err != nil {
+			return nil, err
+		}
+		salts := make(map[string]string, len(content))
+		for key := range content {
+			salts[key] = randomSalt()
+		}
+		saltsJSON, _ := json.Marshal(salts)
+		entry.Salts = string(saltsJSON)
+	}
+
+	record, err := s.record(&entry, nil)
+	if err != nil {
+		return nil, err
+	}
+	entry.ContentHash = record.ContentHash
+	entry.EntryHash = record.Hash()
+
+	if err := tx.Create(&entry).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Versions 查询溯源信息的全部历史版本
+func (s *TraceChainService) Versions(traceID uint) ([]models.TraceChainEntry, error) {
+	var entries []models.TraceChainEntry
+	err := s.db.Where("trace_id = ?", traceID).Order("seq asc").Find(&entries).Error
+	return entries, err
+}
+
+// Records 返回批次序号不超过maxSeq的链上记录，maxSeq为0表示全部
+// disclose 决定某条记录的某个字段是否披露值和盐，为nil时全部披露。
+func (s *TraceChainService) Records(batchID, maxSeq uint, disclose func(entry *models.TraceChainEntry, key string) bool) ([]tracechain.Record, error) {
+	query := s.db.Where("batch_id = ?", batchID)
+	if maxSeq > 0 {
+		query = query.Where("seq <= ?", maxSeq)
+	}
+
+	var entries []models.TraceChainEntry
+	if err := query.Order("seq asc").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	records := make([]tracechain.Record, len(entries))
+	for i := range entries {
+		entry := &entries[i]
+		var filter func(key string) bool
+		if disclose != nil {
+			filter = func(key string) bool { return disclose(entry, key) }
+		}
+		record, err := s.record(entry, filter)
+		if err != nil {
+			return nil, err
+		}
+		records[i] = *record
+	}
+	return records, nil
+}
+
+// VerifyBatch 按数据库中保存的内容重算批次的整条哈希链，用于发现绕过接口直接修改数据库的篡改
+func (s *TraceChainService) VerifyBatch(batchID uint) (string, int, error) {
+	records, err := s.Records(batchID, 0, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	head, err := tracechain.VerifyChain(records)
+	return head, len(records), err
+}
+
+// Publish 以商户各批次的链头计算默克尔根，与最近一次发布的根相同时不重复发布
+func (s *TraceChainService) Publish(merchantID uint) (*models.TraceMerkleRoot, bool, error) {
+	var leaves []TraceRootLeaf
+	err := s.db.Raw(`SELECT e.batch_id, e.seq, e.entry_hash AS head_hash FROM trace_chain_entries e
+		JOIN (SELECT batch_id, MAX(seq) AS seq FROM trace_chain_entries WHERE merchant_id = ? GROUP BY batch_id) h
+		ON e.batch_id = h.batch_id AND e.seq = h.seq ORDER BY e.batch_id`, merchantID).Scan(&leaves).Error
+	if err != nil {
+		return nil, false, err
+	}
+	if len(leaves) == 0 {
+		return nil, false, nil
+	}
+
+	root := tracechain.MerkleRoot(leafHashes(leaves))
+
+	var latest models.TraceMerkleRoot
+	if err := s.db.Where("merchant_id = ?", merchantID).Order("id desc").Limit(1).Find(&latest).Error; err != nil {
+		return nil, false, err
+	}
+	if latest.ID != 0 && latest.Root == root {
+		return &latest, false, nil
+	}
+
+	leavesJSON, _ := json.Marshal(leaves)
+	published := models.TraceMerkleRoot{
+		MerchantID: merchantID,
+		Root:       root,
+		LeafCount:  len(leaves),
+		Leaves:     string(leavesJSON),
+	}
+	if err := s.db.Create(&published).Error; err != nil {
+		return nil, false, err
+	}
+	return &published, true, nil
+}
+
+// PublishAll 为所有有溯源记录的商户发布默克尔根
+func (s *TraceChainService) PublishAll() (int, error) {
+	var merchantIDs []uint
+	if err := s.db.Model(&models.TraceChainEntry{}).Distinct("merchant_id").Pluck("merchant_id", &merchantIDs).Error; err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, merchantID := range merchantIDs {
+		_, changed, err := s.Publish(merchantID)
+		if err != nil {
+			return published, err
+		}
+		if changed {
+			published++
+		}
+	}
+	return published, nil
+}
+
+// Proof 生成批次链头包含在默克尔根中的证明，rootID为0时使用商户最近发布的根
+func (s *TraceChainService) Proof(merchantID, batchID, rootID uint) (*TraceProof, error) {
+	query := s.db.Where("merchant_id = ?", merchantID)
+	if rootID > 0 {
+		query = query.Where("id = ?", rootID)
+	}
+
+	var root models.TraceMerkleRoot
+	if err := query.Order("id desc").Limit(1).Find(&root).Error; err != nil {
+		return nil, err
+	}
+	if root.ID == 0 {
+		return nil, i18n.NewError("trace_chain.root_not_found")
+	}
+
+	var leaves []TraceRootLeaf
+	if err := json.Unmarshal([]byte(root.Leaves), &leaves); err != nil {
+		return nil, err
+	}
+	index := sort.Search(len(leaves), func(i int) bool { return leaves[i].BatchID >= batchID })
+	if index == len(leaves) || leaves[index].BatchID != batchID {
+		return nil, i18n.NewError("trace_chain.not_committed")
+	}
+
+	hashes := leafHashes(leaves)
+	return &TraceProof{
+		Root:     root,
+		Leaf:     leaves[index],
+		LeafHash: hashes[index],
+		Path:     tracechain.MerkleProof(hashes, index),
+	}, nil
+}
+
+// Backfill 为启用哈希链之前写入的溯源信息补写创建记录
+func (s *TraceChainService) Backfill() error {
+	var traces []models.TraceabilityInfo
+	if err := s.db.Where("id NOT IN (?)", s.db.Model(&models.TraceChainEntry{}).Select("trace_id")).
+		Order("batch_id, id").Find(&traces).Error; err != nil {
+		return err
+	}
+
+	for i := range traces {
+		if err := s.db.Transaction(func(tx *gorm.DB) error {
+			_, err := s.Append(tx, &traces[i], tracechain.ActionCreate, 0)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	if len(traces) > 0 {
+		log.Printf("已为%d条历史溯源信息补写哈希链记录", len(traces))
+	}
+	return nil
+}
+
+// record 将链上记录转换为可核验的格式，filter为nil时披露全部字段
+func (s *TraceChainService) record(entry *models.TraceChainEntry, filter func(key string) bool) (*tracechain.Record, error) {
+	record := &tracechain.Record{
+		BatchID:       entry.BatchID,
+		Seq:           entry.Seq,
+		TraceID:       entry.TraceID,
+		Version:       entry.Version,
+		Action:        entry.Action,
+		StageType:     entry.StageType,
+		SchemaVersion: entry.SchemaVersion,
+		Fields:        []tracechain.Field{},
+		PrevHash:      entry.PrevHash,
+		EntryHash:     entry.EntryHash,
+		CreatedAt:     entry.CreatedAt.Unix(),
+	}
+	if entry.Action == tracechain.ActionDelete {
+		return record, nil
+	}
+
+	var content map[string]interface{}
+	if err := json.Unmarshal([]byte(entry.Content), &content); err != nil {
+		return nil, err
+	}
+	var salts map[string]string
+	if err := json.Unmarshal([]byte(entry.Salts), &salts); err != nil {
+		return nil, err
+	}
+	var attachments interface{}
+	if entry.Attachments != "" {
+		if err := json.Unmarshal([]byte(entry.Attachments), &attachments); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := make([]string, 0, len(content))
+	for key := range content {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	digests := make(map[string]string, len(keys))
+	for _, key := range keys {
+		digest, err := tracechain.FieldDigest(salts[key], key, content[key])
+		if err != nil {
+			return nil, err
+		}
+		digests[key] = digest
+
+		field := tracechain.Field{Key: key, Digest: digest}
+		if filter == nil || filter(key) {
+			value, _ := tracechain.Canonical(content[key])
+			field.Salt, field.Value = salts[key], value
+		}
+		record.Fields = append(record.Fields, field)
+	}
+
+	attachmentsHash, err := tracechain.AttachmentsHash(attachments)
+	if err != nil {
+		return nil, err
+	}
+	record.AttachmentsHash = attachmentsHash
+	record.ContentHash = tracechain.ContentHash(entry.StageType, entry.SchemaVersion, digests, attachmentsHash)
+	return record, nil
+}
+
+// leafHashes 计算默克尔叶子哈希
+func leafHashes(leaves []TraceRootLeaf) []string {
+	hashes := make([]string, len(leaves))
+	for i, leaf := range leaves {
+		hashes[i] = tracechain.LeafHash(leaf.BatchID, leaf.Seq, leaf.HeadHash)
+	}
+	return hashes
+}
+
+// randomSalt 生成字段承诺使用的随机盐
+func randomSalt() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// TraceRootPublisher 定期发布溯源默克尔根
+type TraceRootPublisher struct {
+	chain    *TraceChainService
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewTraceRootPublisher 创建溯源默克尔根发布器
+func NewTraceRootPublisher(db *gorm.DB, cfg *config.Config) *TraceRootPublisher {
+	return &TraceRootPublisher{
+		chain:    NewTraceChainService(db),
+		interval: time.Duration(cfg.TraceChain.RootInterval) * time.Minute,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start 启动定时发布协程，间隔为0时不自动发布
+func (p *TraceRootPublisher) Start() {
+	if p.interval <= 0 {
+		close(p.done)
+		return
+	}
+
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				if _, err := p.chain.PublishAll(); err != nil {
+					log.Printf("溯源默克尔根发布失败: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop 停止定时发布协程
+func (p *TraceRootPublisher) Stop() {
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	<-p.done
+}
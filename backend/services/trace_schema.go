@@ -0,0 +1,228 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"time"
+
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+
+	"gorm.io/gorm"
+)
+
+// 溯源字段类型
+const (
+	FieldTypeText     = "text"     // 文本
+	FieldTypeDatetime = "datetime" // 日期时间
+	FieldTypePerson   = "person"   // 人员，值为 {"name": "...", "title": "...", "phone": "..."}
+	FieldTypeNumber   = "number"   // 数字
+	FieldTypeEnum     = "enum"     // 枚举，值必须是options之一
+	FieldTypeImage    = "image"    // 图片，值为附件地址或附件ID
+	FieldTypeFile     = "file"     // 文件，值为附件地址或附件ID
+)
+
+// TraceFieldTypes 支持的字段类型
+var TraceFieldTypes = []string{
+	FieldTypeText, FieldTypeDatetime, FieldTypePerson, FieldTypeNumber,
+	FieldTypeEnum, FieldTypeImage, FieldTypeFile,
+}
+
+// datetimeLayouts datetime字段接受的时间格式
+var datetimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+var fieldKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,49}$`)
+
+// SchemaField 阶段模板中的字段定义
+type SchemaField struct {
+	Key             string   `json:"key"`                  // 字段键，小写字母开头，只含小写字母、数字和下划线
+	Label           string   `json:"label"`                // 显示名称
+	Type            string   `json:"type"`                 // 字段类型
+	Required        bool     `json:"required"`             // 是否必填
+	ConsumerVisible bool     `json:"consumer_visible"`     // 是否对消费者展示
+	Options         []string `json:"options,omitempty"`    // 枚举选项，仅enum类型
+	MaxLength       int      `json:"max_length,omitempty"` // 最大长度，仅text类型，0表示不限制
+	Min             *float64 `json:"min,omitempty"`        // 最小值，仅number类型
+	Max             *float64 `json:"max,omitempty"`        // 最大值，仅number类型
+}
+
+// ValidateSchemaFields 校验阶段模板字段定义
+func ValidateSchemaFields(fields []SchemaField) error {
+	if len(fields) == 0 {
+		return i18n.NewError("trace_schema.fields_required")
+	}
+
+	seen := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		if !fieldKeyPattern.MatchString(field.Key) {
+			return i18n.NewError("trace_schema.field_key_invalid", field.Key)
+		}
+		if seen[field.Key] {
+			return i18n.NewError("trace_schema.field_key_duplicate", field.Key)
+		}
+		seen[field.Key] = true
+
+		if field.Label == "" {
+			return i18n.NewError("trace_schema.field_label_required", field.Key)
+		}
+		if !isTraceFieldType(field.Type) {
+			return i18n.NewError("trace_schema.field_type_invalid", field.Key, field.Type)
+		}
+		if field.Type == FieldTypeEnum && len(field.Options) == 0 {
+			return i18n.NewError("trace_schema.enum_options_required", field.Key)
+		}
+		if field.Min != nil && field.Max != nil && *field.Min > *field.Max {
+			return i18n.NewError("trace_schema.number_range_invalid", field.Key)
+		}
+	}
+	return nil
+}
+
+// ValidateTraceContent 按阶段模板校验溯源信息内容，不允许出现模板外的字段
+func ValidateTraceContent(fields []SchemaField, content map[string]interface{}) error {
+	defined := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		defined[field.Key] = true
+
+		value, exists := content[field.Key]
+		if !exists || value == nil || value == "" {
+			if field.Required {
+				return i18n.NewError("trace.field_required", field.Label)
+			}
+			continue
+		}
+		if err := validateFieldValue(field, value); err != nil {
+			return err
+		}
+	}
+
+	for key := range content {
+		if !defined[key] {
+			return i18n.NewError("trace.field_unknown", key)
+		}
+	}
+	return nil
+}
+
+// validateFieldValue 校验单个字段的值
+func validateFieldValue(field SchemaField, value interface{}) error {
+	invalid := i18n.NewError("trace.field_invalid", field.Label)
+
+	switch field.Type {
+	case FieldTypeText:
+		text, ok := value.(string)
+		if !ok {
+			return invalid
+		}
+		if field.MaxLength > 0 && len([]rune(text)) > field.MaxLength {
+			return i18n.NewError("trace.field_too_long", field.Label, field.MaxLength)
+		}
+	case FieldTypeDatetime:
+		text, ok := value.(string)
+		if !ok || !isDatetime(text) {
+			return invalid
+		}
+	case FieldTypePerson:
+		person, ok := value.(map[string]interface{})
+		if !ok {
+			return invalid
+		}
+		if name, _ := person["name"].(string); name == "" {
+			return invalid
+		}
+	case FieldTypeNumber:
+		number, ok := value.(float64)
+		if !ok {
+			return invalid
+		}
+		if (field.Min != nil && number < *field.Min) || (field.Max != nil && number > *field.Max) {
+			return i18n.NewError("trace.field_out_of_range", field.Label)
+		}
+	case FieldTypeEnum:
+		text, ok := value.(string)
+		if !ok || !containsString(field.Options, text) {
+			return invalid
+		}
+	case FieldTypeImage, FieldTypeFile:
+		switch v := value.(type) {
+		case string:
+		case float64:
+			if v <= 0 {
+				return invalid
+			}
+		default:
+			return invalid
+		}
+	}
+	return nil
+}
+
+// ConsumerTraceContent 只保留对消费者展示的字段，并附带字段名称和类型用于渲染
+func ConsumerTraceContent(fields []SchemaField, content map[string]interface{}) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(fields))
+	for _, field := range fields {
+		value, exists := content[field.Key]
+		if !field.ConsumerVisible || !exists {
+			continue
+		}
+		result = append(result, map[string]interface{}{
+			"key":   field.Key,
+			"label": field.Label,
+			"type":  field.Type,
+			"value": value,
+		})
+	}
+	return result
+}
+
+// CurrentTraceSchema 查询商户某阶段当前生效的模板，未定义模板时返回nil
+func CurrentTraceSchema(db *gorm.DB, merchantID uint, stageType int) (*models.TraceStageSchema, []SchemaField, error) {
+	var schema models.TraceStageSchema
+	err := db.Where("merchant_id = ? AND stage_type = ?", merchantID, stageType).
+		Order("version desc").First(&schema).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fields, err := ParseSchemaFields(schema.Fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &schema, fields, nil
+}
+
+// ParseSchemaFields 解析模板中保存的字段定义
+func ParseSchemaFields(data string) ([]SchemaField, error) {
+	var fields []SchemaField
+	err := json.Unmarshal([]byte(data), &fields)
+	return fields, err
+}
+
+// isTraceFieldType 判断是否为支持的字段类型
+func isTraceFieldType(fieldType string) bool {
+	return containsString(TraceFieldTypes, fieldType)
+}
+
+// isDatetime 判断字符串是否为支持的时间格式
+func isDatetime(value string) bool {
+	for _, layout := range datetimeLayouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString 判断字符串切片是否包含指定值
+func containsString(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
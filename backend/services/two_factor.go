@@ -0,0 +1,308 @@
+package services
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"anti-fake-system/utils"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	totpSkew           = 1  // 允许前后各1个时间步（30秒）的时钟偏差
+	recoveryCodeCount  = 10 // 每次生成的恢复码数量
+	recoveryCodeLength = 10 // 恢复码长度（十六进制字符），显示时每5位用-分隔
+)
+
+// TwoFactorStatus 用户的两步验证状态
+type TwoFactorStatus struct {
+	Enabled       bool  `json:"enabled"`        // 是否已启用
+	Required      bool  `json:"required"`       // 角色是否要求必须启用
+	RecoveryCodes int64 `json:"recovery_codes"` // 剩余可用的恢复码数量
+}
+
+// TwoFactorSetup 绑定验证器App的信息，前端将otpauth_url渲染为二维码供扫描，无法扫码时手动输入密钥
+type TwoFactorSetup struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauth_url"`
+}
+
+// TwoFactorService TOTP两步验证服务（RFC 6238）
+// TOTP密钥由主密钥加密保存，同一时间步的验证码只能使用一次；恢复码只保存摘要，每个只能使用一次。
+type TwoFactorService struct {
+	db          *gorm.DB
+	cfg         *config.Config
+	keys        *KeyManager
+	permissions *PermissionService
+}
+
+// NewTwoFactorService 创建两步验证服务
+func NewTwoFactorService(db *gorm.DB, cfg *config.Config, keys *KeyManager, permissions *PermissionService) *TwoFactorService {
+	return &TwoFactorService{db: db, cfg: cfg, keys: keys, permissions: permissions}
+}
+
+// Status 查询用户的两步验证状态
+func (s *TwoFactorService) Status(user *models.User) (*TwoFactorStatus, error) {
+	required, err := s.Required(user)
+	if err != nil {
+		return nil, err
+	}
+	status := &TwoFactorStatus{Required: required}
+
+	record, err := s.record(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || !record.Enabled {
+		return status, nil
+	}
+	status.Enabled = true
+	err = s.db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&status.RecoveryCodes).Error
+	return status, err
+}
+
+// Required 判断用户的角色是否要求登录时必须通过两步验证
+// 自定义角色按角色设置，内置角色按TWO_FACTOR_REQUIRED_ROLES配置。
+func (s *TwoFactorService) Required(user *models.User) (bool, error) {
+	roles, err := s.permissions.UserRoles(user.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, role := range roles {
+		if role.TwoFactor || (role.Builtin && s.requiredBuiltin(role.Code)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// EnabledUsers 返回已启用两步验证的用户ID集合
+func (s *TwoFactorService) EnabledUsers(userIDs []uint) (map[uint]bool, error) {
+	enabled := make(map[uint]bool)
+	if len(userIDs) == 0 {
+		return enabled, nil
+	}
+	var ids []uint
+	if err := s.db.Model(&models.UserTwoFactor{}).Where("user_id IN ? AND enabled = ?", userIDs, true).
+		Pluck("user_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		enabled[id] = true
+	}
+	return enabled, nil
+}
+
+// Setup 生成新的TOTP密钥，提交验证码启用前可以重复调用，每次都替换为新密钥
+func (s *TwoFactorService) Setup(user *models.User) (*TwoFactorSetup, error) {
+	record, err := s.record(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if record != nil && record.Enabled {
+		return nil, i18n.NewError("two_factor.already_enabled")
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := s.keys.EncryptSecret([]byte(secret))
+	if err != nil {
+		return nil, err
+	}
+	pending := models.UserTwoFactor{UserID: user.ID, Secret: encrypted}
+	if err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"secret": encrypted, "last_step": 0, "updated_at": time.Now()}),
+	}).Create(&pending).Error; err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorSetup{
+		Secret:     secret,
+		OTPAuthURL: utils.TOTPURI(s.cfg.TwoFactor.Issuer, user.Username, secret),
+	}, nil
+}
+
+// Enable 校验绑定后的第一个验证码并启用两步验证，返回新生成的恢复码（只在此时返回明文）
+func (s *TwoFactorService) Enable(user *models.User, code string) ([]string, error) {
+	record, err := s.record(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, i18n.NewError("two_factor.not_setup")
+	}
+	if record.Enabled {
+		return nil, i18n.NewError("two_factor.already_enabled")
+	}
+	if err := s.verifyTOTP(record, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(record).Updates(map[string]interface{}{"enabled": true, "enabled_at": now}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify 校验已启用两步验证的用户提交的验证码，也可以使用一个未使用过的恢复码
+func (s *TwoFactorService) Verify(user *models.User, code string) error {
+	record, err := s.record(user.ID)
+	if err != nil {
+		return err
+	}
+	if record == nil || !record.Enabled {
+		return i18n.NewError("two_factor.not_enabled")
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == utils.TOTPDigits {
+		return s.verifyTOTP(record, code)
+	}
+	return s.useRecoveryCode(user.ID, code)
+}
+
+// Disable 校验验证码后关闭两步验证，角色要求必须启用时不能关闭
+func (s *TwoFactorService) Disable(user *models.User, code string) error {
+	required, err := s.Required(user)
+	if err != nil {
+		return err
+	}
+	if required {
+		return i18n.NewError("two_factor.required")
+	}
+	if err := s.Verify(user, code); err != nil {
+		return err
+	}
+	return s.Reset(user.ID)
+}
+
+// RegenerateRecoveryCodes 校验验证码后重新生成恢复码，原有恢复码全部失效
+func (s *TwoFactorService) RegenerateRecoveryCodes(user *models.User, code string) ([]string, error) {
+	if err := s.Verify(user, code); err != nil {
+		return nil, err
+	}
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	return codes, err
+}
+
+// Reset 删除用户的TOTP密钥和恢复码，用于用户关闭或管理员为丢失设备的用户重置
+func (s *TwoFactorService) Reset(userID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.UserTwoFactor{}).Error
+	})
+}
+
+// record 查询用户的两步验证记录，不存在时返回nil
+func (s *TwoFactorService) record(userID uint) (*models.UserTwoFactor, error) {
+	var record models.UserTwoFactor
+	if err := s.db.Where("user_id = ?", userID).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &record, nil
+}
+
+// verifyTOTP 校验TOTP验证码，通过后记录时间步，同一时间步及更早的验证码不能再次使用
+func (s *TwoFactorService) verifyTOTP(record *models.UserTwoFactor, code string) error {
+	secret, err := s.keys.DecryptSecret(record.Secret)
+	if err != nil {
+		return err
+	}
+	step, ok := utils.ValidateTOTP(string(secret), strings.TrimSpace(code), time.Now(), totpSkew)
+	if !ok {
+		return i18n.NewError("two_factor.code_invalid")
+	}
+
+	// 条件更新保证并发提交同一验证码时只有一个请求通过
+	result := s.db.Model(&models.UserTwoFactor{}).Where("id = ? AND last_step < ?", record.ID, step).Update("last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return i18n.NewError("two_factor.code_invalid")
+	}
+	return nil
+}
+
+// useRecoveryCode 使用一个恢复码，已使用或不存在时返回错误
+func (s *TwoFactorService) useRecoveryCode(userID uint, code string) error {
+	normalized := normalizeRecoveryCode(code)
+	if len(normalized) != recoveryCodeLength {
+		return i18n.NewError("two_factor.code_invalid")
+	}
+	result := s.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(normalized)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return i18n.NewError("two_factor.code_invalid")
+	}
+	return nil
+}
+
+// requiredBuiltin 判断内置角色是否在TWO_FACTOR_REQUIRED_ROLES中
+func (s *TwoFactorService) requiredBuiltin(code string) bool {
+	for _, required := range strings.Split(s.cfg.TwoFactor.RequiredRoles, ",") {
+		if required = strings.TrimSpace(required); required != "" && required == code {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceRecoveryCodes 删除用户原有的恢复码并生成新的一组，返回明文
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		raw, err := randomHex(recoveryCodeLength / 2)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = fmt.Sprintf("%s-%s", raw[:recoveryCodeLength/2], raw[recoveryCodeLength/2:])
+		records[i] = models.RecoveryCode{UserID: userID, CodeHash: hashToken(raw)}
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode 去掉恢复码中的分隔符和空白并转为小写
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
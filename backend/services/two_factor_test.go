@@ -0,0 +1,70 @@
+package services
+
+import (
+	"anti-fake-system/config"
+	"fmt"
+	"testing"
+)
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"abcde-12345", "abcde12345"},
+		{"ABCDE-12345", "abcde12345"},
+		{" abcde 12345 ", "abcde12345"},
+		{"ab-cd-e1-23-45", "abcde12345"},
+		{"abcde12345", "abcde12345"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeRecoveryCode(tt.code); got != tt.want {
+			t.Errorf("normalizeRecoveryCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestRecoveryCodeFormat(t *testing.T) {
+	// 显示的恢复码与replaceRecoveryCodes的格式一致，规范化后应与保存摘要时的原始值相同
+	for i := 0; i < 20; i++ {
+		raw, err := randomHex(recoveryCodeLength / 2)
+		if err != nil {
+			t.Fatalf("randomHex error: %v", err)
+		}
+		if len(raw) != recoveryCodeLength {
+			t.Fatalf("raw code length = %d, want %d", len(raw), recoveryCodeLength)
+		}
+		display := fmt.Sprintf("%s-%s", raw[:recoveryCodeLength/2], raw[recoveryCodeLength/2:])
+		if len(display) != recoveryCodeLength+1 || display[recoveryCodeLength/2] != '-' {
+			t.Fatalf("display code %q is not in xxxxx-xxxxx format", display)
+		}
+		if got := normalizeRecoveryCode(display); got != raw || hashToken(got) != hashToken(raw) {
+			t.Errorf("normalizeRecoveryCode(%q) = %q, want %q", display, got, raw)
+		}
+	}
+}
+
+func TestTwoFactorRequiredBuiltin(t *testing.T) {
+	tests := []struct {
+		name     string
+		required string
+		code     string
+		want     bool
+	}{
+		{"listed", "platform_admin,merchant_admin", "merchant_admin", true},
+		{"listed with spaces", " platform_admin , merchant_admin ", "platform_admin", true},
+		{"not listed", "platform_admin", "merchant_admin", false},
+		{"empty config", "", "platform_admin", false},
+		{"empty code", "platform_admin,", "", false},
+		{"no prefix match", "platform_admin", "platform", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &TwoFactorService{cfg: &config.Config{TwoFactor: config.TwoFactorConfig{RequiredRoles: tt.required}}}
+			if got := s.requiredBuiltin(tt.code); got != tt.want {
+				t.Errorf("requiredBuiltin(%q) = %v, want %v", tt.code, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// archiveBatchSize 归档导出和重新导入时每批处理的记录数
+const archiveBatchSize = 1000
+
+// VerifyArchiver 验证记录归档器
+// 定期将超过保留期的月份表导出为gzip压缩的JSON Lines文件并删除该表，需要调查时可重新导入。
+type VerifyArchiver struct {
+	db   *gorm.DB
+	repo *VerifyRecordRepository
+	cfg  config.VerifyArchiveConfig
+
+	mu   sync.Mutex // 保证同一时间只有一个归档或导入操作
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewVerifyArchiver 创建验证记录归档器
+func NewVerifyArchiver(db *gorm.DB, repo *VerifyRecordRepository, cfg *config.Config) *VerifyArchiver {
+	return &VerifyArchiver{
+		db:   db,
+		repo: repo,
+		cfg:  cfg.VerifyArchive,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Start 启动定时归档协程，保留月数为0时不自动归档
+func (a *VerifyArchiver) Start() {
+	if a.cfg.RetentionMonths <= 0 {
+		close(a.done)
+		return
+	}
+
+	go func() {
+		defer close(a.done)
+
+		ticker := time.NewTicker(time.Duration(a.cfg.CheckInterval) * time.Minute)
+		defer ticker.Stop()
+
+		for {
+			if _, err := a.ArchiveExpired(); err != nil {
+				log.Printf("验证记录归档失败: %v", err)
+			}
+			select {
+			case <-a.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop 停止定时归档协程，等待进行中的归档完成
+func (a *VerifyArchiver) Stop() {
+	select {
+	case <-a.stop:
+	default:
+		close(a.stop)
+	}
+	<-a.done
+}
+
+// ArchiveExpired 归档所有超过保留期的月份表，已重新导入的月份需手动释放后才会再次归档
+func (a *VerifyArchiver) ArchiveExpired() ([]string, error) {
+	if a.cfg.RetentionMonths <= 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	cutoff := VerifyRecordMonth(time.Date(now.Year(), now.Month()-time.Month(a.cfg.RetentionMonths), 1, 0, 0, 0, 0, now.Location()))
+
+	var archived []string
+	for _, month := range a.repo.Months() {
+		if month >= cutoff {
+			break
+		}
+
+		var existing models.VerificationArchive
+		if err := a.db.Where("month = ?", month).First(&existing).Error; err == nil && existing.RestoredAt != nil {
+			continue
+		}
+
+		if _, err := a.Archive(month); err != nil {
+			return archived, err
+		}
+		archived = append(archived, month)
+	}
+	return archived, nil
+}
+
+// Archive 将月份表导出到归档文件并删除该表
+func (a *VerifyArchiver) Archive(month string) (*models.VerificationArchive, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.repo.HasMonth(month) {
+		return nil, i18n.NewError("verify_archive.month_not_found", month)
+	}
+	if month == VerifyRecordMonth(time.Now()) {
+		return nil, i18n.NewError("verify_archive.current_month")
+	}
+
+	if err := os.MkdirAll(a.cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(a.cfg.Dir, VerifyRecordTable(month)+".jsonl.gz")
+
+	count, checksum, err := a.export(month, path)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := models.VerificationArchive{Month: month}
+	if err := a.db.Where("month = ?", month).FirstOrInit(&archive).Error; err != nil {
+		return nil, err
+	}
+	archive.FilePath = path
+	archive.RecordCount = count
+	archive.Checksum = checksum
+	archive.ArchivedAt = time.Now()
+	archive.RestoredAt = nil
+	if err := a.db.Save(&archive).Error; err != nil {
+		return nil, err
+	}
+
+	if err := a.repo.DropMonth(month); err != nil {
+		return nil, err
+	}
+	log.Printf("验证记录月份表 %s 已归档到 %s，共%d条", month, path, count)
+	return &archive, nil
+}
+
+// Restore 将归档文件重新导入为月份表，导入后可通过正常的查询接口调查
+func (a *VerifyArchiver) Restore(month string) (*models.VerificationArchive, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var archive models.VerificationArchive
+	if err := a.db.Where("month = ?", month).First(&archive).Error; err != nil {
+		return nil, i18n.NewError("verify_archive.not_found", month)
+	}
+	if a.repo.HasMonth(month) {
+		return nil, i18n.NewError("verify_archive.already_restored", month)
+	}
+
+	checksum, err := fileChecksum(archive.FilePath)
+	if err != nil {
+		return nil, i18n.NewError("verify_archive.file_missing", archive.FilePath)
+	}
+	if checksum != archive.Checksum {
+		return nil, i18n.NewError("verify_archive.checksum_mismatch", month)
+	}
+
+	if err := a.repo.EnsureMonth(month); err != nil {
+		return nil, err
+	}
+	if err := a.importFile(month, archive.FilePath); err != nil {
+		// 导入失败时删除不完整的表，归档文件保持不变
+		if dropErr := a.repo.DropMonth(month); dropErr != nil {
+			log.Printf("删除不完整的月份表 %s 失败: %v", month, dropErr)
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	archive.RestoredAt = &now
+	if err := a.db.Save(&archive).Error; err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+// Release 删除重新导入的月份表，归档文件保留
+func (a *VerifyArchiver) Release(month string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var archive models.VerificationArchive
+	if err := a.db.Where("month = ? AND restored_at IS NOT NULL", month).First(&archive).Error; err != nil {
+		return i18n.NewError("verify_archive.not_restored", month)
+	}
+	if err := a.repo.DropMonth(month); err != nil {
+		return err
+	}
+	return a.db.Model(&archive).Update("restored_at", nil).Error
+}
+
+// export 分批读取月份表写入gzip文件，先写临时文件再重命名，返回记录数和文件校验值
+func (a *VerifyArchiver) export(month, path string) (int64, string, error) {
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, "", err
+	}
+	defer os.Remove(tmpPath)
+
+	hasher := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(file, hasher))
+	encoder := json.NewEncoder(gz)
+
+	var count int64
+	var batch []models.VerificationRecord
+	result := a.db.Table(VerifyRecordTable(month)).Order("id").
+		FindInBatches(&batch, archiveBatchSize, func(tx *gorm.DB, _ int) error {
+			for i := range batch {
+				if err := encoder.Encode(&batch[i]); err != nil {
+					return err
+				}
+			}
+			count += int64(len(batch))
+			return nil
+		})
+	if result.Error != nil {
+		file.Close()
+		return 0, "", result.Error
+	}
+
+	if err := gz.Close(); err != nil {
+		file.Close()
+		return 0, "", err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return 0, "", err
+	}
+	if err := file.Close(); err != nil {
+		return 0, "", err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return 0, "", err
+	}
+	return count, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// importFile 读取归档文件分批写入月份表
+func (a *VerifyArchiver) importFile(month, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	table := a.db.Table(VerifyRecordTable(month)).Omit(clause.Associations).Session(&gorm.Session{})
+	decoder := json.NewDecoder(gz)
+	batch := make([]models.VerificationRecord, 0, archiveBatchSize)
+	for {
+		var record models.VerificationRecord
+		err := decoder.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		batch = append(batch, record)
+		if len(batch) == archiveBatchSize {
+			if err := table.CreateInBatches(&batch, archiveBatchSize).Error; err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		return table.CreateInBatches(&batch, len(batch)).Error
+	}
+	return nil
+}
+
+// fileChecksum 计算文件的SHA-256校验值
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
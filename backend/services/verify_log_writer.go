@@ -0,0 +1,375 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"anti-fake-system/config"
+	"anti-fake-system/models"
+)
+
+// VerifyLogMetrics 验证记录写入指标
+// 正常情况下 Enqueued + SyncWrites = Written + Failed + QueueLength，Failed 中的记录都已落盘到 SpillFile，
+// 之后由回放协程补录，补录成功的记录计入 Replayed。
+type VerifyLogMetrics struct {
+	Enqueued      uint64 `json:"enqueued"`       // 进入内存队列的记录数
+	SyncWrites    uint64 `json:"sync_writes"`    // 因队列已满或已关闭而同步写入的记录数
+	Written       uint64 `json:"written"`        // 成功写入数据库的记录数（含同步写入）
+	Failed        uint64 `json:"failed"`         // 重试后仍写入失败的记录数
+	Spilled       uint64 `json:"spilled"`        // 写入失败后成功落盘的记录数
+	Batches       uint64 `json:"batches"`        // 已执行的批量写入次数
+	Retries       uint64 `json:"retries"`        // 批量写入重试次数
+	Replayed      uint64 `json:"replayed"`       // 从落盘文件补录成功的记录数
+	QueueLength   int    `json:"queue_length"`   // 当前队列中等待写入的记录数
+	QueueCapacity int    `json:"queue_capacity"` // 队列容量
+}
+
+// VerifyLogWriter 验证记录异步批量写入器
+// 验证接口只负责入队，后台协程在达到批量大小或刷新间隔时使用多行INSERT写入数据库。
+type VerifyLogWriter struct {
+	repo  *VerifyRecordRepository
+	cfg   config.VerifyLogConfig
+	queue chan models.VerificationRecord
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+
+	enqueued   uint64
+	syncWrites uint64
+	written    uint64
+	failed     uint64
+	spilled    uint64
+	batches    uint64
+	retries    uint64
+	replayed   uint64
+
+	spillMu sync.Mutex
+}
+
+// NewVerifyLogWriter 创建验证记录写入器，记录经由仓库写入对应的月份表
+func NewVerifyLogWriter(repo *VerifyRecordRepository, cfg *config.Config) *VerifyLogWriter {
+	return &VerifyLogWriter{
+		repo:  repo,
+		cfg:   cfg.VerifyLog,
+		queue: make(chan models.VerificationRecord, cfg.VerifyLog.BufferSize),
+		done:  make(chan struct{}),
+	}
+}
+
+// Start 启动后台批量写入协程和落盘文件回放协程
+func (w *VerifyLogWriter) Start() {
+	go w.run()
+	go w.replayLoop()
+}
+
+// Write 写入一条验证记录
+// 队列有空位时立即返回；队列已满时最多等待EnqueueTimeout（背压），仍无空位则同步写入，保证记录不丢失。
+func (w *VerifyLogWriter) Write(record models.VerificationRecord) {
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		w.writeSync(record)
+		return
+	}
+
+	select {
+	case w.queue <- record:
+		w.mu.RUnlock()
+		atomic.AddUint64(&w.enqueued, 1)
+		return
+	default:
+	}
+
+	timer := time.NewTimer(time.Duration(w.cfg.EnqueueTimeout) * time.Millisecond)
+	defer timer.Stop()
+
+	select {
+	case w.queue <- record:
+		w.mu.RUnlock()
+		atomic.AddUint64(&w.enqueued, 1)
+	case <-timer.C:
+		w.mu.RUnlock()
+		w.writeSync(record)
+	}
+}
+
+// Close 停止接收新记录并等待队列中的记录全部写入，ctx超时后返回错误
+func (w *VerifyLogWriter) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Metrics 返回当前写入指标
+func (w *VerifyLogWriter) Metrics() VerifyLogMetrics {
+	return VerifyLogMetrics{
+		Enqueued:      atomic.LoadUint64(&w.enqueued),
+		SyncWrites:    atomic.LoadUint64(&w.syncWrites),
+		Written:       atomic.LoadUint64(&w.written),
+		Failed:        atomic.LoadUint64(&w.failed),
+		Spilled:       atomic.LoadUint64(&w.spilled),
+		Batches:       atomic.LoadUint64(&w.batches),
+		Retries:       atomic.LoadUint64(&w.retries),
+		Replayed:      atomic.LoadUint64(&w.replayed),
+		QueueLength:   len(w.queue),
+		QueueCapacity: cap(w.queue),
+	}
+}
+
+// run 批量写入主循环，队列关闭后写完剩余记录再退出
+func (w *VerifyLogWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(time.Duration(w.cfg.FlushInterval) * time.Millisecond)
+	defer ticker.Stop()
+
+	batch := make([]models.VerificationRecord, 0, w.cfg.BatchSize)
+	for {
+		select {
+		case record, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= w.cfg.BatchSize {
+				w.flush(batch)
+				batch = make([]models.VerificationRecord, 0, w.cfg.BatchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = make([]models.VerificationRecord, 0, w.cfg.BatchSize)
+			}
+		}
+	}
+}
+
+// flush 多行INSERT写入一批记录，失败时按指数退避重试，最终失败则落盘
+func (w *VerifyLogWriter) flush(batch []models.VerificationRecord) {
+	if len(batch) == 0 {
+		return
+	}
+	atomic.AddUint64(&w.batches, 1)
+
+	var err error
+	delay := 100 * time.Millisecond
+	for attempt := 0; attempt <= w.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			atomic.AddUint64(&w.retries, 1)
+			time.Sleep(delay)
+			delay *= 2
+		}
+		if err = w.repo.Create(batch); err == nil {
+			atomic.AddUint64(&w.written, uint64(len(batch)))
+			return
+		}
+	}
+
+	log.Printf("验证记录批量写入失败，共%d条: %v", len(batch), err)
+	atomic.AddUint64(&w.failed, uint64(len(batch)))
+	w.spill(batch)
+}
+
+// writeSync 同步写入单条记录
+func (w *VerifyLogWriter) writeSync(record models.VerificationRecord) {
+	atomic.AddUint64(&w.syncWrites, 1)
+	if err := w.repo.Create([]models.VerificationRecord{record}); err != nil {
+		log.Printf("验证记录同步写入失败: %v", err)
+		atomic.AddUint64(&w.failed, 1)
+		w.spill([]models.VerificationRecord{record})
+		return
+	}
+	atomic.AddUint64(&w.written, 1)
+}
+
+// spill 将写入失败的记录追加到落盘文件
+func (w *VerifyLogWriter) spill(records []models.VerificationRecord) {
+	if w.cfg.SpillFile == "" {
+		return
+	}
+
+	w.spillMu.Lock()
+	defer w.spillMu.Unlock()
+
+	file, err := w.openSpill()
+	if err != nil {
+		log.Printf("验证记录落盘文件打开失败: %v", err)
+		return
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for i := range records {
+		records[i].ID = 0
+		if err := encoder.Encode(&records[i]); err != nil {
+			log.Printf("验证记录落盘失败: %v", err)
+			return
+		}
+		atomic.AddUint64(&w.spilled, 1)
+	}
+}
+
+// openSpill 以追加方式打开落盘文件，调用方需持有spillMu
+func (w *VerifyLogWriter) openSpill() (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(w.cfg.SpillFile), 0o755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(w.cfg.SpillFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+}
+
+// replayLoop 启动时回放一次落盘文件，之后按ReplayInterval定期回放，写入器关闭后退出
+func (w *VerifyLogWriter) replayLoop() {
+	if w.cfg.SpillFile == "" {
+		return
+	}
+	w.replaySpill()
+	if w.cfg.ReplayInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(w.cfg.ReplayInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.replaySpill()
+		}
+	}
+}
+
+// replaySpill 将落盘文件中的记录按批量重新写入数据库
+// 回放前先将落盘文件改名为 .replay，回放期间新的落盘记录写入新文件；某一批写入失败时，
+// 该批及剩余记录原样追加回落盘文件等待下次回放，最后删除 .replay 文件，保证记录不丢失也不重复写入。
+func (w *VerifyLogWriter) replaySpill() {
+	replayFile := w.cfg.SpillFile + ".replay"
+
+	// 上次回放中断时遗留的 .replay 文件优先处理
+	w.spillMu.Lock()
+	if _, err := os.Stat(replayFile); os.IsNotExist(err) {
+		if err := os.Rename(w.cfg.SpillFile, replayFile); err != nil {
+			w.spillMu.Unlock()
+			if !os.IsNotExist(err) {
+				log.Printf("验证记录落盘文件改名失败: %v", err)
+			}
+			return
+		}
+	}
+	w.spillMu.Unlock()
+
+	file, err := os.Open(replayFile)
+	if err != nil {
+		log.Printf("验证记录回放文件打开失败: %v", err)
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var batch []models.VerificationRecord
+	var replayErr error
+	for scanner.Scan() {
+		var record models.VerificationRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.Printf("验证记录回放跳过无法解析的行: %v", err)
+			continue
+		}
+		batch = append(batch, record)
+		if len(batch) < w.cfg.BatchSize {
+			continue
+		}
+		if replayErr = w.replayBatch(batch); replayErr != nil {
+			break
+		}
+		batch = nil
+	}
+	if replayErr == nil && scanner.Err() == nil {
+		replayErr = w.replayBatch(batch)
+		batch = nil
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("验证记录回放文件读取失败: %v", err)
+		return
+	}
+
+	if replayErr != nil {
+		log.Printf("验证记录回放写入失败，剩余记录保留到下次回放: %v", replayErr)
+		if err := w.respill(batch, scanner); err != nil {
+			log.Printf("验证记录回放剩余记录落盘失败: %v", err)
+			return
+		}
+	}
+
+	file.Close()
+	if err := os.Remove(replayFile); err != nil {
+		log.Printf("验证记录回放文件删除失败: %v", err)
+	}
+}
+
+// replayBatch 写入一批回放记录
+func (w *VerifyLogWriter) replayBatch(batch []models.VerificationRecord) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := w.repo.Create(batch); err != nil {
+		return err
+	}
+	atomic.AddUint64(&w.replayed, uint64(len(batch)))
+	return nil
+}
+
+// respill 将写入失败的一批记录和回放文件中尚未读取的行追加回落盘文件
+func (w *VerifyLogWriter) respill(batch []models.VerificationRecord, rest *bufio.Scanner) error {
+	w.spillMu.Lock()
+	defer w.spillMu.Unlock()
+
+	file, err := w.openSpill()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for i := range batch {
+		batch[i].ID = 0
+		if err := encoder.Encode(&batch[i]); err != nil {
+			return err
+		}
+	}
+	writer := bufio.NewWriter(file)
+	for rest.Scan() {
+		writer.Write(rest.Bytes())
+		if err := writer.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := rest.Err(); err != nil {
+		return err
+	}
+	return file.Close()
+}
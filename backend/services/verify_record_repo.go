@@ -0,0 +1,217 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"anti-fake-system/models"
+
+	"gorm.io/gorm"
+)
+
+// 验证记录按月分表，表名为 verification_records_YYYYMM
+const (
+	VerifyRecordTablePrefix = "verification_records_"
+	verifyRecordLegacyTable = "verification_records"
+	verifyRecordMonthLayout = "200601"
+)
+
+// VerifyRecordRepository 验证记录仓库
+// 写入时按验证时间路由到对应月份的表，查询时只联合时间范围覆盖到的月份表，避免扫描全部历史数据。
+type VerifyRecordRepository struct {
+	db *gorm.DB
+
+	mu     sync.RWMutex
+	months map[string]bool // 已存在的月份表
+}
+
+// NewVerifyRecordRepository 创建验证记录仓库
+func NewVerifyRecordRepository(db *gorm.DB) *VerifyRecordRepository {
+	return &VerifyRecordRepository{db: db, months: make(map[string]bool)}
+}
+
+// VerifyRecordMonth 返回时间所属的月份标识，如 202601
+func VerifyRecordMonth(t time.Time) string {
+	return t.Format(verifyRecordMonthLayout)
+}
+
+// VerifyRecordTable 返回月份对应的表名
+func VerifyRecordTable(month string) string {
+	return VerifyRecordTablePrefix + month
+}
+
+// Init 加载已有的月份表，创建当月表，并将旧的单表数据迁移到月份表
+func (r *VerifyRecordRepository) Init() error {
+	tables, err := r.db.Migrator().GetTables()
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if month, ok := parseVerifyRecordTable(table); ok {
+			r.months[month] = true
+		}
+	}
+
+	if err := r.migrateLegacy(); err != nil {
+		return err
+	}
+	return r.EnsureMonth(VerifyRecordMonth(time.Now()))
+}
+
+// EnsureMonth 确保月份表存在
+func (r *VerifyRecordRepository) EnsureMonth(month string) error {
+	r.mu.RLock()
+	exists := r.months[month]
+	r.mu.RUnlock()
+	if exists {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.months[month] {
+		return nil
+	}
+	if err := r.db.Table(VerifyRecordTable(month)).AutoMigrate(&models.VerificationRecord{}); err != nil {
+		return err
+	}
+	r.months[month] = true
+	return nil
+}
+
+// HasMonth 判断月份表是否存在（未归档或已重新导入）
+func (r *VerifyRecordRepository) HasMonth(month string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.months[month]
+}
+
+// Months 返回按时间升序排列的月份表列表
+func (r *VerifyRecordRepository) Months() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	months := make([]string, 0, len(r.months))
+	for month := range r.months {
+		months = append(months, month)
+	}
+	sort.Strings(months)
+	return months
+}
+
+// DropMonth 删除月份表，用于归档完成或释放重新导入的数据
+func (r *VerifyRecordRepository) DropMonth(month string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.db.Migrator().DropTable(VerifyRecordTable(month)); err != nil {
+		return err
+	}
+	delete(r.months, month)
+	return nil
+}
+
+// Create 按验证时间将记录分组写入对应的月份表
+func (r *VerifyRecordRepository) Create(records []models.VerificationRecord) error {
+	groups := make(map[string][]models.VerificationRecord)
+	for _, record := range records {
+		month := VerifyRecordMonth(record.VerifyTime)
+		groups[month] = append(groups[month], record)
+	}
+
+	for month, group := range groups {
+		if err := r.EnsureMonth(month); err != nil {
+			return err
+		}
+		if err := r.db.Table(VerifyRecordTable(month)).CreateInBatches(&group, len(group)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Range 返回覆盖[start, end]时间范围的查询，零值表示不限制
+// 返回的查询以 verification_records 为别名，调用方仍需按需添加verify_time条件。
+func (r *VerifyRecordRepository) Range(start, end time.Time) *gorm.DB {
+	var tables []string
+	for _, month := range r.Months() {
+		if !start.IsZero() && month < VerifyRecordMonth(start) {
+			continue
+		}
+		if !end.IsZero() && month > VerifyRecordMonth(end) {
+			continue
+		}
+		tables = append(tables, VerifyRecordTable(month))
+	}
+
+	query := r.db.Model(&models.VerificationRecord{})
+	switch len(tables) {
+	case 0:
+		// 范围内没有数据，使用当月表返回空结果
+		return query.Table(fmt.Sprintf("`%s` AS verification_records", VerifyRecordTable(VerifyRecordMonth(time.Now())))).
+			Where("1 = 0")
+	case 1:
+		return query.Table(fmt.Sprintf("`%s` AS verification_records", tables[0]))
+	}
+
+	selects := make([]string, len(tables))
+	for i, table := range tables {
+		selects[i] = fmt.Sprintf("SELECT * FROM `%s`", table)
+	}
+	return query.Table(fmt.Sprintf("(%s) AS verification_records", strings.Join(selects, " UNION ALL ")))
+}
+
+// All 返回覆盖全部月份表的查询
+func (r *VerifyRecordRepository) All() *gorm.DB {
+	return r.Range(time.Time{}, time.Time{})
+}
+
+// migrateLegacy 将旧的 verification_records 单表按月拆分，迁移完成后重命名为 verification_records_legacy 保留备查
+func (r *VerifyRecordRepository) migrateLegacy() error {
+	migrator := r.db.Migrator()
+	if !migrator.HasTable(verifyRecordLegacyTable) {
+		return nil
+	}
+
+	var months []string
+	if err := r.db.Table(verifyRecordLegacyTable).
+		Distinct("DATE_FORMAT(verify_time, '%Y%m')").
+		Pluck("DATE_FORMAT(verify_time, '%Y%m')", &months).Error; err != nil {
+		return err
+	}
+
+	columns := "security_code, merchant_id, verify_time, ip_address, result, user_agent, created_at"
+	for _, month := range months {
+		start, err := time.ParseInLocation(verifyRecordMonthLayout, month, time.Local)
+		if err != nil {
+			continue
+		}
+		if err := r.EnsureMonth(month); err != nil {
+			return err
+		}
+		sql := fmt.Sprintf("INSERT INTO `%s` (%s) SELECT %s FROM `%s` WHERE verify_time >= ? AND verify_time < ?",
+			VerifyRecordTable(month), columns, columns, verifyRecordLegacyTable)
+		if err := r.db.Exec(sql, start, start.AddDate(0, 1, 0)).Error; err != nil {
+			return err
+		}
+	}
+
+	log.Printf("旧验证记录表已按月拆分，共%d个月份", len(months))
+	return migrator.RenameTable(verifyRecordLegacyTable, verifyRecordLegacyTable+"_legacy")
+}
+
+// parseVerifyRecordTable 从表名中解析月份
+func parseVerifyRecordTable(table string) (string, bool) {
+	if !strings.HasPrefix(table, VerifyRecordTablePrefix) {
+		return "", false
+	}
+	month := strings.TrimPrefix(table, VerifyRecordTablePrefix)
+	if _, err := time.Parse(verifyRecordMonthLayout, month); err != nil {
+		return "", false
+	}
+	return month, true
+}
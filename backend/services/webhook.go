@@ -0,0 +1,682 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+
+	"gorm.io/gorm"
+)
+
+// Webhook事件类型
+const (
+	EventCodeVerified = "code.verified" // 防伪码被验证
+	EventCodeAnomaly  = "code.anomaly"  // 防伪码验证异常（伪品或验证次数超限）
+	EventCodeVoided   = "code.voided"   // 防伪码被作废
+	EventCodeRecalled = "code.recalled" // 防伪码被召回
+	EventPing         = "webhook.ping"  // 测试事件
+)
+
+// Webhook投递状态
+const (
+	DeliveryStatusPending = 0 // 待投递
+	DeliveryStatusSuccess = 1 // 成功
+	DeliveryStatusRetry   = 2 // 等待重试
+	DeliveryStatusDead    = 3 // 死信
+	DeliveryStatusSending = 4 // 投递中，已被某个投递协程认领
+)
+
+// WebhookEventTypes 可订阅的事件类型列表
+var WebhookEventTypes = []string{EventCodeVerified, EventCodeAnomaly, EventCodeVoided, EventCodeRecalled}
+
+// Webhook请求头
+const (
+	WebhookHeaderEvent     = "X-AFS-Event"
+	WebhookHeaderDelivery  = "X-AFS-Delivery"
+	WebhookHeaderTimestamp = "X-AFS-Timestamp"
+	WebhookHeaderSignature = "X-AFS-Signature"
+)
+
+// WebhookEvent 推送给订阅方的事件报文
+type WebhookEvent struct {
+	ID         string      `json:"id"`          // 事件ID
+	Type       string      `json:"type"`        // 事件类型
+	MerchantID uint        `json:"merchant_id"` // 商户ID
+	OccurredAt time.Time   `json:"occurred_at"` // 事件发生时间
+	Data       interface{} `json:"data"`        // 事件数据
+}
+
+// errWebhookAddressForbidden 接收地址解析到回环、链路本地或内网地址
+var errWebhookAddressForbidden = errors.New("接收地址指向内网或保留地址")
+
+// webhookReservedNets 除标准库可识别的私有、回环和链路本地地址外，额外禁止的保留网段
+var webhookReservedNets = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),     // 本网络
+	mustParseCIDR("100.64.0.0/10"), // 运营商级NAT
+	mustParseCIDR("198.18.0.0/15"), // 基准测试网络
+}
+
+// WebhookDispatcher Webhook异步投递器
+// 事件发布时只放入内存事件队列，由后台协程匹配订阅、写入投递记录并完成HTTP投递；
+// 投递队列只保存投递记录ID，服务停止时未投递的记录仍在数据库中，下次启动时继续投递。
+type WebhookDispatcher struct {
+	db     *gorm.DB
+	cfg    config.WebhookConfig
+	client *http.Client
+
+	events     chan webhookBatch // 待写入投递记录的事件
+	deliveries chan uint         // 待投递的投递记录ID
+	stop       chan struct{}
+	wg         sync.WaitGroup
+
+	subsMu sync.RWMutex
+	subs   map[uint]cachedSubscriptions // 按商户缓存的启用订阅
+}
+
+// webhookBatch 同一商户、同一类型的一组事件
+type webhookBatch struct {
+	merchantID uint
+	events     []WebhookEvent
+}
+
+// cachedSubscriptions 商户启用订阅的缓存条目
+type cachedSubscriptions struct {
+	subs     []models.WebhookSubscription
+	loadedAt time.Time
+}
+
+// NewWebhookDispatcher 创建Webhook投递器
+// 连接建立时再次校验目标地址，防止DNS重绑定或重定向到内网地址；release模式下不允许开启内网接收地址。
+func NewWebhookDispatcher(db *gorm.DB, cfg *config.Config) (*WebhookDispatcher, error) {
+	if cfg.Webhook.AllowPrivateTargets {
+		if cfg.Server.Mode == "release" {
+			return nil, errors.New("release模式下不能开启WEBHOOK_ALLOW_PRIVATE_TARGETS")
+		}
+		log.Println("警告: 已开启WEBHOOK_ALLOW_PRIVATE_TARGETS，Webhook可以投递到回环和内网地址，仅限本地联调使用")
+	}
+
+	d := &WebhookDispatcher{
+		db:         db,
+		cfg:        cfg.Webhook,
+		events:     make(chan webhookBatch, cfg.Webhook.QueueSize),
+		deliveries: make(chan uint, cfg.Webhook.QueueSize),
+		stop:       make(chan struct{}),
+		subs:       make(map[uint]cachedSubscriptions),
+	}
+
+	timeout := time.Duration(cfg.Webhook.Timeout) * time.Second
+	dialer := &net.Dialer{Timeout: timeout, Control: d.guardDial}
+	d.client = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+	return d, nil
+}
+
+// Start 启动事件写入协程、投递协程和重试扫描协程
+func (d *WebhookDispatcher) Start() {
+	d.wg.Add(1)
+	go d.persist()
+
+	for i := 0; i < d.cfg.Workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+
+	d.wg.Add(1)
+	go d.scanRetries()
+}
+
+// Stop 停止所有后台协程，事件队列中的事件先写入投递记录，未完成的投递保留在数据库中，下次启动时继续投递
+func (d *WebhookDispatcher) Stop() {
+	close(d.stop)
+	d.wg.Wait()
+}
+
+// Publish 发布事件，事件放入队列后立即返回，投递记录由后台协程写入
+func (d *WebhookDispatcher) Publish(merchantID uint, eventType string, data interface{}) {
+	d.PublishEach(merchantID, eventType, []interface{}{data})
+}
+
+// PublishEach 为每条数据发布一个同类型事件，用于批量操作
+// 商户订阅已缓存且没有订阅该类型时直接返回，不访问数据库；事件队列已满时同步写入投递记录，事件不会丢失。
+func (d *WebhookDispatcher) PublishEach(merchantID uint, eventType string, items []interface{}) {
+	if d == nil || merchantID == 0 || len(items) == 0 {
+		return
+	}
+
+	if subs, ok := d.cachedSubscriptions(merchantID); ok && !anySubscribes(subs, eventType) {
+		return
+	}
+
+	batch := webhookBatch{merchantID: merchantID, events: make([]WebhookEvent, 0, len(items))}
+	for _, data := range items {
+		batch.events = append(batch.events, WebhookEvent{
+			ID:         newEventID(),
+			Type:       eventType,
+			MerchantID: merchantID,
+			OccurredAt: time.Now(),
+			Data:       data,
+		})
+	}
+
+	select {
+	case d.events <- batch:
+	default:
+		log.Printf("Webhook事件队列已满，同步写入投递记录")
+		d.createDeliveries(batch)
+	}
+}
+
+// InvalidateSubscriptions 清除商户的订阅缓存，订阅创建、修改或删除后调用
+func (d *WebhookDispatcher) InvalidateSubscriptions(merchantID uint) {
+	d.subsMu.Lock()
+	delete(d.subs, merchantID)
+	d.subsMu.Unlock()
+}
+
+// cachedSubscriptions 返回未过期的商户订阅缓存
+func (d *WebhookDispatcher) cachedSubscriptions(merchantID uint) ([]models.WebhookSubscription, bool) {
+	d.subsMu.RLock()
+	entry, ok := d.subs[merchantID]
+	d.subsMu.RUnlock()
+	if !ok || time.Since(entry.loadedAt) >= time.Duration(d.cfg.SubscriptionCacheTTL)*time.Second {
+		return nil, false
+	}
+	return entry.subs, true
+}
+
+// subscriptions 返回商户的启用订阅，缓存过期时重新查询
+func (d *WebhookDispatcher) subscriptions(merchantID uint) ([]models.WebhookSubscription, error) {
+	if subs, ok := d.cachedSubscriptions(merchantID); ok {
+		return subs, nil
+	}
+
+	var subs []models.WebhookSubscription
+	if err := d.db.Where("merchant_id = ? AND status = 1", merchantID).Find(&subs).Error; err != nil {
+		return nil, err
+	}
+
+	d.subsMu.Lock()
+	d.subs[merchantID] = cachedSubscriptions{subs: subs, loadedAt: time.Now()}
+	d.subsMu.Unlock()
+	return subs, nil
+}
+
+// anySubscribes 判断是否有订阅包含指定事件类型
+func anySubscribes(subs []models.WebhookSubscription, eventType string) bool {
+	for i := range subs {
+		if SubscribesTo(&subs[i], eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// persist 事件写入协程，为匹配的订阅写入投递记录并放入投递队列，停止时写完队列中剩余的事件
+func (d *WebhookDispatcher) persist() {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case batch := <-d.events:
+			d.createDeliveries(batch)
+		case <-d.stop:
+			for {
+				select {
+				case batch := <-d.events:
+					d.createDeliveries(batch)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// createDeliveries 为一组事件匹配订阅并写入投递记录，订阅只查询一次
+func (d *WebhookDispatcher) createDeliveries(batch webhookBatch) {
+	subs, err := d.subscriptions(batch.merchantID)
+	if err != nil {
+		log.Printf("Webhook订阅查询失败: %v", err)
+		return
+	}
+
+	for _, event := range batch.events {
+		for i := range subs {
+			if !SubscribesTo(&subs[i], event.Type) {
+				continue
+			}
+			delivery, err := d.createDelivery(&subs[i], event)
+			if err != nil {
+				log.Printf("Webhook投递记录创建失败: %v", err)
+				continue
+			}
+			d.enqueue(delivery.ID)
+		}
+	}
+}
+
+// Ping 向指定订阅发送测试事件，返回创建的投递记录
+func (d *WebhookDispatcher) Ping(sub *models.WebhookSubscription) (*models.WebhookDelivery, error) {
+	event := WebhookEvent{
+		ID:         newEventID(),
+		Type:       EventPing,
+		MerchantID: sub.MerchantID,
+		OccurredAt: time.Now(),
+		Data:       map[string]interface{}{"subscription_id": sub.ID},
+	}
+
+	delivery, err := d.createDelivery(sub, event)
+	if err != nil {
+		return nil, err
+	}
+	d.enqueue(delivery.ID)
+	return delivery, nil
+}
+
+// Redeliver 手动重新投递，适用于死信或已成功的投递记录，正在投递中的记录不重复入队
+func (d *WebhookDispatcher) Redeliver(delivery *models.WebhookDelivery) error {
+	result := d.db.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status <> ?", delivery.ID, DeliveryStatusSending).
+		Updates(map[string]interface{}{
+			"status":        DeliveryStatusPending,
+			"next_retry_at": nil,
+			"updated_at":    time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 1 {
+		d.enqueue(delivery.ID)
+	}
+	return nil
+}
+
+// SubscribesTo 判断订阅是否包含指定事件类型
+func SubscribesTo(sub *models.WebhookSubscription, eventType string) bool {
+	if sub.EventTypes == "" {
+		return true
+	}
+
+	var types []string
+	if err := json.Unmarshal([]byte(sub.EventTypes), &types); err != nil || len(types) == 0 {
+		return true
+	}
+
+	for _, t := range types {
+		if t == "*" || t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// IsWebhookEventType 判断事件类型是否可订阅
+func IsWebhookEventType(eventType string) bool {
+	for _, t := range WebhookEventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// SignWebhookPayload 计算报文签名：HMAC-SHA256(secret, timestamp + "." + body)，十六进制编码
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateWebhookURL 校验接收地址：仅允许http/https，且主机解析出的所有地址都不能是回环、链路本地或内网地址
+// allowPrivate对应WEBHOOK_ALLOW_PRIVATE_TARGETS，开启时只校验协议和主机名能否解析，用于本地联调。
+func ValidateWebhookURL(rawURL string, allowPrivate bool) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return i18n.NewError("webhook.url_invalid")
+	}
+
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil || len(ips) == 0 {
+		return i18n.NewError("webhook.url_unresolvable", u.Hostname())
+	}
+	if allowPrivate {
+		return nil
+	}
+	for _, ip := range ips {
+		if isForbiddenWebhookIP(ip) {
+			return i18n.NewError("webhook.url_forbidden")
+		}
+	}
+	return nil
+}
+
+// isForbiddenWebhookIP 判断地址是否为回环、私有、链路本地、组播、未指定或其他保留地址
+func isForbiddenWebhookIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range webhookReservedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// guardDial 在建立连接前校验解析后的目标地址，开启WEBHOOK_ALLOW_PRIVATE_TARGETS时不拦截
+func (d *WebhookDispatcher) guardDial(network, address string, _ syscall.RawConn) error {
+	if d.cfg.AllowPrivateTargets {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || isForbiddenWebhookIP(ip) {
+		return errWebhookAddressForbidden
+	}
+	return nil
+}
+
+// mustParseCIDR 解析网段，仅用于包级常量
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// GenerateWebhookSecret 生成随机签名密钥
+func GenerateWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// work 投递协程，从队列中取出投递记录并发送
+func (d *WebhookDispatcher) work() {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case id := <-d.deliveries:
+			d.deliver(id)
+		}
+	}
+}
+
+// scanRetries 定期扫描到期的重试投递，以及启动前遗留的待投递记录
+func (d *WebhookDispatcher) scanRetries() {
+	defer d.wg.Done()
+
+	d.requeuePending(time.Now())
+
+	ticker := time.NewTicker(time.Duration(d.cfg.ScanInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			var ids []uint
+			d.db.Model(&models.WebhookDelivery{}).
+				Where("status = ? AND next_retry_at <= ?", DeliveryStatusRetry, time.Now()).
+				Order("next_retry_at asc").
+				Limit(d.cfg.QueueSize/2+1).
+				Pluck("id", &ids)
+
+			for _, id := range ids {
+				// 先将状态置为待投递，避免下一轮扫描重复入队
+				result := d.db.Model(&models.WebhookDelivery{}).
+					Where("id = ? AND status = ?", id, DeliveryStatusRetry).
+					Update("status", DeliveryStatusPending)
+				if result.Error == nil && result.RowsAffected == 1 {
+					d.enqueue(id)
+				}
+			}
+
+			d.recoverStale()
+		}
+	}
+}
+
+// staleAfter 投递中或待投递记录超过该时长未更新时视为遗留记录
+func (d *WebhookDispatcher) staleAfter() time.Duration {
+	return time.Duration(d.cfg.Timeout)*time.Second*2 + time.Minute
+}
+
+// recoverStale 处理投递协程异常退出遗留的投递中记录，以及长时间未被处理的待投递记录
+func (d *WebhookDispatcher) recoverStale() {
+	before := time.Now().Add(-d.staleAfter())
+	d.db.Model(&models.WebhookDelivery{}).
+		Where("status = ? AND updated_at < ?", DeliveryStatusSending, before).
+		Updates(map[string]interface{}{
+			"status":        DeliveryStatusRetry,
+			"next_retry_at": time.Now(),
+		})
+	d.requeuePending(before)
+}
+
+// requeuePending 重新入队指定时间之前写入的待投递记录，重复入队的记录在认领时被跳过
+func (d *WebhookDispatcher) requeuePending(before time.Time) {
+	var ids []uint
+	d.db.Model(&models.WebhookDelivery{}).
+		Where("status = ? AND updated_at <= ?", DeliveryStatusPending, before).
+		Order("id asc").
+		Limit(d.cfg.QueueSize/2+1).
+		Pluck("id", &ids)
+
+	for _, id := range ids {
+		d.enqueue(id)
+	}
+}
+
+// enqueue 将投递记录放入队列，队列已满时转为等待重试，由扫描协程稍后处理
+func (d *WebhookDispatcher) enqueue(id uint) {
+	select {
+	case d.deliveries <- id:
+	default:
+		next := time.Now().Add(time.Duration(d.cfg.RetryBaseDelay) * time.Second)
+		d.db.Model(&models.WebhookDelivery{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"status":        DeliveryStatusRetry,
+			"next_retry_at": next,
+		})
+	}
+}
+
+// createDelivery 为订阅生成投递记录
+func (d *WebhookDispatcher) createDelivery(sub *models.WebhookSubscription, event WebhookEvent) (*models.WebhookDelivery, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := models.WebhookDelivery{
+		SubscriptionID: sub.ID,
+		MerchantID:     sub.MerchantID,
+		EventID:        event.ID,
+		EventType:      event.Type,
+		Payload:        string(payload),
+		Status:         DeliveryStatusPending,
+	}
+	if err := d.db.Create(&delivery).Error; err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// deliver 认领并执行一次HTTP投递，记录结果
+// 认领通过条件更新完成，同一投递记录被重复入队或多实例同时扫描时只会发送一次。
+func (d *WebhookDispatcher) deliver(id uint) {
+	claim := d.db.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ?", id, DeliveryStatusPending).
+		Updates(map[string]interface{}{
+			"status":     DeliveryStatusSending,
+			"updated_at": time.Now(),
+		})
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		return
+	}
+
+	var delivery models.WebhookDelivery
+	if err := d.db.First(&delivery, id).Error; err != nil {
+		return
+	}
+
+	var sub models.WebhookSubscription
+	if err := d.db.First(&sub, delivery.SubscriptionID).Error; err != nil {
+		d.db.Model(&delivery).Updates(map[string]interface{}{
+			"status":     DeliveryStatusDead,
+			"last_error": "订阅不存在",
+		})
+		return
+	}
+
+	attempt := delivery.Attempts + 1
+	result, sendErr := d.send(&sub, &delivery)
+
+	// 记录投递日志
+	attemptLog := models.WebhookDeliveryAttempt{
+		DeliveryID:   delivery.ID,
+		Attempt:      attempt,
+		StatusCode:   result.statusCode,
+		ResponseBody: truncate(result.body, 1000),
+		DurationMs:   result.duration.Milliseconds(),
+	}
+	if sendErr != nil {
+		attemptLog.Error = truncate(sendErr.Error(), 500)
+	}
+	d.db.Create(&attemptLog)
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"attempts":         attempt,
+		"last_status_code": result.statusCode,
+		"last_error":       attemptLog.Error,
+		"updated_at":       now,
+	}
+
+	switch {
+	case sendErr == nil:
+		updates["status"] = DeliveryStatusSuccess
+		updates["delivered_at"] = now
+		updates["next_retry_at"] = nil
+	case attempt >= d.cfg.MaxAttempts:
+		updates["status"] = DeliveryStatusDead
+		updates["next_retry_at"] = nil
+	default:
+		updates["status"] = DeliveryStatusRetry
+		updates["next_retry_at"] = now.Add(d.backoff(attempt))
+	}
+
+	d.db.Model(&delivery).Where("status = ?", DeliveryStatusSending).Updates(updates)
+}
+
+// sendResult 单次HTTP投递的结果
+type sendResult struct {
+	statusCode int
+	body       string
+	duration   time.Duration
+}
+
+// send 发送带签名的HTTP请求，非2xx响应视为失败
+func (d *WebhookDispatcher) send(sub *models.WebhookSubscription, delivery *models.WebhookDelivery) (sendResult, error) {
+	var result sendResult
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "anti-fake-system-webhook/1.0")
+	req.Header.Set(WebhookHeaderEvent, delivery.EventType)
+	req.Header.Set(WebhookHeaderDelivery, delivery.EventID)
+	req.Header.Set(WebhookHeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookHeaderSignature, SignWebhookPayload(sub.Secret, timestamp, body))
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	result.duration = time.Since(start)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1000))
+	result.statusCode = resp.StatusCode
+	result.body = string(respBody)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return result, fmt.Errorf("接收方返回状态码 %d", resp.StatusCode)
+	}
+	return result, nil
+}
+
+// backoff 计算第attempt次失败后的重试间隔：base * 2^(attempt-1)，不超过上限
+func (d *WebhookDispatcher) backoff(attempt int) time.Duration {
+	base := float64(d.cfg.RetryBaseDelay)
+	delay := base * math.Pow(2, float64(attempt-1))
+	if max := float64(d.cfg.RetryMaxDelay); delay > max {
+		delay = max
+	}
+	return time.Duration(delay) * time.Second
+}
+
+// newEventID 生成随机事件ID
+func newEventID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return "evt_" + hex.EncodeToString(buf)
+}
+
+// truncate 按字节截断字符串，不会截断多字节字符
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,345 @@
+package services
+
+import (
+	"anti-fake-system/config"
+	"anti-fake-system/i18n"
+	"anti-fake-system/models"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	body := []byte(`{"id":"evt_1"}`)
+	// HMAC-SHA256("whsec_test", "1700000000." + body)
+	const want = "sha256=c89214b5b5da833daed6f0b8c5bb6bd58cea9022bd80ccc78230f3942d632925"
+	if got := SignWebhookPayload("whsec_test", 1700000000, body); got != want {
+		t.Errorf("SignWebhookPayload() = %s, want %s", got, want)
+	}
+
+	base := SignWebhookPayload("whsec_test", 1700000000, body)
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		body      string
+	}{
+		{"other secret", "whsec_other", 1700000000, `{"id":"evt_1"}`},
+		{"other timestamp", "whsec_test", 1700000001, `{"id":"evt_1"}`},
+		{"other body", "whsec_test", 1700000000, `{"id":"evt_2"}`},
+		{"timestamp moved into body", "whsec_test", 170000000, `0.{"id":"evt_1"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if SignWebhookPayload(tt.secret, tt.timestamp, []byte(tt.body)) == base {
+				t.Error("SignWebhookPayload() should differ from the base signature")
+			}
+		})
+	}
+}
+
+func TestSubscribesTo(t *testing.T) {
+	tests := []struct {
+		name       string
+		eventTypes string
+		eventType  string
+		want       bool
+	}{
+		{"empty subscribes to all", "", EventCodeVoided, true},
+		{"empty array subscribes to all", "[]", EventCodeVoided, true},
+		{"invalid json subscribes to all", "not json", EventCodeVoided, true},
+		{"wildcard", `["*"]`, EventCodeRecalled, true},
+		{"listed", `["code.verified","code.voided"]`, EventCodeVoided, true},
+		{"not listed", `["code.verified"]`, EventCodeVoided, false},
+		{"prefix does not match", `["code"]`, EventCodeVoided, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := &models.WebhookSubscription{EventTypes: tt.eventTypes}
+			if got := SubscribesTo(sub, tt.eventType); got != tt.want {
+				t.Errorf("SubscribesTo(%q, %q) = %v, want %v", tt.eventTypes, tt.eventType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsWebhookEventType(t *testing.T) {
+	for _, eventType := range WebhookEventTypes {
+		if !IsWebhookEventType(eventType) {
+			t.Errorf("IsWebhookEventType(%q) = false, want true", eventType)
+		}
+	}
+	for _, eventType := range []string{EventPing, "", "*", "code"} {
+		if IsWebhookEventType(eventType) {
+			t.Errorf("IsWebhookEventType(%q) = true, want false", eventType)
+		}
+	}
+}
+
+func TestIsForbiddenWebhookIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true}, // 云服务元数据地址
+		{"0.0.0.0", true},
+		{"0.1.2.3", true},
+		{"100.64.0.1", true},
+		{"198.18.0.1", true},
+		{"224.0.0.1", true},
+		{"::1", true},
+		{"::", true},
+		{"fc00::1", true},
+		{"fe80::1", true},
+		{"ff02::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"8.8.8.8", false},
+		{"1.1.1.1", false},
+		{"100.128.0.1", false},
+		{"2001:4860:4860::8888", false},
+	}
+	for _, tt := range tests {
+		if got := isForbiddenWebhookIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isForbiddenWebhookIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantKey string
+	}{
+		{"https://8.8.8.8/hook", ""},
+		{"http://[2001:4860:4860::8888]:8080/hook", ""},
+		{"ftp://8.8.8.8/hook", "webhook.url_invalid"},
+		{"https:///hook", "webhook.url_invalid"},
+		{"://bad", "webhook.url_invalid"},
+		{"https://127.0.0.1/hook", "webhook.url_forbidden"},
+		{"https://[::1]/hook", "webhook.url_forbidden"},
+		{"http://169.254.169.254/latest/meta-data", "webhook.url_forbidden"},
+		{"http://10.0.0.1:8080/hook", "webhook.url_forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := ValidateWebhookURL(tt.url, false)
+			if tt.wantKey == "" {
+				if err != nil {
+					t.Errorf("ValidateWebhookURL() error = %v, want nil", err)
+				}
+				return
+			}
+			if key, _ := i18n.ErrorKey(err, ""); key != tt.wantKey {
+				t.Errorf("ValidateWebhookURL() error key = %q, want %q", key, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestValidateWebhookURLAllowPrivate(t *testing.T) {
+	for _, rawURL := range []string{"http://127.0.0.1:8080/hook", "https://[::1]/hook", "http://192.168.1.10/hook"} {
+		if err := ValidateWebhookURL(rawURL, true); err != nil {
+			t.Errorf("ValidateWebhookURL(%s, true) error = %v, want nil", rawURL, err)
+		}
+	}
+	// 开启后仍校验协议和主机名
+	if key, _ := i18n.ErrorKey(ValidateWebhookURL("ftp://127.0.0.1/hook", true), ""); key != "webhook.url_invalid" {
+		t.Errorf("ValidateWebhookURL(ftp, true) error key = %q, want webhook.url_invalid", key)
+	}
+}
+
+func TestNewWebhookDispatcherAllowPrivateTargets(t *testing.T) {
+	cfg := &config.Config{Webhook: config.WebhookConfig{AllowPrivateTargets: true}}
+	cfg.Server.Mode = "release"
+	if _, err := NewWebhookDispatcher(nil, cfg); err == nil {
+		t.Error("NewWebhookDispatcher() in release mode with private targets allowed = nil error, want error")
+	}
+
+	cfg.Server.Mode = "debug"
+	if _, err := NewWebhookDispatcher(nil, cfg); err != nil {
+		t.Errorf("NewWebhookDispatcher() in debug mode error = %v, want nil", err)
+	}
+}
+
+func TestWebhookGuardDial(t *testing.T) {
+	d := &WebhookDispatcher{}
+	tests := []struct {
+		address string
+		want    error
+	}{
+		{"8.8.8.8:443", nil},
+		{"[2001:4860:4860::8888]:443", nil},
+		{"127.0.0.1:80", errWebhookAddressForbidden},
+		{"[::1]:80", errWebhookAddressForbidden},
+		{"192.168.0.10:8080", errWebhookAddressForbidden},
+		{"example.com:80", errWebhookAddressForbidden},
+	}
+	for _, tt := range tests {
+		if err := d.guardDial("tcp", tt.address, nil); !errors.Is(err, tt.want) {
+			t.Errorf("guardDial(%s) = %v, want %v", tt.address, err, tt.want)
+		}
+	}
+	if err := d.guardDial("tcp", "missing-port", nil); err == nil {
+		t.Error("guardDial(missing-port) = nil, want error")
+	}
+
+	d.cfg.AllowPrivateTargets = true
+	if err := d.guardDial("tcp", "127.0.0.1:80", nil); err != nil {
+		t.Errorf("guardDial(127.0.0.1:80) with private targets allowed = %v, want nil", err)
+	}
+}
+
+func TestPublishEachCachedSubscriptions(t *testing.T) {
+	// db为nil，任何数据库访问都会panic
+	d, err := NewWebhookDispatcher(nil, &config.Config{Webhook: config.WebhookConfig{QueueSize: 10, SubscriptionCacheTTL: 60}})
+	if err != nil {
+		t.Fatalf("NewWebhookDispatcher error: %v", err)
+	}
+	d.subs[1] = cachedSubscriptions{loadedAt: time.Now()}
+	d.subs[2] = cachedSubscriptions{
+		subs:     []models.WebhookSubscription{{MerchantID: 2, EventTypes: `["code.voided"]`}},
+		loadedAt: time.Now(),
+	}
+
+	// 没有订阅的商户不入队
+	d.PublishEach(1, EventCodeVerified, []interface{}{1, 2})
+	// 订阅不包含该事件类型时不入队
+	d.Publish(2, EventCodeVerified, 1)
+	if len(d.events) != 0 {
+		t.Fatalf("events queued = %d, want 0", len(d.events))
+	}
+
+	d.PublishEach(2, EventCodeVoided, []interface{}{1, 2})
+	if len(d.events) != 1 {
+		t.Fatalf("events queued = %d, want 1", len(d.events))
+	}
+	batch := <-d.events
+	if batch.merchantID != 2 || len(batch.events) != 2 || batch.events[0].ID == batch.events[1].ID {
+		t.Errorf("queued batch = %+v, want two events with distinct ids", batch)
+	}
+
+	// 缓存失效后不能直接判断，事件交给后台协程查询订阅
+	d.InvalidateSubscriptions(1)
+	d.Publish(1, EventCodeVerified, 1)
+	if len(d.events) != 1 {
+		t.Errorf("events queued after invalidation = %d, want 1", len(d.events))
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	d := &WebhookDispatcher{cfg: config.WebhookConfig{RetryBaseDelay: 30, RetryMaxDelay: 3600}}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{20, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := d.backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookSendSignature(t *testing.T) {
+	const secret = "whsec_test"
+	payload := `{"id":"evt_1","type":"code.voided"}`
+
+	var received *http.Request
+	var receivedBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		receivedBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{Webhook: config.WebhookConfig{Timeout: 5}}
+	d, err := NewWebhookDispatcher(nil, cfg)
+	if err != nil {
+		t.Fatalf("NewWebhookDispatcher error: %v", err)
+	}
+	sub := &models.WebhookSubscription{URL: server.URL, Secret: secret}
+	delivery := &models.WebhookDelivery{EventID: "evt_1", EventType: EventCodeVoided, Payload: payload}
+
+	// 测试服务器监听在回环地址，连接时应被拦截
+	if _, err := d.send(sub, delivery); !errors.Is(err, errWebhookAddressForbidden) {
+		t.Fatalf("send() to loopback error = %v, want %v", err, errWebhookAddressForbidden)
+	}
+	if received != nil {
+		t.Fatal("request reached the loopback server")
+	}
+
+	// 开启WEBHOOK_ALLOW_PRIVATE_TARGETS后可以投递到本机接收端，验证签名和请求头
+	cfg.Webhook.AllowPrivateTargets = true
+	d, err = NewWebhookDispatcher(nil, cfg)
+	if err != nil {
+		t.Fatalf("NewWebhookDispatcher error: %v", err)
+	}
+	result, err := d.send(sub, delivery)
+	if err != nil {
+		t.Fatalf("send() error: %v", err)
+	}
+	if result.statusCode != http.StatusNoContent {
+		t.Errorf("send() status = %d, want %d", result.statusCode, http.StatusNoContent)
+	}
+	if string(receivedBody) != payload {
+		t.Errorf("body = %s, want %s", receivedBody, payload)
+	}
+	if got := received.Header.Get(WebhookHeaderEvent); got != EventCodeVoided {
+		t.Errorf("%s = %q, want %q", WebhookHeaderEvent, got, EventCodeVoided)
+	}
+	if got := received.Header.Get(WebhookHeaderDelivery); got != "evt_1" {
+		t.Errorf("%s = %q, want evt_1", WebhookHeaderDelivery, got)
+	}
+
+	// 接收方按文档重新计算签名
+	timestamp, err := strconv.ParseInt(received.Header.Get(WebhookHeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatalf("invalid %s header: %v", WebhookHeaderTimestamp, err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "." + payload))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := received.Header.Get(WebhookHeaderSignature); !hmac.Equal([]byte(got), []byte(want)) {
+		t.Errorf("%s = %q, want %q", WebhookHeaderSignature, got, want)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 5, "hello"},
+		{"hello", 3, "hel"},
+		{"防伪码", 4, "防"}, // 每个汉字3字节，不截断半个字符
+		{"防伪码", 6, "防伪"},
+		{"防伪码", 2, ""},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Package tracechain 实现溯源记录的防篡改哈希链和默克尔证明，不依赖数据库，第三方可直接用于核验。
+//
+// 每个批次的溯源记录按写入顺序组成一条哈希链，每条记录包含内容哈希和上一条记录的哈希；
+// 平台定期以商户下各批次的链头计算默克尔根并公开。核验时逐条重算链上哈希，
+// 再用默克尔证明确认链头包含在已公开的根中。
+//
+// 内容哈希由逐字段承诺组成，每个字段使用独立的随机盐，公开证明只需披露对消费者展示的字段：
+//
+//	field_digest = SHA256(salt + ":" + key + ":" + canonical(value))
+//	content_hash = SHA256(canonical({"attachments_hash", "fields": {key: field_digest}, "schema_version", "stage_type"}))
+//	entry_hash   = SHA256("AFS-TRACE-1\n" + batch_id + "\n" + seq + "\n" + trace_id + "\n" + version + "\n" +
+//	                      action + "\n" + content_hash + "\n" + prev_hash + "\n" + created_at)
+//
+// canonical 为键按字典序排列、无多余空白、不转义HTML字符的JSON。
+package tracechain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Scheme 哈希链格式标识
+const Scheme = "AFS-TRACE-1"
+
+// GenesisHash 批次第一条记录的上一条哈希
+var GenesisHash = strings.Repeat("0", 64)
+
+// 记录动作
+const (
+	ActionCreate = "create" // 新增溯源信息
+	ActionUpdate = "update" // 修改溯源信息，生成新版本
+	ActionDelete = "delete" // 删除溯源信息，内容哈希为空
+)
+
+// 核验错误
+var (
+	ErrSequence    = errors.New("tracechain: 记录序号不连续")
+	ErrPrevHash    = errors.New("tracechain: 上一条哈希不匹配")
+	ErrEntryHash   = errors.New("tracechain: 记录哈希不匹配")
+	ErrContentHash = errors.New("tracechain: 内容哈希不匹配")
+	ErrFieldDigest = errors.New("tracechain: 字段承诺不匹配")
+	ErrProof       = errors.New("tracechain: 默克尔证明不匹配")
+)
+
+// Field 字段承诺，Salt和Value只对公开字段披露
+type Field struct {
+	Key    string          `json:"key"`
+	Digest string          `json:"digest"`
+	Salt   string          `json:"salt,omitempty"`
+	Value  json.RawMessage `json:"value,omitempty"`
+}
+
+// Record 哈希链上的一条记录
+type Record struct {
+	BatchID         uint    `json:"batch_id"`
+	Seq             uint    `json:"seq"`
+	TraceID         uint    `json:"trace_id"`
+	Version         int     `json:"version"`
+	Action          string  `json:"action"`
+	StageType       int     `json:"stage_type"`
+	SchemaVersion   int     `json:"schema_version"`
+	Fields          []Field `json:"fields"`
+	AttachmentsHash string  `json:"attachments_hash"`
+	ContentHash     string  `json:"content_hash"`
+	PrevHash        string  `json:"prev_hash"`
+	EntryHash       string  `json:"entry_hash"`
+	CreatedAt       int64   `json:"created_at"` // Unix时间戳（秒）
+}
+
+// ProofStep 默克尔证明的一步，Left表示兄弟节点在左侧
+type ProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+// Canonical 输出规范化JSON
+func Canonical(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// CanonicalRaw 将任意JSON文本规范化
+func CanonicalRaw(raw []byte) ([]byte, error) {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return Canonical(v)
+}
+
+// FieldDigest 计算字段承诺
+func FieldDigest(salt, key string, value interface{}) (string, error) {
+	data, err := Canonical(value)
+	if err != nil {
+		return "", err
+	}
+	return sha256Hex([]byte(salt + ":" + key + ":" + string(data))), nil
+}
+
+// AttachmentsHash 计算附件数组的哈希
+func AttachmentsHash(attachments interface{}) (string, error) {
+	data, err := Canonical(attachments)
+	if err != nil {
+		return "", err
+	}
+	return sha256Hex(data), nil
+}
+
+// ContentHash 由字段承诺计算内容哈希，删除记录的内容哈希为空
+func ContentHash(stageType, schemaVersion int, digests map[string]string, attachmentsHash string) string {
+	data, _ := Canonical(map[string]interface{}{
+		"attachments_hash": attachmentsHash,
+		"fields":           digests,
+		"schema_version":   schemaVersion,
+		"stage_type":       stageType,
+	})
+	return sha256Hex(data)
+}
+
+// Hash 计算记录哈希
+func (r *Record) Hash() string {
+	parts := []string{
+		Scheme,
+		strconv.FormatUint(uint64(r.BatchID), 10),
+		strconv.FormatUint(uint64(r.Seq), 10),
+		strconv.FormatUint(uint64(r.TraceID), 10),
+		strconv.Itoa(r.Version),
+		r.Action,
+		r.ContentHash,
+		r.PrevHash,
+		strconv.FormatInt(r.CreatedAt, 10),
+	}
+	return sha256Hex([]byte(strings.Join(parts, "\n")))
+}
+
+// VerifyContent 校验披露的字段值和内容哈希
+func (r *Record) VerifyContent() error {
+	if r.Action == ActionDelete {
+		if r.ContentHash != "" {
+			return ErrContentHash
+		}
+		return nil
+	}
+
+	digests := make(map[string]string, len(r.Fields))
+	for _, field := range r.Fields {
+		if field.Value != nil {
+			var value interface{}
+			if err := json.Unmarshal(field.Value, &value); err != nil {
+				return fmt.Errorf("%w: %s", ErrFieldDigest, field.Key)
+			}
+			digest, err := FieldDigest(field.Salt, field.Key, value)
+			if err != nil || digest != field.Digest {
+				return fmt.Errorf("%w: %s", ErrFieldDigest, field.Key)
+			}
+		}
+		digests[field.Key] = field.Digest
+	}
+	if ContentHash(r.StageType, r.SchemaVersion, digests, r.AttachmentsHash) != r.ContentHash {
+		return ErrContentHash
+	}
+	return nil
+}
+
+// VerifyChain 核验一个批次从第一条开始的连续记录，返回链头哈希
+func VerifyChain(records []Record) (string, error) {
+	prev := GenesisHash
+	for i := range records {
+		record := &records[i]
+		if record.Seq != uint(i+1) {
+			return "", fmt.Errorf("%w: %d", ErrSequence, record.Seq)
+		}
+		if record.PrevHash != prev {
+			return "", fmt.Errorf("%w: %d", ErrPrevHash, record.Seq)
+		}
+		if err := record.VerifyContent(); err != nil {
+			return "", fmt.Errorf("%w (seq %d)", err, record.Seq)
+		}
+		if record.Hash() != record.EntryHash {
+			return "", fmt.Errorf("%w: %d", ErrEntryHash, record.Seq)
+		}
+		prev = record.EntryHash
+	}
+	return prev, nil
+}
+
+// LeafHash 计算批次链头对应的默克尔叶子
+func LeafHash(batchID, seq uint, headHash string) string {
+	data := fmt.Sprintf("%d:%d:%s", batchID, seq, headHash)
+	return sha256Hex(append([]byte{0}, data...))
+}
+
+// nodeHash 计算默克尔内部节点，与叶子使用不同前缀防止第二原像攻击
+func nodeHash(left, right string) string {
+	l, _ := hex.DecodeString(left)
+	r, _ := hex.DecodeString(right)
+	data := append([]byte{1}, l...)
+	return sha256Hex(append(data, r...))
+}
+
+// MerkleRoot 计算默克尔根，奇数个节点时最后一个直接进入上一层
+func MerkleRoot(leaves []string) string {
+	if len(leaves) == 0 {
+		return sha256Hex(nil)
+	}
+	level := append([]string(nil), leaves...)
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+	return level[0]
+}
+
+// MerkleProof 生成第index个叶子的默克尔证明
+func MerkleProof(leaves []string, index int) []ProofStep {
+	proof := []ProofStep{}
+	level := append([]string(nil), leaves...)
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof = append(proof, ProofStep{Hash: level[sibling], Left: sibling < index})
+		}
+		level = nextLevel(level)
+		index /= 2
+	}
+	return proof
+}
+
+// VerifyProof 核验叶子是否包含在默克尔根中
+func VerifyProof(leaf string, proof []ProofStep, root string) error {
+	hash := leaf
+	for _, step := range proof {
+		if step.Left {
+			hash = nodeHash(step.Hash, hash)
+		} else {
+			hash = nodeHash(hash, step.Hash)
+		}
+	}
+	if hash != root {
+		return ErrProof
+	}
+	return nil
+}
+
+// nextLevel 计算默克尔树的上一层
+func nextLevel(level []string) []string {
+	next := make([]string, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 < len(level) {
+			next = append(next, nodeHash(level[i], level[i+1]))
+		} else {
+			next = append(next, level[i])
+		}
+	}
+	return next
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
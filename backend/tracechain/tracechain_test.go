@@ -0,0 +1,266 @@
+package tracechain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// buildChain 生成一个批次的合法哈希链，每条记录披露全部字段
+func buildChain(t *testing.T, batchID uint, n int) []Record {
+	t.Helper()
+	records := make([]Record, 0, n)
+	prev := GenesisHash
+	for i := 1; i <= n; i++ {
+		values := map[string]interface{}{
+			"operator": fmt.Sprintf("张三%d", i),
+			"weight":   float64(i) * 1.5,
+			"note":     "<b>a&b</b>",
+		}
+		fields := make([]Field, 0, len(values))
+		digests := make(map[string]string, len(values))
+		for key, value := range values {
+			salt := fmt.Sprintf("salt-%d-%s", i, key)
+			digest, err := FieldDigest(salt, key, value)
+			if err != nil {
+				t.Fatalf("FieldDigest error: %v", err)
+			}
+			raw, _ := Canonical(value)
+			fields = append(fields, Field{Key: key, Digest: digest, Salt: salt, Value: raw})
+			digests[key] = digest
+		}
+		attachmentsHash, err := AttachmentsHash([]uint{uint(i), uint(i + 1)})
+		if err != nil {
+			t.Fatalf("AttachmentsHash error: %v", err)
+		}
+
+		record := Record{
+			BatchID:         batchID,
+			Seq:             uint(i),
+			TraceID:         uint(100 + i),
+			Version:         1,
+			Action:          ActionCreate,
+			StageType:       i%5 + 1,
+			SchemaVersion:   1,
+			Fields:          fields,
+			AttachmentsHash: attachmentsHash,
+			ContentHash:     ContentHash(i%5+1, 1, digests, attachmentsHash),
+			PrevHash:        prev,
+			CreatedAt:       1700000000 + int64(i),
+		}
+		record.EntryHash = record.Hash()
+		prev = record.EntryHash
+		records = append(records, record)
+	}
+	return records
+}
+
+// rehash 修改记录后重新计算本条及后续记录的哈希，模拟改写整条链
+func rehash(records []Record, from int) {
+	for i := from; i < len(records); i++ {
+		if i > 0 {
+			records[i].PrevHash = records[i-1].EntryHash
+		}
+		records[i].EntryHash = records[i].Hash()
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"sorted keys", map[string]interface{}{"b": 1, "a": 2}, `{"a":2,"b":1}`},
+		{"no html escape", "<a&b>", `"<a&b>"`},
+		{"nested", map[string]interface{}{"z": []int{1, 2}, "a": map[string]string{"y": "1", "x": "2"}}, `{"a":{"x":"2","y":"1"},"z":[1,2]}`},
+		{"unicode", "张三", `"张三"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Canonical(tt.value)
+			if err != nil {
+				t.Fatalf("Canonical error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Canonical() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	raw, err := CanonicalRaw([]byte(" {\"b\": 1,\n \"a\": \"<x>\"} "))
+	if err != nil || string(raw) != `{"a":"<x>","b":1}` {
+		t.Errorf("CanonicalRaw() = %s, %v", raw, err)
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	records := buildChain(t, 7, 5)
+	head, err := VerifyChain(records)
+	if err != nil {
+		t.Fatalf("VerifyChain error: %v", err)
+	}
+	if head != records[len(records)-1].EntryHash {
+		t.Errorf("VerifyChain head = %s, want %s", head, records[len(records)-1].EntryHash)
+	}
+
+	if head, err := VerifyChain(nil); err != nil || head != GenesisHash {
+		t.Errorf("VerifyChain(nil) = %s, %v, want genesis hash", head, err)
+	}
+}
+
+func TestVerifyChainTampered(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(records []Record)
+		want   error
+	}{
+		{"missing first record", func(r []Record) { copy(r, r[1:]) }, ErrSequence},
+		{"sequence gap", func(r []Record) { r[2].Seq = 4 }, ErrSequence},
+		{"prev hash changed", func(r []Record) { r[2].PrevHash = GenesisHash }, ErrPrevHash},
+		{"created at changed", func(r []Record) { r[1].CreatedAt++ }, ErrEntryHash},
+		{"action changed", func(r []Record) { r[1].Action = ActionUpdate }, ErrEntryHash},
+		{"entry hash changed", func(r []Record) { r[4].EntryHash = GenesisHash }, ErrEntryHash},
+		{"disclosed value changed", func(r []Record) { r[0].Fields[0].Value = json.RawMessage(`"forged"`) }, ErrFieldDigest},
+		{"salt changed", func(r []Record) { r[0].Fields[0].Salt = "other" }, ErrFieldDigest},
+		{"invalid disclosed value", func(r []Record) { r[0].Fields[0].Value = json.RawMessage(`{`) }, ErrFieldDigest},
+		{"digest changed", func(r []Record) {
+			r[3].Fields[0].Value = nil
+			r[3].Fields[0].Digest = GenesisHash
+		}, ErrContentHash},
+		{"attachments changed", func(r []Record) { r[3].AttachmentsHash = GenesisHash }, ErrContentHash},
+		{"content rewritten and rehashed", func(r []Record) {
+			r[1].ContentHash = GenesisHash
+			rehash(r, 1)
+		}, ErrContentHash},
+		{"delete with content hash", func(r []Record) {
+			r[4].Action = ActionDelete
+			rehash(r, 4)
+		}, ErrContentHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := buildChain(t, 7, 5)
+			tt.tamper(records)
+			if _, err := VerifyChain(records); !errors.Is(err, tt.want) {
+				t.Errorf("VerifyChain() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyContentDelete(t *testing.T) {
+	record := Record{BatchID: 1, Seq: 1, Action: ActionDelete, PrevHash: GenesisHash}
+	record.EntryHash = record.Hash()
+	if _, err := VerifyChain([]Record{record}); err != nil {
+		t.Errorf("VerifyChain(delete) error: %v", err)
+	}
+}
+
+func TestRecordHashDependsOnEveryField(t *testing.T) {
+	base := buildChain(t, 3, 1)[0]
+	mutations := map[string]func(r *Record){
+		"batch":   func(r *Record) { r.BatchID++ },
+		"seq":     func(r *Record) { r.Seq++ },
+		"trace":   func(r *Record) { r.TraceID++ },
+		"version": func(r *Record) { r.Version++ },
+		"action":  func(r *Record) { r.Action = ActionUpdate },
+		"content": func(r *Record) { r.ContentHash = GenesisHash },
+		"prev":    func(r *Record) { r.PrevHash = base.EntryHash },
+		"created": func(r *Record) { r.CreatedAt++ },
+	}
+	for name, mutate := range mutations {
+		record := base
+		mutate(&record)
+		if record.Hash() == base.EntryHash {
+			t.Errorf("Hash() unchanged after modifying %s", name)
+		}
+	}
+}
+
+func TestMerkleRoot(t *testing.T) {
+	a, b, c := LeafHash(1, 1, GenesisHash), LeafHash(2, 3, GenesisHash), LeafHash(3, 2, GenesisHash)
+	tests := []struct {
+		name   string
+		leaves []string
+		want   string
+	}{
+		{"empty", nil, sha256Hex(nil)},
+		{"single leaf", []string{a}, a},
+		{"two leaves", []string{a, b}, nodeHash(a, b)},
+		{"odd leaf promoted", []string{a, b, c}, nodeHash(nodeHash(a, b), c)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MerkleRoot(tt.leaves); got != tt.want {
+				t.Errorf("MerkleRoot() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if nodeHash(a, b) == nodeHash(b, a) {
+		t.Error("nodeHash should depend on order")
+	}
+}
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		leaves := make([]string, n)
+		for i := range leaves {
+			leaves[i] = LeafHash(uint(i+1), uint(i+2), GenesisHash)
+		}
+		root := MerkleRoot(leaves)
+		for index, leaf := range leaves {
+			proof := MerkleProof(leaves, index)
+			if err := VerifyProof(leaf, proof, root); err != nil {
+				t.Errorf("n=%d index=%d: VerifyProof error: %v", n, index, err)
+			}
+			if n == 1 {
+				continue
+			}
+			other := leaves[(index+1)%n]
+			if err := VerifyProof(other, proof, root); !errors.Is(err, ErrProof) {
+				t.Errorf("n=%d index=%d: proof accepted for another leaf", n, index)
+			}
+			if len(proof) > 0 {
+				forged := append([]ProofStep(nil), proof...)
+				forged[0].Left = !forged[0].Left
+				if err := VerifyProof(leaf, forged, root); !errors.Is(err, ErrProof) {
+					t.Errorf("n=%d index=%d: proof accepted with flipped side", n, index)
+				}
+			}
+		}
+		if err := VerifyProof(leaves[0], MerkleProof(leaves, 0), GenesisHash); !errors.Is(err, ErrProof) {
+			t.Errorf("n=%d: proof accepted for wrong root", n)
+		}
+	}
+}
+
+func TestLeafHash(t *testing.T) {
+	head := buildChain(t, 1, 2)[1].EntryHash
+	base := LeafHash(1, 2, head)
+	tests := []struct {
+		name  string
+		batch uint
+		seq   uint
+		head  string
+	}{
+		{"other batch", 2, 2, head},
+		{"other seq", 1, 3, head},
+		{"other head", 1, 2, GenesisHash},
+		{"ambiguous concatenation", 12, 2, head},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if LeafHash(tt.batch, tt.seq, tt.head) == base {
+				t.Error("LeafHash() should differ from the base leaf")
+			}
+		})
+	}
+
+	// 叶子与内部节点使用不同前缀，内部节点不能冒充叶子
+	if LeafHash(1, 2, head) == nodeHash(head, head) {
+		t.Error("leaf and node hashes should be domain separated")
+	}
+}
@@ -1,7 +1,9 @@
 package utils
 
 import (
-	"anti-fake-system/config"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -13,32 +15,88 @@ type CustomClaims struct {
 	Username   string `json:"username"`
 	UserType   int    `json:"user_type"` // 1-平台用户, 2-商户用户
 	MerchantID *uint  `json:"merchant_id,omitempty"`
+	Purpose    string `json:"purpose,omitempty"` // 令牌用途，访问令牌为空
 	jwt.RegisteredClaims
 }
 
-// GenerateToken 生成JWT令牌
-func GenerateToken(userID uint, username string, userType int, merchantID *uint, cfg *config.Config) (string, error) {
-	claims := CustomClaims{
+// 令牌用途，两步验证和二次验证令牌与访问令牌使用同一套签名密钥，以用途区分，不能互相替代
+const (
+	TokenPurposeAccess    = ""              // 访问令牌
+	TokenPurposeChallenge = "2fa_challenge" // 密码验证通过后提交两步验证码使用的临时令牌
+	TokenPurposeStepUp    = "step_up"       // 敏感操作的二次验证令牌
+)
+
+// TokenKeys 访问令牌的签名密钥来源，按kid查找验证密钥以支持密钥轮换
+type TokenKeys interface {
+	// SigningKey 返回当前签名密钥的kid、签名算法和私钥
+	SigningKey() (kid string, method jwt.SigningMethod, key interface{}, err error)
+	// VerificationKey 返回kid对应的验证密钥，密钥不存在、已吊销或算法不符时返回错误
+	VerificationKey(kid, alg string) (interface{}, error)
+}
+
+// SigningMethods 支持的访问令牌签名算法
+var SigningMethods = map[string]jwt.SigningMethod{
+	jwt.SigningMethodHS256.Alg(): jwt.SigningMethodHS256,
+	jwt.SigningMethodRS256.Alg(): jwt.SigningMethodRS256,
+	jwt.SigningMethodEdDSA.Alg(): jwt.SigningMethodEdDSA,
+}
+
+// GenerateToken 生成访问令牌，返回令牌和其中的声明，声明的ID（jti）用于注销时加入黑名单
+func GenerateToken(keys TokenKeys, userID uint, username string, userType int, merchantID *uint, expire time.Duration) (string, *CustomClaims, error) {
+	return GeneratePurposeToken(keys, TokenPurposeAccess, userID, username, userType, merchantID, expire)
+}
+
+// GeneratePurposeToken 生成指定用途的令牌
+func GeneratePurposeToken(keys TokenKeys, purpose string, userID uint, username string, userType int, merchantID *uint, expire time.Duration) (string, *CustomClaims, error) {
+	kid, method, key, err := keys.SigningKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	claims := &CustomClaims{
 		UserID:     userID,
 		Username:   username,
 		UserType:   userType,
 		MerchantID: merchantID,
+		Purpose:    purpose,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * time.Duration(cfg.JWT.Expire))),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ID:        hex.EncodeToString(jti),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expire)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 			Issuer:    "anti-fake-system",
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(cfg.JWT.Secret))
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
 }
 
-// ParseToken 解析JWT令牌
-func ParseToken(tokenString string) (*CustomClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.Load().JWT.Secret), nil
+// ParseToken 解析JWT令牌，按头部的kid选择验证密钥
+func ParseToken(keys TokenKeys, tokenString string) (*CustomClaims, error) {
+	methods := make([]string, 0, len(SigningMethods))
+	for alg := range SigningMethods {
+		methods = append(methods, alg)
+	}
+	parser := jwt.NewParser(jwt.WithValidMethods(methods))
+
+	token, err := parser.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("令牌缺少kid")
+		}
+		return keys.VerificationKey(kid, token.Method.Alg())
 	})
 
 	if err != nil {
@@ -51,20 +109,3 @@ func ParseToken(tokenString string) (*CustomClaims, error) {
 
 	return nil, jwt.ErrInvalidKey
 }
-
-// RefreshToken 刷新令牌
-func RefreshToken(tokenString string, cfg *config.Config) (string, error) {
-	claims, err := ParseToken(tokenString)
-	if err != nil {
-		return "", err
-	}
-
-	// 检查令牌是否即将过期（剩余时间小于一半）
-	remaining := time.Until(claims.ExpiresAt.Time)
-	if remaining > time.Duration(cfg.JWT.Expire/2)*time.Hour {
-		return tokenString, nil // 不需要刷新
-	}
-
-	// 生成新令牌
-	return GenerateToken(claims.UserID, claims.Username, claims.UserType, claims.MerchantID, cfg)
-}
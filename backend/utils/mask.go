@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"net"
+	"strings"
+)
+
+// MaskIP 对IP地址脱敏，IPv4保留前两段，IPv6保留前三组
+func MaskIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "*"
+	}
+
+	if v4 := parsed.To4(); v4 != nil {
+		parts := strings.Split(v4.String(), ".")
+		return parts[0] + "." + parts[1] + ".*.*"
+	}
+
+	groups := strings.Split(parsed.String(), ":")
+	if len(groups) > 3 {
+		groups = groups[:3]
+	}
+	return strings.Join(groups, ":") + ":*"
+}